@@ -0,0 +1,10 @@
+# Headless VM for unattended CI jobs
+msg.autoAnswer = "TRUE"
+sound.present = "FALSE"
+floppy0.present = "FALSE"
+usb.present = "FALSE"
+ehci.present = "FALSE"
+mks.enable3d = "FALSE"
+RemoteDisplay.vnc.enabled = "FALSE"
+tools.syncTime = "TRUE"
+tools.upgrade.policy = "manual"
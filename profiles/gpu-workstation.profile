@@ -0,0 +1,6 @@
+# Desktop VM with 3D acceleration
+mks.enable3d = "TRUE"
+svga.graphicsMemoryKB = "8388608"
+svga.autodetect = "TRUE"
+sound.present = "TRUE"
+usb_xhci.present = "TRUE"
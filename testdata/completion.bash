@@ -0,0 +1,16 @@
+# bash completion for vmxtool
+_vmxtool() {
+    local line=${COMP_LINE:0:COMP_POINT}
+    local -a words
+    read -ra words <<< "$line"
+    [[ $line == *[[:space:]] ]] && words+=("")
+    local cur=${words[${#words[@]}-1]}
+    local IFS=$'\n'
+    COMPREPLY=($(vmxtool __complete "${words[@]:1}" 2>/dev/null))
+    # Bash replaces only the part of the word after the last : or =
+    local prefix=${cur%"${cur##*[:=]}"}
+    if [[ -n $prefix ]]; then
+        COMPREPLY=("${COMPREPLY[@]#"$prefix"}")
+    fi
+}
+complete -o default -F _vmxtool vmxtool
@@ -0,0 +1,6 @@
+# fish completion for vmxtool
+function __vmxtool_complete
+    set -l words (commandline -opc)
+    vmxtool __complete $words[2..-1] (commandline -ct) 2>/dev/null
+end
+complete -c vmxtool -a '(__vmxtool_complete)'
@@ -1,15 +1,46 @@
 // SPDX-FileCopyrightText: © 2025 David Parsons
 // SPDX-License-Identifier: MIT
-// 
 package main
 
 import (
+	"archive/tar"
 	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"embed"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+	"math"
+	"net/http"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
 	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"text/template/parse"
+	"time"
+	"unicode/utf8"
 )
 
 // Version information - set during build
@@ -19,6 +50,22 @@ var (
 	Commit    = "unknown"
 )
 
+// guestOSData is the table of known guestOS identifiers
+//
+//go:embed guestos.txt
+var guestOSData string
+
+// hardeningData holds the built-in profiles for the harden command
+//
+//go:embed hardening.json
+var hardeningData []byte
+
+// keysData holds the key table for the explain command, set validation
+// and lint
+//
+//go:embed keys.json
+var keysData []byte
+
 // Entry represents a line in the dictionary file
 type Entry struct {
 	Original           string // Original line including comments, whitespace
@@ -34,6 +81,20 @@ type Entry struct {
 type Dictionary struct {
 	Filename string
 	Entries  []*Entry
+	Encoding string // Character encoding of the file (empty for UTF-8)
+	LastWins bool   // Look up the last of duplicate keys, as VMware does
+
+	// NoFinalNewline records that the file did not end with a newline, so
+	// that saving reproduces the original bytes
+	NoFinalNewline bool
+
+	// VMwareCompat writes the file in VMware's canonical format; values are
+	// held unescaped and written with |XX escapes
+	VMwareCompat bool
+
+	// loaded is the state of the file when it was loaded, so that saving
+	// can detect that another program changed it in the meantime
+	loaded fileState
 }
 
 // findClosingQuote finds the index of the closing quote, handling escapes
@@ -50,489 +111,13951 @@ func findClosingQuote(s string, startIdx int) int {
 	return -1
 }
 
-// LoadDictionary loads a dictionary file while preserving layout
-func LoadDictionary(filename string) (*Dictionary, error) {
-	dict := &Dictionary{Filename: filename}
+// windows1252 maps bytes 0x80-0x9F of Windows-1252 to Unicode. Bytes that
+// are undefined in Windows-1252 map to the matching C1 control character
+// so that they survive a round trip.
+var windows1252 = [32]rune{
+	0x20AC, 0x0081, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+	0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0x008D, 0x017D, 0x008F,
+	0x0090, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+	0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0x009D, 0x017E, 0x0178,
+}
 
-	file, err := os.Open(filename)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return dict, nil
+// singleByteCharset returns the Unicode mapping for bytes 0x80-0x9F of a
+// supported single-byte encoding, or false if the encoding is not one that
+// vmxtool converts. Bytes 0xA0-0xFF map directly to U+00A0-U+00FF.
+func singleByteCharset(encoding string) (*[32]rune, bool) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "windows-1252", "cp1252":
+		return &windows1252, true
+	case "iso-8859-1", "iso8859-1", "latin1":
+		var latin1 [32]rune
+		for i := range latin1 {
+			latin1[i] = rune(0x80 + i)
 		}
-		return nil, err
+		return &latin1, true
 	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		original := scanner.Text()
-		trimmed := strings.TrimSpace(original)
+	return nil, false
+}
 
-		entry := &Entry{Original: original}
+// decodeSingleByte converts text in a single-byte encoding to UTF-8
+func decodeSingleByte(data []byte, charset *[32]rune) string {
+	var sb strings.Builder
+	for _, b := range data {
+		switch {
+		case b < 0x80:
+			sb.WriteByte(b)
+		case b < 0xA0:
+			sb.WriteRune(charset[b-0x80])
+		default:
+			sb.WriteRune(rune(b))
+		}
+	}
+	return sb.String()
+}
 
-		// Check if it's a blank line
-		if trimmed == "" {
-			entry.IsBlank = true
-			dict.Entries = append(dict.Entries, entry)
-			continue
+// encodeSingleByte converts UTF-8 text to a single-byte encoding, reporting
+// false if any character cannot be represented
+func encodeSingleByte(text string, charset *[32]rune) ([]byte, bool) {
+	data := make([]byte, 0, len(text))
+	for _, r := range text {
+		switch {
+		case r < 0x80:
+			data = append(data, byte(r))
+		case r >= 0xA0 && r <= 0xFF:
+			data = append(data, byte(r))
+		default:
+			i := slices.Index(charset[:], r)
+			if i == -1 {
+				return nil, false
+			}
+			data = append(data, byte(0x80+i))
 		}
+	}
+	return data, true
+}
+
+// parseLine parses a single line of a dictionary file
+func parseLine(original string) *Entry {
+	trimmed := strings.TrimSpace(original)
 
-		// Check if it's a comment
-		if strings.HasPrefix(trimmed, "#") {
+	entry := &Entry{Original: original}
+
+	// Check if it's a blank line
+	if trimmed == "" {
+		entry.IsBlank = true
+		return entry
+	}
+
+	// Check if it's a comment
+	if strings.HasPrefix(trimmed, "#") {
+		entry.IsComment = true
+		return entry
+	}
+
+	// Parse key-value pair. A quoted key, written by formatKey, may
+	// contain = and whitespace.
+	var key, valueAndComment string
+	if end := findClosingQuote(trimmed, 1); strings.HasPrefix(trimmed, `"`) && end != -1 {
+		rest, found := strings.CutPrefix(strings.TrimLeft(trimmed[end+1:], " \t"), "=")
+		if !found {
 			entry.IsComment = true
-			dict.Entries = append(dict.Entries, entry)
-			continue
+			return entry
 		}
-
-		// Parse key-value pair
+		key = unescapeQuotes(trimmed[1:end])
+		valueAndComment = strings.TrimSpace(rest)
+	} else {
 		parts := strings.SplitN(trimmed, "=", 2)
 		if len(parts) != 2 {
 			entry.IsComment = true
-			dict.Entries = append(dict.Entries, entry)
-			continue
+			return entry
 		}
+		key = strings.TrimSpace(parts[0])
+		valueAndComment = strings.TrimSpace(parts[1])
+	}
 
-		key := strings.TrimSpace(parts[0])
-		valueAndComment := strings.TrimSpace(parts[1])
+	var value string
+	var inlineComment string
+	var inlineCommentSpace string
 
-		var value string
-		var inlineComment string
-		var inlineCommentSpace string
-
-		// Handle quoted values with potential inline comments
-		if strings.HasPrefix(valueAndComment, `"`) {
-			// Find the closing quote
-			endQuoteIdx := findClosingQuote(valueAndComment, 1)
-			if endQuoteIdx != -1 {
-				// Extract quoted value (without outer quotes)
-				value = valueAndComment[1:endQuoteIdx]
-				value = unescapeQuotes(value)
-
-				// Everything after the closing quote
-				remainder := valueAndComment[endQuoteIdx+1:]
-				if len(remainder) > 0 {
-					// Check if there's a comment
-					if commentIdx := strings.Index(remainder, "#"); commentIdx != -1 {
-						// Preserve the whitespace before #
-						inlineCommentSpace = remainder[:commentIdx]
-						// Store the comment (including #)
-						inlineComment = remainder[commentIdx:]
-					}
+	// Handle quoted values with potential inline comments
+	if strings.HasPrefix(valueAndComment, `"`) {
+		// Find the closing quote
+		endQuoteIdx := findClosingQuote(valueAndComment, 1)
+		if endQuoteIdx != -1 {
+			// Extract quoted value (without outer quotes)
+			value = valueAndComment[1:endQuoteIdx]
+			value = unescapeQuotes(value)
+
+			// Everything after the closing quote
+			remainder := valueAndComment[endQuoteIdx+1:]
+			if len(remainder) > 0 {
+				// Check if there's a comment
+				if commentIdx := strings.Index(remainder, "#"); commentIdx != -1 {
+					// Preserve the whitespace before #
+					inlineCommentSpace = remainder[:commentIdx]
+					// Store the comment (including #)
+					inlineComment = remainder[commentIdx:]
 				}
-			} else {
-				// Malformed: no closing quote found, treat as unquoted
-				value = valueAndComment
 			}
 		} else {
-			// Unquoted value - check for inline comment
-			if commentIdx := strings.Index(valueAndComment, "#"); commentIdx != -1 {
-				value = strings.TrimSpace(valueAndComment[:commentIdx])
-				// For unquoted values, preserve spacing before #
-				beforeComment := valueAndComment[:commentIdx]
-				if len(value) < len(beforeComment) {
-					inlineCommentSpace = beforeComment[len(value):]
-				}
-				inlineComment = valueAndComment[commentIdx:]
-			} else {
-				value = valueAndComment
+			// Malformed: no closing quote found, treat as unquoted
+			value = valueAndComment
+		}
+	} else {
+		// Unquoted value - check for inline comment
+		if commentIdx := strings.Index(valueAndComment, "#"); commentIdx != -1 {
+			value = strings.TrimSpace(valueAndComment[:commentIdx])
+			// For unquoted values, preserve spacing before #
+			beforeComment := valueAndComment[:commentIdx]
+			if len(value) < len(beforeComment) {
+				inlineCommentSpace = beforeComment[len(value):]
 			}
+			inlineComment = valueAndComment[commentIdx:]
+		} else {
+			value = valueAndComment
 		}
-
-		entry.Key = key
-		entry.Value = value
-		entry.InlineComment = inlineComment
-		entry.InlineCommentSpace = inlineCommentSpace
-		dict.Entries = append(dict.Entries, entry)
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, err
+	// The line was trimmed to parse it, but an inline comment runs to the
+	// end of the line and keeps its trailing whitespace
+	if inlineComment != "" {
+		inlineComment += original[len(strings.TrimRight(original, " \t")):]
 	}
 
-	return dict, nil
+	entry.Key = key
+	entry.Value = value
+	entry.InlineComment = inlineComment
+	entry.InlineCommentSpace = inlineCommentSpace
+	return entry
 }
 
-// Save saves the dictionary while preserving the original layout
-func (d *Dictionary) Save(filename string) error {
-	file, err := os.Create(filename)
-	if err != nil {
-		return err
+// maxLineSize returns the longest line accepted, set by --max-line-size
+func maxLineSize() int64 {
+	if globalOptions.MaxLineSize == 0 {
+		return defaultMaxLineSize
 	}
-	defer file.Close()
-
-	writer := bufio.NewWriter(file)
-
-	for _, entry := range d.Entries {
-		var line string
-
-		if entry.IsBlank {
-			line = ""
-		} else if entry.IsComment {
-			line = entry.Original
-		} else if entry.Key != "" {
-			// Always quote values for VMX compatibility
-			formattedValue := `"` + escapeQuotes(entry.Value) + `"`
+	return globalOptions.MaxLineSize
+}
 
-			// Rebuild key-value line
-			if strings.Contains(entry.Original, "=") {
-				// Try to preserve the original formatting around the equals sign
-				originalParts := strings.SplitN(entry.Original, "=", 2)
-				keyPart := strings.TrimRight(originalParts[0], " \t")
-				line = keyPart + " = " + formattedValue
-			} else {
-				line = entry.Key + " = " + formattedValue
-			}
+// newLineScanner returns a scanner over the lines of a dictionary file
+func newLineScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, int(min(maxLineSize(), math.MaxInt)))
+	return scanner
+}
 
-			// Append inline comment with exact spacing preserved
-			if entry.InlineComment != "" {
-				line += entry.InlineCommentSpace + entry.InlineComment
-			}
-		} else {
-			line = entry.Original
-		}
+// scanError explains a scanner error after lines lines were read
+func scanError(err error, lines int) error {
+	if errors.Is(err, bufio.ErrTooLong) {
+		return fmt.Errorf("line %d is longer than %s, use --max-line-size to allow longer lines",
+			lines+1, formatBytes(maxLineSize()))
+	}
+	return err
+}
 
-		if _, err := writer.WriteString(line + "\n"); err != nil {
-			return err
+// parseEntries parses the lines of a dictionary file
+func parseEntries(text string) ([]*Entry, error) {
+	var entries []*Entry
+	scanner := newLineScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		if globalOptions.MaxEntries > 0 && len(entries) == globalOptions.MaxEntries {
+			return nil, fmt.Errorf("file has more than %d entries, the limit set by --max-entries", globalOptions.MaxEntries)
 		}
+		entries = append(entries, parseLine(scanner.Text()))
 	}
-
-	return writer.Flush()
+	if err := scanner.Err(); err != nil {
+		return nil, scanError(err, len(entries))
+	}
+	return entries, nil
 }
 
-// escapeQuotes escapes quotes in the value
-func escapeQuotes(value string) string {
-	return strings.ReplaceAll(value, `"`, `\"`)
+// fileSystem is the file access of vmxtool, so that loading, saving and
+// the other file operations can be run against something other than the
+// disk, such as the in-memory file system of the tests. Walking
+// directories, for find, --recursive and glob patterns, uses the disk.
+type fileSystem interface {
+	ReadFile(name string) ([]byte, error)
+	Open(name string) (io.ReadCloser, error)
+	OpenFile(name string, flag int, perm fs.FileMode) (io.WriteCloser, error)
+	CreateTemp(dir, pattern string) (tempFile, error)
+	Rename(oldname, newname string) error
+	Remove(name string) error
+	Chmod(name string, mode fs.FileMode) error
+	MkdirAll(name string, perm fs.FileMode) error
+	Stat(name string) (fs.FileInfo, error)
+	Lstat(name string) (fs.FileInfo, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	EvalSymlinks(name string) (string, error)
 }
 
-// unescapeQuotes removes escape sequences from quotes
-func unescapeQuotes(value string) string {
-	return strings.ReplaceAll(value, `\"`, `"`)
+// tempFile is a temporary file created by a fileSystem
+type tempFile interface {
+	io.WriteCloser
+	Name() string
 }
 
-// findEntryCaseInsensitive finds an entry by key (case-insensitive)
-func (d *Dictionary) findEntryCaseInsensitive(key string) *Entry {
-	lowerKey := strings.ToLower(key)
-	for _, entry := range d.Entries {
-		if strings.ToLower(entry.Key) == lowerKey {
-			return entry
-		}
-	}
-	return nil
+// timeoutError is returned for a file operation that did not finish
+// within --timeout
+type timeoutError struct {
+	Limit time.Duration
 }
 
-// normalizeKeyCase normalizes the key case to use the first encountered case
-func (d *Dictionary) normalizeKeyCase(key string) string {
-	if entry := d.findEntryCaseInsensitive(key); entry != nil {
-		return entry.Key
-	}
-	return key
+func (e timeoutError) Error() string {
+	return fmt.Sprintf("timed out after %v", e.Limit)
 }
 
-// Add adds a new key-value pair (fails if key exists)
-func (d *Dictionary) Add(key, value string) error {
-	if d.KeyExists(key) {
-		return fmt.Errorf("key '%s' already exists", key)
-	}
-
-	entry := &Entry{
-		Original: key + " = " + `"` + escapeQuotes(value) + `"`,
-		Key:      key,
-		Value:    value,
-	}
-	d.Entries = append(d.Entries, entry)
-	return nil
+func (timeoutError) Is(target error) bool {
+	return target == os.ErrDeadlineExceeded
 }
 
-// Set sets a key-value pair (adds or updates)
-func (d *Dictionary) Set(key, value string) {
-	if entry := d.findEntryCaseInsensitive(key); entry != nil {
-		entry.Value = value
-		// Update Original to keep it in sync, preserving inline comment
-		entry.Original = entry.Key + " = " + `"` + escapeQuotes(value) + `"`
-		if entry.InlineComment != "" {
-			entry.Original += entry.InlineCommentSpace + entry.InlineComment
-		}
-		return
+// withTimeout runs a file operation, giving up if it has not finished
+// within --timeout. A read from a hung network mount cannot be
+// interrupted, so an abandoned operation is left blocked in its goroutine
+// until vmxtool exits.
+func withTimeout[T any](op, name string, f func() (T, error)) (T, error) {
+	if globalOptions.Timeout <= 0 {
+		return f()
 	}
 
-	normalizedKey := d.normalizeKeyCase(key)
-	entry := &Entry{
-		Original: normalizedKey + " = " + `"` + escapeQuotes(value) + `"`,
-		Key:      normalizedKey,
-		Value:    value,
+	type result struct {
+		value T
+		err   error
 	}
-	d.Entries = append(d.Entries, entry)
-}
+	done := make(chan result, 1)
+	go func() {
+		value, err := f()
+		done <- result{value, err}
+	}()
 
-// Remove removes a key-value pair
-func (d *Dictionary) Remove(key string) error {
-	for i, entry := range d.Entries {
-		if strings.EqualFold(entry.Key, key) {
-			d.Entries = slices.Delete(d.Entries, i, i+1)
-			return nil
-		}
+	timer := time.NewTimer(globalOptions.Timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-timer.C:
+		var zero T
+		return zero, &fs.PathError{Op: op, Path: name, Err: timeoutError{globalOptions.Timeout}}
 	}
-	return fmt.Errorf("key '%s' does not exist", key)
 }
 
-// Query gets the value for a key
-func (d *Dictionary) Query(key string) (string, error) {
-	if entry := d.findEntryCaseInsensitive(key); entry != nil {
-		return entry.Value, nil
-	}
-	return "", fmt.Errorf("key '%s' does not exist", key)
-}
+// osFileSystem is the fileSystem of the host. Every operation is subject
+// to --timeout.
+type osFileSystem struct{}
 
-// KeyExists checks if a key exists (case-insensitive)
-func (d *Dictionary) KeyExists(key string) bool {
-	return d.findEntryCaseInsensitive(key) != nil
+func (osFileSystem) ReadFile(name string) ([]byte, error) {
+	return withTimeout("read", name, func() ([]byte, error) {
+		return os.ReadFile(name)
+	})
 }
 
-// Print prints all content while preserving layout
-func (d *Dictionary) Print() {
-	for _, entry := range d.Entries {
-		if entry.IsBlank {
-			fmt.Println()
-		} else if entry.IsComment {
-			fmt.Println(entry.Original)
-		} else if entry.Key != "" {
-			formattedValue := `"` + escapeQuotes(entry.Value) + `"`
-			line := fmt.Sprintf("%s = %s", entry.Key, formattedValue)
-			if entry.InlineComment != "" {
-				line += entry.InlineCommentSpace + entry.InlineComment
-			}
-			fmt.Println(line)
-		} else {
-			fmt.Println(entry.Original)
-		}
+func (osFileSystem) Open(name string) (io.ReadCloser, error) {
+	file, err := withTimeout("open", name, func() (*os.File, error) {
+		return os.Open(name)
+	})
+	if err != nil {
+		return nil, err
 	}
+	return timeoutFile{file}, nil
 }
 
-// parseKeyValue parses a KEY=VALUE string
-func parseKeyValue(kv string) (string, string, error) {
-	parts := strings.SplitN(kv, "=", 2)
-	if len(parts) != 2 {
-		return "", "", errors.New("invalid format: expected KEY=VALUE")
-	}
-
-	key := strings.TrimSpace(parts[0])
-	value := strings.TrimSpace(parts[1])
-
-	// Remove quotes if present in input and unescape
-	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
-		value = value[1 : len(value)-1]
-		value = unescapeQuotes(value)
+func (osFileSystem) OpenFile(name string, flag int, perm fs.FileMode) (io.WriteCloser, error) {
+	file, err := withTimeout("open", name, func() (*os.File, error) {
+		return os.OpenFile(name, flag, perm)
+	})
+	if err != nil {
+		return nil, err
 	}
+	return timeoutFile{file}, nil
+}
 
-	if key == "" {
-		return "", "", errors.New("key cannot be empty")
+func (osFileSystem) CreateTemp(dir, pattern string) (tempFile, error) {
+	file, err := withTimeout("open", filepath.Join(dir, pattern), func() (*os.File, error) {
+		return os.CreateTemp(dir, pattern)
+	})
+	if err != nil {
+		return nil, err
 	}
+	return timeoutFile{file}, nil
+}
 
-	return key, value, nil
+func (osFileSystem) Rename(oldname, newname string) error {
+	_, err := withTimeout("rename", newname, func() (struct{}, error) {
+		return struct{}{}, os.Rename(oldname, newname)
+	})
+	return err
 }
 
-// printHelp displays the help message
-func printHelp() {
-	fmt.Println(`A tool to examine and modify VMware VMX configuration files.
+func (osFileSystem) Remove(name string) error {
+	_, err := withTimeout("remove", name, func() (struct{}, error) {
+		return struct{}{}, os.Remove(name)
+	})
+	return err
+}
 
-Available commands:
-    help
-        Prints help.
+func (osFileSystem) Chmod(name string, mode fs.FileMode) error {
+	_, err := withTimeout("chmod", name, func() (struct{}, error) {
+		return struct{}{}, os.Chmod(name, mode)
+	})
+	return err
+}
 
-    version
-        Prints version information.
+func (osFileSystem) MkdirAll(name string, perm fs.FileMode) error {
+	_, err := withTimeout("mkdir", name, func() (struct{}, error) {
+		return struct{}{}, os.MkdirAll(name, perm)
+	})
+	return err
+}
 
-    print FILE
-        Prints the contents of the specified VMX file.
+func (osFileSystem) Stat(name string) (fs.FileInfo, error) {
+	return withTimeout("stat", name, func() (fs.FileInfo, error) {
+		return os.Stat(name)
+	})
+}
 
-    add FILE KEY=VALUE
-        Adds a new entry to the specified VMX file.
-        Fails if the key already exists.
+func (osFileSystem) Lstat(name string) (fs.FileInfo, error) {
+	return withTimeout("lstat", name, func() (fs.FileInfo, error) {
+		return os.Lstat(name)
+	})
+}
 
-    set FILE KEY=VALUE
-        Sets an entry in the specified VMX file, adding it if it does
-        not already exist.
+func (osFileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	return withTimeout("readdir", name, func() ([]fs.DirEntry, error) {
+		return os.ReadDir(name)
+	})
+}
 
-    remove FILE KEY
-        Removes the entry with the specified key from the specified VMX
-        file. Fails if the key does not exist.
+func (osFileSystem) EvalSymlinks(name string) (string, error) {
+	return withTimeout("lstat", name, func() (string, error) {
+		return filepath.EvalSymlinks(name)
+	})
+}
 
-    query FILE KEY
-        Prints the value for the specified key from the specified VMX
-        file. Fails if the key does not exist.`)
+// timeoutFile is an open file whose reads and writes are subject to
+// --timeout. Files that support deadlines, such as pipes, use them. Other
+// reads and writes run under withTimeout on a copy of the caller's
+// buffer, as an abandoned operation may still be using it after Read or
+// Write has returned.
+type timeoutFile struct {
+	file *os.File
 }
 
-// printVersion displays version information
-func printVersion() {
-	fmt.Printf("vmxtool version %s\n", Version)
-	fmt.Printf("Build date: %s\n", BuildDate)
-	fmt.Printf("Commit: %s\n", Commit)
-	fmt.Println("© 2025 David Parsons")
+func (f timeoutFile) Name() string {
+	return f.file.Name()
 }
 
-// run contains the main logic and returns an exit code
-func run() int {
-	if len(os.Args) < 2 {
-		fmt.Println("Error: no command provided")
-		fmt.Println("Use 'vmxtool help' for usage information")
-		return 1
+// deadlineError reports a deadline that expired as withTimeout does
+func (f timeoutFile) deadlineError(err error) error {
+	var pathErr *fs.PathError
+	if errors.Is(err, os.ErrDeadlineExceeded) && errors.As(err, &pathErr) {
+		return &fs.PathError{Op: pathErr.Op, Path: f.file.Name(), Err: timeoutError{globalOptions.Timeout}}
 	}
+	return err
+}
 
-	command := os.Args[1]
+func (f timeoutFile) Read(p []byte) (int, error) {
+	if globalOptions.Timeout <= 0 {
+		return f.file.Read(p)
+	}
+	if f.file.SetReadDeadline(time.Now().Add(globalOptions.Timeout)) == nil {
+		n, err := f.file.Read(p)
+		return n, f.deadlineError(err)
+	}
 
-	switch command {
-	case "help":
-		printHelp()
-		return 0
+	buf := make([]byte, len(p))
+	n, err := withTimeout("read", f.file.Name(), func() (int, error) {
+		return f.file.Read(buf)
+	})
+	copy(p, buf[:n])
+	return n, err
+}
 
-	case "version":
-		printVersion()
-		return 0
+func (f timeoutFile) Write(p []byte) (int, error) {
+	if globalOptions.Timeout <= 0 {
+		return f.file.Write(p)
+	}
+	if f.file.SetWriteDeadline(time.Now().Add(globalOptions.Timeout)) == nil {
+		n, err := f.file.Write(p)
+		return n, f.deadlineError(err)
+	}
 
-	case "print":
-		if len(os.Args) != 3 {
-			fmt.Println("Error: print command requires FILE argument")
-			fmt.Println("Usage: vmxtool print FILE")
-			return 1
-		}
-		filename := os.Args[2]
+	buf := slices.Clone(p)
+	return withTimeout("write", f.file.Name(), func() (int, error) {
+		return f.file.Write(buf)
+	})
+}
 
-		dict, err := LoadDictionary(filename)
-		if err != nil {
-			fmt.Printf("Error loading file: %v\n", err)
-			return 1
-		}
+func (f timeoutFile) Close() error {
+	_, err := withTimeout("close", f.file.Name(), func() (struct{}, error) {
+		return struct{}{}, f.file.Close()
+	})
+	return err
+}
 
-		dict.Print()
-		return 0
+// maxDefaultJobs caps the default of --jobs on hosts with many CPUs
+const maxDefaultJobs = 16
 
-	case "add":
-		if len(os.Args) != 4 {
-			fmt.Println("Error: add command requires FILE and KEY=VALUE arguments")
-			fmt.Println("Usage: vmxtool add FILE KEY=VALUE")
-			return 1
-		}
-		filename := os.Args[2]
-		keyValue := os.Args[3]
+// fileJobs returns the number of files a command run on several files
+// processes at once. Options that print as each file is loaded or saved,
+// or that must stop before the next file is started, keep to one.
+func fileJobs() int {
+	if globalOptions.Verbose || globalOptions.DryRun || globalOptions.FailFast || globalOptions.JSONErrors {
+		return 1
+	}
+	if globalOptions.Jobs == 0 {
+		return min(runtime.NumCPU(), maxDefaultJobs)
+	}
+	return globalOptions.Jobs
+}
 
-		key, value, err := parseKeyValue(keyValue)
-		if err != nil {
-			fmt.Printf("Error: %v\n", err)
-			return 1
+// eachFile calls do for each of names on up to jobs workers and yields
+// each name with its result in the order of names, so that output is the
+// same as processing the files one at a time. No more than jobs files are
+// running or waiting to be yielded at once. Stopping the loop stops
+// further files being started and waits for those already running.
+func eachFile[T any](names []string, jobs int, do func(name string) T) iter.Seq2[string, T] {
+	return func(yield func(string, T) bool) {
+		if jobs < 2 || len(names) < 2 {
+			for _, name := range names {
+				if !yield(name, do(name)) {
+					return
+				}
+			}
+			return
 		}
 
-		dict, err := LoadDictionary(filename)
-		if err != nil {
-			fmt.Printf("Error loading file: %v\n", err)
-			return 1
+		results := make([]T, len(names))
+		done := make([]chan struct{}, len(names))
+		for i := range done {
+			done[i] = make(chan struct{})
 		}
-
-		if dict.KeyExists(key) {
-			existingKey := dict.findEntryCaseInsensitive(key).Key
-			fmt.Printf("Error: key '%s' already exists (as '%s')\n", key, existingKey)
-			return 1
+		next := make(chan int)
+		ahead := make(chan struct{}, jobs)
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+		for range min(jobs, len(names)) {
+			wg.Go(func() {
+				for i := range next {
+					results[i] = do(names[i])
+					close(done[i])
+				}
+			})
 		}
+		go func() {
+			defer close(next)
+			for i := range names {
+				select {
+				case ahead <- struct{}{}:
+				case <-stop:
+					return
+				}
+				select {
+				case next <- i:
+				case <-stop:
+					return
+				}
+			}
+		}()
+		defer wg.Wait()
+		defer close(stop)
 
-		if err := dict.Add(key, value); err != nil {
-			fmt.Printf("Error: %v\n", err)
-			return 1
+		for i, name := range names {
+			<-done[i]
+			if !yield(name, results[i]) {
+				return
+			}
+			<-ahead
 		}
+	}
+}
 
-		if err := dict.Save(filename); err != nil {
-			fmt.Printf("Error saving file: %v\n", err)
-			return 1
-		}
+// loadResult is a file loaded by an eachFile worker
+type loadResult struct {
+	dict *Dictionary
+	err  error
+}
 
-		return 0
+// fileOutput holds the messages of a command run on one of several files
+// at once, to be printed with flush when its turn comes. A nil fileOutput
+// prints them straight away.
+type fileOutput struct {
+	messages []func()
+}
 
-	case "set":
-		if len(os.Args) != 4 {
-			fmt.Println("Error: set command requires FILE and KEY=VALUE arguments")
-			fmt.Println("Usage: vmxtool set FILE KEY=VALUE")
-			return 1
-		}
-		filename := os.Args[2]
-		keyValue := os.Args[3]
+func (o *fileOutput) add(message func()) {
+	if o == nil {
+		message()
+		return
+	}
+	o.messages = append(o.messages, message)
+}
 
-		key, value, err := parseKeyValue(keyValue)
-		if err != nil {
-			fmt.Printf("Error: %v\n", err)
-			return 1
-		}
+func (o *fileOutput) errorf(format string, a ...any) {
+	o.add(func() { errorf(format, a...) })
+}
 
-		dict, err := LoadDictionary(filename)
-		if err != nil {
-			fmt.Printf("Error loading file: %v\n", err)
-			return 1
-		}
+func (o *fileOutput) warnf(format string, a ...any) {
+	o.add(func() { warnf(format, a...) })
+}
 
-		dict.Set(key, value)
+func (o *fileOutput) infof(format string, a ...any) {
+	o.add(func() { infof(format, a...) })
+}
 
-		if err := dict.Save(filename); err != nil {
-			fmt.Printf("Error saving file: %v\n", err)
-			return 1
-		}
+// flush prints the messages held
+func (o *fileOutput) flush() {
+	for _, message := range o.messages {
+		message()
+	}
+	o.messages = nil
+}
 
-		return 0
+// writeFile writes data to the named file through files
+func writeFile(name string, data []byte) error {
+	file, err := files.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o666)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
 
-	case "remove":
-		if len(os.Args) != 4 {
-			fmt.Println("Error: remove command requires FILE and KEY arguments")
-			fmt.Println("Usage: vmxtool remove FILE KEY")
-			return 1
+// replaceFile writes data to a temporary file beside the named file and
+// renames it over the file, so that a failed write never leaves the file
+// half written. The new file gets the permissions of the one it replaces.
+// A symbolic link is written through, replacing the file it points to. A
+// file that does not exist yet is written directly and removed if the
+// write fails, as there is nothing to preserve.
+func replaceFile(name string, data []byte) error {
+	info, err := files.Lstat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		if err := writeFile(name, data); err != nil {
+			files.Remove(name)
+			return err
 		}
-		filename := os.Args[2]
-		key := os.Args[3]
-
-		dict, err := LoadDictionary(filename)
-		if err != nil {
-			fmt.Printf("Error loading file: %v\n", err)
-			return 1
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		if name, err = files.EvalSymlinks(name); err != nil {
+			return err
 		}
-
-		if err := dict.Remove(key); err != nil {
-			fmt.Printf("Error: %v\n", err)
-			return 1
+		if info, err = files.Stat(name); err != nil {
+			return err
 		}
+	}
 
-		if err := dict.Save(filename); err != nil {
-			fmt.Printf("Error saving file: %v\n", err)
-			return 1
-		}
+	out, err := files.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(data); err != nil {
+		out.Close()
+		files.Remove(out.Name())
+		return err
+	}
+	if err := out.Close(); err != nil {
+		files.Remove(out.Name())
+		return err
+	}
+	return renameOver(out.Name(), name, info.Mode().Perm())
+}
 
-		return 0
+// renameOver gives the temporary file temp the permissions perm and
+// renames it to name, removing it if either fails
+func renameOver(temp, name string, perm fs.FileMode) error {
+	if err := files.Chmod(temp, perm); err != nil {
+		files.Remove(temp)
+		return err
+	}
+	if err := files.Rename(temp, name); err != nil {
+		files.Remove(temp)
+		return err
+	}
+	return nil
+}
+
+// files is the fileSystem that dictionaries and the files commands read
+// values from are loaded from and saved to
+var files fileSystem = osFileSystem{}
+
+// lastLoaded is the file LoadDictionary was last called for, which is the
+// file a failure under --json-errors is reported for. It is set by the
+// workers of a command run on several files at once.
+var lastLoaded atomic.Value
+
+// loadedFile returns the file in lastLoaded
+func loadedFile() string {
+	name, _ := lastLoaded.Load().(string)
+	return name
+}
+
+// LoadDictionary loads a dictionary file while preserving layout. A
+// .encoding directive in the file takes precedence over detection; files
+// without one are read as UTF-8 if valid, otherwise as Windows-1252.
+func LoadDictionary(filename string) (*Dictionary, error) {
+	lastLoaded.Store(filename)
+	dict, err := readDictionary(filename)
+	if err != nil {
+		return nil, err
+	}
+	verbosef("Loaded %s: %d lines, %d keys\n", filename, len(dict.Entries), len(dict.Keys()))
+	return dict, nil
+}
+
+// readDictionary is LoadDictionary without the --verbose log, for reading
+// a file again to compare it with what is saved
+func readDictionary(filename string) (*Dictionary, error) {
+	dict := &Dictionary{Filename: filename}
+
+	// The file is examined before it is read, so that a change made while
+	// reading is seen as a conflict when saving rather than missed
+	dict.loaded = statFile(filename)
+	data, err := files.ReadFile(filename)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return dict, nil
+		}
+		return nil, err
+	}
+
+	entries, err := parseEntries(string(data))
+	if err != nil {
+		return nil, err
+	}
+	dict.Entries = entries
+	dict.NoFinalNewline = len(data) > 0 && data[len(data)-1] != '\n'
+
+	// The directive itself is ASCII, so it can be found before decoding
+	if entry := dict.findEntryCaseInsensitive(".encoding"); entry != nil {
+		dict.Encoding = entry.Value
+	} else if !utf8.Valid(data) {
+		dict.Encoding = "windows-1252"
+	}
+
+	if charset, ok := singleByteCharset(dict.Encoding); ok {
+		entries, err := parseEntries(decodeSingleByte(data, charset))
+		if err != nil {
+			return nil, err
+		}
+		dict.Entries = entries
+	}
+
+	if globalOptions.VMwareCompat {
+		dict.VMwareCompat = true
+		for _, entry := range dict.Entries {
+			entry.Value = vmwareUnescape(entry.Value)
+		}
+	}
+	return dict, nil
+}
+
+// line returns the text of the entry as it is written to the file
+func (e *Entry) line() string {
+	if e.IsBlank {
+		return ""
+	}
+	if e.IsComment || e.Key == "" {
+		return e.Original
+	}
+
+	// Always quote values for VMX compatibility
+	formattedValue := `"` + escapeQuotes(e.Value) + `"`
+
+	// Rebuild key-value line
+	var line string
+	if keyEnd := e.keyEnd(); keyEnd != -1 {
+		// Try to preserve the original formatting around the equals sign
+		keyPart := strings.TrimRight(e.Original[:keyEnd], " \t")
+		line = keyPart + " = " + formattedValue
+	} else {
+		line = formatKey(e.Key) + " = " + formattedValue
+	}
+
+	// Append inline comment with exact spacing preserved
+	if e.InlineComment != "" {
+		line += e.InlineCommentSpace + e.InlineComment
+	}
+	return line
+}
+
+// keyEnd returns the index of the = after the key in the original line,
+// skipping any = inside a quoted key, or -1
+func (e *Entry) keyEnd() int {
+	trimmed := strings.TrimLeft(e.Original, " \t")
+	if end := findClosingQuote(trimmed, 1); strings.HasPrefix(trimmed, `"`) && end != -1 {
+		offset := len(e.Original) - len(trimmed) + end + 1
+		if i := strings.Index(e.Original[offset:], "="); i != -1 {
+			return offset + i
+		}
+		return -1
+	}
+	return strings.Index(e.Original, "=")
+}
+
+// render returns the dictionary text while preserving the original layout
+func (d *Dictionary) render() string {
+	if d.VMwareCompat {
+		return d.renderVMware()
+	}
+
+	var sb strings.Builder
+	for _, entry := range d.Entries {
+		sb.WriteString(entry.line() + "\n")
+	}
+	if d.NoFinalNewline {
+		return strings.TrimSuffix(sb.String(), "\n")
+	}
+	return sb.String()
+}
+
+// vmwareEscape escapes a value the way VMware does, writing control
+// characters, quotes, | and # as | followed by two hex digits
+func vmwareEscape(value string) string {
+	var sb strings.Builder
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; c < 0x20 || c == '"' || c == '|' || c == '#' {
+			fmt.Fprintf(&sb, "|%02X", c)
+		} else {
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// vmwareUnescape decodes the |XX escapes written by VMware, leaving any
+// | not followed by two hex digits unchanged
+func vmwareUnescape(value string) string {
+	if !strings.Contains(value, "|") {
+		return value
+	}
+	var sb strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '|' && i+2 < len(value) {
+			if b, err := strconv.ParseUint(value[i+1:i+3], 16, 8); err == nil {
+				sb.WriteByte(byte(b))
+				i += 2
+				continue
+			}
+		}
+		sb.WriteByte(value[i])
+	}
+	return sb.String()
+}
+
+// shebang returns the first line of the file if it is a #! line, or nil.
+// Some generated files start with a marker such as #!/usr/bin/vmware that
+// VMware expects verbatim. It is held as a comment and must stay the first
+// line: SortKeys keeps it in the header and renderVMware, which drops other
+// comments, writes it first.
+func (d *Dictionary) shebang() *Entry {
+	if len(d.Entries) == 0 || !d.Entries[0].IsComment || !strings.HasPrefix(d.Entries[0].Original, "#!") {
+		return nil
+	}
+	return d.Entries[0]
+}
+
+// renderVMware returns the dictionary text in the canonical format VMware
+// writes: .encoding first, then one key = "value" line per key with |XX
+// escapes. Comments and blank lines are dropped, as VMware drops them.
+func (d *Dictionary) renderVMware() string {
+	var sb strings.Builder
+	if shebang := d.shebang(); shebang != nil {
+		sb.WriteString(shebang.Original + "\n")
+	}
+	encoding := d.findEntryCaseInsensitive(".encoding")
+	if encoding != nil {
+		sb.WriteString(formatKey(encoding.Key) + ` = "` + vmwareEscape(encoding.Value) + "\"\n")
+	}
+	for _, entry := range d.Entries {
+		if entry.Key == "" || entry == encoding {
+			continue
+		}
+		sb.WriteString(formatKey(entry.Key) + ` = "` + vmwareEscape(entry.Value) + "\"\n")
+	}
+	return sb.String()
+}
+
+// encode returns the dictionary contents in the file's encoding. If the
+// content cannot be represented in a declared single-byte encoding, the
+// .encoding directive is changed to UTF-8 and a warning is printed.
+func (d *Dictionary) encode() []byte {
+	text := d.render()
+
+	encoding := d.Encoding
+	directive := d.findEntryCaseInsensitive(".encoding")
+	if directive != nil {
+		encoding = directive.Value
+	}
+
+	charset, ok := singleByteCharset(encoding)
+	if !ok {
+		return []byte(text)
+	}
+	if data, ok := encodeSingleByte(text, charset); ok {
+		return data
+	}
+
+	warnf("Warning: content cannot be represented in %s, saving as UTF-8\n", encoding)
+	d.Encoding = "UTF-8"
+	if directive != nil {
+		d.Set(directive.Key, "UTF-8")
+		text = d.render()
+	}
+	return []byte(text)
+}
+
+// Save saves the dictionary while preserving the original layout. The
+// file is replaced as a whole, so a failed save leaves it as it was.
+func (d *Dictionary) Save(filename string) error {
+	data := d.encode()
+	if err := replaceFile(filename, data); err != nil {
+		return err
+	}
+
+	if globalOptions.Verify {
+		return verifySaved(filename, sha256.Sum256(data))
+	}
+	return nil
+}
+
+// verifySaved reads back a file just written, for --verify, and fails if
+// its SHA-256 is not that of the data that was meant to be written
+func verifySaved(filename string, want [sha256.Size]byte) error {
+	file, err := files.Open(filename)
+	if err != nil {
+		return fmt.Errorf("cannot verify %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return fmt.Errorf("cannot verify %s: %w", filename, err)
+	}
+	if got := hash.Sum(nil); !bytes.Equal(got, want[:]) {
+		return fmt.Errorf("%s does not match what was written: SHA-256 is %x, expected %x", filename, got, want)
+	}
+	return nil
+}
+
+// streamMinSize is the file size from which single-key set, remove and
+// query read the file a line at a time instead of loading every entry
+const streamMinSize = 8 * megabyte
+
+// canStream reports whether filename is large enough to be streamed and no
+// option needs the whole file. --vmware-compat and --sort-on-save rework
+// every line, and --timeout and --max-entries go through the loader. A
+// symlink is loaded as usual, as streaming replaces the file rather than
+// writing through the link.
+func canStream(filename string) bool {
+	if globalOptions.VMwareCompat || globalOptions.SortOnSave || globalOptions.Timeout != 0 || globalOptions.MaxEntries != 0 || globalOptions.Journal || globalOptions.DryRun || globalOptions.Verbose {
+		return false
+	}
+	info, err := files.Lstat(filename)
+	return err == nil && info.Mode().IsRegular() && info.Size() >= streamMinSize
+}
+
+// errNoStream stops streaming a file that has to be loaded instead, such
+// as one that is not UTF-8 and so must be decoded as a whole
+var errNoStream = errors.New("file cannot be streamed")
+
+// streamLine parses a line read while streaming
+func streamLine(line string) (*Entry, error) {
+	if !utf8.ValidString(line) {
+		return nil, errNoStream
+	}
+	entry := parseLine(line)
+	if strings.EqualFold(entry.Key, ".encoding") {
+		if _, ok := singleByteCharset(entry.Value); ok {
+			return nil, errNoStream
+		}
+	}
+	return entry, nil
+}
+
+// isASCII reports whether s is plain ASCII, which reads the same whatever
+// the encoding of the file
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// streamQuery looks up key in a large file a line at a time, keeping only
+// the entry found. It returns a dictionary holding that entry, or nil if
+// the file should be loaded instead: it cannot be streamed or does not
+// have the key, which is then reported with suggestions from every key.
+func streamQuery(filename, key string, last bool) (*Dictionary, error) {
+	if !canStream(filename) {
+		return nil, nil
+	}
+	file, err := files.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var found *Entry
+	lines := 0
+	scanner := newLineScanner(file)
+	for scanner.Scan() {
+		lines++
+		entry, err := streamLine(scanner.Text())
+		if err != nil {
+			return nil, nil
+		}
+		if entry.Key == "" || !strings.EqualFold(entry.Key, key) || (found != nil && !last) {
+			continue
+		}
+		found = entry
+		// The rest of the file could still make it decode as another
+		// charset, which only changes non-ASCII values
+		if !last && isASCII(scanner.Text()) {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, scanError(err, lines)
+	}
+	if found == nil {
+		return nil, nil
+	}
+	return &Dictionary{Filename: filename, Entries: []*Entry{found}, LastWins: last}, nil
+}
+
+// lastByteReader records whether anything was read and the last byte
+type lastByteReader struct {
+	r    io.Reader
+	read bool
+	last byte
+}
+
+func (r *lastByteReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.read, r.last = true, p[n-1]
+	}
+	return n, err
+}
+
+// streamRewrite changes one key of a large file a line at a time, without
+// loading every entry. The first entry for key is passed to edit, which
+// changes it or returns false to drop it. If the key is missing, add
+// returns the entry to append, given the key with the casing of the
+// others, or is nil to leave the file to the loader to report. Lines are
+// written as Save writes them, to a temporary file that replaces filename
+// only if something changed. It returns the number of entries for key and
+// whether the file changed, or errNoStream if the file must be loaded to
+// make the change.
+func streamRewrite(filename, key string, edit func(*Entry) bool, add func(key string) *Entry) (int, bool, error) {
+	if err := checkExtension(filename); err != nil {
+		return 0, false, err
+	}
+	if err := checkLock(filename); err != nil {
+		return 0, false, err
+	}
+	// The file is examined before it is read, as LoadDictionary does
+	loaded := statFile(filename)
+	if loaded.err != nil {
+		return 0, false, loaded.err
+	}
+	in, err := files.Open(filename)
+	if err != nil {
+		return 0, false, err
+	}
+	defer in.Close()
+
+	out, err := files.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return 0, false, err
+	}
+	// Once renamed, the temporary file is gone and these do nothing
+	defer files.Remove(out.Name())
+	defer out.Close()
+
+	// Lines are separated as they are written, so that a file without a
+	// final newline keeps it that way, as with NoFinalNewline
+	hash := sha256.New()
+	writer := bufio.NewWriter(io.MultiWriter(out, hash))
+	separator := ""
+	write := func(line string) {
+		writer.WriteString(separator + line)
+		separator = "\n"
+	}
+
+	reader := &lastByteReader{r: in}
+	caser := newKeyCaser(key)
+	matches, changed, lines := 0, false, 0
+	scanner := newLineScanner(reader)
+	for scanner.Scan() {
+		lines++
+		entry, err := streamLine(scanner.Text())
+		if err != nil {
+			return 0, false, err
+		}
+		caser.observe(entry.Key)
+		if entry.Key != "" && strings.EqualFold(entry.Key, key) {
+			matches++
+			if matches == 1 {
+				before := entry.line()
+				if !edit(entry) {
+					changed = true
+					continue
+				}
+				changed = entry.line() != before
+			}
+		}
+		write(entry.line())
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, false, scanError(err, lines)
+	}
+
+	if matches == 0 {
+		if add == nil {
+			return 0, false, errNoStream
+		}
+		write(add(caser.key()).line())
+		changed = true
+	}
+	if !changed {
+		return matches, false, nil
+	}
+	if separator != "" && !(reader.read && reader.last != '\n') {
+		writer.WriteString("\n")
+	}
+
+	if err := writer.Flush(); err != nil {
+		return 0, false, err
+	}
+	if err := out.Close(); err != nil {
+		return 0, false, err
+	}
+	if err := checkConflict(filename, loaded); err != nil {
+		return 0, false, err
+	}
+	if err := backupFile(filename); err != nil {
+		return 0, false, fmt.Errorf("cannot back up %s: %w", filename, err)
+	}
+	if err := renameOver(out.Name(), filename, loaded.info.Mode().Perm()); err != nil {
+		return 0, false, err
+	}
+	if globalOptions.Verify {
+		if err := verifySaved(filename, [sha256.Size]byte(hash.Sum(nil))); err != nil {
+			return 0, false, err
+		}
+	}
+	filesSaved.Add(1)
+	return matches, true, nil
+}
+
+// escapeQuotes escapes quotes in the value
+func escapeQuotes(value string) string {
+	return strings.ReplaceAll(value, `"`, `\"`)
+}
+
+// unescapeQuotes removes escape sequences from quotes
+func unescapeQuotes(value string) string {
+	return strings.ReplaceAll(value, `\"`, `"`)
+}
+
+// findEntryCaseInsensitive finds an entry by key (case-insensitive). If the
+// key is duplicated, the first entry is returned unless LastWins is set.
+func (d *Dictionary) findEntryCaseInsensitive(key string) *Entry {
+	lowerKey := strings.ToLower(key)
+	var found *Entry
+	for _, entry := range d.Entries {
+		if strings.ToLower(entry.Key) == lowerKey {
+			if !d.LastWins {
+				return entry
+			}
+			found = entry
+		}
+	}
+	return found
+}
+
+// normalizeKeyCase normalizes the key case to use the first encountered case.
+// A new key takes the casing of its namespace from the existing keys, so
+// Ethernet0.virtualDev is added as ethernet0.virtualDev to a file that
+// uses ethernet0.
+func (d *Dictionary) normalizeKeyCase(key string) string {
+	if entry := d.findEntryCaseInsensitive(key); entry != nil {
+		return entry.Key
+	}
+
+	caser := newKeyCaser(key)
+	for _, entry := range d.Entries {
+		caser.observe(entry.Key)
+	}
+	return caser.key()
+}
+
+// keyCaser works out the casing of a new key from the existing keys, seen
+// one at a time in file order. Each namespace segment of the key takes the
+// casing of the first existing key that shares the namespace up to it.
+type keyCaser struct {
+	segments []string
+	found    []bool
+}
+
+func newKeyCaser(key string) *keyCaser {
+	segments := strings.Split(key, ".")
+	return &keyCaser{segments: segments, found: make([]bool, len(segments))}
+}
+
+// observe takes the casing of any segments not yet found from an existing key
+func (c *keyCaser) observe(key string) {
+	if key == "" {
+		return
+	}
+	existing := strings.Split(key, ".")
+	for i := range len(c.segments) - 1 {
+		if c.found[i] || len(existing) <= i+1 {
+			continue
+		}
+		if strings.EqualFold(strings.Join(existing[:i+1], "."), strings.Join(c.segments[:i+1], ".")) {
+			c.segments[i] = existing[i]
+			c.found[i] = true
+		}
+	}
+}
+
+// key returns the key with the casing found so far
+func (c *keyCaser) key() string {
+	return strings.Join(c.segments, ".")
+}
+
+// KeyNotFoundError is returned when a key does not exist
+type KeyNotFoundError struct {
+	Key        string
+	Suggestion string // Similar key that does exist, if any
+}
+
+func (e *KeyNotFoundError) Error() string {
+	if e.Suggestion != "" {
+		return fmt.Sprintf("key '%s' does not exist, did you mean '%s'?", e.Key, e.Suggestion)
+	}
+	return fmt.Sprintf("key '%s' does not exist", e.Key)
+}
+
+// notFound returns the error for a key that does not exist, suggesting a
+// similar key from the file or the key table unless --no-suggest is given
+func (d *Dictionary) notFound(key string) *KeyNotFoundError {
+	err := &KeyNotFoundError{Key: key}
+	if globalOptions.NoSuggest {
+		return err
+	}
+
+	candidates := d.Keys()
+	keySegments := strings.Split(key, ".")
+	for _, k := range knownKeys {
+		// Give wildcards the device numbers of the key, so ethernt0 can
+		// suggest ethernet0
+		segments := strings.Split(k.Key, ".")
+		if len(segments) == len(keySegments) {
+			for i, segment := range segments {
+				digits := strings.TrimLeftFunc(keySegments[i], func(r rune) bool { return r < '0' || r > '9' })
+				segments[i] = strings.Replace(segment, "*", cmp.Or(digits, "0"), 1)
+			}
+		}
+		candidates = append(candidates, strings.Join(segments, "."))
+	}
+	if suggestion, ok := closestMatch(key, candidates); ok && !strings.EqualFold(suggestion, key) {
+		err.Suggestion = suggestion
+	}
+	return err
+}
+
+// KeyExistsError is returned when adding a key that already exists
+type KeyExistsError struct {
+	Key string
+}
+
+func (e *KeyExistsError) Error() string {
+	return fmt.Sprintf("key '%s' already exists", e.Key)
+}
+
+// Add adds a new key-value pair (fails if key exists)
+func (d *Dictionary) Add(key, value string) error {
+	if d.KeyExists(key) {
+		return &KeyExistsError{key}
+	}
+
+	d.Entries = append(d.Entries, newEntry(key, value))
+	return nil
+}
+
+// newEntry returns the entry for a new key-value line
+func newEntry(key, value string) *Entry {
+	return &Entry{
+		Original: formatKey(key) + " = " + `"` + escapeQuotes(value) + `"`,
+		Key:      key,
+		Value:    value,
+	}
+}
+
+// Set sets a key-value pair (adds or updates) and reports whether the
+// dictionary was changed
+func (d *Dictionary) Set(key, value string) bool {
+	if entry := d.findEntryCaseInsensitive(key); entry != nil {
+		return entry.setValue(value)
+	}
+
+	d.Entries = append(d.Entries, newEntry(d.normalizeKeyCase(key), value))
+	return true
+}
+
+// setValue changes the value of an entry, reporting whether it changed
+func (e *Entry) setValue(value string) bool {
+	if e.Value == value {
+		return false
+	}
+	e.Value = value
+	// Update Original to keep it in sync, preserving inline comment
+	e.Original = formatKey(e.Key) + " = " + `"` + escapeQuotes(value) + `"`
+	if e.InlineComment != "" {
+		e.Original += e.InlineCommentSpace + e.InlineComment
+	}
+	return true
+}
+
+// SetAll sets a key-value pair like Set, but updates every occurrence of
+// a duplicated key
+func (d *Dictionary) SetAll(key, value string) bool {
+	changed, found := false, false
+	for _, entry := range d.Entries {
+		if entry.Key != "" && strings.EqualFold(entry.Key, key) {
+			changed = entry.setValue(value) || changed
+			found = true
+		}
+	}
+	if !found {
+		return d.Set(key, value)
+	}
+	return changed
+}
+
+// occurrences returns the number of entries for a key
+func (d *Dictionary) occurrences(key string) int {
+	n := 0
+	for _, entry := range d.Entries {
+		if entry.Key != "" && strings.EqualFold(entry.Key, key) {
+			n++
+		}
+	}
+	return n
+}
+
+// SetGrouped sets a key-value pair like Set, but places a new key after the
+// last existing key in the same namespace instead of at the end of the file
+func (d *Dictionary) SetGrouped(key, value string) bool {
+	if d.KeyExists(key) {
+		return d.Set(key, value)
+	}
+
+	namespace := keyNamespace(key)
+	insertAt := -1
+	for i, entry := range d.Entries {
+		if entry.Key != "" && strings.EqualFold(keyNamespace(entry.Key), namespace) {
+			insertAt = i + 1
+		}
+	}
+	if insertAt == -1 {
+		return d.Set(key, value)
+	}
+
+	d.Entries = slices.Insert(d.Entries, insertAt, newEntry(d.normalizeKeyCase(key), value))
+	return true
+}
+
+// Merge applies every key from other to the dictionary, updating existing
+// keys in place. New keys are grouped with their namespace unless
+// appendNew is set. Merge reports whether anything changed.
+func (d *Dictionary) Merge(other *Dictionary, appendNew bool) bool {
+	changed := false
+	for _, entry := range other.Entries {
+		if entry.Key == "" {
+			continue
+		}
+		if appendNew {
+			changed = d.Set(entry.Key, entry.Value) || changed
+		} else {
+			changed = d.SetGrouped(entry.Key, entry.Value) || changed
+		}
+	}
+	return changed
+}
+
+// Remove removes a key-value pair
+func (d *Dictionary) Remove(key string) error {
+	if entry := d.findEntryCaseInsensitive(key); entry != nil {
+		d.removeEntry(entry)
+		return nil
+	}
+	return d.notFound(key)
+}
+
+// Query gets the value for a key
+func (d *Dictionary) Query(key string) (string, error) {
+	if entry := d.findEntryCaseInsensitive(key); entry != nil {
+		return entry.Value, nil
+	}
+	return "", d.notFound(key)
+}
+
+// matchKey reports whether key matches a pattern that may contain * and ?
+// wildcards (case-insensitive)
+func matchKey(pattern, key string) bool {
+	matched, err := path.Match(strings.ToLower(pattern), strings.ToLower(key))
+	return err == nil && matched
+}
+
+// FindMatching returns all entries whose key matches the wildcard pattern
+func (d *Dictionary) FindMatching(pattern string) []*Entry {
+	var matches []*Entry
+	for _, entry := range d.Entries {
+		if entry.Key != "" && matchKey(pattern, entry.Key) {
+			matches = append(matches, entry)
+		}
+	}
+	return matches
+}
+
+// removeEntry removes a specific entry from the dictionary
+func (d *Dictionary) removeEntry(target *Entry) {
+	d.Entries = slices.DeleteFunc(d.Entries, func(entry *Entry) bool {
+		return entry == target
+	})
+}
+
+// QueryOK gets the value for a key, reporting whether the key exists so
+// that an empty value can be told apart from an absent key
+func (d *Dictionary) QueryOK(key string) (string, bool) {
+	if entry := d.findEntryCaseInsensitive(key); entry != nil {
+		return entry.Value, true
+	}
+	return "", false
+}
+
+// renameEntry changes the key of an entry, keeping the layout of its line
+func (d *Dictionary) renameEntry(entry *Entry, newKey string) {
+	if before, after, ok := strings.Cut(entry.Original, "="); ok {
+		if i := strings.Index(before, entry.Key); i != -1 {
+			entry.Original = before[:i] + newKey + before[i+len(entry.Key):] + "=" + after
+		}
+	}
+	entry.Key = newKey
+}
+
+// Disable comments out the entry for a key, keeping its position
+func (d *Dictionary) Disable(key, marker string) error {
+	entry := d.findEntryCaseInsensitive(key)
+	if entry == nil {
+		return d.notFound(key)
+	}
+
+	line := entry.line()
+	trimmed := strings.TrimLeft(line, " \t")
+	indent := line[:len(line)-len(trimmed)]
+	*entry = Entry{Original: indent + marker + " " + trimmed, IsComment: true}
+	return nil
+}
+
+// Enable reactivates a key previously commented out by Disable with the
+// same marker
+func (d *Dictionary) Enable(key, marker string) error {
+	if d.KeyExists(key) {
+		return fmt.Errorf("key '%s' is already enabled", key)
+	}
+
+	for _, entry := range d.Entries {
+		if !entry.IsComment {
+			continue
+		}
+		trimmed := strings.TrimLeft(entry.Original, " \t")
+		indent := entry.Original[:len(entry.Original)-len(trimmed)]
+		if !strings.HasPrefix(trimmed, marker) {
+			continue
+		}
+		parsed := parseLine(indent + strings.TrimLeft(strings.TrimPrefix(trimmed, marker), " \t"))
+		if parsed.Key != "" && strings.EqualFold(parsed.Key, key) {
+			*entry = *parsed
+			return nil
+		}
+	}
+	return fmt.Errorf("no disabled entry for key '%s'", key)
+}
+
+// defaultDisableMarker is the comment marker disable and enable use unless
+// --disable-marker is given
+const defaultDisableMarker = "#"
+
+// FindPrefix returns all entries whose key starts with prefix
+// (case-insensitive)
+func (d *Dictionary) FindPrefix(prefix string) []*Entry {
+	lowerPrefix := strings.ToLower(prefix)
+	var matches []*Entry
+	for _, entry := range d.Entries {
+		if entry.Key != "" && strings.HasPrefix(strings.ToLower(entry.Key), lowerPrefix) {
+			matches = append(matches, entry)
+		}
+	}
+	return matches
+}
+
+// FindSubstring returns the keys containing sub (case-insensitive), in
+// file order with duplicates listed once
+func (d *Dictionary) FindSubstring(sub string) []string {
+	lowerSub := strings.ToLower(sub)
+	var keys []string
+	for _, key := range d.Keys() {
+		if strings.Contains(strings.ToLower(key), lowerSub) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Keys returns the keys in file order, listing duplicates once
+func (d *Dictionary) Keys() []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, entry := range d.Entries {
+		lowerKey := strings.ToLower(entry.Key)
+		if entry.Key == "" || seen[lowerKey] {
+			continue
+		}
+		seen[lowerKey] = true
+		keys = append(keys, entry.Key)
+	}
+	return keys
+}
+
+// KV is a key and its value, as returned by Pairs
+type KV struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Pairs returns every key and value in file order, duplicates included and
+// comments and blank lines left out. The slice is a copy, so changing it
+// does not change the dictionary.
+func (d *Dictionary) Pairs() []KV {
+	pairs := []KV{}
+	for _, entry := range d.Entries {
+		if entry.Key != "" {
+			pairs = append(pairs, KV{entry.Key, entry.Value})
+		}
+	}
+	return pairs
+}
+
+// KeyExists checks if a key exists (case-insensitive)
+func (d *Dictionary) KeyExists(key string) bool {
+	return d.findEntryCaseInsensitive(key) != nil
+}
+
+// Print prints all content while preserving layout
+func (d *Dictionary) Print() {
+	for _, entry := range d.Entries {
+		if entry.IsBlank {
+			fmt.Fprintln(stdout)
+		} else if entry.IsComment {
+			fmt.Fprintln(stdout, entry.Original)
+		} else if entry.Key != "" {
+			formattedValue := `"` + escapeQuotes(entry.Value) + `"`
+			line := fmt.Sprintf("%s = %s", formatKey(entry.Key), formattedValue)
+			if entry.InlineComment != "" {
+				line += entry.InlineCommentSpace + entry.InlineComment
+			}
+			fmt.Fprintln(stdout, line)
+		} else {
+			fmt.Fprintln(stdout, entry.Original)
+		}
+	}
+}
+
+// effectiveValues returns each key once, in the order keys first appear,
+// with the value of its last occurrence as VMware uses
+func (d *Dictionary) effectiveValues() [][2]string {
+	var values [][2]string
+	index := make(map[string]int)
+	for _, entry := range d.Entries {
+		if entry.Key == "" {
+			continue
+		}
+		lower := strings.ToLower(entry.Key)
+		if i, ok := index[lower]; ok {
+			values[i][1] = entry.Value
+			continue
+		}
+		index[lower] = len(values)
+		values = append(values, [2]string{entry.Key, entry.Value})
+	}
+	return values
+}
+
+// formatJSON renders the keys as a JSON object in file order
+func formatJSON(d *Dictionary) string {
+	var sb strings.Builder
+	sb.WriteString("{")
+	for i, kv := range d.effectiveValues() {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		key, _ := json.Marshal(kv[0])
+		value, _ := json.Marshal(kv[1])
+		fmt.Fprintf(&sb, "\n  %s: %s", key, value)
+	}
+	sb.WriteString("\n}\n")
+	return sb.String()
+}
+
+// jsonEntry is a line of the file in the full JSON form written by print
+// --format json --full and read by import. A key line has Key and Value
+// and a comment line only Comment; a blank line has neither.
+type jsonEntry struct {
+	Key     string  `json:"key,omitempty"`
+	Value   *string `json:"value,omitempty"`
+	Comment string  `json:"comment,omitempty"` // the whole comment line, or the inline comment of a key
+}
+
+// formatJSONEntries renders every line of the file, comments and
+// duplicate keys included, as a JSON array in file order
+func formatJSONEntries(d *Dictionary) string {
+	var sb strings.Builder
+	sb.WriteString("[")
+	for i, entry := range d.Entries {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		var item jsonEntry
+		switch {
+		case entry.IsBlank:
+		case entry.IsComment || entry.Key == "":
+			item.Comment = entry.Original
+		default:
+			item.Key = entry.Key
+			item.Value = &entry.Value
+			item.Comment = entry.InlineComment
+		}
+		data, _ := json.Marshal(item)
+		fmt.Fprintf(&sb, "\n  %s", data)
+	}
+	sb.WriteString("\n]\n")
+	return sb.String()
+}
+
+// envName turns a key into a shell variable name by replacing every
+// character that is not a letter, digit or underscore with an underscore
+func envName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '_' || (r >= '0' && r <= '9') || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
+			return r
+		}
+		return '_'
+	}, key)
+	if name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// formatEnv renders the keys as shell variable assignments
+func formatEnv(d *Dictionary) string {
+	var sb strings.Builder
+	for _, kv := range d.effectiveValues() {
+		fmt.Fprintf(&sb, "%s='%s'\n", envName(kv[0]), strings.ReplaceAll(kv[1], "'", `'\''`))
+	}
+	return sb.String()
+}
+
+// formatYAML renders the keys as a YAML mapping with double-quoted values
+func formatYAML(d *Dictionary) string {
+	var sb strings.Builder
+	for _, kv := range d.effectiveValues() {
+		key := kv[0]
+		if strings.ContainsFunc(key, func(r rune) bool { return r == ' ' || r == '#' || r == '"' || r == '\'' }) {
+			key = strconv.Quote(key)
+		}
+		value, _ := json.Marshal(kv[1])
+		fmt.Fprintf(&sb, "%s: %s\n", key, value)
+	}
+	return sb.String()
+}
+
+// printFormat is an output format of the print command. Format is nil for
+// vmx, which prints the file as it is.
+type printFormat struct {
+	Name   string
+	Format func(d *Dictionary) string
+}
+
+// printFormats are the output formats of the print command. Only vmx keeps
+// comments and duplicate keys.
+var printFormats = []printFormat{
+	{"vmx", nil},
+	{"json", formatJSON},
+	{"env", formatEnv},
+	{"yaml", formatYAML},
+}
+
+// runPrint implements the print command
+func runPrint(args []string) int {
+	fs := flag.NewFlagSet("print", flag.ContinueOnError)
+	format := fs.String("format", "vmx", "output format: vmx, json, env or yaml")
+	fs.StringVar(format, "output-format", "vmx", "same as --format")
+	full := fs.Bool("full", false, "with --format json, print every line including comments")
+
+	usage := "Usage: vmxtool print [--format vmx|json|env|yaml] [--full] FILE"
+	positional, err := parseFlags(fs, args)
+	if err == nil && *full && *format != "json" {
+		err = errors.New("--full can only be used with --format json")
+	}
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		errorf("Error: print command requires FILE argument\n")
+		errorln(usage)
+		return exitUsage
+	}
+	filename := positional[0]
+
+	i := slices.IndexFunc(printFormats, func(f printFormat) bool { return f.Name == *format })
+	if i == -1 {
+		errorf("Error: unknown format '%s', expected vmx, json, env or yaml\n", *format)
+		errorln(usage)
+		return exitUsage
+	}
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	if *full {
+		fmt.Fprint(stdout, formatJSONEntries(dict))
+	} else if printFormats[i].Format == nil {
+		dict.Print()
+	} else {
+		fmt.Fprint(stdout, printFormats[i].Format(dict))
+	}
+	return 0
+}
+
+// importData is the structured data read by the import command: either a
+// flat map of keys to values, in the order given, or the full entry form
+type importData struct {
+	Values  [][2]string
+	Entries []jsonEntry
+	Full    bool
+}
+
+// checkImportKeys rejects a flat map that gives the same key twice, which
+// VMware would read as one key, before anything is written
+func checkImportKeys(values [][2]string) error {
+	seen := make(map[string]string)
+	for _, kv := range values {
+		lower := strings.ToLower(kv[0])
+		if first, ok := seen[lower]; ok {
+			return fmt.Errorf("key '%s' is given twice (as '%s' and '%s')", kv[0], first, kv[0])
+		}
+		seen[lower] = kv[0]
+		if err := checkKey(kv[0]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseImportJSON reads a JSON object of string values or an array of
+// entries. The object is read token by token to keep its order and catch
+// duplicate keys, which encoding/json would silently merge.
+func parseImportJSON(data []byte) (*importData, error) {
+	trimmed := bytes.TrimSpace(data)
+	if bytes.HasPrefix(trimmed, []byte("[")) {
+		var entries []jsonEntry
+		if err := json.Unmarshal(trimmed, &entries); err != nil {
+			return nil, err
+		}
+		return &importData{Entries: entries, Full: true}, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(trimmed))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, errors.New("expected a JSON object of keys and values or an array of entries")
+	}
+	result := &importData{}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key := tok.(string)
+		var value string
+		if err := dec.Decode(&value); err != nil {
+			return nil, fmt.Errorf("value of key '%s' is not a string", key)
+		}
+		result.Values = append(result.Values, [2]string{key, value})
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("unexpected data after the JSON object")
+	}
+	return result, nil
+}
+
+// parseYAMLScalar reads a YAML key or value: double-quoted as JSON,
+// single-quoted with a doubled quote for a quote, or plain text up to a
+// comment
+func parseYAMLScalar(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(s, `"`):
+		var value string
+		err := json.Unmarshal([]byte(s), &value)
+		return value, err
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return "", errors.New("unterminated single-quoted string")
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	}
+	if i := strings.Index(s, " #"); i != -1 {
+		s = strings.TrimSpace(s[:i])
+	}
+	return s, nil
+}
+
+// parseImportYAML reads a flat YAML mapping of keys to values, as written
+// by print --format yaml. Nested mappings and sequences are not supported.
+func parseImportYAML(data []byte) (*importData, error) {
+	result := &importData{}
+	for i, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' || strings.HasPrefix(trimmed, "- ") {
+			return nil, fmt.Errorf("line %d: only a flat mapping of keys to values is supported", i+1)
+		}
+
+		// A quoted key may contain a colon, so find the end of it first
+		keyEnd := 0
+		if trimmed[0] == '"' {
+			keyEnd = findClosingQuote(trimmed, 1) + 1
+		} else if trimmed[0] == '\'' {
+			keyEnd = strings.Index(trimmed[1:], "'") + 2
+		}
+		if keyEnd < 0 {
+			return nil, fmt.Errorf("line %d: unterminated quoted key", i+1)
+		}
+		colon := strings.Index(trimmed[keyEnd:], ":")
+		if colon == -1 {
+			return nil, fmt.Errorf("line %d: expected KEY: VALUE", i+1)
+		}
+		key, err := parseYAMLScalar(trimmed[:keyEnd+colon])
+		if err == nil && key == "" {
+			err = errors.New("empty key")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		value, err := parseYAMLScalar(trimmed[keyEnd+colon+1:])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		result.Values = append(result.Values, [2]string{key, value})
+	}
+	return result, nil
+}
+
+// importEntries builds the lines of a file from the full entry form
+func importEntries(items []jsonEntry) ([]*Entry, error) {
+	entries := make([]*Entry, 0, len(items))
+	for i, item := range items {
+		switch {
+		case item.Key == "" && item.Value != nil:
+			return nil, fmt.Errorf("entry %d has a value but no key", i+1)
+		case item.Key == "" && item.Comment == "":
+			entries = append(entries, &Entry{IsBlank: true})
+		case item.Key == "":
+			if !strings.HasPrefix(strings.TrimSpace(item.Comment), "#") {
+				return nil, fmt.Errorf("entry %d: comment line '%s' does not start with #", i+1, item.Comment)
+			}
+			entries = append(entries, &Entry{Original: item.Comment, IsComment: true})
+		default:
+			if err := checkKey(item.Key); err != nil {
+				return nil, fmt.Errorf("entry %d: %v", i+1, err)
+			}
+			if item.Value == nil {
+				return nil, fmt.Errorf("entry %d: key '%s' has no value", i+1, item.Key)
+			}
+			entry := &Entry{Key: item.Key}
+			if item.Comment != "" {
+				if !strings.HasPrefix(item.Comment, "#") {
+					return nil, fmt.Errorf("entry %d: comment of key '%s' does not start with #", i+1, item.Key)
+				}
+				entry.InlineComment = item.Comment
+				entry.InlineCommentSpace = " "
+			}
+			entry.setValue(*item.Value)
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// runImport implements the import command
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	useYAML := fs.Bool("yaml", false, "read DATA as a YAML mapping instead of JSON")
+	noValidate := fs.Bool("no-validate", false, "skip validation of known keys")
+
+	usage := "Usage: vmxtool import [--yaml] [--no-validate] FILE DATA|-"
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	if len(positional) != 2 {
+		errorf("Error: import command requires FILE and DATA arguments\n")
+		errorln(usage)
+		return exitUsage
+	}
+	filename, source := positional[0], positional[1]
+
+	var data []byte
+	if source == "-" {
+		data, err = io.ReadAll(stdin)
+	} else {
+		data, err = files.ReadFile(source)
+	}
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	var imported *importData
+	if *useYAML {
+		imported, err = parseImportYAML(data)
+	} else {
+		imported, err = parseImportJSON(data)
+	}
+	if err == nil && !imported.Full {
+		err = checkImportKeys(imported.Values)
+	}
+	var entries []*Entry
+	if err == nil && imported.Full {
+		entries, err = importEntries(imported.Entries)
+	}
+	if err != nil {
+		errorf("Error: %s: %v\n", source, err)
+		return exitError
+	}
+
+	var keys []string
+	for _, kv := range imported.Values {
+		keys = append(keys, kv[0])
+	}
+	for _, entry := range entries {
+		if entry.Key != "" {
+			keys = append(keys, entry.Key)
+			imported.Values = append(imported.Values, [2]string{entry.Key, entry.Value})
+		}
+	}
+	if !*noValidate {
+		for _, kv := range imported.Values {
+			err := validateKnownValue(kv[0], kv[1])
+			if err == nil && strings.EqualFold(kv[0], "guestOS") {
+				err = validateGuestOS(kv[1])
+			}
+			if err != nil {
+				errorf("Error: %v\n", err)
+				errorf("Use --no-validate to import it anyway\n")
+				return exitError
+			}
+		}
+	}
+	if err := checkSnapshots(nil, filename, keys, false); err != nil {
+		errorf("Error: %v\n", err)
+		return exitError
+	}
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	// The full form replaces the file; the flat map sets each key in place
+	before := dict.render()
+	if imported.Full {
+		dict.Entries = entries
+	} else {
+		for _, kv := range imported.Values {
+			dict.Set(kv[0], kv[1])
+		}
+	}
+
+	if dict.render() == before {
+		return 0
+	}
+
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// renderFuncs are the functions templates can use besides the built-in
+// ones of text/template
+var renderFuncs = template.FuncMap{
+	// default gives fallback if value is unset or empty, as in
+	// {{.mem | default "4096"}}
+	"default": func(fallback, value string) string {
+		return cmp.Or(value, fallback)
+	},
+	"upper":     strings.ToUpper,
+	"macformat": formatMAC,
+}
+
+// formatMAC writes a MAC address given with any of the usual separators,
+// or none, in the lower-case colon form VMware uses
+func formatMAC(s string) (string, error) {
+	digits := strings.Map(func(r rune) rune {
+		if r == ':' || r == '-' || r == '.' {
+			return -1
+		}
+		return r
+	}, s)
+	if _, err := hex.DecodeString(digits); err != nil || len(digits) != 12 {
+		return "", fmt.Errorf("invalid MAC address '%s'", s)
+	}
+	digits = strings.ToLower(digits)
+	pairs := make([]string, 6)
+	for i := range pairs {
+		pairs[i] = digits[2*i : 2*i+2]
+	}
+	return strings.Join(pairs, ":"), nil
+}
+
+// renderLineMarker is written around the number of the template line after
+// each newline of template text while rendering, so that each line of the
+// output can be traced to the template line it starts on
+const renderLineMarker = "\x00"
+
+// markTemplateLines adds the line number of what follows each newline in
+// the text of a parsed template, whose source is text
+func markTemplateLines(node parse.Node, text string) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			markTemplateLines(child, text)
+		}
+	case *parse.IfNode:
+		markTemplateLines(n.List, text)
+		markTemplateLines(n.ElseList, text)
+	case *parse.RangeNode:
+		markTemplateLines(n.List, text)
+		markTemplateLines(n.ElseList, text)
+	case *parse.WithNode:
+		markTemplateLines(n.List, text)
+		markTemplateLines(n.ElseList, text)
+	case *parse.TextNode:
+		// Trim markers such as {{- remove text after Pos, so find where
+		// what is left starts
+		start := int(n.Pos)
+		if i := strings.Index(text[start:], string(n.Text)); i != -1 {
+			start += i
+		}
+		line := 1 + strings.Count(text[:start], "\n")
+		var marked []byte
+		for _, b := range n.Text {
+			marked = append(marked, b)
+			if b == '\n' {
+				line++
+				marked = fmt.Appendf(marked, "%s%d%s", renderLineMarker, line, renderLineMarker)
+			}
+		}
+		n.Text = marked
+	}
+}
+
+// templateFields adds the variables a template uses to used, and those
+// it gives a default for or only tests, as in {{if .mac}}, to defaulted.
+// Fields inside range and with refer to something other than the
+// variables, so only $.NAME counts there.
+func templateFields(node parse.Node, tree *parse.Tree, top bool, used map[string]string, defaulted map[string]bool) {
+	field := func(node parse.Node) (string, bool) {
+		switch n := node.(type) {
+		case *parse.FieldNode:
+			if top && len(n.Ident) == 1 {
+				return n.Ident[0], true
+			}
+		case *parse.VariableNode:
+			if len(n.Ident) == 2 && n.Ident[0] == "$" {
+				return n.Ident[1], true
+			}
+		}
+		return "", false
+	}
+	isDefault := func(cmd *parse.CommandNode) bool {
+		ident, ok := cmd.Args[0].(*parse.IdentifierNode)
+		return ok && ident.Ident == "default"
+	}
+
+	// The condition of if and with may name a variable that is not set
+	condition := func(p *parse.PipeNode) {
+		if p != nil && len(p.Cmds) == 1 && len(p.Cmds[0].Args) == 1 {
+			if name, ok := field(p.Cmds[0].Args[0]); ok {
+				defaulted[name] = true
+			}
+		}
+	}
+
+	var pipe func(p *parse.PipeNode)
+	pipe = func(p *parse.PipeNode) {
+		if p == nil {
+			return
+		}
+		for i, cmd := range p.Cmds {
+			for _, arg := range cmd.Args {
+				if name, ok := field(arg); ok {
+					if _, seen := used[name]; !seen {
+						location, _ := tree.ErrorContext(arg)
+						used[name] = location
+					}
+					if isDefault(cmd) {
+						defaulted[name] = true
+					}
+				}
+				if nested, ok := arg.(*parse.PipeNode); ok {
+					pipe(nested)
+				}
+			}
+			// {{.name | default "x"}} passes .name to default
+			if i+1 < len(p.Cmds) && isDefault(p.Cmds[i+1]) && len(cmd.Args) == 1 {
+				if name, ok := field(cmd.Args[0]); ok {
+					defaulted[name] = true
+				}
+			}
+		}
+	}
+
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			templateFields(child, tree, top, used, defaulted)
+		}
+	case *parse.ActionNode:
+		pipe(n.Pipe)
+	case *parse.TemplateNode:
+		pipe(n.Pipe)
+	case *parse.IfNode:
+		pipe(n.Pipe)
+		condition(n.Pipe)
+		templateFields(n.List, tree, top, used, defaulted)
+		templateFields(n.ElseList, tree, top, used, defaulted)
+	case *parse.RangeNode:
+		pipe(n.Pipe)
+		templateFields(n.List, tree, false, used, defaulted)
+		templateFields(n.ElseList, tree, top, used, defaulted)
+	case *parse.WithNode:
+		pipe(n.Pipe)
+		condition(n.Pipe)
+		templateFields(n.List, tree, false, used, defaulted)
+		templateFields(n.ElseList, tree, top, used, defaulted)
+	}
+}
+
+// missingVariablesError lists the variables a template uses that are not
+// set, each with its location in the template
+type missingVariablesError []string
+
+func (e missingVariablesError) Error() string {
+	return strings.Join(e, "\n")
+}
+
+// renderTemplate runs a template with vars. It returns the output and
+// the template line each output line came from or, unless allowMissing,
+// an error listing every variable that is used without being set or
+// given a default.
+func renderTemplate(name, text string, vars map[string]string, allowMissing bool) (string, []int, error) {
+	tmpl, err := template.New(name).Funcs(renderFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if !allowMissing {
+		used, defaulted := make(map[string]string), make(map[string]bool)
+		for _, t := range tmpl.Templates() {
+			templateFields(t.Tree.Root, t.Tree, true, used, defaulted)
+		}
+		var missing missingVariablesError
+		for name, location := range used {
+			if _, ok := vars[name]; !ok && !defaulted[name] {
+				missing = append(missing, fmt.Sprintf("%s: variable '%s' is not set", location, name))
+			}
+		}
+		if len(missing) > 0 {
+			slices.Sort(missing)
+			return "", nil, missing
+		}
+	}
+
+	for _, t := range tmpl.Templates() {
+		markTemplateLines(t.Tree.Root, text)
+	}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, vars); err != nil {
+		return "", nil, err
+	}
+
+	// A line after a newline in a value has no marker of its own and
+	// belongs to the template line of the value
+	lines := strings.Split(sb.String(), "\n")
+	sources := make([]int, len(lines))
+	source := 1
+	for i, line := range lines {
+		if number, rest, ok := strings.Cut(strings.TrimPrefix(line, renderLineMarker), renderLineMarker); ok && strings.HasPrefix(line, renderLineMarker) {
+			source, _ = strconv.Atoi(number)
+			lines[i] = rest
+		}
+		sources[i] = source
+	}
+	return strings.Join(lines, "\n"), sources, nil
+}
+
+// checkRendered checks that the output of a template is a valid VMX file,
+// reporting problems at the template line that produced them
+func checkRendered(name string, entries []*Entry, sources []int, validate bool) error {
+	seen := make(map[string]int)
+	for i, entry := range entries {
+		where := fmt.Sprintf("%s:%d", name, sources[i])
+		trimmed := strings.TrimSpace(entry.Original)
+		switch {
+		case entry.IsBlank:
+			continue
+		case entry.IsComment && !strings.HasPrefix(trimmed, "#"):
+			return fmt.Errorf("%s: output line %d is not a comment or KEY = VALUE: %s", where, i+1, trimmed)
+		case entry.IsComment:
+			continue
+		}
+		if _, rest, _ := strings.Cut(trimmed, "="); strings.HasPrefix(strings.TrimSpace(rest), `"`) && findClosingQuote(strings.TrimSpace(rest), 1) == -1 {
+			return fmt.Errorf("%s: value of %s has no closing quote", where, entry.Key)
+		}
+		lower := strings.ToLower(entry.Key)
+		if first, ok := seen[lower]; ok {
+			return fmt.Errorf("%s: key '%s' is already set at line %d", where, entry.Key, sources[first])
+		}
+		seen[lower] = i
+		if validate {
+			err := validateKnownValue(entry.Key, entry.Value)
+			if err == nil && strings.EqualFold(entry.Key, "guestOS") {
+				err = validateGuestOS(entry.Value)
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %v", where, err)
+			}
+		}
+	}
+	return nil
+}
+
+// loadRenderVars reads a flat YAML mapping, or a JSON object if the file
+// name ends in .json, of template variables
+func loadRenderVars(filename string) (map[string]string, error) {
+	data, err := files.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var parsed *importData
+	if strings.EqualFold(filepath.Ext(filename), ".json") {
+		parsed, err = parseImportJSON(data)
+	} else {
+		parsed, err = parseImportYAML(data)
+	}
+	if err == nil && parsed.Full {
+		err = errors.New("expected a JSON object of names and values")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	vars := make(map[string]string)
+	for _, kv := range parsed.Values {
+		vars[kv[0]] = kv[1]
+	}
+	return vars, nil
+}
+
+// runRender implements the render command
+func runRender(args []string) int {
+	fs := flag.NewFlagSet("render", flag.ContinueOnError)
+	vars := make(map[string]string)
+	var cliVars [][2]string
+	fs.Func("var", "set template variable NAME=VALUE (repeatable)", func(s string) error {
+		name, value, ok := strings.Cut(s, "=")
+		if !ok || name == "" {
+			return errors.New("expected NAME=VALUE")
+		}
+		cliVars = append(cliVars, [2]string{name, value})
+		return nil
+	})
+	varsFile := fs.String("vars-file", "", "read template variables from a YAML or JSON file")
+	output := fs.String("output", "", "write the result to FILE instead of stdout")
+	overwrite := fs.Bool("overwrite", false, "replace the output file if it exists")
+	allowMissing := fs.Bool("allow-missing", false, "leave variables that are not set empty")
+	noValidate := fs.Bool("no-validate", false, "skip validation of known keys")
+
+	usage := "Usage: vmxtool render TEMPLATE [--var NAME=VALUE]... [--vars-file FILE] [--allow-missing] [--no-validate] [--output FILE [--overwrite]]"
+	positional, err := parseFlags(fs, args)
+	if err == nil && *overwrite && *output == "" {
+		err = errors.New("--overwrite can only be used with --output")
+	}
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		errorf("Error: render command requires TEMPLATE argument\n")
+		errorln(usage)
+		return exitUsage
+	}
+	name := positional[0]
+
+	text, err := files.ReadFile(name)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+	if *varsFile != "" {
+		if vars, err = loadRenderVars(*varsFile); err != nil {
+			errorf("Error loading file: %v\n", err)
+			return exitFileError
+		}
+	}
+	// Variables on the command line override those from the file
+	for _, kv := range cliVars {
+		vars[kv[0]] = kv[1]
+	}
+
+	rendered, sources, err := renderTemplate(filepath.Base(name), string(text), vars, *allowMissing)
+	if err == nil && !utf8.ValidString(rendered) {
+		err = errors.New("output is not valid UTF-8")
+	}
+	if err != nil {
+		for _, line := range strings.Split(err.Error(), "\n") {
+			errorf("Error: %s\n", strings.TrimPrefix(line, "template: "))
+		}
+		if _, ok := err.(missingVariablesError); ok {
+			errorf("Use --var NAME=VALUE to set them, or --allow-missing to leave them empty\n")
+		}
+		return exitError
+	}
+
+	entries, err := parseEntries(rendered)
+	if err == nil {
+		err = checkRendered(filepath.Base(name), entries, sources, !*noValidate)
+	}
+	if err != nil {
+		errorf("Error: %v\n", err)
+		return exitError
+	}
+
+	if *output == "" {
+		fmt.Fprint(stdout, rendered)
+		return 0
+	}
+
+	if _, err := files.Stat(*output); err == nil && !*overwrite {
+		errorf("Error: %s already exists, use --overwrite to replace it\n", *output)
+		return exitError
+	}
+	dict, err := LoadDictionary(*output)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+	dict.Entries = entries
+	dict.NoFinalNewline = rendered != "" && !strings.HasSuffix(rendered, "\n")
+	dict.Encoding = ""
+	if entry := dict.findEntryCaseInsensitive(".encoding"); entry != nil {
+		dict.Encoding = entry.Value
+	}
+	if dict.VMwareCompat {
+		for _, entry := range dict.Entries {
+			entry.Value = vmwareUnescape(entry.Value)
+		}
+	}
+
+	if err := saveDictionary(dict, *output); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+	return 0
+}
+
+// vmwNamespace is the XML namespace of VMware's OVF extensions
+const vmwNamespace = "http://www.vmware.com/schema/ovf"
+
+// ovfConfigKeys maps the vmw:Config keys, which name vSphere settings, to
+// the VMX keys they set. vmw:ExtraConfig keys are VMX keys already.
+var ovfConfigKeys = map[string]string{
+	"firmware":                         "firmware",
+	"bootOptions.efiSecureBootEnabled": "uefi.secureBoot.enabled",
+	"nestedHVEnabled":                  "vhv.enable",
+	"tools.syncTimeWithHost":           "tools.syncTime",
+	"tools.toolsUpgradePolicy":         "tools.upgrade.policy",
+	"virtualICH7MPresent":              "ich7m.present",
+	"virtualSMCPresent":                "smc.present",
+}
+
+// ovfSetting is a key and value from a vmw:Config or vmw:ExtraConfig
+// element. Key is the VMX key, or empty for a vmw:Config key without one.
+type ovfSetting struct {
+	Element string
+	OVFKey  string
+	Key     string
+	Value   string
+}
+
+// parseOVF collects the vmw:Config and vmw:ExtraConfig settings of an OVF
+// descriptor in document order
+func parseOVF(r io.Reader) ([]ovfSetting, error) {
+	var settings []ovfSetting
+	decoder := xml.NewDecoder(r)
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			return settings, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Space != vmwNamespace || (start.Name.Local != "Config" && start.Name.Local != "ExtraConfig") {
+			continue
+		}
+
+		setting := ovfSetting{Element: "vmw:" + start.Name.Local}
+		for _, attr := range start.Attr {
+			if attr.Name.Space != vmwNamespace {
+				continue
+			}
+			switch attr.Name.Local {
+			case "key":
+				setting.OVFKey = attr.Value
+			case "value":
+				setting.Value = attr.Value
+			}
+		}
+		if setting.OVFKey == "" {
+			line, _ := decoder.InputPos()
+			return nil, fmt.Errorf("line %d: %s has no vmw:key", line, setting.Element)
+		}
+
+		setting.Key = setting.OVFKey
+		if start.Name.Local == "Config" {
+			setting.Key = ovfConfigKeys[setting.OVFKey]
+			// vSphere writes booleans in lower case
+			if b, ok := parseBool(setting.Value); ok {
+				setting.Value = strings.ToUpper(strconv.FormatBool(b))
+			}
+		}
+		settings = append(settings, setting)
+	}
+}
+
+// readOVF returns the settings of an OVF file, or of the .ovf member of an
+// OVA, which is a tar archive that starts with it
+func readOVF(filename string) ([]ovfSetting, error) {
+	file, err := files.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if !strings.EqualFold(filepath.Ext(filename), ".ova") {
+		return parseOVF(file)
+	}
+
+	archive := tar.NewReader(file)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil, errors.New("no .ovf descriptor found in the archive")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.EqualFold(path.Ext(header.Name), ".ovf") {
+			return parseOVF(archive)
+		}
+	}
+}
+
+// runOVFExtract implements the ovf-extract command
+func runOVFExtract(args []string) int {
+	fs := flag.NewFlagSet("ovf-extract", flag.ContinueOnError)
+	apply := fs.String("apply", "", "set the extracted keys in this VMX file")
+
+	usage := "Usage: vmxtool ovf-extract FILE.ovf|FILE.ova [--apply TARGET.vmx]"
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		errorf("Error: ovf-extract command requires FILE argument\n")
+		errorln(usage)
+		return exitUsage
+	}
+	filename := positional[0]
+
+	settings, err := readOVF(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	var keys []string
+	for _, s := range settings {
+		if s.Key == "" {
+			warnf("Warning: %s %s has no VMX equivalent, skipped\n", s.Element, s.OVFKey)
+			continue
+		}
+		fmt.Fprintf(stdout, "%s=%s\n", s.Key, s.Value)
+		keys = append(keys, s.Key)
+	}
+
+	if *apply == "" {
+		return 0
+	}
+
+	if err := checkSnapshots(nil, *apply, keys, false); err != nil {
+		errorf("Error: %v\n", err)
+		return exitError
+	}
+
+	dict, err := LoadDictionary(*apply)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	changed := false
+	for _, s := range settings {
+		if s.Key != "" {
+			changed = dict.Set(s.Key, s.Value) || changed
+		}
+	}
+
+	if !changed {
+		return 0
+	}
+
+	if err := saveDictionary(dict, *apply); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// ContentHash returns the SHA-256 of the effective configuration as hex:
+// each key once, in lower case and sorted, with the value VMware uses,
+// |XX escapes decoded. Comments, layout, key order, key case and escaping
+// do not change it, so equivalent files have the same hash.
+func (d *Dictionary) ContentHash() string {
+	values := d.effectiveValues()
+	for i := range values {
+		values[i][0] = strings.ToLower(values[i][0])
+		if !d.VMwareCompat {
+			values[i][1] = vmwareUnescape(values[i][1])
+		}
+	}
+	slices.SortFunc(values, func(a, b [2]string) int { return strings.Compare(a[0], b[0]) })
+	data, _ := json.Marshal(values)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// runChecksum implements the checksum command
+func runChecksum(args []string) int {
+	fs := flag.NewFlagSet("checksum", flag.ContinueOnError)
+	semantic := fs.Bool("semantic", false, "hash the effective keys and values (the default)")
+	raw := fs.Bool("raw", false, "hash the bytes of the file")
+
+	usage := "Usage: vmxtool checksum FILE [--semantic|--raw]"
+	positional, err := parseFlags(fs, args)
+	if err == nil && *semantic && *raw {
+		err = errors.New("--semantic and --raw cannot be used together")
+	}
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		errorf("Error: checksum command requires FILE argument\n")
+		errorln(usage)
+		return exitUsage
+	}
+	filename := positional[0]
+
+	if *raw {
+		data, err := files.ReadFile(filename)
+		if err != nil {
+			errorf("Error loading file: %v\n", err)
+			return exitFileError
+		}
+		sum := sha256.Sum256(data)
+		fmt.Fprintln(stdout, hex.EncodeToString(sum[:]))
+		return 0
+	}
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+	fmt.Fprintln(stdout, dict.ContentHash())
+	return 0
+}
+
+// keyNamespace returns the top-level namespace of a key (the part before
+// the first dot)
+func keyNamespace(key string) string {
+	namespace, _, _ := strings.Cut(key, ".")
+	if namespace == "" {
+		// Keys such as .encoding have no namespace of their own
+		return key
+	}
+	return namespace
+}
+
+// SortKeys sorts the key entries alphabetically (case-insensitive). Lines
+// before the first key, such as a header comment or a #! first line, stay
+// at the top. Every other comment moves with the key that follows it, and
+// comments after the last key stay at the end. Blank lines after the header
+// are removed, as the grouping they marked no longer applies. Duplicate keys
+// keep their relative order.
+func (d *Dictionary) SortKeys() {
+	type block struct {
+		key     string
+		entries []*Entry
+	}
+
+	var header, pending []*Entry
+	var blocks []block
+	for _, entry := range d.Entries {
+		switch {
+		case entry.Key != "":
+			blocks = append(blocks, block{key: strings.ToLower(entry.Key), entries: append(pending, entry)})
+			pending = nil
+		case len(blocks) == 0:
+			header = append(header, entry)
+		case !entry.IsBlank:
+			pending = append(pending, entry)
+		}
+	}
+
+	slices.SortStableFunc(blocks, func(a, b block) int {
+		return strings.Compare(a.key, b.key)
+	})
+
+	entries := header
+	for _, b := range blocks {
+		entries = append(entries, b.entries...)
+	}
+	d.Entries = append(entries, pending...)
+}
+
+// NamespaceCount holds the number of keys in a top-level namespace
+type NamespaceCount struct {
+	Namespace string
+	Count     int
+}
+
+// Namespaces counts keys per top-level namespace (the part before the first
+// dot), sorted by count descending and then by name
+func (d *Dictionary) Namespaces() []NamespaceCount {
+	var counts []NamespaceCount
+	index := make(map[string]int)
+
+	for _, entry := range d.Entries {
+		if entry.Key == "" {
+			continue
+		}
+		namespace := keyNamespace(entry.Key)
+		// Group case-insensitively, keeping the first encountered case
+		lowerNamespace := strings.ToLower(namespace)
+		if i, ok := index[lowerNamespace]; ok {
+			counts[i].Count++
+			continue
+		}
+		index[lowerNamespace] = len(counts)
+		counts = append(counts, NamespaceCount{Namespace: namespace, Count: 1})
+	}
+
+	slices.SortStableFunc(counts, func(a, b NamespaceCount) int {
+		if a.Count != b.Count {
+			return b.Count - a.Count
+		}
+		return strings.Compare(strings.ToLower(a.Namespace), strings.ToLower(b.Namespace))
+	})
+	return counts
+}
+
+// parseKeyValue parses a KEY=VALUE string
+func parseKeyValue(kv string) (string, string, error) {
+	parts := strings.SplitN(kv, "=", 2)
+	if len(parts) != 2 {
+		return "", "", errors.New("invalid format: expected KEY=VALUE")
+	}
+
+	key := strings.TrimSpace(parts[0])
+	value := strings.TrimSpace(parts[1])
+
+	// Remove quotes if present in input and unescape
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+		value = unescapeQuotes(value)
+	}
+
+	if key == "" {
+		return "", "", errors.New("key cannot be empty")
+	}
+	if err := checkKey(key); err != nil {
+		return "", "", err
+	}
+
+	return key, value, nil
+}
+
+// expandEnv substitutes ${NAME} with the value of an environment variable
+// from lookup, for --expand-env. ${NAME:-DEFAULT} gives DEFAULT if NAME is
+// unset or empty, and $$ gives a single $. Any other $ is kept as it is,
+// so that $NAME, which could be part of a password or a Windows path, is
+// never expanded. Every variable that is unset without a default is
+// listed in the error.
+func expandEnv(s string, lookup func(string) (string, bool)) (string, error) {
+	var sb strings.Builder
+	var unset []string
+	for {
+		i := strings.IndexByte(s, '$')
+		if i == -1 || i == len(s)-1 {
+			sb.WriteString(s)
+			break
+		}
+		sb.WriteString(s[:i])
+		s = s[i+1:]
+
+		switch s[0] {
+		case '$':
+			sb.WriteByte('$')
+			s = s[1:]
+			continue
+		case '{':
+		default:
+			sb.WriteByte('$')
+			continue
+		}
+
+		end := strings.IndexByte(s, '}')
+		if end == -1 {
+			return "", fmt.Errorf("no closing } for $%s", s)
+		}
+		name, fallback, hasDefault := strings.Cut(s[1:end], ":-")
+		if !isEnvName(name) {
+			return "", fmt.Errorf("invalid variable name in ${%s}", s[1:end])
+		}
+		s = s[end+1:]
+
+		value, ok := lookup(name)
+		switch {
+		case hasDefault && value == "":
+			value = fallback
+		case !ok && !slices.Contains(unset, name):
+			unset = append(unset, name)
+		}
+		sb.WriteString(value)
+	}
+
+	if len(unset) > 0 {
+		return "", fmt.Errorf("environment variables not set: %s", strings.Join(unset, ", "))
+	}
+	return sb.String(), nil
+}
+
+// isEnvName reports whether name is a valid environment variable name,
+// letters, digits and underscores not starting with a digit
+func isEnvName(name string) bool {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+	return !strings.ContainsFunc(name, func(r rune) bool {
+		return r != '_' && (r < '0' || r > '9') && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z')
+	})
+}
+
+// keyNeedsQuoting reports whether a key cannot be written bare, because it
+// contains whitespace or a character that would end or split the key
+func keyNeedsQuoting(key string) bool {
+	return strings.ContainsAny(key, " \t=#\"")
+}
+
+// formatKey returns a key as it is written to a file, quoted if it needs
+// it so that the line reads back as the same key
+func formatKey(key string) string {
+	if keyNeedsQuoting(key) {
+		return `"` + escapeQuotes(key) + `"`
+	}
+	return key
+}
+
+// checkKey rejects a key given on the command line that would have to be
+// quoted, unless --quote-keys is given
+func checkKey(key string) error {
+	if keyNeedsQuoting(key) && !globalOptions.QuoteKeys {
+		return fmt.Errorf("key '%s' contains whitespace, =, # or a quote, which VMware does not accept; use --quote-keys to write it quoted", key)
+	}
+	return nil
+}
+
+// stdout, stderr and stdin are the standard streams, which the tests
+// replace to capture what a command prints
+var (
+	stdout io.Writer = os.Stdout
+	stderr io.Writer = os.Stderr
+	stdin  io.Reader = os.Stdin
+)
+
+// errorf prints an error, or a hint that goes with one, to stderr. Only
+// the data a command was asked for, such as query values, reports and
+// diffs, is written to stdout, so that output can be piped; errors,
+// warnings and messages about what a command did go to stderr.
+func errorf(format string, a ...any) {
+	if globalOptions.JSONErrors {
+		failure.record(fmt.Sprintf(format, a...), a)
+		return
+	}
+	fmt.Fprintf(stderr, format, a...)
+}
+
+// errorln prints a line of an error, such as a usage line, to stderr
+func errorln(a ...any) {
+	if globalOptions.JSONErrors {
+		failure.record(fmt.Sprintln(a...), a)
+		return
+	}
+	fmt.Fprintln(stderr, a...)
+}
+
+// warnf prints a warning to stderr unless --quiet is given
+func warnf(format string, a ...any) {
+	if globalOptions.JSONErrors {
+		failure.Warnings = append(failure.Warnings, failureLines(fmt.Sprintf(format, a...))...)
+		return
+	}
+	if !globalOptions.Quiet {
+		fmt.Fprintf(stderr, format, a...)
+	}
+}
+
+// infof prints a message about what a command did, such as the keys it
+// changed, to stderr unless --quiet or --json-errors is given
+func infof(format string, a ...any) {
+	if !globalOptions.Quiet && !globalOptions.JSONErrors {
+		fmt.Fprintf(stderr, format, a...)
+	}
+}
+
+// verbosef prints details of loading and saving files to stderr when
+// --verbose is given
+func verbosef(format string, a ...any) {
+	if globalOptions.Verbose && !globalOptions.Quiet && !globalOptions.JSONErrors {
+		fmt.Fprintf(stderr, format, a...)
+	}
+}
+
+// commandFailure is what errorf and warnf collect under --json-errors, to
+// be printed by printFailure as a single JSON object if the command fails
+type commandFailure struct {
+	Err      error    // First error value printed, if any
+	Lines    []string // Error lines printed, less the "Error: " prefix
+	Warnings []string
+}
+
+var failure commandFailure
+
+// record adds a message printed by errorf or errorln with its arguments
+func (f *commandFailure) record(text string, args []any) {
+	if f.Err == nil {
+		for _, arg := range args {
+			if err, ok := arg.(error); ok {
+				f.Err = err
+				break
+			}
+		}
+	}
+	f.Lines = append(f.Lines, failureLines(text)...)
+}
+
+// failureLines splits a message into its lines, without the Error: and
+// Warning: prefixes that the error code and JSON field take the place of
+func failureLines(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		line = strings.TrimPrefix(line, "Error: ")
+		line = strings.TrimPrefix(line, "Warning: ")
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// jsonFailure is the object printed to stderr under --json-errors when a
+// command fails. Error is one of the codes returned by failureCode.
+type jsonFailure struct {
+	Error    string   `json:"error"`
+	Key      string   `json:"key,omitempty"`
+	File     string   `json:"file,omitempty"`
+	Message  string   `json:"message"`
+	Details  []string `json:"details,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+	ExitCode int      `json:"exitCode"`
+}
+
+// failureCode returns the error code for a failure, from the error that
+// was printed where there is one and otherwise from the exit code. These
+// codes are part of the --json-errors output and must not change.
+func failureCode(err error, code int) string {
+	var notFound *KeyNotFoundError
+	var exists *KeyExistsError
+	var timeout timeoutError
+	switch {
+	case errors.As(err, &notFound):
+		return "key_not_found"
+	case errors.As(err, &exists):
+		return "key_exists"
+	case errors.Is(err, errConflict):
+		return "conflict"
+	case errors.Is(err, errLocked):
+		return "locked"
+	case errors.As(err, &timeout):
+		return "timeout"
+	case errors.Is(err, fs.ErrNotExist):
+		return "file_not_found"
+	case errors.Is(err, fs.ErrPermission):
+		return "permission_denied"
+	}
+	switch code {
+	case exitUsage:
+		return "usage"
+	case exitFileError:
+		return "file_error"
+	case exitKeyNotFound:
+		return "key_not_found"
+	case exitKeyExists:
+		return "key_exists"
+	case exitUnchanged:
+		return "unchanged"
+	}
+	return "error"
+}
+
+// printFailure prints the failure collected under --json-errors for a
+// command that returned code
+func printFailure(code int) {
+	f := jsonFailure{
+		Error:    failureCode(failure.Err, code),
+		File:     loadedFile(),
+		Warnings: failure.Warnings,
+		ExitCode: code,
+	}
+	switch {
+	case len(failure.Lines) > 0:
+		f.Message, f.Details = failure.Lines[0], failure.Lines[1:]
+	case code == exitUnchanged:
+		// set --require-change fails without printing anything
+		f.Message = "the value is already set"
+	default:
+		f.Message = fmt.Sprintf("command failed with exit code %d", code)
+	}
+
+	var notFound *KeyNotFoundError
+	var exists *KeyExistsError
+	var pathErr *fs.PathError
+	switch {
+	case errors.As(failure.Err, &notFound):
+		f.Key = notFound.Key
+	case errors.As(failure.Err, &exists):
+		f.Key = exists.Key
+	}
+	if errors.As(failure.Err, &pathErr) {
+		f.File = pathErr.Path
+	}
+
+	data, _ := json.Marshal(f)
+	fmt.Fprintln(stderr, string(data))
+}
+
+// globalOptions holds the options that apply to every command
+var globalOptions struct {
+	SortOnSave   bool
+	MaxLineSize  int64
+	Recursive    bool
+	Quiet        bool
+	Verbose      bool
+	VMwareCompat bool
+	NoSuggest    bool
+	Timeout      time.Duration
+	FailFast     bool
+	Jobs         int
+	MaxEntries   int
+	QuoteKeys    bool
+	Backup       bool
+	BackupDir    string
+	Verify       bool
+	Journal      bool
+	DryRun       bool
+	Diff         bool
+	ExitCode     bool
+	Force        bool
+	JSONErrors   bool
+	RequireVMX   bool
+}
+
+// defaultMaxLineSize is the longest line LoadDictionary accepts unless
+// --max-line-size is given. Lines can be long, for example large nvram or
+// annotation values, but not unbounded.
+const defaultMaxLineSize = 4 * megabyte
+
+// newGlobalFlagSet returns a flag set defining the global options
+func newGlobalFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("vmxtool", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.BoolVar(&globalOptions.SortOnSave, "sort-on-save", false, "sort keys whenever a file is saved")
+	fs.BoolVar(&globalOptions.Recursive, "recursive", false, "run the command on every VMX file under a directory")
+	fs.BoolVar(&globalOptions.Quiet, "quiet", false, "print only errors and the data a command was asked for")
+	fs.BoolVar(&globalOptions.Verbose, "verbose", false, "print each file loaded and each change saved")
+	fs.BoolVar(&globalOptions.VMwareCompat, "vmware-compat", false, "read and write files in VMware's canonical format")
+	fs.BoolVar(&globalOptions.FailFast, "fail-fast", false, "stop at the first file that fails in a --recursive or glob run")
+	fs.BoolVar(&globalOptions.QuoteKeys, "quote-keys", false, "accept keys with whitespace, =, # or quotes and write them quoted")
+	fs.BoolVar(&globalOptions.NoSuggest, "no-suggest", false, "do not suggest similar keys when a key does not exist")
+	fs.BoolVar(&globalOptions.DryRun, "dry-run", false, "print a diff of each file a command would save instead of saving it")
+	fs.BoolVar(&globalOptions.Diff, "diff", false, "with --dry-run, print a unified diff, which it always does")
+	fs.BoolVar(&globalOptions.ExitCode, "exit-code", false, "with --dry-run, exit with 1 if any file would change, which it always does")
+	fs.BoolVar(&globalOptions.Journal, "journal", false, "record the changes to each file so that they can be undone")
+	fs.BoolVar(&globalOptions.RequireVMX, "require-vmx-extension", false, "refuse to save a file whose name does not end in .vmx")
+	fs.BoolVar(&globalOptions.JSONErrors, "json-errors", false, "print a failure as a single JSON object on stderr")
+	fs.BoolVar(&globalOptions.Force, "force", false, "save a file even if another program changed it after it was loaded")
+	fs.BoolVar(&globalOptions.Verify, "verify", false, "read back each saved file and fail if it differs from what was written")
+	fs.BoolVar(&globalOptions.Backup, "backup", false, "copy a file to FILE.bak before saving over it")
+	fs.Func("backup-dir", "keep backups in this directory instead of next to the file", func(s string) error {
+		if s == "" {
+			return errors.New("directory must not be empty")
+		}
+		globalOptions.Backup = true
+		globalOptions.BackupDir = s
+		return nil
+	})
+	fs.Func("max-line-size", "longest line accepted when loading a file", func(s string) error {
+		size, err := parseSize(s, 1)
+		if err != nil {
+			return err
+		}
+		if size == 0 {
+			return errors.New("size must be greater than zero")
+		}
+		globalOptions.MaxLineSize = size
+		return nil
+	})
+	fs.Func("max-entries", "most entries accepted when loading a file", func(s string) error {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		if n < 1 {
+			return errors.New("entries must be at least 1")
+		}
+		globalOptions.MaxEntries = n
+		return nil
+	})
+	fs.Func("jobs", "number of files processed at once when running on several files", func(s string) error {
+		jobs, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		if jobs < 1 {
+			return errors.New("jobs must be at least 1")
+		}
+		globalOptions.Jobs = jobs
+		return nil
+	})
+	fs.Func("timeout", "abort file operations that take longer than this", func(s string) error {
+		timeout, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		if timeout <= 0 {
+			return errors.New("timeout must be greater than zero")
+		}
+		globalOptions.Timeout = timeout
+		return nil
+	})
+	for short, name := range shortFlags {
+		f := fs.Lookup(name)
+		fs.Var(f.Value, short, "short for --"+name)
+	}
+	return fs
+}
+
+// parseGlobalFlags removes global options from args, wherever they appear,
+// and returns the remaining arguments
+func parseGlobalFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var remaining []string
+	var command *command
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			// The command's own flags stop at the terminator too
+			return append(remaining, args[i:]...), nil
+		}
+		if !strings.HasPrefix(arg, "-") {
+			remaining = append(remaining, arg)
+			continue
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		f := fs.Lookup(name)
+		// A flag the command defines itself, such as its own --verbose,
+		// is left to the command
+		if f != nil && len(remaining) > 0 {
+			if command == nil {
+				command = findCommand(remaining[0])
+			}
+			if command != nil && slices.Contains(command.Flags, "--"+name) {
+				f = nil
+			}
+		}
+		if f == nil {
+			remaining = append(remaining, arg)
+			continue
+		}
+		if !hasValue {
+			if isBoolFlag(f) {
+				value = "true"
+			} else if i+1 < len(args) {
+				i++
+				value = args[i]
+			} else {
+				return nil, fmt.Errorf("flag needs an argument: %s", arg)
+			}
+		}
+		if err := setGlobal(fs, name, value, "command line"); err != nil {
+			return nil, fmt.Errorf("invalid value \"%s\" for flag %s: %v", value, arg, err)
+		}
+	}
+	return remaining, nil
+}
+
+// shortFlags are the one-letter global options, each the same as the long
+// option it names
+var shortFlags = map[string]string{"q": "quiet", "v": "verbose"}
+
+// settingSource records where a global option was set and the value it
+// was given, which options defined with Func do not keep, for config show
+type settingSource struct {
+	Source string
+	Value  string
+}
+
+var settingSources = map[string]settingSource{}
+
+// globalFlags is the flag set the global options were parsed with
+var globalFlags *flag.FlagSet
+
+// setGlobal sets a global option on fs, recording where it was set
+func setGlobal(fs *flag.FlagSet, name, value, source string) error {
+	if err := fs.Set(name, value); err != nil {
+		return err
+	}
+	if f := fs.Lookup(name); isBoolFlag(f) {
+		value = f.Value.String()
+	}
+	settingSources[cmp.Or(shortFlags[name], name)] = settingSource{source, value}
+	return nil
+}
+
+// configPath returns the path of the config file and whether it was given
+// by VMXTOOL_CONFIG, in which case it must exist. The default follows the
+// XDG convention on every platform, so that one file works everywhere.
+func configPath() (string, bool) {
+	if path := os.Getenv("VMXTOOL_CONFIG"); path != "" {
+		return path, true
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", false
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "vmxtool", "config.toml"), false
+}
+
+// configSetting is an option set by the config file. Command is empty for
+// a global option, or the command whose table sets one of its flags.
+type configSetting struct {
+	Command string
+	Name    string
+	Value   string
+}
+
+// parseConfig parses a config file, a subset of TOML with one KEY = VALUE
+// per line and [COMMAND] tables. It returns the settings in file order
+// with their values as they would be given on the command line.
+func parseConfig(text string) ([]configSetting, error) {
+	var settings []configSetting
+	seen := make(map[configSetting]bool)
+	table := ""
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			header, rest, ok := strings.Cut(line[1:], "]")
+			if rest = strings.TrimSpace(rest); rest != "" && !strings.HasPrefix(rest, "#") {
+				ok = false
+			}
+			header = strings.TrimSpace(header)
+			if !ok || header == "" || strings.ContainsAny(header, "[]\"'.") {
+				return nil, fmt.Errorf("line %d: expected [COMMAND]", i+1)
+			}
+			table = header
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("line %d: expected KEY = VALUE", i+1)
+		}
+		if seen[configSetting{Command: table, Name: key}] {
+			return nil, fmt.Errorf("line %d: %s is set more than once", i+1, key)
+		}
+		seen[configSetting{Command: table, Name: key}] = true
+
+		value, err := parseConfigValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %v", i+1, key, err)
+		}
+		settings = append(settings, configSetting{table, key, value})
+	}
+	return settings, nil
+}
+
+// parseConfigValue parses a TOML string, boolean or integer, followed by
+// an optional comment
+func parseConfigValue(text string) (string, error) {
+	var value, rest string
+	switch {
+	case strings.HasPrefix(text, `"`):
+		end := findClosingQuote(text, 1)
+		if end == -1 {
+			return "", errors.New("string has no closing quote")
+		}
+		unquoted, err := strconv.Unquote(text[:end+1])
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", text[:end+1])
+		}
+		value, rest = unquoted, text[end+1:]
+	case strings.HasPrefix(text, "'"):
+		end := strings.IndexByte(text[1:], '\'')
+		if end == -1 {
+			return "", errors.New("string has no closing quote")
+		}
+		value, rest = text[1:end+1], text[end+2:]
+	default:
+		value, rest, _ = strings.Cut(text, "#")
+		value = strings.TrimSpace(value)
+		rest = ""
+		if _, err := strconv.ParseInt(strings.ReplaceAll(value, "_", ""), 10, 64); err == nil {
+			value = strings.ReplaceAll(value, "_", "")
+		} else if value != "true" && value != "false" {
+			return "", fmt.Errorf("invalid value '%s', strings must be quoted", value)
+		}
+	}
+	if rest = strings.TrimSpace(rest); rest != "" && !strings.HasPrefix(rest, "#") {
+		return "", fmt.Errorf("unexpected '%s' after the value", rest)
+	}
+	return value, nil
+}
+
+// commandSettings are the flags of commands set by the config file, which
+// parseFlags applies before parsing the command line
+var commandSettings []configSetting
+
+// loadConfig applies the global options set by the config file to fs and
+// keeps the flags it sets for commands in commandSettings. A missing
+// default config file is not an error.
+func loadConfig(fs *flag.FlagSet) error {
+	path, required := configPath()
+	if path == "" {
+		return nil
+	}
+	data, err := files.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot read config file: %w", err)
+	}
+
+	settings, err := parseConfig(string(data))
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	for _, setting := range settings {
+		if setting.Command != "" {
+			command := findCommand(setting.Command)
+			switch {
+			case command == nil || command.Hidden:
+				return fmt.Errorf("config file %s: unknown command '%s'", path, setting.Command)
+			case !slices.Contains(command.Flags, "--"+setting.Name):
+				return fmt.Errorf("config file %s: unknown option '%s' for the %s command", path, setting.Name, setting.Command)
+			}
+			commandSettings = append(commandSettings, setting)
+			continue
+		}
+		name, value := setting.Name, setting.Value
+		f := fs.Lookup(name)
+		if f == nil || shortFlags[name] != "" {
+			return fmt.Errorf("config file %s: unknown option '%s'", path, name)
+		}
+		if isBoolFlag(f) && value != "true" && value != "false" {
+			return fmt.Errorf("config file %s: %s must be true or false", path, name)
+		}
+		if err := setGlobal(fs, name, value, "config file"); err != nil {
+			return fmt.Errorf("config file %s: invalid value \"%s\" for %s: %v", path, value, name, err)
+		}
+	}
+	return nil
+}
+
+// optionEnvName returns the environment variable that sets a global
+// option, such as VMXTOOL_BACKUP_DIR for --backup-dir
+func optionEnvName(name string) string {
+	return "VMXTOOL_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// loadEnvironment applies the global options set by VMXTOOL_ environment
+// variables to fs
+func loadEnvironment(fs *flag.FlagSet) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(optionEnvName(f.Name))
+		if !ok || err != nil || shortFlags[f.Name] != "" {
+			return
+		}
+		if e := setGlobal(fs, f.Name, value, "environment"); e != nil {
+			err = fmt.Errorf("invalid value \"%s\" for %s: %v", value, optionEnvName(f.Name), e)
+		}
+	})
+	return err
+}
+
+// isBoolFlag reports whether f takes no value on the command line
+func isBoolFlag(f *flag.Flag) bool {
+	boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && boolFlag.IsBoolFlag()
+}
+
+// runConfig implements the config command
+func runConfig(args []string) int {
+	args, err := parseArgs("config", args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool config show\n")
+		return exitUsage
+	}
+	if len(args) != 1 || args[0] != "show" {
+		errorf("Error: config command requires show subcommand\n")
+		errorf("Usage: vmxtool config show\n")
+		return exitUsage
+	}
+
+	path, _ := configPath()
+	if _, err := files.Stat(path); err != nil {
+		path += " (not found)"
+	}
+	fmt.Fprintf(stdout, "Config file: %s\n\n", path)
+
+	fmt.Fprintf(stdout, "%-16s %-12s %s\n", "OPTION", "VALUE", "SOURCE")
+	globalFlags.VisitAll(func(f *flag.Flag) {
+		if shortFlags[f.Name] != "" {
+			return
+		}
+		source, ok := settingSources[f.Name]
+		if !ok {
+			source = settingSource{"default", f.DefValue}
+		}
+		fmt.Fprintf(stdout, "%-16s %-12s %s\n", f.Name, cmp.Or(source.Value, "-"), source.Source)
+	})
+	for _, setting := range commandSettings {
+		fmt.Fprintf(stdout, "%-16s %-12s %s\n", "["+setting.Command+"] "+setting.Name, cmp.Or(setting.Value, "-"), "config file")
+	}
+	return 0
+}
+
+// saveDictionary saves a dictionary after applying the global options
+// that affect how files are written
+func saveDictionary(dict *Dictionary, filename string) error {
+	if err := checkExtension(filename); err != nil {
+		return err
+	}
+	if globalOptions.SortOnSave {
+		dict.SortKeys()
+	}
+	if globalOptions.DryRun {
+		return previewDryRun(dict, filename)
+	}
+	if err := checkLock(filename); err != nil {
+		return err
+	}
+
+	var changes []journalChange
+	if globalOptions.Journal || globalOptions.Verbose {
+		saved, err := readDictionary(filename)
+		if err != nil {
+			return err
+		}
+		changes = journalChanges(saved, dict)
+	}
+	if filename == dict.Filename {
+		if err := checkConflict(filename, dict.loaded); err != nil {
+			return err
+		}
+	}
+	if err := backupFile(filename); err != nil {
+		return fmt.Errorf("cannot back up %s: %w", filename, err)
+	}
+	if err := dict.Save(filename); err != nil {
+		return err
+	}
+	filesSaved.Add(1)
+	if filename == dict.Filename {
+		dict.loaded = statFile(filename)
+	}
+	for _, change := range changes {
+		switch {
+		case change.Old == nil:
+			verbosef("  added %s = \"%s\"\n", change.Key, *change.New)
+		case change.New == nil:
+			verbosef("  removed %s = \"%s\"\n", change.Key, *change.Old)
+		default:
+			verbosef("  changed %s = \"%s\" (was \"%s\")\n", change.Key, *change.New, *change.Old)
+		}
+	}
+	verbosef("Saved %s\n", filename)
+	if !globalOptions.Journal {
+		return nil
+	}
+	if err := appendJournal(filename, changes); err != nil {
+		return fmt.Errorf("cannot write journal of %s: %w", filename, err)
+	}
+	return nil
+}
+
+// checkExtension refuses a file whose name does not end in .vmx under
+// --require-vmx-extension, to guard against saving over a disk
+// descriptor or other file named by mistake
+func checkExtension(filename string) error {
+	if globalOptions.RequireVMX && !strings.EqualFold(filepath.Ext(filename), ".vmx") {
+		return fmt.Errorf("%s is not a .vmx file, use --require-vmx-extension=false to change it anyway", filename)
+	}
+	return nil
+}
+
+// errConflict is returned when saving a file that another program, such
+// as VMware itself, changed after vmxtool loaded it. Saving would
+// silently undo that program's change.
+var errConflict = errors.New("conflict")
+
+// checkConflict returns errConflict if filename is no longer the version
+// that was loaded, unless --force is given. The file is compared by
+// identity, modification time and size, which catches VMware saving the
+// file while a command runs; a change within the resolution of the file
+// system clock that keeps the size is not seen.
+func checkConflict(filename string, loaded fileState) error {
+	if globalOptions.Force {
+		return nil
+	}
+	if current := statFile(filename); !current.same(loaded) {
+		return fmt.Errorf("%w: %s was changed by another program after it was loaded, use --force to save over the change", errConflict, filename)
+	}
+	return nil
+}
+
+// errLocked is returned when saving a file that a running VM holds open.
+// VMware keeps the file in memory and writes it back when the VM stops,
+// undoing the change.
+var errLocked = errors.New("locked")
+
+// lockSuffix is appended to a file name by VMware to name the directory
+// that holds its locks while the VM runs
+const lockSuffix = ".lck"
+
+// checkLock returns errLocked if filename has a lock directory, unless
+// --force is given
+func checkLock(filename string) error {
+	if globalOptions.Force {
+		return nil
+	}
+	if _, err := files.Stat(filename + lockSuffix); err == nil {
+		return fmt.Errorf("%w: %s is in use by a running VM, as %s exists; stop the VM or use --force to save anyway", errLocked, filename, filename+lockSuffix)
+	}
+	return nil
+}
+
+// dryRunChanged records that the global --dry-run found a file that would
+// have changed, for --exit-code
+var dryRunChanged bool
+
+// previewDryRun prints, in place of saving a file under the global
+// --dry-run, a unified diff from the saved file to what would be written
+func previewDryRun(dict *Dictionary, filename string) error {
+	saved, err := readDictionary(filename)
+	if err != nil {
+		return err
+	}
+	before, after := saved.render(), dict.render()
+	if before == after {
+		return nil
+	}
+	fmt.Fprint(stdout, unifiedDiff(filename, filename, before, after))
+	dryRunChanged = true
+	filesSaved.Add(1)
+	return nil
+}
+
+// journalSuffix is appended to a file name to name its change journal
+const journalSuffix = ".vmxtool-journal"
+
+// journalChange is the change to one key by a command. Old is nil for a
+// key that was added and New is nil for one that was removed. Text is the
+// line that held a changed or removed key, and Line its line number for a
+// removed key, so that undo can put the line back as it was.
+type journalChange struct {
+	Key  string  `json:"key"`
+	Old  *string `json:"old,omitempty"`
+	New  *string `json:"new,omitempty"`
+	Text string  `json:"text,omitempty"`
+	Line int     `json:"line,omitempty"`
+}
+
+// journalRecord is a line of the change journal, written for each save
+// that changed a key when --journal is given
+type journalRecord struct {
+	Time    time.Time       `json:"time"`
+	Command []string        `json:"command"`
+	Changes []journalChange `json:"changes"`
+}
+
+// journalCommand is the command being run, recorded in the journal
+var journalCommand []string
+
+// journalChanges returns the keys whose effective value differs between
+// two versions of a file: changed and added keys in the order of after,
+// then removed keys
+func journalChanges(before, after *Dictionary) []journalChange {
+	old := make(map[string]string)
+	for _, kv := range before.effectiveValues() {
+		old[strings.ToLower(kv[0])] = kv[1]
+	}
+	// The last occurrence of each key, whose value is the effective one
+	lines := make(map[string]int)
+	for i, entry := range before.Entries {
+		if entry.Key != "" {
+			lines[strings.ToLower(entry.Key)] = i
+		}
+	}
+
+	var changes []journalChange
+	seen := make(map[string]bool)
+	for _, kv := range after.effectiveValues() {
+		lower := strings.ToLower(kv[0])
+		seen[lower] = true
+		value, ok := old[lower]
+		switch {
+		case !ok:
+			changes = append(changes, journalChange{Key: kv[0], New: &kv[1]})
+		case value != kv[1]:
+			text := before.Entries[lines[lower]].Original
+			changes = append(changes, journalChange{Key: kv[0], Old: &value, New: &kv[1], Text: text})
+		}
+	}
+	for _, kv := range before.effectiveValues() {
+		lower := strings.ToLower(kv[0])
+		if !seen[lower] {
+			i := lines[lower]
+			changes = append(changes, journalChange{Key: kv[0], Old: &kv[1], Text: before.Entries[i].Original, Line: i + 1})
+		}
+	}
+	return changes
+}
+
+// journaledEntry returns the entry for the line a journal change recorded
+// for its key, or nil if there is none or it no longer holds the old value
+func (change journalChange) journaledEntry() *Entry {
+	if change.Text == "" || change.Old == nil {
+		return nil
+	}
+	entry := parseLine(change.Text)
+	if !strings.EqualFold(entry.Key, change.Key) || entry.Value != *change.Old {
+		return nil
+	}
+	return entry
+}
+
+// appendJournal adds a record of changes to the journal of filename
+func appendJournal(filename string, changes []journalChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(journalRecord{time.Now(), journalCommand, changes})
+	if err != nil {
+		return err
+	}
+	file, err := files.OpenFile(filename+journalSuffix, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// readJournal returns the records of the journal of filename, oldest first
+func readJournal(filename string) ([]journalRecord, error) {
+	data, err := files.ReadFile(filename + journalSuffix)
+	if err != nil {
+		return nil, err
+	}
+	var records []journalRecord
+	for i, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var record journalRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			return nil, fmt.Errorf("%s line %d: %v", filename+journalSuffix, i+1, err)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// formatCommand joins the arguments of a command, quoting those that the
+// shell would split or expand
+func formatCommand(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = arg
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'$*?\\") {
+			quoted[i] = strconv.Quote(arg)
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// revertRecord undoes the changes of a journal record in dict, first
+// checking that every key still has the value the record left it with
+func revertRecord(dict *Dictionary, record journalRecord) error {
+	dict.LastWins = true
+	for _, change := range record.Changes {
+		current, ok := dict.QueryOK(change.Key)
+		switch {
+		case change.New == nil && ok:
+			return fmt.Errorf("%s was removed by '%s' but is now \"%s\"", change.Key, formatCommand(record.Command), current)
+		case change.New != nil && !ok:
+			return fmt.Errorf("%s was set to \"%s\" by '%s' but no longer exists", change.Key, *change.New, formatCommand(record.Command))
+		case change.New != nil && current != *change.New:
+			return fmt.Errorf("%s was set to \"%s\" by '%s' but is now \"%s\"", change.Key, *change.New, formatCommand(record.Command), current)
+		}
+	}
+
+	var removed []journalChange
+	for _, change := range slices.Backward(record.Changes) {
+		switch {
+		case change.Old == nil:
+			for dict.KeyExists(change.Key) {
+				dict.Remove(change.Key)
+			}
+		case change.New == nil:
+			removed = append(removed, change)
+		default:
+			dict.SetAll(change.Key, *change.Old)
+			// A key that appears once gets its old line back, with the
+			// spacing and inline comment it had
+			if entry := change.journaledEntry(); entry != nil && dict.occurrences(change.Key) == 1 {
+				*dict.findEntryCaseInsensitive(change.Key) = *entry
+			}
+		}
+	}
+
+	// Removed keys go back at their old lines, earliest first so that
+	// each line number counts the lines restored before it
+	for _, change := range slices.Backward(removed) {
+		entry := change.journaledEntry()
+		if entry == nil || change.Line < 1 {
+			dict.Set(change.Key, *change.Old)
+			continue
+		}
+		dict.Entries = slices.Insert(dict.Entries, min(change.Line-1, len(dict.Entries)), entry)
+	}
+	return nil
+}
+
+// runUndo implements the undo command
+func runUndo(args []string) int {
+	fs := flag.NewFlagSet("undo", flag.ContinueOnError)
+	steps := fs.Int("steps", 1, "number of journal records to undo")
+
+	usage := "Usage: vmxtool undo FILE [--steps N]"
+	positional, err := parseFlags(fs, args)
+	if err == nil && *steps < 1 {
+		err = errors.New("steps must be at least 1")
+	}
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		errorf("Error: undo command requires FILE argument\n")
+		errorln(usage)
+		return exitUsage
+	}
+	filename := positional[0]
+
+	records, err := readJournal(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		errorf("Error: %s has no journal, changes are only recorded with --journal\n", filename)
+		return exitError
+	}
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+	if *steps > len(records) {
+		errorf("Error: the journal of %s has only %d changes\n", filename, len(records))
+		return exitError
+	}
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	before := dict.render()
+	remaining := records[:len(records)-*steps]
+	for _, record := range slices.Backward(records[len(remaining):]) {
+		if err := revertRecord(dict, record); err != nil {
+			errorf("Error: %s changed since it was journaled: %v\n", filename, err)
+			errorln("Nothing was undone")
+			return exitError
+		}
+		infof("Undid %s\n", formatCommand(record.Command))
+	}
+
+	// Undoing is not itself journaled, the reverted records are dropped
+	globalOptions.Journal = false
+	if dict.render() != before {
+		if err := saveDictionary(dict, filename); err != nil {
+			errorf("Error saving file: %v\n", err)
+			return exitFileError
+		}
+	}
+	if globalOptions.DryRun {
+		return 0
+	}
+
+	if len(remaining) == 0 {
+		err = files.Remove(filename + journalSuffix)
+	} else {
+		var sb strings.Builder
+		for _, record := range remaining {
+			data, _ := json.Marshal(record)
+			sb.Write(append(data, '\n'))
+		}
+		err = writeFile(filename+journalSuffix, []byte(sb.String()))
+	}
+	if err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// runHistory implements the history command
+func runHistory(args []string) int {
+	args, err := parseArgs("history", args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool history FILE\n")
+		return exitUsage
+	}
+	if len(args) != 1 {
+		errorf("Error: history command requires FILE argument\n")
+		errorf("Usage: vmxtool history FILE\n")
+		return exitUsage
+	}
+	filename := args[0]
+
+	records, err := readJournal(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		infof("%s has no journal, changes are only recorded with --journal\n", filename)
+		return 0
+	}
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	// Steps count back from the latest change, as undo --steps does
+	for i, record := range records {
+		fmt.Fprintf(stdout, "%d  %s  %s\n", len(records)-i, record.Time.Local().Format("2006-01-02 15:04:05"), formatCommand(record.Command))
+		for _, change := range record.Changes {
+			switch {
+			case change.Old == nil:
+				fmt.Fprintf(stdout, "    + %s = \"%s\"\n", change.Key, *change.New)
+			case change.New == nil:
+				fmt.Fprintf(stdout, "    - %s (was \"%s\")\n", change.Key, *change.Old)
+			default:
+				fmt.Fprintf(stdout, "    %s: \"%s\" -> \"%s\"\n", change.Key, *change.Old, *change.New)
+			}
+		}
+	}
+	return 0
+}
+
+// backupFile copies filename, before it is overwritten, to filename.bak
+// or, with --backup-dir, into that directory under its absolute path with
+// a timestamp, so that backups of files with the same name never collide.
+// A file that does not exist yet has nothing to back up. The backup has
+// the permissions of the file, so that it exposes no more than the file.
+func backupFile(filename string) error {
+	if !globalOptions.Backup {
+		return nil
+	}
+	info, err := files.Stat(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	perm := info.Mode().Perm()
+	in, err := files.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	name := filename + ".bak"
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if globalOptions.BackupDir != "" {
+		abs, err := filepath.Abs(filename)
+		if err != nil {
+			return err
+		}
+		// The volume name of a Windows path, such as C:, becomes C
+		rel := strings.TrimSuffix(filepath.VolumeName(abs), ":") + abs[len(filepath.VolumeName(abs)):]
+		name = filepath.Join(globalOptions.BackupDir, rel) + "." + time.Now().Format("20060102-150405") + ".bak"
+		if err := files.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			return err
+		}
+		// Never overwrite an earlier backup taken in the same second
+		flags = os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	}
+
+	base := strings.TrimSuffix(name, ".bak")
+	for n := 1; ; n++ {
+		out, err := files.OpenFile(name, flags, perm)
+		if errors.Is(err, fs.ErrExist) {
+			name = fmt.Sprintf("%s-%d.bak", base, n)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		// An existing backup keeps its mode when truncated, and a new one
+		// is created with the umask applied, so the mode is set before
+		// anything is written to it
+		if err := files.Chmod(name, perm); err != nil {
+			out.Close()
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	}
+}
+
+// filesSaved counts the files written by saveDictionary. Commands only
+// save when something changed, so batch runs use it to tell modified
+// files from unchanged ones.
+var filesSaved atomic.Int64
+
+// batchSummary counts the outcomes of a command run on several files
+type batchSummary struct {
+	Processed int
+	Modified  int
+	Unchanged int
+	Errored   int
+}
+
+// record adds the outcome of one run, given its exit code and whether it
+// saved the file
+func (b *batchSummary) record(code int, saved bool) {
+	b.Processed++
+	switch {
+	case code != 0:
+		b.Errored++
+	case saved:
+		b.Modified++
+	default:
+		b.Unchanged++
+	}
+}
+
+// print prints the summary line unless --quiet was given
+func (b *batchSummary) print() {
+	infof("%d files processed: %d modified, %d unchanged, %d errored\n",
+		b.Processed, b.Modified, b.Unchanged, b.Errored)
+}
+
+// parseFlags parses command flags, which may appear before or after the
+// positional arguments, and returns the positional arguments
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	fs.SetOutput(io.Discard)
+	if err := applyCommandSettings(fs); err != nil {
+		return nil, err
+	}
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		// Everything after a -- terminator is positional, even if it
+		// starts with -
+		if parsed := len(args) - fs.NArg(); parsed > 0 && args[parsed-1] == "--" {
+			return append(positional, fs.Args()...), nil
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// applyCommandSettings sets the flags of fs that the config file sets for
+// its command. A flag of another subcommand of the command is left out.
+func applyCommandSettings(fs *flag.FlagSet) error {
+	command, _, _ := strings.Cut(fs.Name(), " ")
+	for _, setting := range commandSettings {
+		if setting.Command != command || fs.Lookup(setting.Name) == nil {
+			continue
+		}
+		if err := fs.Set(setting.Name, setting.Value); err != nil {
+			return fmt.Errorf("invalid value \"%s\" for %s in the [%s] table of the config file: %v", setting.Value, setting.Name, command, err)
+		}
+	}
+	return nil
+}
+
+// parseArgs parses the arguments of a command that defines no flags, so
+// that an unknown flag is an error and -- ends the flags as it does for
+// other commands, and returns the positional arguments
+func parseArgs(command string, args []string) ([]string, error) {
+	return parseFlags(flag.NewFlagSet(command, flag.ContinueOnError), args)
+}
+
+// generateUUID returns a new random UUID in VMware's byte-pair format
+func generateUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	pairs := make([]string, len(b))
+	for i, v := range b {
+		pairs[i] = fmt.Sprintf("%02x", v)
+	}
+	return strings.Join(pairs[:8], " ") + "-" + strings.Join(pairs[8:], " "), nil
+}
+
+// clonePrepAction is the change clone-prep makes to a matching key
+type clonePrepAction int
+
+const (
+	clonePrepRemove clonePrepAction = iota
+	clonePrepRegenerateUUID
+)
+
+// clonePrepTable lists the keys that tie a VM to its original copy or host.
+// Patterns may contain * wildcards and are matched case-insensitively.
+var clonePrepTable = []struct {
+	Pattern string
+	Action  clonePrepAction
+}{
+	{"checkpoint.vmState", clonePrepRemove},
+	{"sched.swap.derivedName", clonePrepRemove},
+	{"vc.uuid", clonePrepRemove},
+	{"vmci0.id", clonePrepRemove},
+	{"migrate.hostLog", clonePrepRemove},
+	{"ethernet*.generatedAddress", clonePrepRemove},
+	{"ethernet*.generatedAddressOffset", clonePrepRemove},
+	{"uuid.bios", clonePrepRegenerateUUID},
+	{"uuid.location", clonePrepRegenerateUUID},
+}
+
+// parseBool interprets a VMX boolean value, reporting false for ok if the
+// value is not a recognised boolean
+func parseBool(value string) (result bool, ok bool) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "yes", "on", "1":
+		return true, true
+	case "false", "no", "off", "0":
+		return false, true
+	}
+	return false, false
+}
+
+// formatBool formats a boolean as a VMX value
+func formatBool(b bool) string {
+	if b {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+// queryBoolOr returns the boolean value of a key, or def if the key is
+// absent or not a boolean
+func (d *Dictionary) queryBoolOr(key string, def bool) bool {
+	value, err := d.Query(key)
+	if err != nil {
+		return def
+	}
+	if b, ok := parseBool(value); ok {
+		return b
+	}
+	return def
+}
+
+// queryOr returns the value of a key, or def if the key is absent
+func (d *Dictionary) queryOr(key, def string) string {
+	if value, err := d.Query(key); err == nil {
+		return value
+	}
+	return def
+}
+
+// notSet is shown in status reports for keys that are absent
+const notSet = "not set"
+
+// statusRow is a labelled value in a status report
+type statusRow struct {
+	Label string
+	Value string
+}
+
+// printStatus prints a status report with the values aligned
+func printStatus(rows []statusRow) {
+	width := 0
+	for _, row := range rows {
+		width = max(width, len(row.Label))
+	}
+	for _, row := range rows {
+		fmt.Fprintf(stdout, "%-*s %s\n", width+1, row.Label+":", row.Value)
+	}
+}
+
+// Range of virtual hardware versions accepted by set-hw-version
+const (
+	minHWVersion = 3
+	maxHWVersion = 22
+)
+
+// hwFeature is a key that is only valid from a minimum virtual hardware
+// version. Patterns may contain * wildcards.
+type hwFeature struct {
+	Pattern    string
+	MinVersion int
+	Feature    string
+}
+
+// hwFeatureTable lists the keys of the key table that need a minimum
+// virtual hardware version
+var hwFeatureTable = func() []hwFeature {
+	var features []hwFeature
+	for _, k := range knownKeys {
+		if k.HWVersion > 0 {
+			features = append(features, hwFeature{k.Key, k.HWVersion, k.Feature})
+		}
+	}
+	return features
+}()
+
+// HWVersion returns the virtual hardware version of the dictionary
+func (d *Dictionary) HWVersion() (int, error) {
+	value, err := d.Query("virtualHW.version")
+	if err != nil {
+		return 0, err
+	}
+	version, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid virtualHW.version '%s'", value)
+	}
+	return version, nil
+}
+
+// incompatibleKeys returns a description of each enabled key that needs a
+// newer hardware version than the one given
+func (d *Dictionary) incompatibleKeys(version int) []string {
+	var incompatible []string
+	for _, feature := range hwFeatureTable {
+		if version >= feature.MinVersion {
+			continue
+		}
+		for _, entry := range d.FindMatching(feature.Pattern) {
+			// Keys explicitly set to FALSE do not enable the feature
+			if enabled, ok := parseBool(entry.Value); ok && !enabled {
+				continue
+			}
+			incompatible = append(incompatible, fmt.Sprintf("%s (%s, requires version %d)",
+				entry.Key, feature.Feature, feature.MinVersion))
+		}
+	}
+	return incompatible
+}
+
+// knownKey describes a key in the embedded key table. Keys may contain *
+// wildcards for device numbers.
+type knownKey struct {
+	Key         string   `json:"key"`
+	Description string   `json:"description"`
+	Type        string   `json:"type"` // boolean, integer, choice or string
+	Values      []string `json:"values,omitempty"`
+	Min         *int64   `json:"min,omitempty"`
+	Max         *int64   `json:"max,omitempty"`
+	Default     string   `json:"default,omitempty"`
+	HWVersion   int      `json:"hwVersion,omitempty"`
+	Feature     string   `json:"feature,omitempty"`
+}
+
+// knownKeys is the embedded key table used by explain, set validation and
+// lint. The table is part of the binary, so it failing to parse is a bug.
+var knownKeys = func() []knownKey {
+	var keys []knownKey
+	if err := json.Unmarshal(keysData, &keys); err != nil {
+		panic(fmt.Sprintf("invalid built-in key table: %v", err))
+	}
+	return keys
+}()
+
+// lookupKnownKey finds the entry of the key table matching key
+func lookupKnownKey(key string) (knownKey, bool) {
+	for _, k := range knownKeys {
+		if matchKey(k.Key, key) {
+			return k, true
+		}
+	}
+	return knownKey{}, false
+}
+
+// knownKeysWithPrefix returns the entries of the key table under prefix,
+// where the wildcards of table keys match the numbers in prefix
+func knownKeysWithPrefix(prefix string) []knownKey {
+	prefixSegments := strings.Split(prefix, ".")
+	var keys []knownKey
+	for _, k := range knownKeys {
+		segments := strings.Split(k.Key, ".")
+		if len(prefixSegments) > len(segments) {
+			continue
+		}
+		last := len(prefixSegments) - 1
+		matched := true
+		for i, p := range prefixSegments[:last] {
+			matched = matched && matchKey(segments[i], p)
+		}
+		p := prefixSegments[last]
+		if matched && (matchKey(segments[last], p) || strings.HasPrefix(strings.ToLower(segments[last]), strings.ToLower(p))) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// validate checks a value against the type and range of a known key
+func (k knownKey) validate(key, value string) error {
+	switch k.Type {
+	case "boolean":
+		if _, ok := parseBool(value); !ok {
+			return fmt.Errorf("%s must be TRUE or FALSE, got '%s'", key, value)
+		}
+	case "integer":
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s must be a number, got '%s'", key, value)
+		}
+		if (k.Min != nil && n < *k.Min) || (k.Max != nil && n > *k.Max) {
+			return fmt.Errorf("%s must be %s, got %d", key, k.valueRange(), n)
+		}
+	case "choice":
+		if !slices.ContainsFunc(k.Values, func(v string) bool { return strings.EqualFold(v, value) }) {
+			return fmt.Errorf("%s must be one of %s, got '%s'", k.Key, strings.Join(k.Values, ", "), value)
+		}
+	}
+	return nil
+}
+
+// valueRange describes the values a known key accepts
+func (k knownKey) valueRange() string {
+	switch {
+	case k.Type == "boolean":
+		return "TRUE or FALSE"
+	case k.Type == "choice":
+		return strings.Join(k.Values, ", ")
+	case k.Min != nil && k.Max != nil:
+		return fmt.Sprintf("from %d to %d", *k.Min, *k.Max)
+	case k.Min != nil:
+		return fmt.Sprintf("at least %d", *k.Min)
+	case k.Max != nil:
+		return fmt.Sprintf("at most %d", *k.Max)
+	}
+	return "any"
+}
+
+// validateKnownValue checks a value for key against the key table,
+// accepting any value for keys that are not in it
+func validateKnownValue(key, value string) error {
+	if k, ok := lookupKnownKey(key); ok {
+		return k.validate(key, value)
+	}
+	return nil
+}
+
+// printKnownKey prints the key table entry for a key, with its current
+// value when dict is not nil
+func printKnownKey(k knownKey, key string, dict *Dictionary) {
+	rows := []statusRow{
+		{"Key", k.Key},
+		{"Description", k.Description},
+		{"Type", k.Type},
+		{"Values", k.valueRange()},
+		{"Default", cmp.Or(k.Default, notSet)},
+	}
+	if k.HWVersion > 0 {
+		rows = append(rows, statusRow{"Hardware version", fmt.Sprintf("%d or later", k.HWVersion)})
+	}
+	if dict != nil {
+		rows = append(rows, statusRow{"Current value", dict.queryOr(key, notSet)})
+	}
+	printStatus(rows)
+}
+
+// runExplain implements the explain command
+func runExplain(args []string) int {
+	args, err := parseArgs("explain", args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool explain [FILE] KEY\n")
+		return exitUsage
+	}
+	if len(args) < 1 || len(args) > 2 {
+		errorf("Error: explain command requires KEY argument\n")
+		errorf("Usage: vmxtool explain [FILE] KEY\n")
+		return exitUsage
+	}
+	key := args[len(args)-1]
+
+	var dict *Dictionary
+	if len(args) == 2 {
+		var err error
+		dict, err = LoadDictionary(args[0])
+		if err != nil {
+			errorf("Error loading file: %v\n", err)
+			return exitFileError
+		}
+	}
+
+	if k, ok := lookupKnownKey(key); ok && !strings.HasSuffix(key, ".") {
+		printKnownKey(k, key, dict)
+		return 0
+	}
+
+	keys := knownKeysWithPrefix(key)
+	if len(keys) == 0 {
+		fmt.Fprintf(stdout, "No information available for %s\n", key)
+		return 0
+	}
+	var rows []statusRow
+	for _, k := range keys {
+		rows = append(rows, statusRow{k.Key, k.Description})
+	}
+	printStatus(rows)
+	return 0
+}
+
+// GuestOS describes a known guestOS identifier
+type GuestOS struct {
+	ID          string
+	Description string
+}
+
+// knownGuestOSes parses the embedded guestOS table
+func knownGuestOSes() []GuestOS {
+	var guests []GuestOS
+	for _, line := range strings.Split(guestOSData, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, description, _ := strings.Cut(line, " ")
+		guests = append(guests, GuestOS{ID: id, Description: strings.TrimSpace(description)})
+	}
+	return guests
+}
+
+// lookupGuestOS finds a known guestOS identifier (case-insensitive)
+func lookupGuestOS(id string) (GuestOS, bool) {
+	for _, guest := range knownGuestOSes() {
+		if strings.EqualFold(guest.ID, id) {
+			return guest, true
+		}
+	}
+	return GuestOS{}, false
+}
+
+// editDistance returns the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// closestMatch returns the candidate nearest to s by case-insensitive edit
+// distance, or false if none is close enough to be a plausible typo
+func closestMatch(s string, candidates []string) (string, bool) {
+	best := ""
+	bestDistance := -1
+	lower := strings.ToLower(s)
+	for _, candidate := range candidates {
+		distance := editDistance(lower, strings.ToLower(candidate))
+		if bestDistance == -1 || distance < bestDistance {
+			best = candidate
+			bestDistance = distance
+		}
+	}
+	if bestDistance == -1 || bestDistance > max(1, len([]rune(s))/3) {
+		return "", false
+	}
+	return best, true
+}
+
+// validateGuestOS checks that id is a known guestOS identifier
+func validateGuestOS(id string) error {
+	if _, ok := lookupGuestOS(id); ok {
+		return nil
+	}
+	var ids []string
+	for _, guest := range knownGuestOSes() {
+		ids = append(ids, guest.ID)
+	}
+	if suggestion, ok := closestMatch(id, ids); ok {
+		return fmt.Errorf("unknown guestOS '%s', did you mean '%s'?", id, suggestion)
+	}
+	return fmt.Errorf("unknown guestOS '%s'", id)
+}
+
+// runGuestOS implements the guestos command
+func runGuestOS(args []string) int {
+	args, err := parseArgs("guestos", args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool guestos list [FILTER]\n")
+		return exitUsage
+	}
+	if len(args) < 1 || args[0] != "list" || len(args) > 2 {
+		errorf("Error: guestos command requires list subcommand\n")
+		errorf("Usage: vmxtool guestos list [FILTER]\n")
+		return exitUsage
+	}
+
+	filter := ""
+	if len(args) == 2 {
+		filter = strings.ToLower(args[1])
+	}
+
+	for _, guest := range knownGuestOSes() {
+		if !strings.Contains(strings.ToLower(guest.ID), filter) &&
+			!strings.Contains(strings.ToLower(guest.Description), filter) {
+			continue
+		}
+		fmt.Fprintf(stdout, "%-24s %s\n", guest.ID, guest.Description)
+	}
+	return 0
+}
+
+// minHWVersionFor returns the minimum hardware version required by a key
+// according to hwFeatureTable, or 0 if there is no requirement
+func minHWVersionFor(key string) int {
+	for _, feature := range hwFeatureTable {
+		if matchKey(feature.Pattern, key) {
+			return feature.MinVersion
+		}
+	}
+	return 0
+}
+
+// runSetHWVersion implements the set-hw-version command
+func runSetHWVersion(args []string) int {
+	fs := flag.NewFlagSet("set-hw-version", flag.ContinueOnError)
+	force := fs.Bool("force", false, "change version even if keys become incompatible")
+
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool set-hw-version [--force] FILE VERSION\n")
+		return exitUsage
+	}
+	if len(positional) != 2 {
+		errorf("Error: set-hw-version command requires FILE and VERSION arguments\n")
+		errorf("Usage: vmxtool set-hw-version [--force] FILE VERSION\n")
+		return exitUsage
+	}
+	filename := positional[0]
+
+	version, err := strconv.Atoi(positional[1])
+	if err != nil || version < minHWVersion || version > maxHWVersion {
+		errorf("Error: hardware version must be a number from %d to %d\n", minHWVersion, maxHWVersion)
+		return exitUsage
+	}
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	if err := checkSnapshots(nil, filename, []string{"virtualHW.version"}, !*force); err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Use --force to change the version anyway\n")
+		return exitError
+	}
+
+	if incompatible := dict.incompatibleKeys(version); len(incompatible) > 0 {
+		if !*force {
+			errorf("Error: the following keys are not supported by hardware version %d:\n", version)
+			for _, key := range incompatible {
+				errorf("    %s\n", key)
+			}
+			errorf("Use --force to change the version anyway\n")
+			return exitError
+		}
+		warnf("Warning: the following keys are not supported by hardware version %d:\n", version)
+		for _, key := range incompatible {
+			warnf("    %s\n", key)
+		}
+	}
+
+	// virtualHW.productCompatibility names the product family, hosted
+	// or esx, not a version, so it is left as it is
+	if !dict.Set("virtualHW.version", strconv.Itoa(version)) {
+		return 0
+	}
+
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// Size units accepted by parseSize
+const (
+	kilobyte int64 = 1024
+	megabyte       = 1024 * kilobyte
+	gigabyte       = 1024 * megabyte
+	terabyte       = 1024 * gigabyte
+)
+
+// parseSize parses a size such as 512, 512M, 8G or 2GB into bytes. Plain
+// numbers are multiplied by defaultUnit.
+func parseSize(s string, defaultUnit int64) (int64, error) {
+	trimmed := strings.ToUpper(strings.TrimSpace(s))
+	unit := defaultUnit
+	for _, suffix := range []struct {
+		Suffix string
+		Unit   int64
+	}{
+		{"KB", kilobyte}, {"MB", megabyte}, {"GB", gigabyte}, {"TB", terabyte},
+		{"K", kilobyte}, {"M", megabyte}, {"G", gigabyte}, {"T", terabyte},
+		{"B", 1},
+	} {
+		if strings.HasSuffix(trimmed, suffix.Suffix) {
+			trimmed = strings.TrimSpace(strings.TrimSuffix(trimmed, suffix.Suffix))
+			unit = suffix.Unit
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(trimmed, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+	if n > 0 && unit > (1<<62)/n {
+		return 0, fmt.Errorf("size '%s' is too large", s)
+	}
+	return n * unit, nil
+}
+
+// formatMB formats a number of megabytes for display
+func formatMB(mb int64) string {
+	if mb >= 1024 && mb%1024 == 0 {
+		return fmt.Sprintf("%d MB (%d GB)", mb, mb/1024)
+	}
+	return fmt.Sprintf("%d MB", mb)
+}
+
+// formatBytes formats a byte count using the largest whole unit
+func formatBytes(n int64) string {
+	for _, unit := range []struct {
+		Name string
+		Size int64
+	}{
+		{"TB", terabyte}, {"GB", gigabyte}, {"MB", megabyte}, {"KB", kilobyte},
+	} {
+		if n >= unit.Size && n%unit.Size == 0 {
+			return fmt.Sprintf("%d %s", n/unit.Size, unit.Name)
+		}
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
+
+// flagWasSet reports whether the named flag was given on the command line
+func flagWasSet(fs *flag.FlagSet, name string) bool {
+	found := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
+}
+
+// maxMemoryTable lists the maximum guest memory in MB supported from each
+// virtual hardware version on Fusion and Workstation
+var maxMemoryTable = []struct {
+	MinVersion int
+	MaxMB      int64
+}{
+	{4, 3600},
+	{6, 8192},
+	{7, 32768},
+	{8, 65536},
+	{16, 131072},
+}
+
+// maxMemoryMB returns the maximum guest memory for a hardware version, or
+// false if the version is older than any in the table
+func maxMemoryMB(version int) (int64, bool) {
+	var maxMB int64
+	found := false
+	for _, limit := range maxMemoryTable {
+		if version >= limit.MinVersion {
+			maxMB = limit.MaxMB
+			found = true
+		}
+	}
+	return maxMB, found
+}
+
+// validateMemory checks that a memsize value in MB is acceptable to VMware
+func validateMemory(mb int64) error {
+	if mb <= 0 || mb%4 != 0 {
+		return fmt.Errorf("memory must be a positive multiple of 4 MB, got %d MB", mb)
+	}
+	return nil
+}
+
+// maxVCPUs is the largest number of virtual CPUs VMware accepts
+const maxVCPUs = 128
+
+// validateCPUs checks that a numvcpus value is acceptable to VMware
+func validateCPUs(cpus int) error {
+	if cpus < 1 || cpus > maxVCPUs {
+		return fmt.Errorf("number of CPUs must be from 1 to %d, got %d", maxVCPUs, cpus)
+	}
+	return nil
+}
+
+// validateResourceValue checks memsize and numvcpus values set directly,
+// ignoring any other key
+func validateResourceValue(key, value string) error {
+	switch strings.ToLower(key) {
+	case "memsize":
+		mb, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return fmt.Errorf("memsize must be a number of MB, got '%s'", value)
+		}
+		return validateMemory(mb)
+	case "numvcpus":
+		cpus, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("numvcpus must be a number, got '%s'", value)
+		}
+		return validateCPUs(cpus)
+	}
+	return nil
+}
+
+// validateTopology checks that the virtual CPU count can be split evenly
+// into sockets of coresPerSocket cores
+func validateTopology(cpus, coresPerSocket int) error {
+	if err := validateCPUs(cpus); err != nil {
+		return err
+	}
+	if coresPerSocket < 1 {
+		return fmt.Errorf("cores per socket must be at least 1, got %d", coresPerSocket)
+	}
+	if cpus%coresPerSocket != 0 {
+		return fmt.Errorf("%d CPUs cannot be divided into sockets of %d cores", cpus, coresPerSocket)
+	}
+	return nil
+}
+
+// queryInt returns the integer value of a key, or def if it is absent
+func (d *Dictionary) queryInt(key string, def int) (int, error) {
+	value, err := d.Query(key)
+	if err != nil {
+		return def, nil
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s '%s'", key, value)
+	}
+	return n, nil
+}
+
+// printResources prints a summary of the memory and CPU settings
+func printResources(dict *Dictionary) {
+	memory := notSet
+	if value, err := dict.Query("memsize"); err == nil {
+		if mb, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64); err == nil {
+			memory = formatMB(mb)
+		} else {
+			memory = value
+		}
+	}
+
+	rows := []statusRow{
+		{"Memory", memory},
+		{"Virtual CPUs", dict.queryOr("numvcpus", notSet)},
+		{"Cores per socket", dict.queryOr("cpuid.coresPerSocket", notSet)},
+	}
+
+	numCPUs, err1 := dict.queryInt("numvcpus", 1)
+	numCores, err2 := dict.queryInt("cpuid.coresPerSocket", 1)
+	if err1 == nil && err2 == nil && validateTopology(numCPUs, numCores) == nil {
+		rows = append(rows, statusRow{"Sockets", strconv.Itoa(numCPUs / numCores)})
+	}
+
+	printStatus(rows)
+}
+
+// runResources implements the resources command
+func runResources(args []string) int {
+	fs := flag.NewFlagSet("resources", flag.ContinueOnError)
+	memory := fs.String("memory", "", "memory size in MB, or with an M or G suffix")
+	cpus := fs.Int("cpus", 0, "number of virtual CPUs")
+	cores := fs.Int("cores-per-socket", 0, "number of cores per virtual socket")
+
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool resources FILE [--memory SIZE] [--cpus N] [--cores-per-socket N]\n")
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		errorf("Error: resources command requires FILE argument\n")
+		errorf("Usage: vmxtool resources FILE [--memory SIZE] [--cpus N] [--cores-per-socket N]\n")
+		return exitUsage
+	}
+	filename := positional[0]
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	setMemory := flagWasSet(fs, "memory")
+	setCPUs := flagWasSet(fs, "cpus")
+	setCores := flagWasSet(fs, "cores-per-socket")
+
+	if !setMemory && !setCPUs && !setCores {
+		printResources(dict)
+		return 0
+	}
+
+	changed := false
+
+	if setMemory {
+		bytes, err := parseSize(*memory, megabyte)
+		if err != nil {
+			errorf("Error: %v\n", err)
+			return exitUsage
+		}
+		if bytes%megabyte != 0 {
+			errorf("Error: memory must be a whole number of MB, got '%s'\n", *memory)
+			return exitError
+		}
+		mb := bytes / megabyte
+		if err := validateMemory(mb); err != nil {
+			errorf("Error: %v\n", err)
+			return exitError
+		}
+		if version, err := dict.HWVersion(); err == nil {
+			if maxMB, ok := maxMemoryMB(version); ok && mb > maxMB {
+				warnf("Warning: %s exceeds the maximum of %s for hardware version %d\n",
+					formatMB(mb), formatMB(maxMB), version)
+			}
+		}
+		changed = dict.Set("memsize", strconv.FormatInt(mb, 10)) || changed
+	}
+
+	if setCPUs || setCores {
+		// Validate against the existing value of whichever is not given
+		numCPUs, err := dict.queryInt("numvcpus", 1)
+		if err != nil {
+			errorf("Error: %v\n", err)
+			return exitError
+		}
+		numCores, err := dict.queryInt("cpuid.coresPerSocket", 1)
+		if err != nil {
+			errorf("Error: %v\n", err)
+			return exitError
+		}
+		if setCPUs {
+			numCPUs = *cpus
+		}
+		if setCores {
+			numCores = *cores
+		}
+		if err := validateTopology(numCPUs, numCores); err != nil {
+			errorf("Error: %v\n", err)
+			return exitError
+		}
+		if setCPUs {
+			changed = dict.Set("numvcpus", strconv.Itoa(numCPUs)) || changed
+		}
+		if setCores {
+			changed = dict.Set("cpuid.coresPerSocket", strconv.Itoa(numCores)) || changed
+		}
+	}
+
+	if !changed {
+		return 0
+	}
+
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// numaKeys are the keys that shape the NUMA layout and scheduling of the
+// virtual CPUs
+var numaKeys = []string{
+	"numa.vcpu.maxPerVirtualNode",
+	"numa.autosize.vcpu.maxPerVirtualNode",
+	"numa.vcpu.preferHT",
+	"numa.nodeAffinity",
+	"sched.cpu.affinity",
+}
+
+// affinityCount returns the number of host CPUs in a sched.cpu.affinity
+// list such as 0,2,4-7, or false for all or an unreadable list
+func affinityCount(value string) (int, bool) {
+	if strings.EqualFold(strings.TrimSpace(value), "all") {
+		return 0, false
+	}
+	count := 0
+	for _, item := range strings.Split(value, ",") {
+		low, high, isRange := strings.Cut(strings.TrimSpace(item), "-")
+		a, err := strconv.Atoi(low)
+		if err != nil {
+			return 0, false
+		}
+		b := a
+		if isRange {
+			if b, err = strconv.Atoi(high); err != nil || b < a {
+				return 0, false
+			}
+		}
+		count += b - a + 1
+	}
+	return count, true
+}
+
+// topologyWarnings returns the problems with the NUMA and affinity keys
+// for a topology of cpus virtual CPUs
+func topologyWarnings(dict *Dictionary, cpus, cores int) []string {
+	var warnings []string
+	if perNode, err := dict.queryInt("numa.vcpu.maxPerVirtualNode", 0); err == nil && perNode > 0 {
+		if cpus%perNode != 0 {
+			warnings = append(warnings, fmt.Sprintf("%d CPUs cannot be divided into NUMA nodes of numa.vcpu.maxPerVirtualNode = %d", cpus, perNode))
+		} else if perNode%cores != 0 {
+			warnings = append(warnings, fmt.Sprintf("NUMA nodes of %d CPUs split sockets of %d cores", perNode, cores))
+		}
+	}
+	if n, ok := affinityCount(dict.queryOr("sched.cpu.affinity", "all")); ok && n < cpus {
+		warnings = append(warnings, fmt.Sprintf("sched.cpu.affinity allows %d host CPUs for %d virtual CPUs", n, cpus))
+	}
+	return warnings
+}
+
+// printTopology prints the virtual CPU layout and the NUMA keys
+func printTopology(dict *Dictionary) {
+	cpus, err1 := dict.queryInt("numvcpus", 1)
+	cores, err2 := dict.queryInt("cpuid.coresPerSocket", 1)
+	layout := "invalid"
+	if err1 == nil && err2 == nil && validateTopology(cpus, cores) == nil {
+		layout = fmt.Sprintf("%d sockets x %d cores", cpus/cores, cores)
+	}
+
+	rows := []statusRow{
+		{"Virtual CPUs", dict.queryOr("numvcpus", notSet)},
+		{"Cores per socket", dict.queryOr("cpuid.coresPerSocket", notSet)},
+		{"Layout", layout},
+	}
+	for _, key := range numaKeys {
+		if value, ok := dict.QueryOK(key); ok {
+			rows = append(rows, statusRow{key, value})
+		}
+	}
+	printStatus(rows)
+
+	if layout != "invalid" {
+		for _, warning := range topologyWarnings(dict, cpus, cores) {
+			warnf("Warning: %s\n", warning)
+		}
+	}
+}
+
+// runTopology implements the topology command
+func runTopology(args []string) int {
+	fs := flag.NewFlagSet("topology", flag.ContinueOnError)
+	sockets := fs.Int("sockets", 0, "number of virtual CPU sockets")
+	cores := fs.Int("cores", 0, "number of cores in each socket")
+
+	usage := "Usage: vmxtool topology FILE [--sockets N --cores N]"
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		errorf("Error: topology command requires FILE argument\n")
+		errorln(usage)
+		return exitUsage
+	}
+	if flagWasSet(fs, "sockets") != flagWasSet(fs, "cores") {
+		errorf("Error: --sockets and --cores must be given together\n")
+		errorln(usage)
+		return exitUsage
+	}
+	filename := positional[0]
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	if !flagWasSet(fs, "sockets") {
+		printTopology(dict)
+		return 0
+	}
+
+	if *sockets < 1 {
+		errorf("Error: sockets must be at least 1, got %d\n", *sockets)
+		return exitError
+	}
+	cpus := *sockets * *cores
+	if err := validateTopology(cpus, *cores); err != nil {
+		errorf("Error: %d sockets of %d cores: %v\n", *sockets, *cores, err)
+		return exitError
+	}
+	for _, warning := range topologyWarnings(dict, cpus, *cores) {
+		warnf("Warning: %s\n", warning)
+	}
+
+	changed := dict.Set("numvcpus", strconv.Itoa(cpus))
+	changed = dict.SetGrouped("cpuid.coresPerSocket", strconv.Itoa(*cores)) || changed
+	if !changed {
+		return 0
+	}
+
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// vtpmCleanupKeys lists keys removed along with vtpm.present when a
+// virtual TPM is turned off
+var vtpmCleanupKeys = []string{
+	"vtpm.*",
+	"managedvm.autoAddVTPM",
+}
+
+// hasEncryption reports whether the VM has VMware encryption configured
+func (d *Dictionary) hasEncryption() bool {
+	return len(d.FindMatching("encryption.*")) > 0
+}
+
+// printVTPMStatus prints the virtual TPM state and its prerequisites
+func printVTPMStatus(dict *Dictionary) {
+	state := "off"
+	if dict.queryBoolOr("vtpm.present", false) {
+		state = "on"
+	}
+	encryption := "not configured"
+	if dict.hasEncryption() {
+		encryption = "configured"
+	}
+	printStatus([]statusRow{
+		{"vTPM", state},
+		{"Hardware version", fmt.Sprintf("%s (requires %d or later)",
+			dict.queryOr("virtualHW.version", notSet), minHWVersionFor("vtpm.present"))},
+		{"Firmware", fmt.Sprintf("%s (requires efi)", dict.queryOr("firmware", notSet))},
+		{"Encryption", encryption},
+	})
+}
+
+// runVTPM implements the vtpm command
+func runVTPM(args []string) int {
+	args, err := parseArgs("vtpm", args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool vtpm FILE [on|off]\n")
+		return exitUsage
+	}
+	if len(args) < 1 || len(args) > 2 {
+		errorf("Error: vtpm command requires FILE argument\n")
+		errorf("Usage: vmxtool vtpm FILE [on|off]\n")
+		return exitUsage
+	}
+	filename := args[0]
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	if len(args) == 1 {
+		printVTPMStatus(dict)
+		return 0
+	}
+
+	enable, ok := parseBool(args[1])
+	if !ok {
+		errorf("Error: invalid state '%s', expected on or off\n", args[1])
+		errorf("Usage: vmxtool vtpm FILE [on|off]\n")
+		return exitUsage
+	}
+
+	changed := false
+	if enable {
+		required := minHWVersionFor("vtpm.present")
+		version, err := dict.HWVersion()
+		if err != nil || version < required {
+			errorf("Error: a virtual TPM requires hardware version %d or later, found %s\n",
+				required, dict.queryOr("virtualHW.version", notSet))
+			errorf("Use 'vmxtool set-hw-version' to upgrade the VM first\n")
+			return exitError
+		}
+		if !strings.EqualFold(dict.queryOr("firmware", ""), "efi") {
+			errorf("Error: a virtual TPM requires EFI firmware, found %s\n", dict.queryOr("firmware", notSet))
+			return exitError
+		}
+
+		changed = dict.Set("vtpm.present", "TRUE")
+
+		if dict.hasEncryption() {
+			warnf("Warning: encryption keys are present, but VMware will verify the VM is encrypted before powering on\n")
+		} else {
+			warnf("Warning: VMware requires the VM to be encrypted before a virtual TPM can be used\n")
+			warnf("Encrypt the VM from Fusion or Workstation, as this cannot be done by editing the VMX file\n")
+		}
+	} else {
+		for _, pattern := range vtpmCleanupKeys {
+			for _, entry := range dict.FindMatching(pattern) {
+				dict.removeEntry(entry)
+				changed = true
+			}
+		}
+	}
+
+	if !changed {
+		return 0
+	}
+
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// absentToken is printed by query --show-absence for a missing key
+const absentToken = "<absent>"
+
+// runQuery implements the query command
+func runQuery(args []string) int {
+	fs := flag.NewFlagSet("query", flag.ContinueOnError)
+	showAbsence := fs.Bool("show-absence", false, "print "+absentToken+" for a missing key")
+	last := fs.Bool("last", false, "use the last of duplicate keys, as VMware does")
+	fuzzy := fs.Bool("fuzzy", false, "accept part of a key if it matches only one key")
+	print0 := addPrint0Flag(fs)
+	out := fs.String("out", "", "write the decoded value to a file instead of printing it")
+	boolExit := fs.Bool("bool-exit", false, "print nothing and exit 0 for a true value, 1 for false and 2 otherwise")
+
+	usage := "Usage: vmxtool query [--show-absence] [--last] [--fuzzy] [--print0] FILE... KEY\n" +
+		"       vmxtool query [--last] [--fuzzy] FILE KEY --out PATH\n" +
+		"       vmxtool query [--last] [--fuzzy] FILE KEY --bool-exit"
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	if len(positional) < 2 {
+		errorf("Error: query command requires FILE and KEY arguments\n")
+		errorln(usage)
+		return exitUsage
+	}
+	filenames := positional[:len(positional)-1]
+	key := positional[len(positional)-1]
+	if *out != "" && (len(filenames) > 1 || *showAbsence || *print0) {
+		errorf("Error: --out takes a single FILE and cannot be used with --show-absence or --print0\n")
+		errorln(usage)
+		return exitUsage
+	}
+	if *boolExit && (len(filenames) > 1 || *showAbsence || *print0 || *out != "") {
+		errorf("Error: --bool-exit takes a single FILE and cannot be used with --show-absence, --print0 or --out\n")
+		errorln(usage)
+		return exitUsage
+	}
+
+	status := 0
+	for filename, loaded := range eachFile(filenames, fileJobs(), func(filename string) loadResult {
+		dict, err := streamQuery(filename, key, *last)
+		if err == nil && dict == nil {
+			dict, err = LoadDictionary(filename)
+		}
+		return loadResult{dict, err}
+	}) {
+		dict, err := loaded.dict, loaded.err
+		if err != nil {
+			errorf("Error loading file: %v\n", err)
+			status = exitFileError
+			continue
+		}
+
+		dict.LastWins = *last
+
+		// With several files, each value is labelled with its file
+		label := ""
+		if len(filenames) > 1 {
+			label = filename + ": "
+		}
+
+		fileKey := key
+		if *fuzzy && !dict.KeyExists(key) {
+			switch candidates := dict.FindSubstring(key); len(candidates) {
+			case 0:
+			case 1:
+				fileKey = candidates[0]
+			default:
+				if *boolExit {
+					return boolExitInvalid
+				}
+				errorf("Error: '%s' matches %d keys: %s\n", key, len(candidates), strings.Join(candidates, ", "))
+				if status == 0 {
+					status = exitError
+				}
+				continue
+			}
+		}
+
+		value, ok := dict.QueryOK(fileKey)
+		if *boolExit {
+			switch b, isBool := parseBool(value); {
+			case !ok || !isBool:
+				return boolExitInvalid
+			case !b:
+				return boolExitFalse
+			}
+			return 0
+		}
+		if !ok {
+			if *showAbsence {
+				printRecord(label+absentToken, *print0)
+				continue
+			}
+			if len(filenames) > 1 {
+				errorf("Error: %v in %s\n", dict.notFound(fileKey), filename)
+			} else {
+				errorf("Error: %v\n", dict.notFound(fileKey))
+			}
+			// A file error is the more serious failure, so keep its code
+			if status == 0 {
+				status = exitKeyNotFound
+			}
+			continue
+		}
+
+		if *out != "" {
+			// VMware writes characters such as newlines as |XX escapes
+			if !dict.VMwareCompat {
+				value = vmwareUnescape(value)
+			}
+			if err := writeFile(*out, []byte(value)); err != nil {
+				errorf("Error saving file: %v\n", err)
+				return exitFileError
+			}
+			continue
+		}
+
+		printRecord(label+value, *print0)
+	}
+	return status
+}
+
+// Exit codes of query --bool-exit, which tell a shell conditional whether a
+// boolean key is true
+const (
+	boolExitFalse   = 1 // the value is false
+	boolExitInvalid = 2 // the key is missing or not a boolean
+)
+
+// addPrint0Flag defines the --print0 flag and its -0 short form
+func addPrint0Flag(fs *flag.FlagSet) *bool {
+	print0 := fs.Bool("print0", false, "end each record with a NUL byte instead of a newline")
+	fs.BoolVar(print0, "0", false, "short for --print0")
+	return print0
+}
+
+// printRecord prints one output record, ending it with a NUL byte when
+// print0 is set so values containing newlines survive xargs -0
+func printRecord(record string, print0 bool) {
+	if print0 {
+		fmt.Fprint(stdout, record+"\x00")
+	} else {
+		fmt.Fprintln(stdout, record)
+	}
+}
+
+// runKeys implements the keys command
+func runKeys(args []string) int {
+	fs := flag.NewFlagSet("keys", flag.ContinueOnError)
+	print0 := addPrint0Flag(fs)
+
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool keys [--print0] FILE\n")
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		errorf("Error: keys command requires FILE argument\n")
+		errorf("Usage: vmxtool keys [--print0] FILE\n")
+		return exitUsage
+	}
+
+	dict, err := LoadDictionary(positional[0])
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	for _, key := range dict.Keys() {
+		printRecord(key, *print0)
+	}
+	return 0
+}
+
+// runComments implements the comments command
+func runComments(args []string) int {
+	fs := flag.NewFlagSet("comments", flag.ContinueOnError)
+	inline := fs.Bool("inline", false, "also print inline comments, after their key")
+	trim := fs.Bool("trim", false, "remove leading and trailing whitespace from each comment")
+
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool comments [--inline] [--trim] FILE\n")
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		errorf("Error: comments command requires FILE argument\n")
+		errorf("Usage: vmxtool comments [--inline] [--trim] FILE\n")
+		return exitUsage
+	}
+
+	dict, err := LoadDictionary(positional[0])
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	// Comments are printed byte for byte unless --trim is given, as some
+	// files carry headers laid out with tabs and spaces
+	text := func(comment string) string {
+		if *trim {
+			return strings.TrimSpace(comment)
+		}
+		return comment
+	}
+
+	// Entries are one per line, so the index gives the line number
+	for i, entry := range dict.Entries {
+		switch {
+		case entry.IsComment:
+			fmt.Fprintf(stdout, "%d: %s\n", i+1, text(entry.Original))
+		case *inline && entry.InlineComment != "":
+			fmt.Fprintf(stdout, "%d: %s %s\n", i+1, entry.Key, text(entry.InlineComment))
+		}
+	}
+	return 0
+}
+
+// runList implements the list command
+func runList(args []string) int {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	print0 := addPrint0Flag(fs)
+
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool list [--print0] FILE\n")
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		errorf("Error: list command requires FILE argument\n")
+		errorf("Usage: vmxtool list [--print0] FILE\n")
+		return exitUsage
+	}
+
+	dict, err := LoadDictionary(positional[0])
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	for _, key := range dict.Keys() {
+		value, _ := dict.QueryOK(key)
+		printRecord(key+" = "+value, *print0)
+	}
+	return 0
+}
+
+// nestedConflicts lists key values that conflict with nested virtualization
+var nestedConflicts = []struct {
+	Key    string
+	Value  string
+	Reason string
+}{
+	{"hypervisor.cpuid.v0", "FALSE", "hides the hypervisor from the guest"},
+	{"vpmc.enable", "TRUE", "virtual performance counters conflict with nested hypervisors"},
+	{"ulm.disableMitigations", "TRUE", "side-channel mitigation override conflicts with nested hypervisors"},
+}
+
+// nestedOverrideKeys lists monitor overrides that affect how nested
+// virtualization behaves and are reported in the status
+var nestedOverrideKeys = []string{
+	"monitor.allowLegacyCPU",
+	"monitor.virtual_exec",
+	"monitor.virtual_mmu",
+}
+
+// valuesEqual compares two VMX values, treating booleans by meaning
+func valuesEqual(a, b string) bool {
+	boolA, okA := parseBool(a)
+	boolB, okB := parseBool(b)
+	if okA && okB {
+		return boolA == boolB
+	}
+	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+}
+
+// nestedConflictEntries returns the entries that conflict with nested
+// virtualization, with the reason for each
+func (d *Dictionary) nestedConflictEntries() ([]*Entry, []string) {
+	var entries []*Entry
+	var reasons []string
+	for _, conflict := range nestedConflicts {
+		if entry := d.findEntryCaseInsensitive(conflict.Key); entry != nil && valuesEqual(entry.Value, conflict.Value) {
+			entries = append(entries, entry)
+			reasons = append(reasons, conflict.Reason)
+		}
+	}
+	return entries, reasons
+}
+
+// printNestedStatus prints the nested virtualization state
+func printNestedStatus(dict *Dictionary) {
+	state := "off"
+	if dict.queryBoolOr("vhv.enable", false) {
+		state = "on"
+	}
+	rows := []statusRow{{"Nested virtualization", state}}
+
+	conflicts, reasons := dict.nestedConflictEntries()
+	if len(conflicts) == 0 {
+		rows = append(rows, statusRow{"Conflicts", "none"})
+	}
+	for i, entry := range conflicts {
+		rows = append(rows, statusRow{"Conflict", fmt.Sprintf("%s = \"%s\" (%s)", entry.Key, entry.Value, reasons[i])})
+	}
+
+	overrides := 0
+	for _, key := range nestedOverrideKeys {
+		if entry := dict.findEntryCaseInsensitive(key); entry != nil {
+			rows = append(rows, statusRow{"Override", fmt.Sprintf("%s = \"%s\"", entry.Key, entry.Value)})
+			overrides++
+		}
+	}
+	if overrides == 0 {
+		rows = append(rows, statusRow{"Overrides", "none"})
+	}
+
+	printStatus(rows)
+}
+
+// runNested implements the nested command
+func runNested(args []string) int {
+	fs := flag.NewFlagSet("nested", flag.ContinueOnError)
+	fix := fs.Bool("fix", false, "remove conflicting keys")
+
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool nested FILE [on|off] [--fix]\n")
+		return exitUsage
+	}
+	if len(positional) < 1 || len(positional) > 2 {
+		errorf("Error: nested command requires FILE argument\n")
+		errorf("Usage: vmxtool nested FILE [on|off] [--fix]\n")
+		return exitUsage
+	}
+	filename := positional[0]
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	if len(positional) == 1 {
+		printNestedStatus(dict)
+		return 0
+	}
+
+	enable, ok := parseBool(positional[1])
+	if !ok {
+		errorf("Error: invalid state '%s', expected on or off\n", positional[1])
+		errorf("Usage: vmxtool nested FILE [on|off] [--fix]\n")
+		return exitUsage
+	}
+
+	changed := false
+	if enable {
+		required := minHWVersionFor("vhv.enable")
+		if version, err := dict.HWVersion(); err != nil || version < required {
+			errorf("Error: nested virtualization requires hardware version %d or later, found %s\n",
+				required, dict.queryOr("virtualHW.version", notSet))
+			return exitError
+		}
+
+		changed = dict.Set("vhv.enable", "TRUE")
+
+		conflicts, reasons := dict.nestedConflictEntries()
+		for i, entry := range conflicts {
+			if *fix {
+				infof("Removed %s = \"%s\" (%s)\n", entry.Key, entry.Value, reasons[i])
+				dict.removeEntry(entry)
+				changed = true
+			} else {
+				warnf("Warning: %s = \"%s\" conflicts with nested virtualization (%s)\n", entry.Key, entry.Value, reasons[i])
+			}
+		}
+		if len(conflicts) > 0 && !*fix {
+			warnf("Use --fix to remove the conflicting keys\n")
+		}
+	} else if entry := dict.findEntryCaseInsensitive("vhv.enable"); entry != nil {
+		dict.removeEntry(entry)
+		changed = true
+	}
+
+	if !changed {
+		return 0
+	}
+
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// Graphics memory limits. svga.vramSize is stored in bytes while
+// svga.graphicsMemoryKB is stored in KB.
+const (
+	maxVRAMSize       = 128 * megabyte
+	maxGraphicsMemory = 8 * gigabyte
+)
+
+// printGraphicsStatus prints the current graphics settings
+func printGraphicsStatus(dict *Dictionary) {
+	enable3d := notSet
+	if value, ok := dict.QueryOK("mks.enable3d"); ok {
+		enable3d = "off"
+		if b, _ := parseBool(value); b {
+			enable3d = "on"
+		}
+	}
+
+	vram := notSet
+	if value, ok := dict.QueryOK("svga.vramSize"); ok {
+		vram = value + " bytes"
+		if n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64); err == nil {
+			vram = fmt.Sprintf("%s (%d bytes)", formatBytes(n), n)
+		}
+	}
+
+	gfxMemory := notSet
+	if value, ok := dict.QueryOK("svga.graphicsMemoryKB"); ok {
+		gfxMemory = value + " KB"
+		if n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64); err == nil {
+			gfxMemory = fmt.Sprintf("%s (%d KB)", formatBytes(n*kilobyte), n)
+		}
+	}
+
+	printStatus([]statusRow{
+		{"3D acceleration", enable3d},
+		{"VRAM", vram},
+		{"Graphics memory", gfxMemory},
+		{"Autodetect", dict.queryOr("svga.autodetect", notSet)},
+	})
+}
+
+// runGraphics implements the graphics command
+func runGraphics(args []string) int {
+	fs := flag.NewFlagSet("graphics", flag.ContinueOnError)
+	enable3d := fs.String("3d", "", "turn 3D acceleration on or off")
+	vram := fs.String("vram", "", "VRAM size in MB, or with a K, M or G suffix")
+	gfxMemory := fs.String("gfx-memory", "", "graphics memory in MB, or with a K, M or G suffix")
+
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool graphics FILE [--3d on|off] [--vram SIZE] [--gfx-memory SIZE]\n")
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		errorf("Error: graphics command requires FILE argument\n")
+		errorf("Usage: vmxtool graphics FILE [--3d on|off] [--vram SIZE] [--gfx-memory SIZE]\n")
+		return exitUsage
+	}
+	filename := positional[0]
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	set3d := flagWasSet(fs, "3d")
+	setVRAM := flagWasSet(fs, "vram")
+	setGfxMemory := flagWasSet(fs, "gfx-memory")
+
+	if !set3d && !setVRAM && !setGfxMemory {
+		printGraphicsStatus(dict)
+		return 0
+	}
+
+	changed := false
+
+	if set3d {
+		on, ok := parseBool(*enable3d)
+		if !ok {
+			errorf("Error: invalid --3d value '%s', expected on or off\n", *enable3d)
+			return exitUsage
+		}
+		changed = dict.Set("mks.enable3d", formatBool(on)) || changed
+		if !on && (dict.KeyExists("svga.graphicsMemoryKB") || dict.KeyExists("svga.vramSize")) {
+			infof("Note: graphics memory settings are kept but have no effect while 3D is off\n")
+		}
+	}
+
+	if setVRAM {
+		size, err := parseSize(*vram, megabyte)
+		if err != nil {
+			errorf("Error: %v\n", err)
+			return exitUsage
+		}
+		if size <= 0 || size > maxVRAMSize {
+			errorf("Error: VRAM size must be greater than 0 and at most %s\n", formatBytes(maxVRAMSize))
+			return exitError
+		}
+		changed = dict.Set("svga.vramSize", strconv.FormatInt(size, 10)) || changed
+		// VMware ignores svga.vramSize while autodetect is enabled
+		changed = dict.Set("svga.autodetect", "FALSE") || changed
+	}
+
+	if setGfxMemory {
+		size, err := parseSize(*gfxMemory, megabyte)
+		if err != nil {
+			errorf("Error: %v\n", err)
+			return exitUsage
+		}
+		if size <= 0 || size > maxGraphicsMemory {
+			errorf("Error: graphics memory must be greater than 0 and at most %s\n", formatBytes(maxGraphicsMemory))
+			return exitError
+		}
+		if size%kilobyte != 0 {
+			errorf("Error: graphics memory must be a whole number of KB, got '%s'\n", *gfxMemory)
+			return exitError
+		}
+		changed = dict.Set("svga.graphicsMemoryKB", strconv.FormatInt(size/kilobyte, 10)) || changed
+	}
+
+	if !changed {
+		return 0
+	}
+
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// readKeyList reads a file containing one key per line, ignoring blank
+// lines and lines starting with #
+func readKeyList(filename string) ([]string, error) {
+	data, err := files.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, line := range strings.Split(string(data), "\n") {
+		key := strings.TrimSpace(line)
+		if key == "" || strings.HasPrefix(key, "#") {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// runAdd implements the add command
+func runAdd(args []string) int {
+	fs := flag.NewFlagSet("add", flag.ContinueOnError)
+	strict := fs.Bool("strict", false, "treat snapshot warnings as errors")
+	expand := fs.Bool("expand-env", false, "substitute ${NAME} in VALUE from the environment")
+
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool add [--strict] [--expand-env] FILE KEY=VALUE\n")
+		return exitUsage
+	}
+	if len(positional) != 2 {
+		errorf("Error: add command requires FILE and KEY=VALUE arguments\n")
+		errorf("Usage: vmxtool add [--strict] [--expand-env] FILE KEY=VALUE\n")
+		return exitUsage
+	}
+	filename := positional[0]
+	keyValue := positional[1]
+
+	key, value, err := parseKeyValue(keyValue)
+	if err == nil && *expand {
+		value, err = expandEnv(value, os.LookupEnv)
+	}
+	if err != nil {
+		errorf("Error: %v\n", err)
+		return exitUsage
+	}
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	if dict.KeyExists(key) {
+		existingKey := dict.findEntryCaseInsensitive(key).Key
+		errorf("Error: %v (as '%s')\n", &KeyExistsError{key}, existingKey)
+		return exitKeyExists
+	}
+
+	if err := checkSnapshots(nil, filename, []string{key}, *strict); err != nil {
+		errorf("Error: %v\n", err)
+		return exitError
+	}
+
+	if err := dict.Add(key, value); err != nil {
+		errorf("Error: %v\n", err)
+		return exitCode(err)
+	}
+
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// runRemove implements the remove command
+func runRemove(args []string) int {
+	filename, apply, code := prepareRemove(args)
+	if apply == nil {
+		return code
+	}
+	return apply(filename, nil)
+}
+
+// prepareRemove parses the arguments of the remove command and returns
+// the function that removes the keys from a file
+func prepareRemove(args []string) (string, applyFunc, int) {
+	fs := flag.NewFlagSet("remove", flag.ContinueOnError)
+	keysFrom := fs.String("keys-from", "", "file listing the keys to remove, one per line")
+	ignoreMissing := fs.Bool("ignore-missing", false, "skip keys that do not exist")
+	strict := fs.Bool("strict", false, "treat snapshot warnings as errors")
+
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool remove [--strict] FILE KEY\n")
+		errorf("       vmxtool remove [--strict] FILE --keys-from LISTFILE [--ignore-missing]\n")
+		return "", nil, exitUsage
+	}
+	if (*keysFrom == "" && len(positional) != 2) || (*keysFrom != "" && len(positional) != 1) {
+		errorf("Error: remove command requires FILE and KEY arguments, or FILE and --keys-from\n")
+		errorf("Usage: vmxtool remove [--strict] FILE KEY\n")
+		errorf("       vmxtool remove [--strict] FILE --keys-from LISTFILE [--ignore-missing]\n")
+		return "", nil, exitUsage
+	}
+
+	if *keysFrom == "" {
+		key := positional[1]
+		return positional[0], func(filename string, out *fileOutput) int {
+			if err := checkSnapshots(out, filename, []string{key}, *strict); err != nil {
+				out.errorf("Error: %v\n", err)
+				return exitError
+			}
+
+			// A large file is changed a line at a time; a missing key is
+			// left to the loader to report with suggestions
+			if canStream(filename) {
+				_, _, err := streamRewrite(filename, key, func(*Entry) bool { return false }, nil)
+				if err == nil {
+					return 0
+				}
+				if !errors.Is(err, errNoStream) {
+					out.errorf("Error saving file: %v\n", err)
+					return exitFileError
+				}
+			}
+
+			dict, err := LoadDictionary(filename)
+			if err != nil {
+				out.errorf("Error loading file: %v\n", err)
+				return exitFileError
+			}
+			if err := dict.Remove(key); err != nil {
+				out.errorf("Error: %v\n", err)
+				return exitCode(err)
+			}
+			if err := saveDictionary(dict, filename); err != nil {
+				out.errorf("Error saving file: %v\n", err)
+				return exitFileError
+			}
+			return 0
+		}, 0
+	}
+
+	keys, err := readKeyList(*keysFrom)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return "", nil, exitFileError
+	}
+
+	return positional[0], func(filename string, out *fileOutput) int {
+		dict, err := LoadDictionary(filename)
+		if err != nil {
+			out.errorf("Error loading file: %v\n", err)
+			return exitFileError
+		}
+
+		if err := checkSnapshots(out, filename, keys, *strict); err != nil {
+			out.errorf("Error: %v\n", err)
+			return exitError
+		}
+
+		removed := 0
+		var missing []error
+		for _, key := range keys {
+			if err := dict.Remove(key); err != nil {
+				missing = append(missing, err)
+				continue
+			}
+			removed++
+		}
+
+		if len(missing) > 0 && !*ignoreMissing {
+			for _, err := range missing {
+				out.errorf("Error: %v\n", err)
+			}
+			out.errorf("No keys removed, use --ignore-missing to skip missing keys\n")
+			return exitKeyNotFound
+		}
+
+		out.infof("Removed %d keys, %d missing\n", removed, len(missing))
+
+		if removed == 0 {
+			return 0
+		}
+
+		if err := saveDictionary(dict, filename); err != nil {
+			out.errorf("Error saving file: %v\n", err)
+			return exitFileError
+		}
+
+		return 0
+	}, 0
+}
+
+// Recommended VNC port range; the display number is the offset from 5900
+const (
+	vncPortFirst = 5900
+	vncPortLast  = 5999
+)
+
+// readSecret reads a single line from stdin, prompting if stdin is a
+// terminal
+func readSecret(prompt string) (string, error) {
+	if isTerminal(os.Stdin) {
+		fmt.Fprint(stderr, prompt)
+	}
+	line, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", fmt.Errorf("reading from stdin: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// printVNCStatus prints the VNC server settings with the password masked
+func printVNCStatus(dict *Dictionary) {
+	state := "off"
+	if dict.queryBoolOr("RemoteDisplay.vnc.enabled", false) {
+		state = "on"
+	}
+	password := notSet
+	if dict.KeyExists("RemoteDisplay.vnc.password") {
+		password = "********"
+	}
+	printStatus([]statusRow{
+		{"VNC", state},
+		{"Port", dict.queryOr("RemoteDisplay.vnc.port", notSet)},
+		{"Password", password},
+	})
+}
+
+// runVNC implements the vnc command
+func runVNC(args []string) int {
+	fs := flag.NewFlagSet("vnc", flag.ContinueOnError)
+	port := fs.Int("port", 0, "VNC port")
+	passwordFromStdin := fs.Bool("password-from-stdin", false, "read the VNC password from stdin")
+	noPassword := fs.Bool("no-password", false, "allow VNC without a password")
+
+	usage := "Usage: vmxtool vnc FILE [on [--port PORT] [--password-from-stdin|--no-password] | off]"
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	if len(positional) < 1 || len(positional) > 2 {
+		errorf("Error: vnc command requires FILE argument\n")
+		errorln(usage)
+		return exitUsage
+	}
+	filename := positional[0]
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	if len(positional) == 1 {
+		printVNCStatus(dict)
+		return 0
+	}
+
+	enable, ok := parseBool(positional[1])
+	if !ok {
+		errorf("Error: invalid state '%s', expected on or off\n", positional[1])
+		errorln(usage)
+		return exitUsage
+	}
+
+	changed := false
+	if enable {
+		if *passwordFromStdin && *noPassword {
+			errorf("Error: --password-from-stdin and --no-password cannot be used together\n")
+			return exitUsage
+		}
+
+		vncPort := vncPortFirst
+		if flagWasSet(fs, "port") {
+			vncPort = *port
+		} else if existing, err := dict.queryInt("RemoteDisplay.vnc.port", vncPortFirst); err == nil {
+			vncPort = existing
+		}
+		// The valid range comes from the key table
+		if k, ok := lookupKnownKey("RemoteDisplay.vnc.port"); ok {
+			if err := k.validate(k.Key, strconv.Itoa(vncPort)); err != nil {
+				errorf("Error: invalid port: %v\n", err)
+				return exitUsage
+			}
+		}
+		if vncPort < vncPortFirst || vncPort > vncPortLast {
+			warnf("Warning: port %d is outside the usual VNC range %d-%d\n", vncPort, vncPortFirst, vncPortLast)
+		}
+
+		switch {
+		case *passwordFromStdin:
+			password, err := readSecret("VNC password: ")
+			if err != nil {
+				errorf("Error: %v\n", err)
+				return exitError
+			}
+			if password == "" {
+				errorf("Error: the VNC password is empty, use --no-password to allow access without one\n")
+				return exitError
+			}
+			if len(password) > 8 {
+				warnf("Warning: VNC clients only use the first 8 characters of the password\n")
+			}
+			changed = dict.Set("RemoteDisplay.vnc.password", password) || changed
+		case *noPassword:
+			if entry := dict.findEntryCaseInsensitive("RemoteDisplay.vnc.password"); entry != nil {
+				dict.removeEntry(entry)
+				changed = true
+			}
+			warnf("Warning: anyone who can reach the port can connect without a password\n")
+		case !dict.KeyExists("RemoteDisplay.vnc.password"):
+			errorf("Error: no VNC password is set, use --password-from-stdin to set one or --no-password\n")
+			return exitError
+		}
+
+		changed = dict.Set("RemoteDisplay.vnc.enabled", "TRUE") || changed
+		changed = dict.Set("RemoteDisplay.vnc.port", strconv.Itoa(vncPort)) || changed
+		if vncPort >= vncPortFirst && vncPort <= vncPortLast {
+			infof("VNC enabled, connect to the host on port %d (display :%d)\n", vncPort, vncPort-vncPortFirst)
+		} else {
+			infof("VNC enabled, connect to the host on port %d\n", vncPort)
+		}
+	} else {
+		changed = dict.Set("RemoteDisplay.vnc.enabled", "FALSE")
+		if entry := dict.findEntryCaseInsensitive("RemoteDisplay.vnc.password"); entry != nil {
+			dict.removeEntry(entry)
+			changed = true
+		}
+	}
+
+	if !changed {
+		return 0
+	}
+
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// deviceIndex splits a numbered device key such as sharedFolder3.hostPath
+// into its index (3) and property (hostPath). The prefix is matched
+// case-insensitively.
+func deviceIndex(key, prefix string) (int, string, bool) {
+	if len(key) <= len(prefix) || !strings.EqualFold(key[:len(prefix)], prefix) {
+		return 0, "", false
+	}
+	digits, property, ok := strings.Cut(key[len(prefix):], ".")
+	if !ok || digits == "" {
+		return 0, "", false
+	}
+	index, err := strconv.Atoi(digits)
+	if err != nil || index < 0 {
+		return 0, "", false
+	}
+	return index, property, true
+}
+
+// deviceIndices returns the sorted indices of the numbered devices with the
+// given key prefix
+func (d *Dictionary) deviceIndices(prefix string) []int {
+	var indices []int
+	for _, entry := range d.Entries {
+		if index, _, ok := deviceIndex(entry.Key, prefix); ok && !slices.Contains(indices, index) {
+			indices = append(indices, index)
+		}
+	}
+	slices.Sort(indices)
+	return indices
+}
+
+// sharedFolder is an HGFS shared folder defined by sharedFolderN.* keys
+type sharedFolder struct {
+	Index    int
+	Name     string
+	HostPath string
+	Enabled  bool
+	ReadOnly bool
+}
+
+// sharedFolders returns the shared folders defined in the dictionary
+func (d *Dictionary) sharedFolders() []sharedFolder {
+	var folders []sharedFolder
+	for _, index := range d.deviceIndices("sharedFolder") {
+		prefix := fmt.Sprintf("sharedFolder%d.", index)
+		folders = append(folders, sharedFolder{
+			Index:    index,
+			Name:     d.queryOr(prefix+"guestName", ""),
+			HostPath: d.queryOr(prefix+"hostPath", ""),
+			Enabled:  d.queryBoolOr(prefix+"enabled", false),
+			ReadOnly: !d.queryBoolOr(prefix+"writeAccess", false),
+		})
+	}
+	return folders
+}
+
+// runSharedFolder implements the shared-folder command
+func runSharedFolder(args []string) int {
+	if len(args) < 1 {
+		errorf("Error: shared-folder command requires add, remove or list subcommand\n")
+		errorf("Usage: vmxtool shared-folder add|remove|list FILE ...\n")
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "add":
+		return runSharedFolderAdd(args[1:])
+	case "remove":
+		return runSharedFolderRemove(args[1:])
+	case "list":
+		return runSharedFolderList(args[1:])
+	}
+
+	errorf("Error: unknown shared-folder subcommand '%s'\n", args[0])
+	errorf("Usage: vmxtool shared-folder add|remove|list FILE ...\n")
+	return exitUsage
+}
+
+// runSharedFolderAdd implements the shared-folder add command
+func runSharedFolderAdd(args []string) int {
+	fs := flag.NewFlagSet("shared-folder add", flag.ContinueOnError)
+	name := fs.String("name", "", "name of the folder in the guest")
+	hostPath := fs.String("host-path", "", "path of the folder on the host")
+	readOnly := fs.Bool("read-only", false, "share the folder read-only")
+	disabled := fs.Bool("disabled", false, "add the folder disabled")
+
+	usage := "Usage: vmxtool shared-folder add FILE --name NAME --host-path PATH [--read-only] [--disabled]"
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	if len(positional) != 1 || *name == "" || *hostPath == "" {
+		errorf("Error: shared-folder add command requires FILE, --name and --host-path arguments\n")
+		errorln(usage)
+		return exitUsage
+	}
+	filename := positional[0]
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	folders := dict.sharedFolders()
+	index := 0
+	for _, folder := range folders {
+		if strings.EqualFold(folder.Name, *name) {
+			errorf("Error: shared folder '%s' already exists\n", *name)
+			return exitKeyExists
+		}
+		index = max(index, folder.Index+1)
+	}
+
+	prefix := fmt.Sprintf("sharedFolder%d.", index)
+	dict.SetGrouped(prefix+"present", "TRUE")
+	dict.SetGrouped(prefix+"enabled", formatBool(!*disabled))
+	dict.SetGrouped(prefix+"readAccess", "TRUE")
+	dict.SetGrouped(prefix+"writeAccess", formatBool(!*readOnly))
+	dict.SetGrouped(prefix+"hostPath", *hostPath)
+	dict.SetGrouped(prefix+"guestName", *name)
+	dict.SetGrouped(prefix+"expiration", "never")
+	dict.Set("sharedFolder.maxNum", strconv.Itoa(len(folders)+1))
+	dict.Set("isolation.tools.hgfs.disable", "FALSE")
+
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// runSharedFolderRemove implements the shared-folder remove command
+func runSharedFolderRemove(args []string) int {
+	args, err := parseArgs("shared-folder remove", args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool shared-folder remove FILE NAME\n")
+		return exitUsage
+	}
+	if len(args) != 2 {
+		errorf("Error: shared-folder remove command requires FILE and NAME arguments\n")
+		errorf("Usage: vmxtool shared-folder remove FILE NAME\n")
+		return exitUsage
+	}
+	filename := args[0]
+	name := args[1]
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	folders := dict.sharedFolders()
+	removeIndex := slices.IndexFunc(folders, func(folder sharedFolder) bool {
+		return strings.EqualFold(folder.Name, name)
+	})
+	if removeIndex == -1 {
+		errorf("Error: shared folder '%s' does not exist\n", name)
+		return exitKeyNotFound
+	}
+	removed := folders[removeIndex]
+
+	// Remove the folder, then renumber the later folders to close the gap
+	// so that the indices stay below sharedFolder.maxNum
+	for _, entry := range slices.Clone(dict.Entries) {
+		index, property, ok := deviceIndex(entry.Key, "sharedFolder")
+		if !ok {
+			continue
+		}
+		if index == removed.Index {
+			dict.removeEntry(entry)
+		} else if position := slices.IndexFunc(folders, func(folder sharedFolder) bool {
+			return folder.Index == index
+		}); position > removeIndex {
+			dict.renameEntry(entry, fmt.Sprintf("sharedFolder%d.%s", position-1, property))
+		}
+	}
+	dict.Set("sharedFolder.maxNum", strconv.Itoa(len(folders)-1))
+
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// runSharedFolderList implements the shared-folder list command
+func runSharedFolderList(args []string) int {
+	args, err := parseArgs("shared-folder list", args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool shared-folder list FILE\n")
+		return exitUsage
+	}
+	if len(args) != 1 {
+		errorf("Error: shared-folder list command requires FILE argument\n")
+		errorf("Usage: vmxtool shared-folder list FILE\n")
+		return exitUsage
+	}
+	filename := args[0]
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	folders := dict.sharedFolders()
+	if len(folders) == 0 {
+		fmt.Fprintln(stdout, "No shared folders")
+		return 0
+	}
+
+	nameWidth := len("NAME")
+	for _, folder := range folders {
+		nameWidth = max(nameWidth, len(folder.Name))
+	}
+	fmt.Fprintf(stdout, "%-5s %-*s %-8s %-6s %s\n", "INDEX", nameWidth, "NAME", "ENABLED", "ACCESS", "HOST PATH")
+	for _, folder := range folders {
+		enabled := "yes"
+		if !folder.Enabled {
+			enabled = "no"
+		}
+		access := "rw"
+		if folder.ReadOnly {
+			access = "ro"
+		}
+		fmt.Fprintf(stdout, "%-5d %-*s %-8s %-6s %s\n", folder.Index, nameWidth, folder.Name, enabled, access, folder.HostPath)
+	}
+
+	if dict.queryBoolOr("isolation.tools.hgfs.disable", false) {
+		warnf("Warning: shared folders are disabled by isolation.tools.hgfs.disable\n")
+	}
+	return 0
+}
+
+// maxSerialPorts is the number of serial ports Fusion and Workstation
+// support (serial0 to serial3)
+const maxSerialPorts = 4
+
+// serialNetworkSchemes lists the URI schemes accepted for network backed
+// serial ports
+var serialNetworkSchemes = []string{"telnet", "telnets", "tcp", "tcp4", "tcp6", "ssl", "tls"}
+
+// serialBackend is a parsed --backend value for serial add
+type serialBackend struct {
+	Type   string // file, pipe or network
+	Target string // file name, pipe name or network URI
+}
+
+// parseSerialBackend parses and validates a TYPE:TARGET backend string
+func parseSerialBackend(s string) (serialBackend, error) {
+	backendType, target, ok := strings.Cut(s, ":")
+	backend := serialBackend{Type: strings.ToLower(backendType), Target: target}
+	if !ok || target == "" {
+		return backend, fmt.Errorf("invalid backend '%s', expected file:PATH, pipe:NAME or network:URI", s)
+	}
+
+	switch backend.Type {
+	case "file", "pipe":
+		return backend, nil
+	case "network":
+		scheme, address, ok := strings.Cut(target, "://")
+		if !ok || !slices.Contains(serialNetworkSchemes, strings.ToLower(scheme)) {
+			return backend, fmt.Errorf("invalid network URI '%s', expected SCHEME://[HOST]:PORT with scheme %s",
+				target, strings.Join(serialNetworkSchemes, ", "))
+		}
+		i := strings.LastIndex(address, ":")
+		if i == -1 {
+			return backend, fmt.Errorf("invalid network URI '%s', no port given", target)
+		}
+		if port, err := strconv.Atoi(address[i+1:]); err != nil || port < 1 || port > 65535 {
+			return backend, fmt.Errorf("invalid port in network URI '%s'", target)
+		}
+		return backend, nil
+	}
+	return backend, fmt.Errorf("invalid backend type '%s', expected file, pipe or network", backendType)
+}
+
+// serialComment returns the comment placed above a serial port block
+// added by vmxtool
+func serialComment(index int) string {
+	return fmt.Sprintf("# serial%d added by vmxtool", index)
+}
+
+// runSerial implements the serial command
+func runSerial(args []string) int {
+	if len(args) < 1 {
+		errorf("Error: serial command requires add, remove or list subcommand\n")
+		errorf("Usage: vmxtool serial add|remove|list FILE ...\n")
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "add":
+		return runSerialAdd(args[1:])
+	case "remove":
+		return runSerialRemove(args[1:])
+	case "list":
+		return runSerialList(args[1:])
+	}
+
+	errorf("Error: unknown serial subcommand '%s'\n", args[0])
+	errorf("Usage: vmxtool serial add|remove|list FILE ...\n")
+	return exitUsage
+}
+
+// runSerialAdd implements the serial add command
+func runSerialAdd(args []string) int {
+	fs := flag.NewFlagSet("serial add", flag.ContinueOnError)
+	backendFlag := fs.String("backend", "", "file:PATH, pipe:NAME or network:URI")
+	indexFlag := fs.Int("index", -1, "serial port number")
+	endpoint := fs.String("endpoint", "server", "pipe or network end point: client or server")
+	yield := fs.Bool("yield-on-msr-read", true, "yield the vCPU when the guest polls the port")
+
+	usage := "Usage: vmxtool serial add FILE --backend TYPE:TARGET [--index N] [--endpoint client|server] [--yield-on-msr-read=false]"
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	if len(positional) != 1 || *backendFlag == "" {
+		errorf("Error: serial add command requires FILE and --backend arguments\n")
+		errorln(usage)
+		return exitUsage
+	}
+	filename := positional[0]
+
+	backend, err := parseSerialBackend(*backendFlag)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		return exitUsage
+	}
+	if *endpoint != "client" && *endpoint != "server" {
+		errorf("Error: invalid end point '%s', expected client or server\n", *endpoint)
+		return exitUsage
+	}
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	used := dict.deviceIndices("serial")
+	index := *indexFlag
+	if index == -1 {
+		for index = 0; slices.Contains(used, index); index++ {
+		}
+	}
+	if index < 0 || index >= maxSerialPorts {
+		errorf("Error: serial port number must be from 0 to %d\n", maxSerialPorts-1)
+		return exitError
+	}
+	if slices.Contains(used, index) {
+		errorf("Error: serial%d already exists\n", index)
+		return exitKeyExists
+	}
+
+	dict.Entries = append(dict.Entries, &Entry{Original: serialComment(index), IsComment: true})
+	prefix := fmt.Sprintf("serial%d.", index)
+	dict.Set(prefix+"present", "TRUE")
+	dict.Set(prefix+"fileType", backend.Type)
+	dict.Set(prefix+"fileName", backend.Target)
+	switch backend.Type {
+	case "pipe":
+		dict.Set(prefix+"pipe.endPoint", *endpoint)
+		dict.Set(prefix+"tryNoRxLoss", "FALSE")
+	case "network":
+		dict.Set(prefix+"network.endPoint", *endpoint)
+	}
+	dict.Set(prefix+"yieldOnMsrRead", formatBool(*yield))
+	dict.Set(prefix+"startConnected", "TRUE")
+
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// runSerialRemove implements the serial remove command
+func runSerialRemove(args []string) int {
+	args, err := parseArgs("serial remove", args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool serial remove FILE N\n")
+		return exitUsage
+	}
+	if len(args) != 2 {
+		errorf("Error: serial remove command requires FILE and N arguments\n")
+		errorf("Usage: vmxtool serial remove FILE N\n")
+		return exitUsage
+	}
+	filename := args[0]
+
+	index, err := strconv.Atoi(args[1])
+	if err != nil {
+		errorf("Error: invalid serial port number '%s'\n", args[1])
+		return exitUsage
+	}
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	if !slices.Contains(dict.deviceIndices("serial"), index) {
+		errorf("Error: serial%d does not exist\n", index)
+		return exitKeyNotFound
+	}
+
+	dict.Entries = slices.DeleteFunc(dict.Entries, func(entry *Entry) bool {
+		if entry.IsComment {
+			return strings.TrimSpace(entry.Original) == serialComment(index)
+		}
+		i, _, ok := deviceIndex(entry.Key, "serial")
+		return ok && i == index
+	})
+
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// runSerialList implements the serial list command
+func runSerialList(args []string) int {
+	args, err := parseArgs("serial list", args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool serial list FILE\n")
+		return exitUsage
+	}
+	if len(args) != 1 {
+		errorf("Error: serial list command requires FILE argument\n")
+		errorf("Usage: vmxtool serial list FILE\n")
+		return exitUsage
+	}
+	filename := args[0]
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	indices := dict.deviceIndices("serial")
+	if len(indices) == 0 {
+		fmt.Fprintln(stdout, "No serial ports")
+		return 0
+	}
+
+	fmt.Fprintf(stdout, "%-7s %-8s %-8s %-9s %s\n", "PORT", "PRESENT", "TYPE", "END POINT", "TARGET")
+	for _, index := range indices {
+		prefix := fmt.Sprintf("serial%d.", index)
+		present := "no"
+		if dict.queryBoolOr(prefix+"present", false) {
+			present = "yes"
+		}
+		fileType := dict.queryOr(prefix+"fileType", "device")
+		endpoint := dict.queryOr(prefix+"pipe.endPoint", dict.queryOr(prefix+"network.endPoint", "-"))
+		fmt.Fprintf(stdout, "%-7s %-8s %-8s %-9s %s\n", fmt.Sprintf("serial%d", index), present, fileType, endpoint,
+			dict.queryOr(prefix+"fileName", ""))
+	}
+	return 0
+}
+
+// usbControllers lists the USB controller keys from the slowest to the
+// fastest controller
+var usbControllers = []struct {
+	Key  string
+	Name string
+}{
+	{"usb.present", "USB 1.1 (UHCI)"},
+	{"ehci.present", "USB 2.0 (EHCI)"},
+	{"usb_xhci.present", "USB 3.1 (xHCI)"},
+}
+
+// printUSBStatus prints the USB controller settings
+func printUSBStatus(dict *Dictionary) {
+	version := "off"
+	var rows []statusRow
+	for _, controller := range usbControllers {
+		state := "off"
+		if dict.queryBoolOr(controller.Key, false) {
+			state = "on"
+			version = controller.Name
+		}
+		rows = append(rows, statusRow{controller.Name, state})
+	}
+	rows = append(rows,
+		statusRow{"Highest version", version},
+		statusRow{"Autoconnect", dict.queryOr("usb.generic.autoconnect", notSet)})
+	printStatus(rows)
+}
+
+// runUSB implements the usb command
+func runUSB(args []string) int {
+	fs := flag.NewFlagSet("usb", flag.ContinueOnError)
+	version := fs.String("version", "", "USB version: 2 or 3.1")
+	autoconnect := fs.String("autoconnect", "", "connect new USB devices to the VM: on or off")
+
+	usage := "Usage: vmxtool usb FILE [--version 2|3.1] [--autoconnect on|off] | off"
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	if len(positional) < 1 || len(positional) > 2 || (len(positional) == 2 && positional[1] != "off") {
+		errorf("Error: usb command requires FILE argument\n")
+		errorln(usage)
+		return exitUsage
+	}
+	filename := positional[0]
+	off := len(positional) == 2
+	if off && (*version != "" || *autoconnect != "") {
+		errorf("Error: off cannot be combined with --version or --autoconnect\n")
+		errorln(usage)
+		return exitUsage
+	}
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	if !off && *version == "" && *autoconnect == "" {
+		printUSBStatus(dict)
+		return 0
+	}
+
+	changed := false
+
+	if off {
+		for _, controller := range usbControllers {
+			if dict.KeyExists(controller.Key) {
+				changed = dict.Set(controller.Key, "FALSE") || changed
+			}
+		}
+	}
+
+	switch *version {
+	case "":
+	case "2", "2.0":
+		changed = dict.Set("usb.present", "TRUE") || changed
+		changed = dict.Set("ehci.present", "TRUE") || changed
+		if entry := dict.findEntryCaseInsensitive("usb_xhci.present"); entry != nil {
+			dict.removeEntry(entry)
+			changed = true
+		}
+	case "3", "3.0", "3.1":
+		required := minHWVersionFor("usb_xhci.present")
+		if hwVersion, err := dict.HWVersion(); err != nil || hwVersion < required {
+			warnf("Warning: USB 3.1 requires hardware version %d or later, found %s\n",
+				required, dict.queryOr("virtualHW.version", notSet))
+		}
+		changed = dict.Set("usb.present", "TRUE") || changed
+		changed = dict.Set("ehci.present", "TRUE") || changed
+		changed = dict.Set("usb_xhci.present", "TRUE") || changed
+	default:
+		errorf("Error: invalid USB version '%s', expected 2 or 3.1\n", *version)
+		return exitUsage
+	}
+
+	if *autoconnect != "" {
+		on, ok := parseBool(*autoconnect)
+		if !ok {
+			errorf("Error: invalid --autoconnect value '%s', expected on or off\n", *autoconnect)
+			return exitUsage
+		}
+		changed = dict.Set("usb.generic.autoconnect", formatBool(on)) || changed
+	}
+
+	if !changed {
+		return 0
+	}
+
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// isolationSetting is a guest feature controlled by the isolation command
+type isolationSetting struct {
+	Flag     string
+	Label    string
+	Keys     []string
+	Inverted bool
+	Default  bool
+}
+
+// isolationState returns the effective state of a setting and whether it
+// is set explicitly. The feature is off if any of its keys turns it off.
+func (d *Dictionary) isolationState(setting isolationSetting) (on bool, explicit bool) {
+	on = setting.Default
+	for _, key := range setting.Keys {
+		value, ok := d.QueryOK(key)
+		if !ok {
+			continue
+		}
+		b, ok := parseBool(value)
+		if !ok {
+			continue
+		}
+		explicit = true
+		if b == setting.Inverted {
+			return false, true
+		}
+		on = true
+	}
+	return on, explicit
+}
+
+// isolationSettings maps the isolation command's flags to VMX keys. Most
+// keys are .disable switches, so the key is inverted: on is written as
+// FALSE. setGUIOptions.enable and enableHostInfo are not. Default is the
+// state VMware uses when the keys are absent.
+var isolationSettings = []isolationSetting{
+	{"copy", "Copy from guest", []string{"isolation.tools.copy.disable"}, true, true},
+	{"paste", "Paste to guest", []string{"isolation.tools.paste.disable"}, true, true},
+	{"dnd", "Drag and drop", []string{"isolation.tools.dnd.disable"}, true, true},
+	{"disk-ops", "Disk shrink and wipe", []string{"isolation.tools.diskShrink.disable", "isolation.tools.diskWiper.disable"}, true, true},
+	{"gui-options", "Guest GUI options", []string{"isolation.tools.setGUIOptions.enable"}, false, false},
+	{"hostinfo", "Host info for guest", []string{"tools.guestlib.enableHostInfo"}, false, false},
+}
+
+// runIsolation implements the isolation command
+func runIsolation(args []string) int {
+	fs := flag.NewFlagSet("isolation", flag.ContinueOnError)
+	values := make(map[string]*string)
+	for _, setting := range isolationSettings {
+		values[setting.Flag] = fs.String(setting.Flag, "", setting.Label+": on or off")
+	}
+	lockdown := fs.Bool("lockdown", false, "turn every setting off")
+
+	usage := "Usage: vmxtool isolation FILE [--copy on|off] [--paste on|off] [--dnd on|off] [--disk-ops on|off] [--gui-options on|off] [--hostinfo on|off] [--lockdown]"
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		errorf("Error: isolation command requires FILE argument\n")
+		errorln(usage)
+		return exitUsage
+	}
+	filename := positional[0]
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	// Work out the requested state of each setting before changing anything
+	requested := make(map[string]bool)
+	for _, setting := range isolationSettings {
+		if *lockdown {
+			requested[setting.Flag] = false
+		}
+		if !flagWasSet(fs, setting.Flag) {
+			continue
+		}
+		on, ok := parseBool(*values[setting.Flag])
+		if !ok {
+			errorf("Error: invalid --%s value '%s', expected on or off\n", setting.Flag, *values[setting.Flag])
+			return exitUsage
+		}
+		requested[setting.Flag] = on
+	}
+
+	if len(requested) == 0 {
+		var rows []statusRow
+		for _, setting := range isolationSettings {
+			on, explicit := dict.isolationState(setting)
+			state := "off"
+			if on {
+				state = "on"
+			}
+			if !explicit {
+				state += " (default)"
+			}
+			rows = append(rows, statusRow{setting.Label, state})
+		}
+		printStatus(rows)
+		return 0
+	}
+
+	changed := false
+	for _, setting := range isolationSettings {
+		on, ok := requested[setting.Flag]
+		if !ok {
+			continue
+		}
+		for _, key := range setting.Keys {
+			changed = dict.Set(key, formatBool(on != setting.Inverted)) || changed
+		}
+	}
+
+	if !changed {
+		return 0
+	}
+
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// macOSPrepKey is a key the macos-prep command writes, with the reason
+// it is needed so the table can be audited
+type macOSPrepKey struct {
+	Key    string
+	Value  string
+	Reason string
+}
+
+// macOSPrepKeys lists the keys a macOS guest needs on an unlocked host
+var macOSPrepKeys = []macOSPrepKey{
+	{"smc.present", "TRUE", "macOS requires an Apple SMC"},
+	{"smc.version", "0", "SMC revision expected by the unlocker"},
+	{"ich7m.present", "TRUE", "ICH7-M chipset devices used by macOS"},
+	{"firmware", "efi", "macOS only boots from EFI"},
+	{"smbios.reflectHost", "FALSE", "use the VMX SMBIOS values, not the host's"},
+	{"board-id.reflectHost", "FALSE", "use board-id from the VMX file"},
+	{"hw.model.reflectHost", "FALSE", "use hw.model from the VMX file"},
+	{"serialNumber.reflectHost", "FALSE", "use serialNumber from the VMX file"},
+	{"usb.present", "TRUE", "USB input devices need a USB controller"},
+	{"usb_xhci.present", "TRUE", "recent macOS releases expect USB 3"},
+	{"keyboard.vusb.enable", "TRUE", "USB keyboard, PS/2 is not supported"},
+	{"mouse.vusb.enable", "TRUE", "USB mouse, PS/2 is not supported"},
+}
+
+// macOSBoardIDs maps Mac models to their board-id values
+var macOSBoardIDs = map[string]string{
+	"iMac20,1":       "Mac-CFF7D910A743CAAF",
+	"MacBookPro16,1": "Mac-E1008331FDC96864",
+	"Macmini8,1":     "Mac-7BA5B2DFE22DDD8C",
+	"MacPro7,1":      "Mac-27AD2F918AE68F61",
+}
+
+// serialCharset is the set of characters used in Apple serial numbers,
+// which never contain I or O
+const serialCharset = "0123456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+
+// syntheticSerialComment marks generated serial numbers in the VMX file
+const syntheticSerialComment = "# synthetic serial generated by vmxtool"
+
+// generateSerial returns a random 12 character serial number in the Apple
+// format. It is not a valid serial for any real Mac.
+func generateSerial() (string, error) {
+	var b [12]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	for i, v := range b {
+		b[i] = serialCharset[int(v)%len(serialCharset)]
+	}
+	return string(b[:]), nil
+}
+
+// runMacOSPrep implements the macos-prep command
+func runMacOSPrep(args []string) int {
+	fs := flag.NewFlagSet("macos-prep", flag.ContinueOnError)
+	model := fs.String("model", "", "Mac model to report, such as MacBookPro16,1")
+	serial := fs.String("serial", "", "serial number to report, or auto to generate one")
+	check := fs.Bool("check", false, "list missing keys without changing the file")
+
+	usage := "Usage: vmxtool macos-prep FILE [--model MODEL] [--serial auto|VALUE] [--check]"
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		errorf("Error: macos-prep command requires FILE argument\n")
+		errorln(usage)
+		return exitUsage
+	}
+	filename := positional[0]
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	if *check {
+		missing := 0
+		for _, k := range macOSPrepKeys {
+			value, ok := dict.QueryOK(k.Key)
+			switch {
+			case !ok:
+				fmt.Fprintf(stdout, "Missing: %s = \"%s\" (%s)\n", k.Key, k.Value, k.Reason)
+			case !valuesEqual(value, k.Value):
+				fmt.Fprintf(stdout, "Wrong: %s = \"%s\", expected \"%s\" (%s)\n", k.Key, escapeQuotes(value), k.Value, k.Reason)
+			default:
+				continue
+			}
+			missing++
+		}
+		if missing > 0 {
+			fmt.Fprintf(stdout, "%d of %d required keys missing or wrong\n", missing, len(macOSPrepKeys))
+			return exitDifferent
+		}
+		fmt.Fprintln(stdout, "All required keys are set")
+		return 0
+	}
+
+	changed := false
+	for _, k := range macOSPrepKeys {
+		changed = dict.SetGrouped(k.Key, k.Value) || changed
+	}
+
+	if *model != "" {
+		changed = dict.SetGrouped("hw.model", *model) || changed
+		if boardID, ok := macOSBoardIDs[*model]; ok {
+			changed = dict.SetGrouped("board-id", boardID) || changed
+		} else {
+			warnf("Warning: board-id for model '%s' is not known, board-id not changed\n", *model)
+		}
+	}
+
+	if *serial != "" {
+		value := *serial
+		if value == "auto" {
+			if value, err = generateSerial(); err != nil {
+				errorf("Error: %v\n", err)
+				return exitError
+			}
+		}
+		changed = dict.SetGrouped("serialNumber", value) || changed
+		if *serial == "auto" {
+			entry := dict.findEntryCaseInsensitive("serialNumber")
+			entry.InlineComment = syntheticSerialComment
+			entry.InlineCommentSpace = " "
+			infof("Generated synthetic serial number %s\n", value)
+		}
+	}
+
+	if !changed {
+		return 0
+	}
+
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// mitigationKey is a key that disables side-channel mitigations, with the
+// products that honour it
+type mitigationKey struct {
+	Key      string
+	Products string
+}
+
+// mitigationKeys lists the known mitigation keys. New releases can be
+// handled with the --key flag until they are added here.
+var mitigationKeys = []mitigationKey{
+	{"ulm.disableMitigations", "Workstation 16+ and Fusion 12+"},
+}
+
+// runMitigations implements the mitigations command
+func runMitigations(args []string) int {
+	fs := flag.NewFlagSet("mitigations", flag.ContinueOnError)
+	var customKeys []mitigationKey
+	fs.Func("key", "mitigation key to manage instead of the known keys (repeatable)", func(s string) error {
+		customKeys = append(customKeys, mitigationKey{s, "given with --key"})
+		return nil
+	})
+
+	usage := "Usage: vmxtool mitigations FILE [on|off|status] [--key KEY]..."
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	if len(positional) < 1 || len(positional) > 2 {
+		errorf("Error: mitigations command requires FILE argument\n")
+		errorln(usage)
+		return exitUsage
+	}
+	filename := positional[0]
+
+	keys := mitigationKeys
+	if len(customKeys) > 0 {
+		keys = customKeys
+	}
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	if len(positional) == 1 || positional[1] == "status" {
+		var rows []statusRow
+		disabled, explicit := false, false
+		for _, k := range keys {
+			value, ok := dict.QueryOK(k.Key)
+			if !ok {
+				rows = append(rows, statusRow{k.Key, notSet + " (" + k.Products + ")"})
+				continue
+			}
+			explicit = true
+			if b, ok := parseBool(value); ok && b {
+				disabled = true
+			}
+			rows = append(rows, statusRow{k.Key, value + " (" + k.Products + ")"})
+		}
+		switch {
+		case disabled:
+			rows = append(rows, statusRow{"Mitigations", "off (disabled explicitly)"})
+		case explicit:
+			rows = append(rows, statusRow{"Mitigations", "on (enabled explicitly)"})
+		default:
+			rows = append(rows, statusRow{"Mitigations", "on (enabled by default, no keys present)"})
+		}
+		printStatus(rows)
+		return 0
+	}
+
+	enable, ok := parseBool(positional[1])
+	if !ok {
+		errorf("Error: invalid state '%s', expected on, off or status\n", positional[1])
+		errorln(usage)
+		return exitUsage
+	}
+
+	changed := false
+	for _, k := range keys {
+		if enable {
+			if entry := dict.findEntryCaseInsensitive(k.Key); entry != nil {
+				dict.removeEntry(entry)
+				infof("Removed %s (%s)\n", k.Key, k.Products)
+				changed = true
+			}
+			continue
+		}
+		if dict.SetGrouped(k.Key, "TRUE") {
+			infof("Set %s = \"TRUE\" (%s)\n", k.Key, k.Products)
+			changed = true
+		}
+	}
+
+	if !enable {
+		warnf("Warning: disabling side-channel mitigations improves performance but exposes the host and other guests to attacks such as Spectre from this guest\n")
+	}
+
+	if !changed {
+		return 0
+	}
+
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// verboseLogKeys are the debug keys added by logging --verbose
+var verboseLogKeys = []struct {
+	Key   string
+	Value string
+}{
+	{"vmx.buildType", "debug"},
+	{"log.throttleBytesPerSec", "0"},
+}
+
+// formatLogRotateSize adds the size in larger units to a log.rotateSize
+// value, which VMware stores in bytes
+func formatLogRotateSize(value string) string {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil && n >= kilobyte {
+		return fmt.Sprintf("%s (%s)", value, formatBytes(n))
+	}
+	return value
+}
+
+// runLogging implements the logging command
+func runLogging(args []string) int {
+	fs := flag.NewFlagSet("logging", flag.ContinueOnError)
+	enable := fs.Bool("enable", false, "turn logging on")
+	disable := fs.Bool("disable", false, "turn logging off")
+	logFile := fs.String("file", "", "log file name, relative to the VMX directory")
+	rotateSize := fs.String("rotate-size", "", "size at which the log is rotated, such as 2M")
+	keep := fs.Int("keep", 0, "number of old log files to keep")
+	verbose := fs.Bool("verbose", false, "add debug logging keys, or remove them with --verbose=false")
+
+	usage := "Usage: vmxtool logging FILE [--enable|--disable] [--file PATH] [--rotate-size SIZE] [--keep N] [--verbose]"
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		errorf("Error: logging command requires FILE argument\n")
+		errorln(usage)
+		return exitUsage
+	}
+	if *enable && *disable {
+		errorf("Error: --enable and --disable cannot be used together\n")
+		errorln(usage)
+		return exitUsage
+	}
+	filename := positional[0]
+
+	// Validate every value before changing anything
+	var rotateBytes int64
+	if *rotateSize != "" {
+		if rotateBytes, err = parseSize(*rotateSize, 1); err != nil {
+			errorf("Error: %v\n", err)
+			return exitUsage
+		}
+	}
+	if flagWasSet(fs, "keep") && *keep < 0 {
+		errorf("Error: invalid --keep value %d, expected 0 or more\n", *keep)
+		return exitUsage
+	}
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	if fs.NFlag() == 0 {
+		state := "on"
+		if !dict.queryBoolOr("logging", true) {
+			state = "off"
+		}
+		if !dict.KeyExists("logging") {
+			state += " (default)"
+		}
+		rows := []statusRow{
+			{"Logging", state},
+			{"Log file", dict.queryOr("log.fileName", "vmware.log (default)")},
+			{"Rotate size", formatLogRotateSize(dict.queryOr("log.rotateSize", notSet))},
+			{"Old logs kept", dict.queryOr("log.keepOld", notSet)},
+		}
+		for _, k := range verboseLogKeys {
+			rows = append(rows, statusRow{k.Key, dict.queryOr(k.Key, notSet)})
+		}
+		printStatus(rows)
+		return 0
+	}
+
+	changed := false
+	if *enable || *disable {
+		changed = dict.SetGrouped("logging", formatBool(*enable)) || changed
+	}
+	if *logFile != "" {
+		changed = dict.SetGrouped("log.fileName", *logFile) || changed
+
+		// VMware resolves relative log paths against the VMX directory
+		logPath := *logFile
+		if !filepath.IsAbs(logPath) {
+			logPath = filepath.Join(filepath.Dir(filename), logPath)
+		}
+		if _, err := files.Stat(filepath.Dir(logPath)); err != nil {
+			warnf("Warning: log directory %s does not exist\n", filepath.Dir(logPath))
+		}
+	}
+	if *rotateSize != "" {
+		changed = dict.SetGrouped("log.rotateSize", strconv.FormatInt(rotateBytes, 10)) || changed
+	}
+	if flagWasSet(fs, "keep") {
+		changed = dict.SetGrouped("log.keepOld", strconv.Itoa(*keep)) || changed
+	}
+	if flagWasSet(fs, "verbose") {
+		for _, k := range verboseLogKeys {
+			if *verbose {
+				changed = dict.SetGrouped(k.Key, k.Value) || changed
+			} else if entry := dict.findEntryCaseInsensitive(k.Key); entry != nil {
+				dict.removeEntry(entry)
+				changed = true
+			}
+		}
+		if *verbose {
+			warnf("Warning: debug logging slows the virtual machine down, remove it with --verbose=false when done\n")
+		}
+	}
+
+	if !changed {
+		return 0
+	}
+
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// hardeningSetting is a key and the value a hardening profile requires
+type hardeningSetting struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// hardeningProfile is a named set of hardening settings. A profile can
+// extend a built-in profile, overriding or adding to its settings.
+type hardeningProfile struct {
+	Name        string             `json:"name"`
+	Description string             `json:"description,omitempty"`
+	Extends     string             `json:"extends,omitempty"`
+	Settings    []hardeningSetting `json:"settings"`
+}
+
+// builtinHardeningProfiles returns the profiles compiled into vmxtool
+func builtinHardeningProfiles() ([]hardeningProfile, error) {
+	var profiles []hardeningProfile
+	if err := json.Unmarshal(hardeningData, &profiles); err != nil {
+		return nil, fmt.Errorf("invalid built-in hardening profiles: %v", err)
+	}
+	return profiles, nil
+}
+
+// resolveHardeningProfile returns profile with the settings of the
+// profiles it extends merged in, so it no longer extends anything
+func resolveHardeningProfile(profile hardeningProfile, builtin []hardeningProfile) (hardeningProfile, error) {
+	seen := make(map[string]bool)
+	chain := []hardeningProfile{profile}
+	for p := profile; p.Extends != ""; {
+		if seen[p.Extends] {
+			return hardeningProfile{}, fmt.Errorf("hardening profile '%s' extends itself", p.Extends)
+		}
+		seen[p.Extends] = true
+		i := slices.IndexFunc(builtin, func(b hardeningProfile) bool { return b.Name == p.Extends })
+		if i == -1 {
+			return hardeningProfile{}, fmt.Errorf("unknown hardening profile '%s'", p.Extends)
+		}
+		p = builtin[i]
+		chain = append(chain, p)
+	}
+
+	// Apply the most basic profile first so extensions override it
+	resolved := hardeningProfile{Name: profile.Name, Description: profile.Description}
+	index := make(map[string]int)
+	for _, p := range slices.Backward(chain) {
+		for _, setting := range p.Settings {
+			lowerKey := strings.ToLower(setting.Key)
+			if i, ok := index[lowerKey]; ok {
+				resolved.Settings[i] = setting
+				continue
+			}
+			index[lowerKey] = len(resolved.Settings)
+			resolved.Settings = append(resolved.Settings, setting)
+		}
+	}
+	return resolved, nil
+}
+
+// loadHardeningProfile returns the named built-in profile, or the profile
+// in filename if it is not empty, with any extended profiles merged in
+func loadHardeningProfile(name, filename string) (hardeningProfile, error) {
+	builtin, err := builtinHardeningProfiles()
+	if err != nil {
+		return hardeningProfile{}, err
+	}
+
+	if filename != "" {
+		data, err := files.ReadFile(filename)
+		if err != nil {
+			return hardeningProfile{}, err
+		}
+		var profile hardeningProfile
+		if err := json.Unmarshal(data, &profile); err != nil {
+			return hardeningProfile{}, fmt.Errorf("invalid hardening profile %s: %v", filename, err)
+		}
+		return resolveHardeningProfile(profile, builtin)
+	}
+
+	for _, profile := range builtin {
+		if profile.Name == name {
+			return resolveHardeningProfile(profile, builtin)
+		}
+	}
+	var names []string
+	for _, profile := range builtin {
+		names = append(names, profile.Name)
+	}
+	return hardeningProfile{}, fmt.Errorf("unknown hardening profile '%s', expected %s", name, strings.Join(names, " or "))
+}
+
+// runHarden implements the harden command
+func runHarden(args []string) int {
+	fs := flag.NewFlagSet("harden", flag.ContinueOnError)
+	profileName := fs.String("profile", "baseline", "built-in profile: baseline or strict")
+	profileFile := fs.String("profile-file", "", "JSON file containing a custom profile")
+	check := fs.Bool("check", false, "report compliance without changing the file")
+	export := fs.Bool("export", false, "print the profile as JSON")
+
+	usage := "Usage: vmxtool harden FILE [--profile baseline|strict | --profile-file PATH] [--check]\n" +
+		"       vmxtool harden --export [--profile baseline|strict | --profile-file PATH]"
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	if flagWasSet(fs, "profile") && *profileFile != "" {
+		errorf("Error: --profile and --profile-file cannot be used together\n")
+		errorln(usage)
+		return exitUsage
+	}
+	if (*export && len(positional) != 0) || (!*export && len(positional) != 1) {
+		errorf("Error: harden command requires FILE argument, or --export without one\n")
+		errorln(usage)
+		return exitUsage
+	}
+
+	profile, err := loadHardeningProfile(*profileName, *profileFile)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		return exitError
+	}
+
+	if *export {
+		data, err := json.MarshalIndent(profile, "", "  ")
+		if err != nil {
+			errorf("Error: %v\n", err)
+			return exitError
+		}
+		fmt.Fprintln(stdout, string(data))
+		return 0
+	}
+
+	filename := positional[0]
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	if *check {
+		var rows []statusRow
+		failures := 0
+		for _, setting := range profile.Settings {
+			value, ok := dict.QueryOK(setting.Key)
+			switch {
+			case !ok:
+				rows = append(rows, statusRow{setting.Key, fmt.Sprintf("FAIL (not set, expected \"%s\")", setting.Value)})
+				failures++
+			case !valuesEqual(value, setting.Value):
+				rows = append(rows, statusRow{setting.Key, fmt.Sprintf("FAIL (\"%s\", expected \"%s\")", escapeQuotes(value), setting.Value)})
+				failures++
+			default:
+				rows = append(rows, statusRow{setting.Key, "PASS"})
+			}
+		}
+		printStatus(rows)
+		fmt.Fprintf(stdout, "%d of %d settings pass the %s profile\n", len(profile.Settings)-failures, len(profile.Settings), profile.Name)
+		if failures > 0 {
+			return exitDifferent
+		}
+		return 0
+	}
+
+	changes := 0
+	for _, setting := range profile.Settings {
+		if dict.SetGrouped(setting.Key, setting.Value) {
+			changes++
+		}
+	}
+	infof("Changed %d of %d settings for the %s profile\n", changes, len(profile.Settings), profile.Name)
+
+	if changes == 0 {
+		return 0
+	}
+
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// cleanKey is a key pattern removed by the clean command
+type cleanKey struct {
+	Pattern    string
+	Reason     string
+	Aggressive bool // only removed with --aggressive
+	File       bool // the value names a file; kept while the file exists
+}
+
+// cleanKeys lists runtime and host-specific keys that VMware recreates
+// when needed, so removing them is safe
+var cleanKeys = []cleanKey{
+	{"checkpoint.vmState", "suspended state file", false, true},
+	{"checkpoint.vmState.readOnly", "suspended state flag", false, false},
+	{"sched.swap.derivedName", "swap file from the last power on", false, true},
+	{"migrate.hostLog", "migration log file", false, true},
+	{"migrate.hostLogState", "migration state", false, false},
+	{"migrate.migrationId", "migration identifier", false, false},
+	{"vmotion.checkpointFBSize", "frame buffer size from the last migration", false, false},
+	{"vmotion.checkpointSVGAPrimarySize", "SVGA size from the last migration", false, false},
+	{"gui.lastPoweredViewMode", "console view mode", false, false},
+	{"numa.autosize.cookie", "NUMA sizing cache", false, false},
+	{"numa.autosize.vcpu.maxPerVirtualNode", "NUMA sizing cache", false, false},
+	{"toolsInstallManager.lastInstallError", "VMware Tools installer status", false, false},
+	{"toolsInstallManager.updateCounter", "VMware Tools installer status", false, false},
+	{"*.pciSlotNumber", "PCI slot assigned by the host", true, false},
+	{"vmci0.id", "VMCI identifier assigned by the host", true, false},
+	{"monitor.phys_bits_used", "physical address width of the last host", true, false},
+	{"cleanShutdown", "shutdown state", true, false},
+	{"softPowerOff", "power off state", true, false},
+}
+
+// runClean implements the clean command
+func runClean(args []string) int {
+	fs := flag.NewFlagSet("clean", flag.ContinueOnError)
+	aggressive := fs.Bool("aggressive", false, "also remove host-specific keys such as PCI slot numbers")
+	list := fs.Bool("list", false, "list the keys clean removes")
+
+	usage := "Usage: vmxtool clean FILE [--aggressive]\n" +
+		"       vmxtool clean --list"
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+
+	if *list {
+		var rows []statusRow
+		for _, k := range cleanKeys {
+			reason := k.Reason
+			if k.Aggressive {
+				reason += " (--aggressive)"
+			}
+			if k.File {
+				reason += ", kept while the file exists"
+			}
+			rows = append(rows, statusRow{k.Pattern, reason})
+		}
+		printStatus(rows)
+		return 0
+	}
+
+	if len(positional) != 1 {
+		errorf("Error: clean command requires FILE argument\n")
+		errorln(usage)
+		return exitUsage
+	}
+	filename := positional[0]
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	verb := "Removed"
+	if globalOptions.DryRun {
+		verb = "Would remove"
+	}
+	removed := 0
+	for _, k := range cleanKeys {
+		if k.Aggressive && !*aggressive {
+			continue
+		}
+		for _, entry := range dict.FindMatching(k.Pattern) {
+			// Relative paths are relative to the VMX file
+			if k.File && entry.Value != "" {
+				filePath := entry.Value
+				if !filepath.IsAbs(filePath) {
+					filePath = filepath.Join(filepath.Dir(filename), filePath)
+				}
+				if _, err := files.Stat(filePath); err == nil {
+					infof("Kept %s = \"%s\" (file exists)\n", entry.Key, escapeQuotes(entry.Value))
+					continue
+				}
+			}
+			infof("%s %s = \"%s\" (%s)\n", verb, entry.Key, escapeQuotes(entry.Value), k.Reason)
+			dict.removeEntry(entry)
+			removed++
+		}
+	}
+
+	if removed == 0 {
+		return 0
+	}
+
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// portabilityIssue is a host-specific binding found by the portable
+// command. Fix is nil when the binding cannot be removed safely.
+type portabilityIssue struct {
+	Key        string
+	Value      string
+	Problem    string
+	Suggestion string
+	Fix        func(d *Dictionary)
+}
+
+// isHostPath reports whether p is an absolute path on any host OS, since
+// a VMX file may have been written on Windows, macOS or Linux
+func isHostPath(p string) bool {
+	if strings.HasPrefix(p, "/") || strings.HasPrefix(p, `\`) {
+		return true
+	}
+	// Windows drive letter, such as C:\ or C:/
+	if len(p) < 3 || p[1] != ':' || (p[2] != '\\' && p[2] != '/') {
+		return false
+	}
+	drive := p[0] | 0x20
+	return drive >= 'a' && drive <= 'z'
+}
+
+// detachImage removes the image from a CD-ROM device such as sata0:1 and
+// stops it connecting at power on
+func (d *Dictionary) detachImage(device string) {
+	d.Set(device+".fileName", "")
+	d.SetGrouped(device+".startConnected", "FALSE")
+}
+
+// portabilityIssues returns the host-specific bindings in the dictionary
+func (d *Dictionary) portabilityIssues() []portabilityIssue {
+	var issues []portabilityIssue
+
+	// CD-ROM images and drives, and disks stored outside the VM directory
+	for _, pattern := range []string{"ide*:*.fileName", "sata*:*.fileName", "scsi*:*.fileName", "nvme*:*.fileName"} {
+		for _, entry := range d.FindMatching(pattern) {
+			device := strings.TrimSuffix(entry.Key, ".fileName")
+			deviceType := strings.ToLower(d.queryOr(device+".deviceType", ""))
+			switch {
+			case deviceType == "cdrom-image" && isHostPath(entry.Value):
+				issues = append(issues, portabilityIssue{entry.Key, entry.Value,
+					"ISO image on a host path", "detach the image",
+					func(d *Dictionary) { d.detachImage(device) }})
+			case deviceType == "cdrom-raw" && !strings.EqualFold(entry.Value, "auto detect"):
+				issues = append(issues, portabilityIssue{entry.Key, entry.Value,
+					"bound to a host CD drive", "use auto detect",
+					func(d *Dictionary) { d.Set(device+".fileName", "auto detect") }})
+			case strings.HasPrefix(deviceType, "cdrom"):
+			case isHostPath(entry.Value):
+				issues = append(issues, portabilityIssue{entry.Key, entry.Value,
+					"disk outside the VM directory", "copy the disk into the VM directory and use a relative path", nil})
+			}
+		}
+	}
+
+	// Network adapters on host-only or specific host networks
+	for _, index := range d.deviceIndices("ethernet") {
+		prefix := fmt.Sprintf("ethernet%d.", index)
+		connectionType := strings.ToLower(d.queryOr(prefix+"connectionType", ""))
+		vnet, hasVnet := d.QueryOK(prefix + "vnet")
+		bsdName, hasBSDName := d.QueryOK(prefix + "bsdName")
+		var key, value, problem string
+		switch {
+		case connectionType == "hostonly":
+			key, value, problem = prefix+"connectionType", connectionType, "host-only network"
+		case connectionType == "custom" && hasVnet:
+			key, value, problem = prefix+"vnet", vnet, "bound to a host virtual network"
+		case connectionType == "bridged" && hasBSDName:
+			key, value, problem = prefix+"bsdName", bsdName, "bridged to a specific host adapter"
+		default:
+			continue
+		}
+		issues = append(issues, portabilityIssue{key, value, problem, "switch to nat",
+			func(d *Dictionary) {
+				d.Set(prefix+"connectionType", "nat")
+				d.Remove(prefix + "vnet")
+				d.Remove(prefix + "bsdName")
+			}})
+	}
+
+	// USB devices connected automatically by their host device ID
+	for _, entry := range d.FindMatching("usb.autoConnect.device*") {
+		issues = append(issues, portabilityIssue{entry.Key, entry.Value,
+			"USB device of this host connected automatically", "remove the key",
+			func(d *Dictionary) { d.removeEntry(entry) }})
+	}
+
+	// Serial and parallel ports passed through to host devices, or
+	// writing to host paths
+	for _, port := range []string{"serial", "parallel"} {
+		for _, index := range d.deviceIndices(port) {
+			prefix := fmt.Sprintf("%s%d.", port, index)
+			fileType := strings.ToLower(d.queryOr(prefix+"fileType", ""))
+			fileName := d.queryOr(prefix+"fileName", "")
+			switch {
+			case fileType == "device":
+				issues = append(issues, portabilityIssue{prefix + "fileName", fileName,
+					"passed through to a host device", "remove the port",
+					func(d *Dictionary) { d.SetGrouped(prefix+"present", "FALSE") }})
+			case fileType == "file" && isHostPath(fileName):
+				issues = append(issues, portabilityIssue{prefix + "fileName", fileName,
+					"output file on a host path", "use a path relative to the VM directory", nil})
+			}
+		}
+	}
+
+	// Shared folders always point at the host
+	for _, folder := range d.sharedFolders() {
+		if folder.HostPath == "" {
+			continue
+		}
+		issues = append(issues, portabilityIssue{fmt.Sprintf("sharedFolder%d.hostPath", folder.Index), folder.HostPath,
+			"shared folder on this host", "update the path on the new host", nil})
+	}
+
+	return issues
+}
+
+// runPortable implements the portable command
+func runPortable(args []string) int {
+	fs := flag.NewFlagSet("portable", flag.ContinueOnError)
+	fix := fs.Bool("fix", false, "apply the safe fixes")
+
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool portable FILE [--fix]\n")
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		errorf("Error: portable command requires FILE argument\n")
+		errorf("Usage: vmxtool portable FILE [--fix]\n")
+		return exitUsage
+	}
+	filename := positional[0]
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	issues := dict.portabilityIssues()
+	unresolved := 0
+	for _, issue := range issues {
+		description := fmt.Sprintf("%s = \"%s\": %s", issue.Key, escapeQuotes(issue.Value), issue.Problem)
+		switch {
+		case *fix && issue.Fix != nil:
+			issue.Fix(dict)
+			infof("Fixed: %s (%s)\n", description, issue.Suggestion)
+		case *fix:
+			fmt.Fprintf(stdout, "Unresolved: %s, %s\n", description, issue.Suggestion)
+			unresolved++
+		default:
+			fmt.Fprintf(stdout, "Issue: %s, suggested fix: %s\n", description, issue.Suggestion)
+			unresolved++
+		}
+	}
+
+	if len(issues) == 0 {
+		fmt.Fprintln(stdout, "No host-specific bindings found")
+		return 0
+	}
+
+	if *fix && unresolved < len(issues) {
+		if err := saveDictionary(dict, filename); err != nil {
+			errorf("Error saving file: %v\n", err)
+			return exitFileError
+		}
+	}
+
+	if unresolved > 0 {
+		return exitDifferent
+	}
+	return 0
+}
+
+// relocatePath rewrites p if it starts with the directory from, matching
+// either slash style, and returns the new path and whether it matched.
+// The rest of the path uses the separator style of to.
+func relocatePath(p, from, to string) (string, bool) {
+	normalized := strings.ReplaceAll(p, `\`, "/")
+	prefix := strings.TrimSuffix(strings.ReplaceAll(from, `\`, "/"), "/")
+	if prefix == "" || !strings.HasPrefix(normalized, prefix) {
+		return p, false
+	}
+	rest := normalized[len(prefix):]
+	if rest != "" && rest[0] != '/' {
+		// Only match whole path components
+		return p, false
+	}
+
+	to = strings.TrimRight(to, `/\`)
+	if strings.Contains(to, `\`) && !strings.Contains(to, "/") {
+		rest = strings.ReplaceAll(rest, "/", `\`)
+	}
+	return to + rest, true
+}
+
+// relocateKeys returns the entries whose values are file paths rewritten
+// by the relocate command
+func (d *Dictionary) relocateKeys() []*Entry {
+	entries := d.FindMatching("*.fileName")
+	if entry := d.findEntryCaseInsensitive("nvram"); entry != nil {
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// runRelocate implements the relocate command
+func runRelocate(args []string) int {
+	fs := flag.NewFlagSet("relocate", flag.ContinueOnError)
+	from := fs.String("from", "", "old path prefix")
+	to := fs.String("to", "", "new path prefix")
+	toRelative := fs.Bool("to-relative", false, "make paths under the VMX directory relative")
+
+	usage := "Usage: vmxtool relocate FILE [--from OLD --to NEW] [--to-relative]"
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		errorf("Error: relocate command requires FILE argument\n")
+		errorln(usage)
+		return exitUsage
+	}
+	if (*from == "") != (*to == "") || (*from == "" && !*toRelative) {
+		errorf("Error: relocate command requires --from and --to, or --to-relative\n")
+		errorln(usage)
+		return exitUsage
+	}
+	filename := positional[0]
+
+	vmxDir, err := filepath.Abs(filepath.Dir(filename))
+	if err != nil {
+		errorf("Error: %v\n", err)
+		return exitFileError
+	}
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	var rows []statusRow
+	var missing []string
+	for _, entry := range dict.relocateKeys() {
+		newPath := entry.Value
+		if *from != "" {
+			newPath, _ = relocatePath(newPath, *from, *to)
+		}
+		if *toRelative && filepath.IsAbs(newPath) {
+			if rel, err := filepath.Rel(vmxDir, newPath); err == nil && filepath.IsLocal(rel) {
+				newPath = filepath.ToSlash(rel)
+			}
+		}
+		if newPath == entry.Value {
+			continue
+		}
+
+		rows = append(rows, statusRow{entry.Key, fmt.Sprintf("%s -> %s", entry.Value, newPath)})
+		resolved := newPath
+		if !filepath.IsAbs(resolved) {
+			resolved = filepath.Join(vmxDir, resolved)
+		}
+		if _, err := files.Stat(resolved); err != nil {
+			missing = append(missing, fmt.Sprintf("%s: %s does not exist", entry.Key, newPath))
+		}
+		dict.Set(entry.Key, newPath)
+	}
+
+	if len(rows) == 0 {
+		infof("Nothing to change\n")
+		return 0
+	}
+	printStatus(rows)
+	for _, m := range missing {
+		warnf("Warning: %s\n", m)
+	}
+
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// fileReference is a file named by a VMX key, checked by the check command
+type fileReference struct {
+	Key      string `json:"key"`
+	Path     string `json:"path"`
+	Resolved string `json:"resolved,omitempty"`
+	Status   string `json:"status"`
+	Required bool   `json:"required"`
+	Device   string `json:"-"` // CD-ROM device, for --fix-detach
+}
+
+// Reference statuses reported by the check command
+const (
+	refOK           = "OK"
+	refMissing      = "MISSING"
+	refUnreadable   = "UNREADABLE"
+	refUnresolvable = "UNRESOLVABLE"
+)
+
+// resolveReference returns the path a VMX file reference names on this
+// host, relative to vmxDir, or false if it is a path for another OS, such
+// as a Windows drive letter when running elsewhere
+func resolveReference(p, vmxDir string) (string, bool) {
+	if runtime.GOOS != "windows" {
+		if isHostPath(p) && !strings.HasPrefix(p, "/") {
+			return "", false
+		}
+		p = strings.ReplaceAll(p, `\`, "/")
+	}
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(vmxDir, p)
+	}
+	return p, true
+}
+
+// fileReferences returns the files the dictionary refers to. References
+// are required unless VMware can do without them: images on devices that
+// are not connected at power on, and nvram, which is created at boot.
+func (d *Dictionary) fileReferences() []fileReference {
+	var refs []fileReference
+	for _, pattern := range []string{"ide*:*.fileName", "sata*:*.fileName", "scsi*:*.fileName", "nvme*:*.fileName"} {
+		for _, entry := range d.FindMatching(pattern) {
+			device := strings.TrimSuffix(entry.Key, ".fileName")
+			deviceType := strings.ToLower(d.queryOr(device+".deviceType", ""))
+			if entry.Value == "" || deviceType == "cdrom-raw" || !d.queryBoolOr(device+".present", true) {
+				continue
+			}
+			ref := fileReference{Key: entry.Key, Path: entry.Value, Required: true}
+			if deviceType == "cdrom-image" {
+				ref.Device = device
+				ref.Required = d.queryBoolOr(device+".startConnected", true)
+			}
+			refs = append(refs, ref)
+		}
+	}
+
+	if value, ok := d.QueryOK("floppy0.fileName"); ok && value != "" &&
+		d.queryBoolOr("floppy0.present", true) && strings.EqualFold(d.queryOr("floppy0.fileType", "file"), "file") {
+		refs = append(refs, fileReference{Key: "floppy0.fileName", Path: value, Required: d.queryBoolOr("floppy0.startConnected", true)})
+	}
+
+	if value, ok := d.QueryOK("nvram"); ok && value != "" {
+		refs = append(refs, fileReference{Key: "nvram", Path: value})
+	}
+
+	for _, index := range d.deviceIndices("serial") {
+		prefix := fmt.Sprintf("serial%d.", index)
+		if strings.EqualFold(d.queryOr(prefix+"fileType", ""), "file") && d.queryBoolOr(prefix+"present", false) {
+			refs = append(refs, fileReference{Key: prefix + "fileName", Path: d.queryOr(prefix+"fileName", "")})
+		}
+	}
+
+	for _, folder := range d.sharedFolders() {
+		if folder.HostPath != "" && folder.Enabled {
+			refs = append(refs, fileReference{Key: fmt.Sprintf("sharedFolder%d.hostPath", folder.Index), Path: folder.HostPath, Required: true})
+		}
+	}
+	return refs
+}
+
+// runCheck implements the check command
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	jsonOutput := fs.Bool("json", false, "print the report as JSON")
+	fixDetach := fs.Bool("fix-detach", false, "detach missing ISO images")
+
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool check FILE [--json] [--fix-detach]\n")
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		errorf("Error: check command requires FILE argument\n")
+		errorf("Usage: vmxtool check FILE [--json] [--fix-detach]\n")
+		return exitUsage
+	}
+	filename := positional[0]
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	vmxDir := filepath.Dir(filename)
+	refs := dict.fileReferences()
+	failures := 0
+	var detached []string
+	for i := range refs {
+		ref := &refs[i]
+		resolved, ok := resolveReference(ref.Path, vmxDir)
+		switch {
+		case !ok:
+			ref.Status = refUnresolvable
+		case isReadable(resolved):
+			ref.Status = refOK
+		default:
+			ref.Status = refMissing
+			if _, err := files.Stat(resolved); err == nil {
+				ref.Status = refUnreadable
+			}
+		}
+		ref.Resolved = resolved
+
+		if ref.Status != refOK && ref.Device != "" && *fixDetach {
+			dict.detachImage(ref.Device)
+			detached = append(detached, ref.Key)
+			ref.Required = false
+		}
+		if ref.Status != refOK && ref.Required {
+			failures++
+		}
+	}
+
+	if *jsonOutput {
+		if refs == nil {
+			refs = []fileReference{}
+		}
+		data, err := json.MarshalIndent(refs, "", "  ")
+		if err != nil {
+			errorf("Error: %v\n", err)
+			return exitError
+		}
+		fmt.Fprintln(stdout, string(data))
+	} else {
+		var rows []statusRow
+		for _, ref := range refs {
+			value := ref.Status + " " + ref.Path
+			if ref.Status != refOK && !ref.Required {
+				value += " (warning only)"
+			}
+			rows = append(rows, statusRow{ref.Key, value})
+		}
+		if len(rows) == 0 {
+			fmt.Fprintln(stdout, "No file references found")
+		}
+		printStatus(rows)
+		for _, key := range detached {
+			infof("Detached %s\n", key)
+		}
+	}
+
+	if len(detached) > 0 {
+		if err := saveDictionary(dict, filename); err != nil {
+			errorf("Error saving file: %v\n", err)
+			return exitFileError
+		}
+	}
+
+	if failures > 0 {
+		return exitDifferent
+	}
+	return 0
+}
+
+// isReadable reports whether the file or directory at p can be opened
+func isReadable(p string) bool {
+	f, err := files.Open(p)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
+
+// diskDevice splits a storage key such as scsi0:1.fileName into its
+// controller (scsi0) and device (scsi0:1)
+func diskDevice(key string) (controller, device string, ok bool) {
+	for _, bus := range []string{"ide", "sata", "scsi", "nvme"} {
+		if len(key) <= len(bus) || !strings.EqualFold(key[:len(bus)], bus) {
+			continue
+		}
+		ctrlDigits, rest, found := strings.Cut(key[len(bus):], ":")
+		if !found {
+			return "", "", false
+		}
+		devDigits, _, found := strings.Cut(rest, ".")
+		if !found {
+			return "", "", false
+		}
+		if _, err := strconv.Atoi(ctrlDigits); err != nil {
+			return "", "", false
+		}
+		if _, err := strconv.Atoi(devDigits); err != nil {
+			return "", "", false
+		}
+		controller = key[:len(bus)+len(ctrlDigits)]
+		return controller, key[:len(controller)+1+len(devDigits)], true
+	}
+	return "", "", false
+}
+
+// storageBuses gives the highest device number of each storage controller
+// type
+var storageBuses = []struct {
+	Name    string
+	Display string
+	MaxUnit int
+}{
+	{"ide", "IDE", 1},
+	{"sata", "SATA", 29},
+	{"scsi", "SCSI", 15},
+	{"nvme", "NVMe", 14},
+}
+
+// parseController splits a controller name such as nvme0 into its bus
+// and display name, and gives the highest device number of the bus
+func parseController(name string) (bus, display string, maxUnit int, err error) {
+	for _, b := range storageBuses {
+		if digits, ok := strings.CutPrefix(strings.ToLower(name), b.Name); ok {
+			if n, err := strconv.Atoi(digits); err == nil && n >= 0 {
+				return b.Name, b.Display, b.MaxUnit, nil
+			}
+		}
+	}
+	return "", "", 0, fmt.Errorf("invalid controller '%s', expected ide, sata, scsi or nvme and a number, such as nvme0", name)
+}
+
+// runConvertController implements the convert-controller command
+func runConvertController(args []string) int {
+	fs := flag.NewFlagSet("convert-controller", flag.ContinueOnError)
+	from := fs.String("from", "", "controller to move the devices from, such as scsi0")
+	to := fs.String("to", "", "controller to move the devices to, such as nvme0")
+
+	usage := "Usage: vmxtool convert-controller FILE --from CONTROLLER --to CONTROLLER"
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	if len(positional) != 1 || *from == "" || *to == "" {
+		errorf("Error: convert-controller command requires FILE, --from and --to arguments\n")
+		errorln(usage)
+		return exitUsage
+	}
+	filename := positional[0]
+
+	_, _, _, err = parseController(*from)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	toBus, toDisplay, maxUnit, err := parseController(*to)
+	if err == nil && strings.EqualFold(*from, *to) {
+		err = fmt.Errorf("--from and --to are both %s", *from)
+	}
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	source, target := strings.ToLower(*from), strings.ToLower(*to)
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	if required := minHWVersionFor(target + ".present"); required > 0 {
+		version, err := dict.HWVersion()
+		if err != nil || version < required {
+			errorf("Error: %s controllers need hardware version %d or later, use set-hw-version first\n", toDisplay, required)
+			return exitError
+		}
+	}
+
+	// Collect the devices to move and check each fits on the target
+	var devices []string
+	used := make(map[string]bool)
+	for _, entry := range dict.Entries {
+		controller, device, ok := diskDevice(entry.Key)
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(controller) {
+		case source:
+			if !slices.Contains(devices, strings.ToLower(device)) {
+				devices = append(devices, strings.ToLower(device))
+			}
+		case target:
+			used[strings.ToLower(device)] = true
+		}
+	}
+	if len(devices) == 0 {
+		errorf("Error: %s has no devices\n", source)
+		return exitKeyNotFound
+	}
+	for _, device := range devices {
+		unitDigits := device[len(source)+1:]
+		unit, _ := strconv.Atoi(unitDigits)
+		newDevice := target + ":" + unitDigits
+		switch {
+		case used[newDevice]:
+			errorf("Error: %s already exists, so %s cannot be moved to it\n", newDevice, device)
+			return exitKeyExists
+		case unit > maxUnit || (toBus == "scsi" && unit == 7):
+			errorf("Error: %s cannot be moved, %s controllers have no device %d\n", device, toDisplay, unit)
+			return exitError
+		case toBus == "nvme" && strings.Contains(strings.ToLower(dict.queryOr(device+".deviceType", "")), "cdrom"):
+			errorf("Error: %s is a CD-ROM drive, which NVMe controllers cannot hold\n", device)
+			return exitError
+		}
+	}
+
+	renamed, removed := "Renamed", "Removed"
+	if globalOptions.DryRun {
+		renamed, removed = "Would rename", "Would remove"
+	}
+	for _, entry := range slices.Clone(dict.Entries) {
+		if entry.Key == "" {
+			continue
+		}
+		if controller, _, ok := diskDevice(entry.Key); ok && strings.EqualFold(controller, source) {
+			newKey := target + entry.Key[len(controller):]
+			infof("%s %s to %s\n", renamed, entry.Key, newKey)
+			dict.renameEntry(entry, newKey)
+			continue
+		}
+		// Controller keys such as scsi0.virtualDev belong to the old bus
+		if strings.HasPrefix(strings.ToLower(entry.Key), source+".") {
+			infof("%s %s\n", removed, entry.Key)
+			dict.removeEntry(entry)
+		}
+	}
+
+	// Boot order lists devices by name
+	for _, key := range []string{"bios.hddOrder", "bios.bootOrder"} {
+		if value, ok := dict.QueryOK(key); ok {
+			var items []string
+			for _, item := range strings.Split(value, ",") {
+				if i := slices.Index(devices, strings.ToLower(strings.TrimSpace(item))); i != -1 {
+					item = target + devices[i][len(source):]
+				}
+				items = append(items, item)
+			}
+			dict.Set(key, strings.Join(items, ","))
+		}
+	}
+
+	if toBus != "ide" {
+		dict.SetGrouped(target+".present", "TRUE")
+	}
+	if toBus == "scsi" && !dict.KeyExists(target+".virtualDev") {
+		dict.SetGrouped(target+".virtualDev", "lsisas1068")
+	}
+
+	warnf("Warning: the guest OS needs a driver for the %s controller to boot from %s\n", toDisplay, target)
+
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// dependencyRule is a check-deps rule. Check returns a message for each
+// key found without the companion keys it needs.
+type dependencyRule struct {
+	ID          string
+	Description string
+	Check       func(d *Dictionary) []string
+}
+
+// presentRule checks that every numbered device with the key prefix has a
+// PREFIXN.present key
+func presentRule(prefix string) func(d *Dictionary) []string {
+	return func(d *Dictionary) []string {
+		var problems []string
+		for _, index := range d.deviceIndices(prefix) {
+			device := fmt.Sprintf("%s%d", prefix, index)
+			if !d.KeyExists(device + ".present") {
+				problems = append(problems, fmt.Sprintf("%s.* keys without %s.present", device, device))
+			}
+		}
+		return problems
+	}
+}
+
+// requireRule checks that when key has value, another key has a value
+func requireRule(key, value, requiredKey, requiredValue string) func(d *Dictionary) []string {
+	return func(d *Dictionary) []string {
+		if v, ok := d.QueryOK(key); !ok || !valuesEqual(v, value) {
+			return nil
+		}
+		if v, ok := d.QueryOK(requiredKey); ok && (requiredValue == "" || valuesEqual(v, requiredValue)) {
+			return nil
+		}
+		if requiredValue == "" {
+			return []string{fmt.Sprintf("%s = \"%s\" without %s", key, value, requiredKey)}
+		}
+		return []string{fmt.Sprintf("%s = \"%s\" without %s = \"%s\"", key, value, requiredKey, requiredValue)}
+	}
+}
+
+// dependencyRules is the check-deps ruleset
+var dependencyRules = []dependencyRule{
+	{"disk-present", "storage device keys need DEVICE.present", func(d *Dictionary) []string {
+		var problems []string
+		seen := make(map[string]bool)
+		for _, entry := range d.Entries {
+			_, device, ok := diskDevice(entry.Key)
+			if !ok || seen[strings.ToLower(device)] {
+				continue
+			}
+			seen[strings.ToLower(device)] = true
+			if !d.KeyExists(device + ".present") {
+				problems = append(problems, fmt.Sprintf("%s.* keys without %s.present", device, device))
+			}
+		}
+		return problems
+	}},
+	{"controller-present", "SATA, SCSI and NVMe devices need their controller present", func(d *Dictionary) []string {
+		var problems []string
+		seen := make(map[string]bool)
+		for _, entry := range d.Entries {
+			controller, device, ok := diskDevice(entry.Key)
+			if !ok || seen[strings.ToLower(controller)] || strings.HasPrefix(strings.ToLower(controller), "ide") {
+				continue
+			}
+			if !d.queryBoolOr(device+".present", false) {
+				continue
+			}
+			seen[strings.ToLower(controller)] = true
+			if !d.queryBoolOr(controller+".present", false) {
+				problems = append(problems, fmt.Sprintf("%s is present without %s.present = \"TRUE\"", device, controller))
+			}
+		}
+		return problems
+	}},
+	{"ethernet-present", "network adapter keys need ethernetN.present", presentRule("ethernet")},
+	{"serial-present", "serial port keys need serialN.present", presentRule("serial")},
+	{"shared-folder-count", "shared folders need sharedFolder.maxNum to cover them", func(d *Dictionary) []string {
+		indices := d.deviceIndices("sharedFolder")
+		if len(indices) == 0 {
+			return nil
+		}
+		highest := indices[len(indices)-1]
+		maxNum, err := d.queryInt("sharedFolder.maxNum", 0)
+		if err == nil && maxNum > highest {
+			return nil
+		}
+		return []string{fmt.Sprintf("sharedFolder%d.* keys without sharedFolder.maxNum of at least %d", highest, highest+1)}
+	}},
+	{"vtpm-efi", "a virtual TPM needs EFI firmware", requireRule("vtpm.present", "TRUE", "firmware", "efi")},
+	{"vnc-port", "the VNC server needs a port", requireRule("RemoteDisplay.vnc.enabled", "TRUE", "RemoteDisplay.vnc.port", "")},
+}
+
+// runCheckDeps implements the check-deps command
+func runCheckDeps(args []string) int {
+	fs := flag.NewFlagSet("check-deps", flag.ContinueOnError)
+	var disabled []string
+	fs.Func("disable", "comma-separated rules to skip (repeatable)", func(s string) error {
+		disabled = append(disabled, strings.Split(s, ",")...)
+		return nil
+	})
+	list := fs.Bool("list", false, "list the rules")
+
+	usage := "Usage: vmxtool check-deps FILE [--disable RULE[,RULE...]]\n" +
+		"       vmxtool check-deps --list"
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+
+	if *list {
+		var rows []statusRow
+		for _, rule := range dependencyRules {
+			rows = append(rows, statusRow{rule.ID, rule.Description})
+		}
+		printStatus(rows)
+		return 0
+	}
+
+	if len(positional) != 1 {
+		errorf("Error: check-deps command requires FILE argument\n")
+		errorln(usage)
+		return exitUsage
+	}
+	for _, id := range disabled {
+		if !slices.ContainsFunc(dependencyRules, func(rule dependencyRule) bool { return rule.ID == id }) {
+			errorf("Error: unknown rule '%s', use --list to see the rules\n", id)
+			return exitUsage
+		}
+	}
+	filename := positional[0]
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	problems := 0
+	for _, rule := range dependencyRules {
+		if slices.Contains(disabled, rule.ID) {
+			continue
+		}
+		for _, problem := range rule.Check(dict) {
+			fmt.Fprintf(stdout, "%s: %s\n", rule.ID, problem)
+			problems++
+		}
+	}
+
+	if problems > 0 {
+		return exitDifferent
+	}
+	fmt.Fprintln(stdout, "No missing dependencies found")
+	return 0
+}
+
+// Severities of lint findings
+const (
+	lintError   = "error"
+	lintWarning = "warning"
+)
+
+// lintFinding is a problem reported by a lint rule
+type lintFinding struct {
+	Rule     string   `json:"rule"`
+	Severity string   `json:"severity"`
+	Message  string   `json:"message"`
+	Keys     []string `json:"keys"`
+}
+
+// lintRule is a lint rule. Check returns a finding for each problem, with
+// Rule and Severity filled in by the lint command.
+type lintRule struct {
+	ID          string
+	Severity    string
+	Description string
+	Check       func(d *Dictionary) []lintFinding
+}
+
+// lintIssue returns a finding for a message and the keys involved
+func lintIssue(message string, keys ...string) []lintFinding {
+	return []lintFinding{{Message: message, Keys: keys}}
+}
+
+// noEFIGuests lists the guestOS identifiers that cannot boot from EFI
+var noEFIGuests = []string{
+	"dos", "win2000pro", "win2000serv", "winxphome", "winxppro",
+	"winNetEnterprise", "winNetStandard", "winvista", "windows7", "longhorn",
+}
+
+// minMemoryMB gives the least memory in MB that a guestOS installs with,
+// matched by identifier prefix in order
+var minMemoryMB = []struct {
+	Prefix string
+	MB     int64
+}{
+	{"windows11", 4096},
+	{"arm-windows11", 4096},
+	{"windows2019srv", 2048},
+	{"windows2022srv", 2048},
+	{"windows9srv", 2048},
+	{"windows9-64", 2048},
+	{"windows9", 1024},
+	{"windows8-64", 2048},
+	{"windows8", 1024},
+	{"darwin2", 4096},
+	{"darwin1", 2048},
+}
+
+// allowEmptyKeys are the key patterns the empty-value rule accepts an
+// empty value for; lint --allow-empty adds to them
+var allowEmptyKeys = []string{
+	"annotation",
+	"checkpoint.vmState",
+	"ethernet*.address",
+	"ide*:*.fileName",
+	"sata*:*.fileName",
+}
+
+// lintRules is the lint ruleset, in the order findings are reported
+var lintRules = []lintRule{
+	{"nested-32bit", lintError, "nested virtualization needs a 64-bit guestOS", func(d *Dictionary) []lintFinding {
+		guestOS := d.queryOr("guestOS", "")
+		if !d.queryBoolOr("vhv.enable", false) || guestOS == "" || strings.HasSuffix(guestOS, "-64") {
+			return nil
+		}
+		if _, known := lookupGuestOS(guestOS); !known {
+			return nil
+		}
+		return lintIssue(fmt.Sprintf("vhv.enable is TRUE but guestOS '%s' is 32-bit", guestOS), "vhv.enable", "guestOS")
+	}},
+	{"efi-unsupported", lintError, "the guestOS cannot boot from EFI firmware", func(d *Dictionary) []lintFinding {
+		guestOS := d.queryOr("guestOS", "")
+		if !valuesEqual(d.queryOr("firmware", "bios"), "efi") || !slices.ContainsFunc(noEFIGuests, func(id string) bool { return strings.EqualFold(id, guestOS) }) {
+			return nil
+		}
+		return lintIssue(fmt.Sprintf("firmware is efi but guestOS '%s' has no EFI support", guestOS), "firmware", "guestOS")
+	}},
+	{"efi-required", lintError, "macOS, Windows 11 and ARM guests need EFI firmware", func(d *Dictionary) []lintFinding {
+		guestOS := strings.ToLower(d.queryOr("guestOS", ""))
+		if !strings.HasPrefix(guestOS, "darwin") && !strings.HasPrefix(guestOS, "windows11") && !strings.HasPrefix(guestOS, "arm-") {
+			return nil
+		}
+		if valuesEqual(d.queryOr("firmware", "bios"), "efi") {
+			return nil
+		}
+		return lintIssue(fmt.Sprintf("guestOS '%s' needs firmware = \"efi\"", guestOS), "firmware", "guestOS")
+	}},
+	{"secure-boot-bios", lintError, "secure boot needs EFI firmware", func(d *Dictionary) []lintFinding {
+		if !d.queryBoolOr("uefi.secureBoot.enabled", false) || valuesEqual(d.queryOr("firmware", "bios"), "efi") {
+			return nil
+		}
+		return lintIssue("uefi.secureBoot.enabled is TRUE without firmware = \"efi\"", "uefi.secureBoot.enabled", "firmware")
+	}},
+	{"xhci-without-usb", lintWarning, "a USB 3 controller needs the USB controller present", func(d *Dictionary) []lintFinding {
+		if !d.queryBoolOr("usb_xhci.present", false) || d.queryBoolOr("usb.present", false) {
+			return nil
+		}
+		return lintIssue("usb_xhci.present is TRUE without usb.present = \"TRUE\"", "usb_xhci.present", "usb.present")
+	}},
+	{"hgfs-isolated", lintWarning, "shared folders do not work with HGFS disabled", func(d *Dictionary) []lintFinding {
+		if len(d.sharedFolders()) == 0 || !d.queryBoolOr("isolation.tools.hgfs.disable", false) {
+			return nil
+		}
+		return lintIssue("shared folders are defined but isolation.tools.hgfs.disable is TRUE", "sharedFolder*", "isolation.tools.hgfs.disable")
+	}},
+	{"memsize-invalid", lintError, "memsize must be a positive multiple of 4 MB", func(d *Dictionary) []lintFinding {
+		value, ok := d.QueryOK("memsize")
+		if !ok {
+			return nil
+		}
+		if err := validateResourceValue("memsize", value); err != nil {
+			return lintIssue(err.Error(), "memsize")
+		}
+		return nil
+	}},
+	{"memsize-minimum", lintWarning, "memsize is below the minimum for the guestOS", func(d *Dictionary) []lintFinding {
+		guestOS := d.queryOr("guestOS", "")
+		mb, err := strconv.ParseInt(d.queryOr("memsize", ""), 10, 64)
+		if err != nil {
+			return nil
+		}
+		for _, limit := range minMemoryMB {
+			if strings.HasPrefix(strings.ToLower(guestOS), strings.ToLower(limit.Prefix)) {
+				if mb < limit.MB {
+					return lintIssue(fmt.Sprintf("memsize of %d MB is below the %d MB guestOS '%s' needs", mb, limit.MB, guestOS), "memsize", "guestOS")
+				}
+				return nil
+			}
+		}
+		return nil
+	}},
+	{"memsize-hw-version", lintError, "memsize exceeds the maximum for the hardware version", func(d *Dictionary) []lintFinding {
+		version, err := d.HWVersion()
+		if err != nil {
+			return nil
+		}
+		mb, err := strconv.ParseInt(d.queryOr("memsize", ""), 10, 64)
+		if err != nil {
+			return nil
+		}
+		if maxMB, ok := maxMemoryMB(version); ok && mb > maxMB {
+			return lintIssue(fmt.Sprintf("memsize of %d MB exceeds the %d MB hardware version %d allows", mb, maxMB, version), "memsize", "virtualHW.version")
+		}
+		return nil
+	}},
+	{"cpu-topology", lintError, "numvcpus must divide into sockets of cpuid.coresPerSocket cores", func(d *Dictionary) []lintFinding {
+		cpus, err := d.queryInt("numvcpus", 1)
+		if err != nil {
+			return lintIssue(err.Error(), "numvcpus")
+		}
+		cores, err := d.queryInt("cpuid.coresPerSocket", 1)
+		if err != nil {
+			return lintIssue(err.Error(), "cpuid.coresPerSocket")
+		}
+		if err := validateTopology(cpus, cores); err != nil {
+			return lintIssue(err.Error(), "numvcpus", "cpuid.coresPerSocket")
+		}
+		return nil
+	}},
+	{"vtpm-encryption", lintError, "a virtual TPM needs the VM to be encrypted", func(d *Dictionary) []lintFinding {
+		if !d.queryBoolOr("vtpm.present", false) || d.hasEncryption() {
+			return nil
+		}
+		return lintIssue("vtpm.present is TRUE but the VM is not encrypted", "vtpm.present")
+	}},
+	{"windows11-tpm", lintWarning, "Windows 11 needs a virtual TPM to install", func(d *Dictionary) []lintFinding {
+		guestOS := strings.ToLower(d.queryOr("guestOS", ""))
+		if !strings.Contains(guestOS, "windows11") || d.queryBoolOr("vtpm.present", false) {
+			return nil
+		}
+		return lintIssue(fmt.Sprintf("guestOS '%s' without vtpm.present = \"TRUE\"", guestOS), "guestOS", "vtpm.present")
+	}},
+	{"vnc-no-password", lintWarning, "the VNC server should have a password", func(d *Dictionary) []lintFinding {
+		if !d.queryBoolOr("RemoteDisplay.vnc.enabled", false) || d.KeyExists("RemoteDisplay.vnc.password") {
+			return nil
+		}
+		return lintIssue("RemoteDisplay.vnc.enabled is TRUE without RemoteDisplay.vnc.password", "RemoteDisplay.vnc.enabled")
+	}},
+	{"invalid-value", lintError, "values of known keys must have the right type and range", func(d *Dictionary) []lintFinding {
+		var findings []lintFinding
+		for _, entry := range d.Entries {
+			if entry.Key == "" {
+				continue
+			}
+			if err := validateKnownValue(entry.Key, entry.Value); err != nil {
+				findings = append(findings, lintIssue(err.Error(), entry.Key)...)
+			}
+		}
+		return findings
+	}},
+	{"empty-value", lintWarning, "keys should not have empty values", func(d *Dictionary) []lintFinding {
+		var findings []lintFinding
+		for _, entry := range d.Entries {
+			if entry.Key == "" || strings.TrimSpace(entry.Value) != "" {
+				continue
+			}
+			if slices.ContainsFunc(allowEmptyKeys, func(pattern string) bool { return matchKey(pattern, entry.Key) }) {
+				continue
+			}
+			findings = append(findings, lintIssue(fmt.Sprintf("%s has an empty value", entry.Key), entry.Key)...)
+		}
+		return findings
+	}},
+	{"duplicate-key", lintWarning, "a key is set more than once", func(d *Dictionary) []lintFinding {
+		var findings []lintFinding
+		seen := make(map[string]int)
+		for _, entry := range d.Entries {
+			if entry.Key == "" {
+				continue
+			}
+			seen[strings.ToLower(entry.Key)]++
+			if seen[strings.ToLower(entry.Key)] == 2 {
+				findings = append(findings, lintIssue(fmt.Sprintf("%s is set more than once", entry.Key), entry.Key)...)
+			}
+		}
+		return findings
+	}},
+}
+
+// runLint implements the lint command
+func runLint(args []string) int {
+	fs := flag.NewFlagSet("lint", flag.ContinueOnError)
+	var disabled []string
+	fs.Func("disable", "comma-separated rules to skip (repeatable)", func(s string) error {
+		disabled = append(disabled, strings.Split(s, ",")...)
+		return nil
+	})
+	jsonOutput := fs.Bool("json", false, "print the findings as JSON")
+	list := fs.Bool("list", false, "list the rules")
+	failOnEmpty := fs.Bool("fail-on-empty", false, "treat empty values as errors")
+	fs.Func("allow-empty", "comma-separated keys that may be empty (repeatable)", func(s string) error {
+		allowEmptyKeys = append(allowEmptyKeys, strings.Split(s, ",")...)
+		return nil
+	})
+
+	usage := "Usage: vmxtool lint FILE [--disable RULE[,RULE...]] [--fail-on-empty] [--allow-empty KEY[,KEY...]] [--json]\n" +
+		"       vmxtool lint --list"
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+
+	if *list {
+		var rows []statusRow
+		for _, rule := range lintRules {
+			rows = append(rows, statusRow{rule.ID, rule.Severity + ", " + rule.Description})
+		}
+		printStatus(rows)
+		return 0
+	}
+
+	if len(positional) != 1 {
+		errorf("Error: lint command requires FILE argument\n")
+		errorln(usage)
+		return exitUsage
+	}
+	for _, id := range disabled {
+		if !slices.ContainsFunc(lintRules, func(rule lintRule) bool { return rule.ID == id }) {
+			errorf("Error: unknown rule '%s', use --list to see the rules\n", id)
+			return exitUsage
+		}
+	}
+	filename := positional[0]
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	findings := []lintFinding{}
+	errorCount := 0
+	for _, rule := range lintRules {
+		if slices.Contains(disabled, rule.ID) {
+			continue
+		}
+		severity := rule.Severity
+		if rule.ID == "empty-value" && *failOnEmpty {
+			severity = lintError
+		}
+		for _, finding := range rule.Check(dict) {
+			finding.Rule = rule.ID
+			finding.Severity = severity
+			findings = append(findings, finding)
+			if severity == lintError {
+				errorCount++
+			}
+		}
+	}
+
+	if *jsonOutput {
+		data, err := json.MarshalIndent(findings, "", "  ")
+		if err != nil {
+			errorf("Error: %v\n", err)
+			return exitError
+		}
+		fmt.Fprintln(stdout, string(data))
+	} else if len(findings) == 0 {
+		fmt.Fprintln(stdout, "No problems found")
+	} else {
+		for _, finding := range findings {
+			fmt.Fprintf(stdout, "%s: %s: %s (%s)\n", finding.Severity, finding.Rule, finding.Message, strings.Join(finding.Keys, ", "))
+		}
+	}
+
+	if errorCount > 0 {
+		return exitDifferent
+	}
+	return 0
+}
+
+// policyRule is a rule of an enforce policy. Key may contain * wildcards;
+// every key it matches must satisfy Equals and Regex.
+type policyRule struct {
+	ID        string   `json:"id"`
+	Key       string   `json:"key"`
+	Required  bool     `json:"required,omitempty"`  // some matching key must exist
+	Forbidden bool     `json:"forbidden,omitempty"` // no matching key may exist
+	Equals    string   `json:"equals,omitempty"`
+	Regex     string   `json:"regex,omitempty"`
+	Min       *int64   `json:"min,omitempty"` // matching values must be numbers in range
+	Max       *int64   `json:"max,omitempty"`
+	Values    []string `json:"values,omitempty"`   // matching values must be one of these
+	Severity  string   `json:"severity,omitempty"` // error, the default, or warning
+}
+
+// policy is the policy file read by the enforce command
+type policy struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description,omitempty"`
+	Rules       []policyRule `json:"rules"`
+}
+
+// policyViolation is a key that does not satisfy a policy rule
+type policyViolation struct {
+	Rule     policyRule
+	Key      string
+	Expected string
+	Actual   string
+	Fix      func(d *Dictionary) // nil when there is no mechanical fix
+}
+
+// loadPolicy reads and checks a policy file
+func loadPolicy(filename string) (*policy, error) {
+	data, err := files.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var p policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %v", filename, err)
+	}
+	seen := make(map[string]bool)
+	for i, rule := range p.Rules {
+		switch {
+		case rule.ID == "":
+			return nil, fmt.Errorf("invalid policy %s: rule %d has no id", filename, i+1)
+		case seen[rule.ID]:
+			return nil, fmt.Errorf("invalid policy %s: duplicate rule id '%s'", filename, rule.ID)
+		case rule.Key == "":
+			return nil, fmt.Errorf("invalid policy %s: rule '%s' has no key", filename, rule.ID)
+		case rule.Forbidden && (rule.Required || rule.Equals != "" || rule.Regex != "" || rule.Min != nil || rule.Max != nil || rule.Values != nil):
+			return nil, fmt.Errorf("invalid policy %s: rule '%s' is forbidden and has other constraints", filename, rule.ID)
+		case rule.Min != nil && rule.Max != nil && *rule.Min > *rule.Max:
+			return nil, fmt.Errorf("invalid policy %s: rule '%s' has min greater than max", filename, rule.ID)
+		case rule.Severity != "" && rule.Severity != lintError && rule.Severity != lintWarning:
+			return nil, fmt.Errorf("invalid policy %s: rule '%s' has unknown severity '%s'", filename, rule.ID, rule.Severity)
+		}
+		if rule.Regex != "" {
+			if _, err := regexp.Compile(rule.Regex); err != nil {
+				return nil, fmt.Errorf("invalid policy %s: rule '%s': %v", filename, rule.ID, err)
+			}
+		}
+		if rule.Severity == "" {
+			p.Rules[i].Severity = lintError
+		}
+		seen[rule.ID] = true
+	}
+	return &p, nil
+}
+
+// check returns the violations of a rule in a dictionary
+func (rule policyRule) check(d *Dictionary) []policyViolation {
+	entries := d.FindMatching(rule.Key)
+	if rule.Forbidden {
+		var violations []policyViolation
+		for _, entry := range entries {
+			violations = append(violations, policyViolation{rule, entry.Key, notSet, `"` + entry.Value + `"`,
+				func(d *Dictionary) { d.removeEntry(entry) }})
+		}
+		return violations
+	}
+
+	if len(entries) == 0 {
+		// A key without wildcards that must have a value can be added
+		wildcard := strings.ContainsAny(rule.Key, "*?[")
+		switch {
+		case rule.Equals != "" && !wildcard:
+			return []policyViolation{{rule, rule.Key, `"` + rule.Equals + `"`, notSet,
+				func(d *Dictionary) { d.SetGrouped(rule.Key, rule.Equals) }}}
+		case rule.Required:
+			return []policyViolation{{rule, rule.Key, "set", notSet, nil}}
+		}
+		return nil
+	}
+
+	var violations []policyViolation
+	for _, entry := range entries {
+		if rule.Equals != "" && !valuesEqual(entry.Value, rule.Equals) {
+			violations = append(violations, policyViolation{rule, entry.Key, `"` + rule.Equals + `"`, `"` + entry.Value + `"`,
+				func(d *Dictionary) { entry.setValue(rule.Equals) }})
+		}
+		if rule.Regex != "" && !regexp.MustCompile(rule.Regex).MatchString(entry.Value) {
+			violations = append(violations, policyViolation{rule, entry.Key, "match " + rule.Regex, `"` + entry.Value + `"`, nil})
+		}
+		if rule.Min != nil || rule.Max != nil {
+			n, err := strconv.ParseInt(strings.TrimSpace(entry.Value), 10, 64)
+			if err != nil || (rule.Min != nil && n < *rule.Min) || (rule.Max != nil && n > *rule.Max) {
+				expected := "a number " + knownKey{Min: rule.Min, Max: rule.Max}.valueRange()
+				violations = append(violations, policyViolation{rule, entry.Key, expected, `"` + entry.Value + `"`, nil})
+			}
+		}
+		if rule.Values != nil && !slices.ContainsFunc(rule.Values, func(v string) bool { return valuesEqual(v, entry.Value) }) {
+			violations = append(violations, policyViolation{rule, entry.Key, "one of " + strings.Join(rule.Values, ", "), `"` + entry.Value + `"`, nil})
+		}
+	}
+	return violations
+}
+
+// runEnforce implements the enforce command
+func runEnforce(args []string) int {
+	fs := flag.NewFlagSet("enforce", flag.ContinueOnError)
+	fix := fs.Bool("fix", false, "set and remove keys to satisfy equals and forbidden rules")
+
+	usage := "Usage: vmxtool enforce FILE POLICY [--fix]"
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	if len(positional) != 2 {
+		errorf("Error: enforce command requires FILE and POLICY arguments\n")
+		errorln(usage)
+		return exitUsage
+	}
+	filename := positional[0]
+
+	p, err := loadPolicy(positional[1])
+	if err != nil {
+		errorf("Error: %v\n", err)
+		return exitError
+	}
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	var violations []policyViolation
+	for _, rule := range p.Rules {
+		violations = append(violations, rule.check(dict)...)
+	}
+
+	fixed, errorCount := 0, 0
+	for _, v := range violations {
+		status := v.Rule.Severity
+		if *fix && v.Fix != nil {
+			v.Fix(dict)
+			fixed++
+			status = "fixed"
+		} else if v.Rule.Severity == lintError {
+			errorCount++
+		}
+		fmt.Fprintf(stdout, "%s: %s: %s: expected %s, actual %s\n", status, v.Rule.ID, v.Key, v.Expected, v.Actual)
+	}
+	if len(violations) == 0 {
+		fmt.Fprintf(stdout, "File complies with policy %s\n", p.Name)
+	}
+
+	if fixed > 0 {
+		if err := saveDictionary(dict, filename); err != nil {
+			errorf("Error saving file: %v\n", err)
+			return exitFileError
+		}
+	}
+
+	if errorCount > 0 {
+		return exitDifferent
+	}
+	return 0
+}
+
+// snapshot is a snapshot listed in a .vmsd file
+type snapshot struct {
+	UID    string
+	Name   string
+	Parent string
+}
+
+// snapshotFile returns the .vmsd file that lists the snapshots of a VMX file
+func snapshotFile(filename string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + ".vmsd"
+}
+
+// loadSnapshots reads the snapshots of a VMX file and the UID of the
+// current one. The .vmsd file uses the VMX format, so it is read with
+// LoadDictionary; a missing file means there are no snapshots.
+func loadSnapshots(filename string) ([]snapshot, string, error) {
+	vmsd, err := LoadDictionary(snapshotFile(filename))
+	if err != nil {
+		return nil, "", err
+	}
+	var snapshots []snapshot
+	for _, index := range vmsd.deviceIndices("snapshot") {
+		prefix := fmt.Sprintf("snapshot%d.", index)
+		uid, ok := vmsd.QueryOK(prefix + "uid")
+		if !ok {
+			continue
+		}
+		snapshots = append(snapshots, snapshot{
+			UID:    uid,
+			Name:   vmsd.queryOr(prefix+"displayName", ""),
+			Parent: vmsd.queryOr(prefix+"parent", ""),
+		})
+	}
+	return snapshots, vmsd.queryOr("snapshot.current", ""), nil
+}
+
+// snapshotChain returns the names from the root snapshot down to s
+func snapshotChain(s snapshot, snapshots []snapshot) []string {
+	byUID := make(map[string]snapshot)
+	for _, other := range snapshots {
+		byUID[other.UID] = other
+	}
+	chain := []string{s.Name}
+	seen := map[string]bool{s.UID: true}
+	for parent, ok := byUID[s.Parent]; ok && !seen[parent.UID]; parent, ok = byUID[parent.Parent] {
+		seen[parent.UID] = true
+		chain = append([]string{parent.Name}, chain...)
+	}
+	return chain
+}
+
+// snapshotSensitive reports whether changing key can break existing
+// snapshots: storage devices, their controllers and virtual hardware
+func snapshotSensitive(key string) bool {
+	if _, _, ok := diskDevice(key); ok {
+		return true
+	}
+	for _, controller := range []string{"ide", "sata", "scsi", "nvme"} {
+		if _, _, ok := deviceIndex(key, controller); ok {
+			return true
+		}
+	}
+	return len(key) > len("virtualHW.") && strings.EqualFold(key[:len("virtualHW.")], "virtualHW.")
+}
+
+// checkSnapshots warns through out if any of keys can break the snapshots
+// of the VM in filename. With strict, it returns an error instead.
+func checkSnapshots(out *fileOutput, filename string, keys []string, strict bool) error {
+	var sensitive []string
+	for _, key := range keys {
+		if snapshotSensitive(key) {
+			sensitive = append(sensitive, key)
+		}
+	}
+	if len(sensitive) == 0 {
+		return nil
+	}
+
+	snapshots, _, err := loadSnapshots(filename)
+	if err != nil || len(snapshots) == 0 {
+		return nil
+	}
+	message := fmt.Sprintf("the VM has %d snapshots and changing %s can break them", len(snapshots), strings.Join(sensitive, ", "))
+	if strict {
+		return errors.New(message)
+	}
+	out.warnf("Warning: %s\n", message)
+	return nil
+}
+
+// runSnapshots implements the snapshots command
+func runSnapshots(args []string) int {
+	args, err := parseArgs("snapshots", args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool snapshots FILE\n")
+		return exitUsage
+	}
+	if len(args) != 1 {
+		errorf("Error: snapshots command requires FILE argument\n")
+		errorf("Usage: vmxtool snapshots FILE\n")
+		return exitUsage
+	}
+	filename := args[0]
+
+	snapshots, current, err := loadSnapshots(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+	if len(snapshots) == 0 {
+		fmt.Fprintln(stdout, "No snapshots")
+		return 0
+	}
+
+	var rows []statusRow
+	for _, s := range snapshots {
+		label := "UID " + s.UID
+		if s.UID == current {
+			label += " (current)"
+		}
+		rows = append(rows, statusRow{label, strings.Join(snapshotChain(s, snapshots), " > ")})
+	}
+	printStatus(rows)
+	return 0
+}
+
+// The VMDK descriptor parser stays in this file because vmxtool has no
+// go.mod: build-all.sh builds it with go build vmxtool.go, and a file
+// built that way cannot import a package of this repository. The parser
+// returns errors rather than printing them and uses nothing of vmxtool
+// but files and withTimeout, so that it can move to a package of its own
+// if vmxtool becomes a module.
+
+// vmdkExtent is an extent line of a VMDK descriptor, such as
+// RW 4192256 FLAT "disk-flat.vmdk" 0
+type vmdkExtent struct {
+	Access  string
+	Sectors int64
+	Type    string
+	File    string
+}
+
+// vmdkDescriptor holds the fields of a VMDK descriptor that describe the
+// disk chain
+type vmdkDescriptor struct {
+	CID                string
+	ParentCID          string
+	CreateType         string
+	ParentFileNameHint string
+	Extents            []vmdkExtent
+}
+
+// noParentCID is the parentCID of a disk without a parent
+const noParentCID = "ffffffff"
+
+// parseVMDKDescriptor parses the text of a VMDK descriptor. Both sparse
+// and flat descriptors use the same format.
+func parseVMDKDescriptor(text string) (*vmdkDescriptor, error) {
+	desc := &vmdkDescriptor{}
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(strings.TrimRight(line, "\x00"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if key, value, ok := strings.Cut(line, "="); ok {
+			value = strings.Trim(strings.TrimSpace(value), `"`)
+			switch strings.TrimSpace(key) {
+			case "CID":
+				desc.CID = strings.ToLower(value)
+			case "parentCID":
+				desc.ParentCID = strings.ToLower(value)
+			case "createType":
+				desc.CreateType = value
+			case "parentFileNameHint":
+				desc.ParentFileNameHint = value
+			}
+			continue
+		}
+
+		// Extent lines are ACCESS SECTORS TYPE ["FILE" [OFFSET]]
+		fields := strings.Fields(line)
+		if len(fields) < 3 || !slices.Contains([]string{"RW", "RDONLY", "NOACCESS"}, fields[0]) {
+			return nil, fmt.Errorf("line %d: unrecognised descriptor line '%s'", i+1, line)
+		}
+		sectors, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid extent size '%s'", i+1, fields[1])
+		}
+		extent := vmdkExtent{Access: fields[0], Sectors: sectors, Type: fields[2]}
+		if start := strings.Index(line, `"`); start != -1 {
+			if end := strings.Index(line[start+1:], `"`); end != -1 {
+				extent.File = line[start+1 : start+1+end]
+			}
+		}
+		desc.Extents = append(desc.Extents, extent)
+	}
+
+	if desc.CID == "" {
+		return nil, errors.New("not a VMDK descriptor: no CID")
+	}
+	return desc, nil
+}
+
+// Layout of the header of a hosted sparse extent, which may hold an
+// embedded descriptor
+const (
+	sparseMagic            = "KDMV"
+	sparseHeaderSize       = 44
+	sparseDescriptorOffset = 28 // in sectors
+	sparseDescriptorSize   = 36 // in sectors
+	sectorSize             = 512
+	maxDescriptorSize      = 64 * 1024
+)
+
+// loadVMDKDescriptor reads the descriptor of a VMDK file, which is either
+// a text descriptor or a sparse extent with an embedded descriptor
+func loadVMDKDescriptor(filename string) (*vmdkDescriptor, error) {
+	return withTimeout("read", filename, func() (*vmdkDescriptor, error) {
+		return readVMDKDescriptor(filename)
+	})
+}
+
+// readVMDKDescriptor reads the descriptor of a VMDK file without a timeout
+func readVMDKDescriptor(filename string) (*vmdkDescriptor, error) {
+	f, err := files.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header := make([]byte, sparseHeaderSize)
+	n, err := io.ReadFull(f, header)
+	if err == nil && string(header[:4]) == sparseMagic {
+		offset := binary.LittleEndian.Uint64(header[sparseDescriptorOffset:])
+		size := binary.LittleEndian.Uint64(header[sparseDescriptorSize:])
+		if offset == 0 || size == 0 || size*sectorSize > maxDescriptorSize {
+			return nil, fmt.Errorf("%s: sparse disk has no embedded descriptor", filename)
+		}
+		// The descriptor follows the header, so it is reached by reading on
+		data := make([]byte, size*sectorSize)
+		skip := int64(offset*sectorSize) - int64(n)
+		if skip < 0 {
+			return nil, fmt.Errorf("%s: sparse disk descriptor overlaps its header", filename)
+		}
+		if _, err := io.CopyN(io.Discard, f, skip); err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+		if _, err := io.ReadFull(f, data); err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+		desc, err := parseVMDKDescriptor(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+		return desc, nil
+	}
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+
+	// Text descriptors are small, so never read a large flat extent
+	rest, err := io.ReadAll(io.LimitReader(f, maxDescriptorSize))
+	if err != nil {
+		return nil, err
+	}
+	desc, err := parseVMDKDescriptor(string(header[:n]) + string(rest))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return desc, nil
+}
+
+// maxDiskChainLength stops disk-chain following a loop of parent links
+const maxDiskChainLength = 256
+
+// printDiskChain prints the chain of a disk from the child to the base
+// disk and reports whether it is intact
+func printDiskChain(device, path, vmxDir string) bool {
+	fmt.Fprintf(stdout, "%s:\n", device)
+	resolved, ok := resolveReference(path, vmxDir)
+	for depth := 0; ; depth++ {
+		if !ok {
+			fmt.Fprintf(stdout, "    BROKEN: %s is a path for another OS\n", path)
+			return false
+		}
+		desc, err := loadVMDKDescriptor(resolved)
+		if err != nil {
+			fmt.Fprintf(stdout, "    BROKEN: %v\n", err)
+			return false
+		}
+		fmt.Fprintf(stdout, "    %s (CID %s, %s)\n", path, desc.CID, desc.CreateType)
+
+		if desc.ParentCID == "" || desc.ParentCID == noParentCID {
+			return true
+		}
+		if desc.ParentFileNameHint == "" {
+			fmt.Fprintf(stdout, "    BROKEN: parentCID %s but no parentFileNameHint\n", desc.ParentCID)
+			return false
+		}
+		if depth == maxDiskChainLength {
+			fmt.Fprintln(stdout, "    BROKEN: chain too long, the parent links may form a loop")
+			return false
+		}
+
+		// Parent hints are relative to the child disk
+		path = desc.ParentFileNameHint
+		resolved, ok = resolveReference(path, filepath.Dir(resolved))
+		if !ok {
+			continue
+		}
+		parent, err := loadVMDKDescriptor(resolved)
+		if err != nil {
+			continue
+		}
+		if parent.CID != desc.ParentCID {
+			fmt.Fprintf(stdout, "    BROKEN: parentCID %s does not match CID %s of %s\n", desc.ParentCID, parent.CID, path)
+			return false
+		}
+	}
+}
+
+// runDiskChain implements the disk-chain command
+func runDiskChain(args []string) int {
+	args, err := parseArgs("disk-chain", args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool disk-chain FILE\n")
+		return exitUsage
+	}
+	if len(args) != 1 {
+		errorf("Error: disk-chain command requires FILE argument\n")
+		errorf("Usage: vmxtool disk-chain FILE\n")
+		return exitUsage
+	}
+	filename := args[0]
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	disks, broken := 0, 0
+	for _, pattern := range []string{"ide*:*.fileName", "sata*:*.fileName", "scsi*:*.fileName", "nvme*:*.fileName"} {
+		for _, entry := range dict.FindMatching(pattern) {
+			if !strings.EqualFold(filepath.Ext(entry.Value), ".vmdk") {
+				continue
+			}
+			disks++
+			if !printDiskChain(strings.TrimSuffix(entry.Key, ".fileName"), entry.Value, filepath.Dir(filename)) {
+				broken++
+			}
+		}
+	}
+
+	if disks == 0 {
+		fmt.Fprintln(stdout, "No disks found")
+		return 0
+	}
+	if broken > 0 {
+		fmt.Fprintf(stdout, "%d of %d disk chains are broken\n", broken, disks)
+		return exitDifferent
+	}
+	return 0
+}
+
+// devicePresent reports whether a device is enabled, which it is unless
+// DEVICE.present is FALSE
+func (d *Dictionary) devicePresent(device string) bool {
+	return d.queryBoolOr(device+".present", true)
+}
+
+// diskSize returns the size of a VMDK from its descriptor, or false if it
+// cannot be read
+func diskSize(path, vmxDir string) (int64, bool) {
+	resolved, ok := resolveReference(path, vmxDir)
+	if !ok {
+		return 0, false
+	}
+	desc, err := loadVMDKDescriptor(resolved)
+	if err != nil {
+		return 0, false
+	}
+	var sectors int64
+	for _, extent := range desc.Extents {
+		sectors += extent.Sectors
+	}
+	return sectors * sectorSize, sectors > 0
+}
+
+// infoNICs returns a line for each enabled network adapter
+func infoNICs(dict *Dictionary) []string {
+	var lines []string
+	for _, index := range dict.deviceIndices("ethernet") {
+		device := fmt.Sprintf("ethernet%d", index)
+		if !dict.devicePresent(device) {
+			continue
+		}
+		details := []string{
+			dict.queryOr(device+".virtualDev", "default adapter"),
+			dict.queryOr(device+".connectionType", "bridged"),
+		}
+		if vnet, ok := dict.QueryOK(device + ".vnet"); ok {
+			details = append(details, vnet)
+		}
+		mac, ok := dict.QueryOK(device + ".address")
+		if !ok {
+			mac, ok = dict.QueryOK(device + ".generatedAddress")
+		}
+		if ok {
+			details = append(details, "MAC "+mac)
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", device, strings.Join(details, ", ")))
+	}
+	return lines
+}
+
+// infoDisks returns a line for each enabled disk and CD/DVD drive
+func infoDisks(dict *Dictionary, vmxDir string) []string {
+	var lines []string
+	for _, pattern := range []string{"ide*:*.fileName", "sata*:*.fileName", "scsi*:*.fileName", "nvme*:*.fileName"} {
+		for _, entry := range dict.FindMatching(pattern) {
+			controller, device, ok := diskDevice(entry.Key)
+			if !ok || !dict.devicePresent(controller) || !dict.devicePresent(device) {
+				continue
+			}
+			deviceType := dict.queryOr(device+".deviceType", "disk")
+			if strings.EqualFold(filepath.Ext(entry.Value), ".vmdk") {
+				deviceType = "disk"
+			}
+			line := fmt.Sprintf("%s: %s %s", device, deviceType, entry.Value)
+			if virtualDev, ok := dict.QueryOK(controller + ".virtualDev"); ok {
+				line = fmt.Sprintf("%s (%s): %s %s", device, virtualDev, deviceType, entry.Value)
+			}
+			if deviceType == "disk" {
+				if size, ok := diskSize(entry.Value, vmxDir); ok {
+					line += ", " + formatBytes(size)
+				}
+			}
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// printInfo prints a summary of the VM, omitting what is not set
+func printInfo(dict *Dictionary, vmxDir string) {
+	fmt.Fprintln(stdout, dict.queryOr("displayName", filepath.Base(dict.Filename)))
+
+	var rows []statusRow
+	if id, ok := dict.QueryOK("guestOS"); ok {
+		if guest, known := lookupGuestOS(id); known {
+			id = fmt.Sprintf("%s (%s)", guest.Description, id)
+		}
+		rows = append(rows, statusRow{"Guest OS", id})
+	}
+	if version, ok := dict.QueryOK("virtualHW.version"); ok {
+		rows = append(rows, statusRow{"Hardware version", version})
+	}
+	if firmware, ok := dict.QueryOK("firmware"); ok {
+		rows = append(rows, statusRow{"Firmware", firmware})
+	}
+	if value, ok := dict.QueryOK("memsize"); ok {
+		if mb, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64); err == nil {
+			value = formatMB(mb)
+		}
+		rows = append(rows, statusRow{"Memory", value})
+	}
+	if value, ok := dict.QueryOK("numvcpus"); ok {
+		cpus, err1 := dict.queryInt("numvcpus", 1)
+		cores, err2 := dict.queryInt("cpuid.coresPerSocket", 1)
+		if err1 == nil && err2 == nil && validateTopology(cpus, cores) == nil {
+			value = fmt.Sprintf("%d (%d sockets x %d cores)", cpus, cpus/cores, cores)
+		}
+		rows = append(rows, statusRow{"CPUs", value})
+	}
+	for i := range rows {
+		rows[i].Label = "  " + rows[i].Label
+	}
+	printStatus(rows)
+
+	for _, section := range []struct {
+		Title string
+		Lines []string
+	}{
+		{"Network adapters", infoNICs(dict)},
+		{"Disks", infoDisks(dict, vmxDir)},
+	} {
+		if len(section.Lines) == 0 {
+			continue
+		}
+		fmt.Fprintf(stdout, "  %s:\n", section.Title)
+		for _, line := range section.Lines {
+			fmt.Fprintf(stdout, "    %s\n", line)
+		}
+	}
+}
+
+// runInfo implements the info command
+func runInfo(args []string) int {
+	args, err := parseArgs("info", args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool info FILE\n")
+		return exitUsage
+	}
+	if len(args) != 1 {
+		errorf("Error: info command requires FILE argument\n")
+		errorf("Usage: vmxtool info FILE\n")
+		return exitUsage
+	}
+	filename := args[0]
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	printInfo(dict, filepath.Dir(filename))
+	return 0
+}
+
+// fileState identifies a version of a file, for watch and for detecting
+// changes made by another program before saving. VMware saves by writing
+// a new file and renaming it over the old one, so the file is identified
+// by path and compared by identity as well as time and size.
+type fileState struct {
+	info os.FileInfo
+	err  error
+}
+
+func statFile(filename string) fileState {
+	info, err := files.Stat(filename)
+	return fileState{info, err}
+}
+
+// same reports whether two states are of the same version of the file
+func (s fileState) same(other fileState) bool {
+	if s.err != nil || other.err != nil {
+		return s.err != nil && other.err != nil
+	}
+	return sameFile(s.info, other.info) &&
+		s.info.ModTime().Equal(other.info.ModTime()) &&
+		s.info.Size() == other.info.Size()
+}
+
+// sameFile reports whether a and b describe the same file, as os.SameFile
+// does for files on disk. A fileSystem other than the disk identifies its
+// files by the value of Sys.
+func sameFile(a, b fs.FileInfo) bool {
+	return os.SameFile(a, b) || a.Sys() != nil && a.Sys() == b.Sys()
+}
+
+// shellCommand returns a command running cmd with the platform's shell
+func shellCommand(cmd string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", cmd)
+	}
+	return exec.Command("/bin/sh", "-c", cmd)
+}
+
+// runWatch implements the watch command. It polls the file rather than
+// relying on change notifications, which are not available on every
+// platform or network file system, and waits for the file to stay
+// unchanged for one interval so that a burst of writes is reported once.
+func runWatch(args []string) int {
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	key := fs.String("key", "", "print only the value of KEY when it changes")
+	interval := fs.Duration("interval", time.Second, "how often to check the file")
+	execCmd := fs.String("exec", "", "command to run after each change")
+
+	usage := "Usage: vmxtool watch FILE [--key KEY] [--interval DURATION] [--exec CMD]"
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		errorf("Error: watch command requires FILE argument\n")
+		errorln(usage)
+		return exitUsage
+	}
+	if *interval <= 0 {
+		errorf("Error: --interval must be greater than zero\n")
+		errorln(usage)
+		return exitUsage
+	}
+	filename := positional[0]
+
+	state := statFile(filename)
+	if state.err != nil {
+		errorf("Error loading file: %v\n", state.err)
+		return exitFileError
+	}
+
+	lastValue, hadValue := "", false
+	// show prints the file or key and reports whether it printed anything
+	show := func() bool {
+		dict, err := LoadDictionary(filename)
+		if err != nil {
+			errorf("Error loading file: %v\n", err)
+			return false
+		}
+		stamp := time.Now().Format("15:04:05")
+		if *key == "" {
+			fmt.Fprintf(stdout, "==> %s %s <==\n", stamp, filename)
+			fmt.Fprint(stdout, dict.render())
+			return true
+		}
+		value, ok := dict.QueryOK(*key)
+		if ok == hadValue && value == lastValue {
+			return false
+		}
+		lastValue, hadValue = value, ok
+		if ok {
+			fmt.Fprintf(stdout, "%s %s = %s\n", stamp, *key, value)
+		} else {
+			fmt.Fprintf(stdout, "%s %s %s\n", stamp, *key, notSet)
+		}
+		return true
+	}
+	show()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	pending := false
+	for {
+		select {
+		case <-interrupt:
+			return 0
+		case <-ticker.C:
+		}
+
+		current := statFile(filename)
+		if !current.same(state) {
+			// Still being written, or replaced; check again next tick
+			state, pending = current, true
+			continue
+		}
+		if !pending || current.err != nil {
+			continue
+		}
+		pending = false
+
+		if show() && *execCmd != "" {
+			cmd := shellCommand(*execCmd)
+			cmd.Stdout, cmd.Stderr = stdout, stderr
+			cmd.Env = append(os.Environ(), "VMXTOOL_FILE="+filename)
+			if err := cmd.Run(); err != nil {
+				warnf("Warning: %s: %v\n", *execCmd, err)
+			}
+		}
+	}
+}
+
+// guestinfoPrefix is the namespace of keys the guest can read with
+// vmware-rpctool or vmtoolsd --cmd "info-get"
+const guestinfoPrefix = "guestinfo."
+
+// defaultSetInfoSizeLimit is the default of tools.setinfo.sizeLimit
+const defaultSetInfoSizeLimit = 1048576
+
+// guestinfoKey returns the VMX key for a guestinfo name, which may be
+// given with or without the guestinfo. prefix
+func guestinfoKey(name string) string {
+	if strings.HasPrefix(strings.ToLower(name), guestinfoPrefix) {
+		return name
+	}
+	return guestinfoPrefix + name
+}
+
+// encodeGuestinfo encodes a payload for a guestinfo key, returning the
+// value and the encoding understood by cloud-init
+func encodeGuestinfo(payload []byte, useBase64, useGzip bool) (string, string, error) {
+	if useGzip {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(payload); err != nil {
+			return "", "", err
+		}
+		if err := zw.Close(); err != nil {
+			return "", "", err
+		}
+		return base64.StdEncoding.EncodeToString(buf.Bytes()), "gzip+base64", nil
+	}
+	if useBase64 {
+		return base64.StdEncoding.EncodeToString(payload), "base64", nil
+	}
+
+	// A raw payload is written as it is, less the newline that ends most
+	// files, and cannot span lines
+	text := strings.TrimSuffix(string(payload), "\n")
+	if !utf8.ValidString(text) || strings.ContainsFunc(text, func(r rune) bool { return r < 0x20 && r != '\t' }) {
+		return "", "", errors.New("payload contains newlines or control characters, use --base64 or --gzip")
+	}
+	return text, "", nil
+}
+
+// decodeGuestinfo reverses encodeGuestinfo for the given encoding
+func decodeGuestinfo(value, encoding string) ([]byte, error) {
+	switch strings.ToLower(encoding) {
+	case "":
+		return []byte(value), nil
+	case "base64", "b64":
+		return base64.StdEncoding.DecodeString(value)
+	case "gzip+base64", "gz+b64":
+		data, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, err
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return io.ReadAll(zr)
+	}
+	return nil, fmt.Errorf("unsupported encoding '%s'", encoding)
+}
+
+// runGuestinfo implements the guestinfo command
+func runGuestinfo(args []string) int {
+	if len(args) < 1 {
+		errorf("Error: guestinfo command requires set, get or list subcommand\n")
+		errorf("Usage: vmxtool guestinfo set|get|list FILE ...\n")
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "set":
+		return runGuestinfoSet(args[1:])
+	case "get":
+		return runGuestinfoGet(args[1:])
+	case "list":
+		return runGuestinfoList(args[1:])
+	}
+
+	errorf("Error: unknown guestinfo subcommand '%s'\n", args[0])
+	errorf("Usage: vmxtool guestinfo set|get|list FILE ...\n")
+	return exitUsage
+}
+
+// runGuestinfoSet implements the guestinfo set command
+func runGuestinfoSet(args []string) int {
+	fs := flag.NewFlagSet("guestinfo set", flag.ContinueOnError)
+	from := fs.String("from", "", "file to read the payload from, - for standard input")
+	useBase64 := fs.Bool("base64", false, "base64 encode the payload")
+	useGzip := fs.Bool("gzip", false, "gzip and base64 encode the payload")
+
+	usage := "Usage: vmxtool guestinfo set FILE NAME --from FILE2 [--base64] [--gzip]"
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	if len(positional) != 2 || *from == "" {
+		errorf("Error: guestinfo set command requires FILE, NAME and --from arguments\n")
+		errorln(usage)
+		return exitUsage
+	}
+	filename := positional[0]
+	key := guestinfoKey(positional[1])
+
+	var payload []byte
+	if *from == "-" {
+		payload, err = io.ReadAll(stdin)
+	} else {
+		payload, err = files.ReadFile(*from)
+	}
+	if err != nil {
+		errorf("Error reading payload: %v\n", err)
+		return exitFileError
+	}
+
+	value, encoding, err := encodeGuestinfo(payload, *useBase64, *useGzip)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		return exitError
+	}
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	// Refuse values that vmxtool itself could not load again
+	maxLineSize := maxLineSize()
+	if int64(len(key)+len(value)+5) > maxLineSize {
+		errorf("Error: %s value of %s is longer than %s, use --max-line-size to allow longer lines\n",
+			key, formatBytes(int64(len(value))), formatBytes(maxLineSize))
+		return exitError
+	}
+	if sizeLimit, err := dict.queryInt("tools.setinfo.sizeLimit", defaultSetInfoSizeLimit); err == nil && len(value) > sizeLimit {
+		warnf("Warning: %s value of %s exceeds tools.setinfo.sizeLimit of %s\n",
+			key, formatBytes(int64(len(value))), formatBytes(int64(sizeLimit)))
+	}
+
+	changed := dict.SetGrouped(key, value)
+	if encoding != "" {
+		changed = dict.SetGrouped(key+".encoding", encoding) || changed
+	} else if dict.Remove(key+".encoding") == nil {
+		changed = true
+	}
+	if !changed {
+		return 0
+	}
+
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// runGuestinfoGet implements the guestinfo get command
+func runGuestinfoGet(args []string) int {
+	fs := flag.NewFlagSet("guestinfo get", flag.ContinueOnError)
+	decode := fs.Bool("decode", false, "decode the value using its .encoding key")
+
+	usage := "Usage: vmxtool guestinfo get FILE NAME [--decode]"
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	if len(positional) != 2 {
+		errorf("Error: guestinfo get command requires FILE and NAME arguments\n")
+		errorln(usage)
+		return exitUsage
+	}
+	filename := positional[0]
+	key := guestinfoKey(positional[1])
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	value, err := dict.Query(key)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		return exitCode(err)
+	}
+	if !*decode {
+		fmt.Fprintln(stdout, value)
+		return 0
+	}
+
+	payload, err := decodeGuestinfo(value, dict.queryOr(key+".encoding", ""))
+	if err != nil {
+		errorf("Error decoding %s: %v\n", key, err)
+		return exitError
+	}
+	// The payload is written exactly as it was stored
+	stdout.Write(payload)
+	return 0
+}
+
+// runGuestinfoList implements the guestinfo list command
+func runGuestinfoList(args []string) int {
+	args, err := parseArgs("guestinfo list", args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool guestinfo list FILE\n")
+		return exitUsage
+	}
+	if len(args) != 1 {
+		errorf("Error: guestinfo list command requires FILE argument\n")
+		errorf("Usage: vmxtool guestinfo list FILE\n")
+		return exitUsage
+	}
+	filename := args[0]
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	var keys []string
+	for _, entry := range dict.FindPrefix(guestinfoPrefix) {
+		if slices.Contains(keys, entry.Key) {
+			continue
+		}
+		if !strings.HasSuffix(entry.Key, ".encoding") || !dict.KeyExists(strings.TrimSuffix(entry.Key, ".encoding")) {
+			keys = append(keys, entry.Key)
+		}
+	}
+	if len(keys) == 0 {
+		fmt.Fprintln(stdout, "No guestinfo keys")
+		return 0
+	}
+
+	fmt.Fprintf(stdout, "%-40s %10s  %s\n", "KEY", "SIZE", "ENCODING")
+	for _, key := range keys {
+		value, _ := dict.QueryOK(key)
+		fmt.Fprintf(stdout, "%-40s %10s  %s\n", key, formatBytes(int64(len(value))), dict.queryOr(key+".encoding", "-"))
+	}
+	return 0
+}
+
+// formatKeyValue formats a key and value as a KEY="VALUE" argument that
+// parseKeyValue reads back unchanged
+func formatKeyValue(key, value string) string {
+	return key + `="` + escapeQuotes(value) + `"`
+}
+
+// runQueryPrefix implements the query-prefix command
+func runQueryPrefix(args []string) int {
+	fs := flag.NewFlagSet("query-prefix", flag.ContinueOnError)
+	reimportable := fs.Bool("reimportable", false, "print KEY=\"VALUE\" lines that set accepts")
+
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool query-prefix [--reimportable] FILE PREFIX\n")
+		return exitUsage
+	}
+	if len(positional) != 2 {
+		errorf("Error: query-prefix command requires FILE and PREFIX arguments\n")
+		errorf("Usage: vmxtool query-prefix [--reimportable] FILE PREFIX\n")
+		return exitUsage
+	}
+	filename := positional[0]
+	prefix := positional[1]
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	matches := dict.FindPrefix(prefix)
+	if len(matches) == 0 {
+		errorf("Error: no keys start with '%s'\n", prefix)
+		return exitKeyNotFound
+	}
+
+	for _, entry := range matches {
+		if *reimportable {
+			fmt.Fprintln(stdout, formatKeyValue(entry.Key, entry.Value))
+		} else {
+			fmt.Fprintf(stdout, "%s = %s\n", entry.Key, entry.Value)
+		}
+	}
+	return 0
+}
+
+// timeSyncKeys lists the keys that must all be FALSE to stop VMware Tools
+// changing the guest clock
+var timeSyncKeys = []string{
+	"tools.syncTime",
+	"time.synchronize.continue",
+	"time.synchronize.restore",
+	"time.synchronize.resume.disk",
+	"time.synchronize.shrink",
+	"time.synchronize.tools.startup",
+}
+
+// printTimeSyncStatus prints the time synchronization keys and the
+// effective state, calling out partial configurations
+func printTimeSyncStatus(dict *Dictionary) {
+	var rows []statusRow
+	var enabled, missing []string
+	for _, key := range timeSyncKeys {
+		value, ok := dict.QueryOK(key)
+		if !ok {
+			missing = append(missing, key)
+			rows = append(rows, statusRow{key, notSet})
+			continue
+		}
+		if b, ok := parseBool(value); !ok || b {
+			enabled = append(enabled, key)
+		}
+		rows = append(rows, statusRow{key, value})
+	}
+
+	var state string
+	switch {
+	case len(missing) == len(timeSyncKeys):
+		state = "on (VMware defaults)"
+	case len(missing) == 0 && len(enabled) == 0:
+		state = "off"
+	default:
+		state = "partial"
+	}
+	rows = append(rows, statusRow{"Time synchronization", state})
+	printStatus(rows)
+
+	if state == "partial" {
+		warnf("Warning: time synchronization is only partly disabled, so the guest clock can still be changed\n")
+		if len(missing) > 0 {
+			warnf("Missing: %s\n", strings.Join(missing, ", "))
+		}
+		if len(enabled) > 0 {
+			warnf("Not FALSE: %s\n", strings.Join(enabled, ", "))
+		}
+		warnf("Use 'vmxtool timesync FILE off' to disable it completely\n")
+	}
+}
+
+// runTimeSync implements the timesync command
+func runTimeSync(args []string) int {
+	args, err := parseArgs("timesync", args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool timesync FILE [on|off|status]\n")
+		return exitUsage
+	}
+	if len(args) < 1 || len(args) > 2 {
+		errorf("Error: timesync command requires FILE argument\n")
+		errorf("Usage: vmxtool timesync FILE [on|off|status]\n")
+		return exitUsage
+	}
+	filename := args[0]
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	if len(args) == 1 || args[1] == "status" {
+		printTimeSyncStatus(dict)
+		return 0
+	}
+
+	enable, ok := parseBool(args[1])
+	if !ok {
+		errorf("Error: invalid state '%s', expected on, off or status\n", args[1])
+		errorf("Usage: vmxtool timesync FILE [on|off|status]\n")
+		return exitUsage
+	}
+
+	changed := false
+	for _, key := range timeSyncKeys {
+		if !enable {
+			changed = dict.Set(key, "FALSE") || changed
+		} else if entry := dict.findEntryCaseInsensitive(key); entry != nil {
+			dict.removeEntry(entry)
+			changed = true
+		}
+	}
+
+	if !changed {
+		return 0
+	}
+
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// uuidAction is a choice of the uuid-action command
+type uuidAction struct {
+	Name        string
+	Value       string // uuid.action value, empty to remove the key
+	Explanation string
+}
+
+// uuidActions explains what each uuid-action choice does when the VM is
+// next powered on after being moved or copied
+var uuidActions = []uuidAction{
+	{"keep", "keep", "the VM keeps its UUID and MAC addresses, as if it was moved"},
+	{"create", "create", "the VM gets a new UUID and MAC addresses, as if it was copied"},
+	{"prompt", "", "VMware asks whether the VM was moved or copied"},
+}
+
+// printAutoAnswerStatus prints the settings that control VMware's
+// power-on questions
+func printAutoAnswerStatus(dict *Dictionary) {
+	autoAnswer := "off"
+	if dict.queryBoolOr("msg.autoAnswer", false) {
+		autoAnswer = "on"
+	}
+
+	action := "prompt"
+	explanation := uuidActions[len(uuidActions)-1].Explanation
+	if value, ok := dict.QueryOK("uuid.action"); ok {
+		action = value
+		explanation = "unknown value, VMware asks whether the VM was moved or copied"
+		for _, a := range uuidActions {
+			if a.Value != "" && strings.EqualFold(a.Value, value) {
+				explanation = a.Explanation
+			}
+		}
+	}
+
+	printStatus([]statusRow{
+		{"Auto answer", autoAnswer},
+		{"UUID action", action},
+	})
+	if autoAnswer == "on" {
+		fmt.Fprintln(stdout, "Questions are answered with their default choice, so the VM will not wait at power-on")
+	}
+	fmt.Fprintf(stdout, "When moved or copied, %s\n", explanation)
+}
+
+// runAutoAnswer implements the autoanswer command
+func runAutoAnswer(args []string) int {
+	args, err := parseArgs("autoanswer", args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool autoanswer FILE [on|off|status]\n")
+		return exitUsage
+	}
+	if len(args) < 1 || len(args) > 2 {
+		errorf("Error: autoanswer command requires FILE argument\n")
+		errorf("Usage: vmxtool autoanswer FILE [on|off|status]\n")
+		return exitUsage
+	}
+	filename := args[0]
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	if len(args) == 1 || args[1] == "status" {
+		printAutoAnswerStatus(dict)
+		return 0
+	}
+
+	enable, ok := parseBool(args[1])
+	if !ok {
+		errorf("Error: invalid state '%s', expected on, off or status\n", args[1])
+		errorf("Usage: vmxtool autoanswer FILE [on|off|status]\n")
+		return exitUsage
+	}
+
+	changed := false
+	if enable {
+		changed = dict.SetGrouped("msg.autoAnswer", "TRUE")
+	} else if entry := dict.findEntryCaseInsensitive("msg.autoAnswer"); entry != nil {
+		dict.removeEntry(entry)
+		changed = true
+	}
+	if !changed {
+		return 0
+	}
+
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// runUUIDAction implements the uuid-action command
+func runUUIDAction(args []string) int {
+	args, err := parseArgs("uuid-action", args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool uuid-action FILE [keep|create|prompt|status]\n")
+		return exitUsage
+	}
+	if len(args) < 1 || len(args) > 2 {
+		errorf("Error: uuid-action command requires FILE argument\n")
+		errorf("Usage: vmxtool uuid-action FILE [keep|create|prompt|status]\n")
+		return exitUsage
+	}
+	filename := args[0]
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	if len(args) == 1 || args[1] == "status" {
+		printAutoAnswerStatus(dict)
+		return 0
+	}
+
+	i := slices.IndexFunc(uuidActions, func(a uuidAction) bool {
+		return a.Name == strings.ToLower(args[1])
+	})
+	if i == -1 {
+		errorf("Error: invalid action '%s', expected keep, create, prompt or status\n", args[1])
+		errorf("Usage: vmxtool uuid-action FILE [keep|create|prompt|status]\n")
+		return exitUsage
+	}
+	action := uuidActions[i]
+
+	changed := false
+	if action.Value != "" {
+		changed = dict.SetGrouped("uuid.action", action.Value)
+	} else if entry := dict.findEntryCaseInsensitive("uuid.action"); entry != nil {
+		dict.removeEntry(entry)
+		changed = true
+	}
+	infof("When moved or copied, %s\n", action.Explanation)
+	if !changed {
+		return 0
+	}
+
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// batchSetting is a setting read by set --batch, with the line it starts
+// on for errors. HereDoc is set for a value given as a here-doc.
+type batchSetting struct {
+	Key     string
+	Value   string
+	Line    int
+	HereDoc bool
+}
+
+// parseBatch parses the input of set --batch: KEY=VALUE lines, read as set
+// reads its argument, and here-docs, a KEY<<END line followed by the lines
+// of the value and a line holding only END. Blank lines and lines starting
+// with # are skipped, except in a here-doc, whose lines are kept as they
+// are without their line endings.
+func parseBatch(text string) ([]batchSetting, error) {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+
+	var settings []batchSetting
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// In KEY=VALUE, << is part of the value
+		key, delimiter, ok := strings.Cut(line, "<<")
+		if !ok || strings.Contains(key, "=") {
+			key, value, err := parseKeyValue(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", i+1, err)
+			}
+			settings = append(settings, batchSetting{key, value, i + 1, false})
+			continue
+		}
+
+		key, delimiter = strings.TrimSpace(key), strings.TrimSpace(delimiter)
+		if key == "" {
+			return nil, fmt.Errorf("line %d: key cannot be empty", i+1)
+		}
+		if err := checkKey(key); err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		if delimiter == "" || strings.ContainsAny(delimiter, " \t\"") {
+			return nil, fmt.Errorf("line %d: invalid here-doc delimiter %q: expected %s<<WORD", i+1, delimiter, key)
+		}
+		start := i
+		var value []string
+		for i++; i < len(lines) && strings.TrimSpace(lines[i]) != delimiter; i++ {
+			value = append(value, lines[i])
+		}
+		if i == len(lines) {
+			return nil, fmt.Errorf("line %d: here-doc for %s is not terminated by a line %s", start+1, key, delimiter)
+		}
+		settings = append(settings, batchSetting{key, strings.Join(value, "\n"), start + 1, true})
+	}
+	return settings, nil
+}
+
+// checkSetValue makes the checks of set on a value, before any file is
+// loaded, and reports whether the value may be set. Errors and warnings
+// are printed with where, if not empty, before the message.
+func checkSetValue(where, key, value string, noValidate, validateResources, strict bool) bool {
+	if !noValidate && strings.EqualFold(key, "guestOS") {
+		if err := validateGuestOS(value); err != nil {
+			errorf("Error: %s%v\n", where, err)
+			errorf("Use --no-validate to set it anyway, or 'vmxtool guestos list' to see known values\n")
+			return false
+		}
+	}
+	if !noValidate {
+		if err := validateKnownValue(key, value); err != nil {
+			errorf("Error: %s%v\n", where, err)
+			errorf("Use --no-validate to set it anyway, or 'vmxtool explain KEY' to see the values it takes\n")
+			return false
+		}
+	}
+
+	if validateResources {
+		if err := validateResourceValue(key, value); err != nil {
+			if strict {
+				errorf("Error: %s%v\n", where, err)
+				return false
+			}
+			warnf("Warning: %s%v\n", where, err)
+		}
+	}
+	return true
+}
+
+// runSet implements the set command
+func runSet(args []string) int {
+	filename, apply, code := prepareSet(args)
+	if apply == nil {
+		return code
+	}
+	return apply(filename, nil)
+}
+
+// prepareSet parses and validates the arguments of the set command and
+// returns the function that sets the key in a file
+func prepareSet(args []string) (string, applyFunc, int) {
+	fs := flag.NewFlagSet("set", flag.ContinueOnError)
+	requireChange := fs.Bool("require-change", false, "exit with a distinct code if nothing changed")
+	noValidate := fs.Bool("no-validate", false, "skip validation of known keys")
+	updateOnly := fs.Bool("update-only", false, "fail if the key does not already exist")
+	validateResources := fs.Bool("validate-resources", false, "check memsize and numvcpus values")
+	strict := fs.Bool("strict", false, "treat validation and snapshot warnings as errors")
+	allDupes := fs.Bool("all-dupes", false, "update every occurrence of a duplicated key")
+	valueFrom := fs.String("value-from", "", "read the value of KEY from a file")
+	expand := fs.Bool("expand-env", false, "substitute ${NAME} in the value from the environment")
+	batch := fs.String("batch", "", "read KEY=VALUE lines and here-docs from a file, or - for stdin")
+
+	usage := "Usage: vmxtool set [--require-change] [--update-only] [--all-dupes] [--no-validate] [--validate-resources [--strict]] [--expand-env] FILE KEY=VALUE\n" +
+		"       vmxtool set [options] FILE KEY --value-from PATH\n" +
+		"       vmxtool set [options] FILE --batch PATH|-"
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return "", nil, exitUsage
+	}
+	if *batch != "" {
+		if len(positional) != 1 || *valueFrom != "" {
+			errorf("Error: set --batch requires a single FILE argument and cannot be used with --value-from\n")
+			errorln(usage)
+			return "", nil, exitUsage
+		}
+		var data []byte
+		if *batch == "-" {
+			data, err = io.ReadAll(stdin)
+		} else {
+			data, err = files.ReadFile(*batch)
+		}
+		if err != nil {
+			errorf("Error loading file: %v\n", err)
+			return "", nil, exitFileError
+		}
+		settings, err := parseBatch(string(data))
+		if err != nil {
+			errorf("Error: %v\n", err)
+			return "", nil, exitUsage
+		}
+
+		var keys []string
+		for i, setting := range settings {
+			if *expand {
+				if settings[i].Value, err = expandEnv(setting.Value, os.LookupEnv); err != nil {
+					errorf("Error: line %d: %v\n", setting.Line, err)
+					return "", nil, exitUsage
+				}
+			}
+			// A here-doc is written as --value-from writes a file
+			if setting.HereDoc && !globalOptions.VMwareCompat {
+				settings[i].Value = vmwareEscape(settings[i].Value)
+			}
+			if !checkSetValue(fmt.Sprintf("line %d: ", setting.Line), setting.Key, settings[i].Value, *noValidate, *validateResources, *strict) {
+				return "", nil, exitError
+			}
+			keys = append(keys, setting.Key)
+		}
+
+		// Every setting is made before the file is saved once, and none if
+		// one of them fails
+		return positional[0], func(filename string, out *fileOutput) int {
+			if err := checkSnapshots(out, filename, keys, *strict); err != nil {
+				out.errorf("Error: %v\n", err)
+				return exitError
+			}
+
+			dict, err := LoadDictionary(filename)
+			if err != nil {
+				out.errorf("Error loading file: %v\n", err)
+				return exitFileError
+			}
+
+			changed := false
+			topology := false
+			for _, setting := range settings {
+				if *updateOnly && !dict.KeyExists(setting.Key) {
+					out.errorf("Error: line %d: %v\n", setting.Line, dict.notFound(setting.Key))
+					return exitKeyNotFound
+				}
+				set := dict.Set
+				if *allDupes {
+					set = dict.SetAll
+				} else if n := dict.occurrences(setting.Key); n > 1 {
+					out.warnf("Warning: %s appears %d times and only the first is updated, but VMware uses the last; use --all-dupes to update them all\n", setting.Key, n)
+				}
+				if set(setting.Key, setting.Value) {
+					changed = true
+				}
+				topology = topology || strings.EqualFold(setting.Key, "numvcpus") || strings.EqualFold(setting.Key, "cpuid.coresPerSocket")
+			}
+
+			if topology && !*noValidate {
+				cpus, err1 := dict.queryInt("numvcpus", 1)
+				cores, err2 := dict.queryInt("cpuid.coresPerSocket", 1)
+				if err1 == nil && err2 == nil {
+					if err := validateTopology(cpus, cores); err != nil {
+						out.errorf("Error: %v\n", err)
+						out.errorf("Use --no-validate to set it anyway\n")
+						return exitError
+					}
+				}
+			}
+
+			if !changed {
+				if *requireChange {
+					return exitUnchanged
+				}
+				return 0
+			}
+			if err := saveDictionary(dict, filename); err != nil {
+				out.errorf("Error saving file: %v\n", err)
+				return exitFileError
+			}
+			return 0
+		}, 0
+	}
+	if len(positional) != 2 {
+		errorf("Error: set command requires FILE and KEY=VALUE arguments\n")
+		errorln(usage)
+		return "", nil, exitUsage
+	}
+
+	var key, value string
+	if *valueFrom != "" {
+		key = positional[1]
+		if err := checkKey(key); err != nil {
+			errorf("Error: %v\n", err)
+			return "", nil, exitUsage
+		}
+		data, err := files.ReadFile(*valueFrom)
+		if err != nil {
+			errorf("Error loading file: %v\n", err)
+			return "", nil, exitFileError
+		}
+		value = string(data)
+		if *expand {
+			if value, err = expandEnv(value, os.LookupEnv); err != nil {
+				errorf("Error: %v in %s\n", err, *valueFrom)
+				return "", nil, exitUsage
+			}
+		}
+		// Escape characters that cannot appear in a quoted value as VMware
+		// does; in --vmware-compat mode values are escaped when saved
+		if !globalOptions.VMwareCompat {
+			value = vmwareEscape(value)
+		}
+	} else {
+		key, value, err = parseKeyValue(positional[1])
+		if err == nil && *expand {
+			value, err = expandEnv(value, os.LookupEnv)
+		}
+		if err != nil {
+			errorf("Error: %v\n", err)
+			return "", nil, exitUsage
+		}
+	}
+
+	if !checkSetValue("", key, value, *noValidate, *validateResources, *strict) {
+		return "", nil, exitError
+	}
+
+	// The CPU count and cores per socket are only valid together
+	topologyKey := !*noValidate && (strings.EqualFold(key, "numvcpus") || strings.EqualFold(key, "cpuid.coresPerSocket"))
+
+	return positional[0], func(filename string, out *fileOutput) int {
+		if err := checkSnapshots(out, filename, []string{key}, *strict); err != nil {
+			out.errorf("Error: %v\n", err)
+			return exitError
+		}
+
+		// A large file is changed a line at a time unless the change needs the
+		// other keys
+		if !*allDupes && !topologyKey && canStream(filename) {
+			var add func(string) *Entry
+			if !*updateOnly {
+				add = func(k string) *Entry { return newEntry(k, value) }
+			}
+			matches, changed, err := streamRewrite(filename, key, func(entry *Entry) bool {
+				entry.setValue(value)
+				return true
+			}, add)
+			switch {
+			case errors.Is(err, errNoStream):
+			case err != nil:
+				out.errorf("Error saving file: %v\n", err)
+				return exitFileError
+			default:
+				if matches > 1 {
+					out.warnf("Warning: %s appears %d times and only the first is updated, but VMware uses the last; use --all-dupes to update them all\n", key, matches)
+				}
+				if !changed && *requireChange {
+					return exitUnchanged
+				}
+				return 0
+			}
+		}
+
+		dict, err := LoadDictionary(filename)
+		if err != nil {
+			out.errorf("Error loading file: %v\n", err)
+			return exitFileError
+		}
+
+		if *updateOnly && !dict.KeyExists(key) {
+			out.errorf("Error: %v\n", dict.notFound(key))
+			return exitKeyNotFound
+		}
+
+		if topologyKey {
+			cpus, err1 := dict.queryInt("numvcpus", 1)
+			cores, err2 := dict.queryInt("cpuid.coresPerSocket", 1)
+			n, err3 := strconv.Atoi(strings.TrimSpace(value))
+			if err1 == nil && err2 == nil && err3 == nil {
+				if strings.EqualFold(key, "numvcpus") {
+					cpus = n
+				} else {
+					cores = n
+				}
+				if err := validateTopology(cpus, cores); err != nil {
+					out.errorf("Error: %v\n", err)
+					out.errorf("Use --no-validate to set it anyway, or 'vmxtool topology FILE --sockets N --cores N' to set both\n")
+					return exitError
+				}
+			}
+		}
+
+		set := dict.Set
+		if *allDupes {
+			set = dict.SetAll
+		} else if n := dict.occurrences(key); n > 1 {
+			out.warnf("Warning: %s appears %d times and only the first is updated, but VMware uses the last; use --all-dupes to update them all\n", key, n)
+		}
+
+		// Only rewrite the file when the value actually changed
+		if !set(key, value) {
+			if *requireChange {
+				return exitUnchanged
+			}
+			return 0
+		}
+
+		if err := saveDictionary(dict, filename); err != nil {
+			out.errorf("Error saving file: %v\n", err)
+			return exitFileError
+		}
+
+		return 0
+	}, 0
+}
+
+// runMerge implements the merge command
+func runMerge(args []string) int {
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	appendNew := fs.Bool("append-new", false, "append new keys at the end of the file")
+
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool merge [--append-new] BASE OVERLAY\n")
+		return exitUsage
+	}
+	if len(positional) != 2 {
+		errorf("Error: merge command requires BASE and OVERLAY arguments\n")
+		errorf("Usage: vmxtool merge [--append-new] BASE OVERLAY\n")
+		return exitUsage
+	}
+	baseFile := positional[0]
+	overlayFile := positional[1]
+
+	base, err := LoadDictionary(baseFile)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	// Unlike the base file, a missing overlay is an error
+	if _, err := files.Stat(overlayFile); err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+	overlay, err := LoadDictionary(overlayFile)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	if !base.Merge(overlay, *appendNew) {
+		return 0
+	}
+
+	if err := saveDictionary(base, baseFile); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// profileFiles holds the built-in profiles for the profile command
+//
+//go:embed profiles/*.profile
+var profileFiles embed.FS
+
+// profileExt is the extension of profile files
+const profileExt = ".profile"
+
+// profileInfo describes a profile found by the profile command
+type profileInfo struct {
+	Name        string
+	Source      string // directory, or "built-in"
+	Description string
+	Dict        *Dictionary
+}
+
+// parseProfile parses a profile, which is in the VMX file format. The first
+// comment line is its description.
+func parseProfile(name, source string, data []byte) (profileInfo, error) {
+	entries, err := parseEntries(string(data))
+	if err != nil {
+		return profileInfo{}, fmt.Errorf("profile %s: %v", name, err)
+	}
+	info := profileInfo{Name: name, Source: source, Dict: &Dictionary{Filename: name + profileExt, Entries: entries}}
+	for _, entry := range entries {
+		if entry.IsComment {
+			info.Description = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(entry.Original), "#"))
+			break
+		}
+	}
+	return info, nil
+}
+
+// findProfiles returns the built-in profiles and those in dir, if given,
+// sorted by name. A profile in dir replaces a built-in one of that name.
+func findProfiles(dir string) ([]profileInfo, error) {
+	var profiles []profileInfo
+	add := func(info profileInfo) {
+		profiles = slices.DeleteFunc(profiles, func(p profileInfo) bool { return p.Name == info.Name })
+		profiles = append(profiles, info)
+	}
+
+	builtin, err := fs.Glob(profileFiles, "profiles/*"+profileExt)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range builtin {
+		data, err := profileFiles.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		info, err := parseProfile(strings.TrimSuffix(path.Base(file), profileExt), "built-in", data)
+		if err != nil {
+			return nil, err
+		}
+		add(info)
+	}
+
+	if dir != "" {
+		if _, err := files.Stat(dir); err != nil {
+			return nil, err
+		}
+		entries, err := files.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasSuffix(entry.Name(), profileExt) {
+				continue
+			}
+			file := filepath.Join(dir, entry.Name())
+			data, err := files.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			info, err := parseProfile(strings.TrimSuffix(filepath.Base(file), profileExt), dir, data)
+			if err != nil {
+				return nil, err
+			}
+			add(info)
+		}
+	}
+
+	slices.SortFunc(profiles, func(a, b profileInfo) int { return strings.Compare(a.Name, b.Name) })
+	return profiles, nil
+}
+
+// findProfile returns the named profile
+func findProfile(name, dir string) (profileInfo, error) {
+	profiles, err := findProfiles(dir)
+	if err != nil {
+		return profileInfo{}, err
+	}
+	for _, p := range profiles {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return profileInfo{}, fmt.Errorf("unknown profile '%s', use 'vmxtool profile list' to see the profiles", name)
+}
+
+// profileComment returns the comment recording that a profile was applied
+func profileComment(name string) string {
+	return fmt.Sprintf("# profile %s applied by vmxtool", name)
+}
+
+// runProfile implements the profile command
+func runProfile(args []string) int {
+	if len(args) < 1 {
+		errorf("Error: profile command requires apply, list or show subcommand\n")
+		errorf("Usage: vmxtool profile apply|list|show ...\n")
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "apply":
+		return runProfileApply(args[1:])
+	case "list":
+		return runProfileList(args[1:])
+	case "show":
+		return runProfileShow(args[1:])
+	}
+
+	errorf("Error: unknown profile subcommand '%s'\n", args[0])
+	errorf("Usage: vmxtool profile apply|list|show ...\n")
+	return exitUsage
+}
+
+// runProfileApply implements the profile apply command
+func runProfileApply(args []string) int {
+	fs := flag.NewFlagSet("profile apply", flag.ContinueOnError)
+	dir := fs.String("profile-dir", "", "directory of profiles to use as well as the built-in ones")
+	overwrite := fs.Bool("overwrite", false, "replace existing values that differ from the profile")
+	expand := fs.Bool("expand-env", false, "substitute ${NAME} in the profile's values from the environment")
+
+	usage := "Usage: vmxtool profile apply FILE NAME [--profile-dir DIR] [--overwrite] [--expand-env]"
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	if len(positional) != 2 {
+		errorf("Error: profile apply command requires FILE and NAME arguments\n")
+		errorln(usage)
+		return exitUsage
+	}
+	filename := positional[0]
+
+	profile, err := findProfile(positional[1], *dir)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		return exitError
+	}
+	if *expand {
+		for _, entry := range profile.Dict.Entries {
+			if entry.Key == "" {
+				continue
+			}
+			value, err := expandEnv(entry.Value, os.LookupEnv)
+			if err != nil {
+				errorf("Error: %s: %v\n", entry.Key, err)
+				return exitUsage
+			}
+			entry.setValue(value)
+		}
+	}
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	conflicts := 0
+	for _, entry := range profile.Dict.Entries {
+		if entry.Key == "" {
+			continue
+		}
+		if value, ok := dict.QueryOK(entry.Key); ok && value != entry.Value {
+			warnf("Conflict: %s is \"%s\", profile %s sets \"%s\"\n", entry.Key, escapeQuotes(value), profile.Name, escapeQuotes(entry.Value))
+			conflicts++
+		}
+	}
+	if conflicts > 0 && !*overwrite {
+		errorf("Error: %d keys conflict with profile %s, use --overwrite to replace them\n", conflicts, profile.Name)
+		return exitKeyExists
+	}
+
+	changed := dict.Merge(profile.Dict, false)
+	comment := profileComment(profile.Name)
+	if changed && !slices.ContainsFunc(dict.Entries, func(e *Entry) bool { return e.IsComment && strings.TrimSpace(e.Original) == comment }) {
+		dict.Entries = append(dict.Entries, &Entry{Original: comment, IsComment: true})
+	}
+
+	if !changed {
+		return 0
+	}
+
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// runProfileList implements the profile list command
+func runProfileList(args []string) int {
+	fs := flag.NewFlagSet("profile list", flag.ContinueOnError)
+	dir := fs.String("profile-dir", "", "directory of profiles to list as well as the built-in ones")
+
+	positional, err := parseFlags(fs, args)
+	if err == nil && len(positional) != 0 {
+		err = errors.New("profile list command takes no arguments")
+	}
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool profile list [--profile-dir DIR]\n")
+		return exitUsage
+	}
+
+	profiles, err := findProfiles(*dir)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		return exitError
+	}
+
+	fmt.Fprintf(stdout, "%-20s %-12s %s\n", "NAME", "SOURCE", "DESCRIPTION")
+	for _, p := range profiles {
+		fmt.Fprintf(stdout, "%-20s %-12s %s\n", p.Name, p.Source, p.Description)
+	}
+	return 0
+}
+
+// runProfileShow implements the profile show command
+func runProfileShow(args []string) int {
+	fs := flag.NewFlagSet("profile show", flag.ContinueOnError)
+	dir := fs.String("profile-dir", "", "directory of profiles to use as well as the built-in ones")
+
+	usage := "Usage: vmxtool profile show NAME [--profile-dir DIR]"
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		errorf("Error: profile show command requires NAME argument\n")
+		errorln(usage)
+		return exitUsage
+	}
+
+	profile, err := findProfile(positional[0], *dir)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		return exitError
+	}
+
+	profile.Dict.Print()
+	return 0
+}
+
+// firstDifferentLine returns the 1-based number of the first line that
+// differs between a and b, or 0 if they are identical
+func firstDifferentLine(a, b []byte) int {
+	if bytes.Equal(a, b) {
+		return 0
+	}
+	linesA := bytes.SplitAfter(a, []byte("\n"))
+	linesB := bytes.SplitAfter(b, []byte("\n"))
+	for i := range min(len(linesA), len(linesB)) {
+		if !bytes.Equal(linesA[i], linesB[i]) {
+			return i + 1
+		}
+	}
+	return min(len(linesA), len(linesB)) + 1
+}
+
+// runDisableEnable implements the disable and enable commands
+func runDisableEnable(command string, args []string) int {
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	marker := fs.String("disable-marker", defaultDisableMarker, "comment marker for disabled keys, starting with #")
+
+	usage := fmt.Sprintf("Usage: vmxtool %s [--disable-marker STRING] FILE KEY", command)
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	if len(positional) != 2 {
+		errorf("Error: %s command requires FILE and KEY arguments\n", command)
+		errorln(usage)
+		return exitUsage
+	}
+	// Anything else would not be read back as a comment
+	if !strings.HasPrefix(*marker, "#") {
+		errorf("Error: invalid --disable-marker '%s', it must start with #\n", *marker)
+		errorln(usage)
+		return exitUsage
+	}
+	filename := positional[0]
+	key := positional[1]
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	if command == "disable" {
+		err = dict.Disable(key, *marker)
+	} else {
+		err = dict.Enable(key, *marker)
+	}
+	if err != nil {
+		errorf("Error: %v\n", err)
+		return exitCode(err)
+	}
+
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// runAppend implements the append and unappend commands, which add a token
+// to or remove it from a space-separated list value
+func runAppend(command string, args []string) int {
+	args, err := parseArgs(command, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool %s FILE KEY TOKEN\n", command)
+		return exitUsage
+	}
+	if len(args) != 3 {
+		errorf("Error: %s command requires FILE, KEY and TOKEN arguments\n", command)
+		errorf("Usage: vmxtool %s FILE KEY TOKEN\n", command)
+		return exitUsage
+	}
+	filename := args[0]
+	key := args[1]
+	token := args[2]
+	if token == "" || strings.ContainsAny(token, " \t") {
+		errorf("Error: invalid token '%s', it must be a single word\n", token)
+		return exitUsage
+	}
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	value, exists := dict.QueryOK(key)
+	if command == "unappend" && !exists {
+		err := dict.notFound(key)
+		errorf("Error: %v\n", err)
+		return exitCode(err)
+	}
+
+	tokens := strings.Fields(value)
+	if command == "append" {
+		if slices.Contains(tokens, token) {
+			return 0
+		}
+		tokens = append(tokens, token)
+	} else {
+		if !slices.Contains(tokens, token) {
+			return 0
+		}
+		tokens = slices.DeleteFunc(tokens, func(t string) bool { return t == token })
+	}
+
+	dict.SetGrouped(key, strings.Join(tokens, " "))
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// normalizeKeys gives keys that share a case-insensitive prefix the same
+// casing for that prefix, taken from the first key seen or lower case. It
+// returns the keys renamed, as old and new names.
+func (d *Dictionary) normalizeKeys(lower bool) [][2]string {
+	var renamed [][2]string
+	canonical := make(map[string]string)
+	for _, entry := range d.Entries {
+		if entry.Key == "" {
+			continue
+		}
+		segments := strings.Split(entry.Key, ".")
+		prefix := ""
+		for i, segment := range segments {
+			prefix += strings.ToLower(segment) + "."
+			if lower {
+				segments[i] = strings.ToLower(segment)
+			} else if spelling, ok := canonical[prefix]; ok {
+				segments[i] = spelling
+			} else {
+				canonical[prefix] = segment
+			}
+		}
+		if key := strings.Join(segments, "."); key != entry.Key {
+			renamed = append(renamed, [2]string{entry.Key, key})
+			d.renameEntry(entry, key)
+		}
+	}
+	return renamed
+}
+
+// runNormalizeKeys implements the normalize-keys command
+func runNormalizeKeys(args []string) int {
+	fs := flag.NewFlagSet("normalize-keys", flag.ContinueOnError)
+	style := fs.String("style", "first-seen", "casing to use: first-seen or lower")
+
+	usage := "Usage: vmxtool normalize-keys FILE [--style first-seen|lower]"
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		errorf("Error: normalize-keys command requires FILE argument\n")
+		errorln(usage)
+		return exitUsage
+	}
+	if *style != "first-seen" && *style != "lower" {
+		errorf("Error: invalid style '%s', expected first-seen or lower\n", *style)
+		errorln(usage)
+		return exitUsage
+	}
+	filename := positional[0]
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	renamed := dict.normalizeKeys(*style == "lower")
+	verb := "Renamed"
+	if globalOptions.DryRun {
+		verb = "Would rename"
+	}
+	for _, r := range renamed {
+		infof("%s %s to %s\n", verb, r[0], r[1])
+	}
+
+	if len(renamed) == 0 {
+		return 0
+	}
+
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// replaceValue sets every entry whose value is old to new and returns
+// the keys changed
+func (d *Dictionary) replaceValue(old, new string, ignoreCase bool) []string {
+	var changed []string
+	for _, entry := range d.Entries {
+		if entry.Key == "" {
+			continue
+		}
+		if entry.Value == old || ignoreCase && strings.EqualFold(entry.Value, old) {
+			if entry.setValue(new) {
+				changed = append(changed, entry.Key)
+			}
+		}
+	}
+	return changed
+}
+
+// runReplaceValue implements the replace-value command
+func runReplaceValue(args []string) int {
+	fs := flag.NewFlagSet("replace-value", flag.ContinueOnError)
+	ignoreCase := fs.Bool("ignore-case", false, "match OLDVALUE case-insensitively")
+
+	usage := "Usage: vmxtool replace-value [--ignore-case] FILE OLDVALUE NEWVALUE"
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	if len(positional) != 3 {
+		errorf("Error: replace-value command requires FILE, OLDVALUE and NEWVALUE arguments\n")
+		errorln(usage)
+		return exitUsage
+	}
+	filename, oldValue, newValue := positional[0], positional[1], positional[2]
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	changed := dict.replaceValue(oldValue, newValue, *ignoreCase)
+	for _, key := range changed {
+		infof("Replaced %s\n", key)
+	}
+	infof("%d of %d values replaced\n", len(changed), len(dict.Keys()))
+
+	if len(changed) == 0 {
+		return 0
+	}
+
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// runSort implements the sort command
+func runSort(args []string) int {
+	args, err := parseArgs("sort", args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool sort FILE\n")
+		return exitUsage
+	}
+	if len(args) != 1 {
+		errorf("Error: sort command requires FILE argument\n")
+		errorf("Usage: vmxtool sort FILE\n")
+		return exitUsage
+	}
+	filename := args[0]
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	before := dict.render()
+	dict.SortKeys()
+	if dict.render() == before {
+		return 0
+	}
+
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// runRoundTrip implements the hidden roundtrip command, which writes the
+// file as it would be saved to stdout and reports whether it is
+// byte-for-byte identical to the original
+func runRoundTrip(args []string) int {
+	args, err := parseArgs("roundtrip", args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool roundtrip FILE\n")
+		return exitUsage
+	}
+	if len(args) != 1 {
+		errorf("Error: roundtrip command requires FILE argument\n")
+		errorf("Usage: vmxtool roundtrip FILE\n")
+		return exitUsage
+	}
+	filename := args[0]
+
+	original, err := files.ReadFile(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	saved := dict.encode()
+	stdout.Write(saved)
+
+	if line := firstDifferentLine(original, saved); line != 0 {
+		errorf("Round trip differs from the original at line %d\n", line)
+		return exitDifferent
+	}
+	return 0
+}
+
+// runDump implements the hidden dump command, a debugging aid that prints
+// the fields each line of a file is parsed into, with strings quoted so
+// that whitespace and escapes can be seen
+func runDump(args []string) int {
+	args, err := parseArgs("dump", args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool dump FILE\n")
+		return exitUsage
+	}
+	if len(args) != 1 {
+		errorf("Error: dump command requires FILE argument\n")
+		errorf("Usage: vmxtool dump FILE\n")
+		return exitUsage
+	}
+
+	dict, err := LoadDictionary(args[0])
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	for i, entry := range dict.Entries {
+		fmt.Fprintf(stdout, "%d: {IsBlank:%t IsComment:%t Key:%q Value:%q InlineComment:%q InlineCommentSpace:%q}\n",
+			i+1, entry.IsBlank, entry.IsComment, entry.Key, entry.Value, entry.InlineComment, entry.InlineCommentSpace)
+	}
+	return 0
+}
+
+// ChangeKind describes how a key differs between two dictionaries
+type ChangeKind int
+
+const (
+	KeyAdded ChangeKind = iota
+	KeyRemoved
+	KeyChanged
+)
+
+// KeyChange is a difference in a single key between two dictionaries
+type KeyChange struct {
+	Kind     ChangeKind
+	Key      string
+	OldValue string
+	NewValue string
+}
+
+// DiffDictionaries compares the keys of two dictionaries (case-insensitive),
+// listing removed and changed keys in the order of a, then added keys in
+// the order of b
+func DiffDictionaries(a, b *Dictionary) []KeyChange {
+	var changes []KeyChange
+	seen := make(map[string]bool)
+
+	for _, entry := range a.Entries {
+		lowerKey := strings.ToLower(entry.Key)
+		if entry.Key == "" || seen[lowerKey] {
+			continue
+		}
+		seen[lowerKey] = true
+		oldValue, _ := a.QueryOK(entry.Key)
+		newValue, ok := b.QueryOK(entry.Key)
+		if !ok {
+			changes = append(changes, KeyChange{Kind: KeyRemoved, Key: entry.Key, OldValue: oldValue})
+		} else if oldValue != newValue {
+			changes = append(changes, KeyChange{Kind: KeyChanged, Key: entry.Key, OldValue: oldValue, NewValue: newValue})
+		}
+	}
+
+	for _, entry := range b.Entries {
+		lowerKey := strings.ToLower(entry.Key)
+		if entry.Key == "" || seen[lowerKey] {
+			continue
+		}
+		seen[lowerKey] = true
+		changes = append(changes, KeyChange{Kind: KeyAdded, Key: entry.Key, NewValue: entry.Value})
+	}
+
+	return changes
+}
+
+// ANSI colour codes used for diff output
+const (
+	colorRed    = "31"
+	colorGreen  = "32"
+	colorYellow = "33"
+)
+
+// colorize wraps s in an ANSI colour escape sequence if enabled
+func colorize(s, color string, enabled bool) string {
+	if !enabled {
+		return s
+	}
+	return "\x1b[" + color + "m" + s + "\x1b[0m"
+}
+
+// isTerminal reports whether f is connected to a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// useColor decides whether to colour output from a --color setting of
+// always, never or auto. Auto colours only when stdout is a terminal and
+// NO_COLOR is not set.
+func useColor(mode string) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto", "":
+		f, ok := stdout.(*os.File)
+		return ok && isTerminal(f) && os.Getenv("NO_COLOR") == "", nil
+	}
+	return false, fmt.Errorf("invalid color mode '%s', expected always, never or auto", mode)
+}
+
+// diffLine is one line of a line diff: ' ' for unchanged, '-' for removed
+// and '+' for added
+type diffLine struct {
+	Op   byte
+	Text string
+}
+
+// splitLines splits text into lines without their line endings
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffLines returns a minimal line diff of a and b. Common leading and
+// trailing lines are matched first so the quadratic LCS table only covers
+// the changed region, which is small for typical edits.
+func diffLines(a, b []string) []diffLine {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	midA := a[prefix : len(a)-suffix]
+	midB := b[prefix : len(b)-suffix]
+
+	// lcs[i][j] is the length of the longest common subsequence of
+	// midA[i:] and midB[j:]
+	lcs := make([][]int, len(midA)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(midB)+1)
+	}
+	for i := len(midA) - 1; i >= 0; i-- {
+		for j := len(midB) - 1; j >= 0; j-- {
+			if midA[i] == midB[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var lines []diffLine
+	for _, text := range a[:prefix] {
+		lines = append(lines, diffLine{' ', text})
+	}
+	i, j := 0, 0
+	for i < len(midA) && j < len(midB) {
+		switch {
+		case midA[i] == midB[j]:
+			lines = append(lines, diffLine{' ', midA[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, diffLine{'-', midA[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', midB[j]})
+			j++
+		}
+	}
+	for ; i < len(midA); i++ {
+		lines = append(lines, diffLine{'-', midA[i]})
+	}
+	for ; j < len(midB); j++ {
+		lines = append(lines, diffLine{'+', midB[j]})
+	}
+	for _, text := range a[len(a)-suffix:] {
+		lines = append(lines, diffLine{' ', text})
+	}
+	return lines
+}
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+// unifiedDiff returns a unified diff of the texts a and b, or an empty
+// string if they are identical
+func unifiedDiff(nameA, nameB, a, b string) string {
+	lines := diffLines(splitLines(a), splitLines(b))
+
+	// posA[k] and posB[k] count the lines of a and b before lines[k]
+	posA := make([]int, len(lines)+1)
+	posB := make([]int, len(lines)+1)
+	for k, line := range lines {
+		posA[k+1], posB[k+1] = posA[k], posB[k]
+		if line.Op != '+' {
+			posA[k+1]++
+		}
+		if line.Op != '-' {
+			posB[k+1]++
+		}
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(lines); {
+		if lines[i].Op == ' ' {
+			i++
+			continue
+		}
+
+		// Extend the hunk while the gap to the next change is small
+		// enough for their context lines to overlap
+		end := i + 1
+		for j := end; j < len(lines) && j-end <= 2*diffContext; j++ {
+			if lines[j].Op != ' ' {
+				end = j + 1
+			}
+		}
+		first := max(0, i-diffContext)
+		last := min(len(lines), end+diffContext)
+
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s\n+++ %s\n", nameA, nameB)
+		}
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n",
+			hunkRange(posA[first], posA[last]-posA[first]),
+			hunkRange(posB[first], posB[last]-posB[first]))
+		for _, line := range lines[first:last] {
+			sb.WriteByte(line.Op)
+			sb.WriteString(line.Text + "\n")
+		}
+		i = last
+	}
+	return sb.String()
+}
+
+// hunkRange formats the start,count part of a unified diff hunk header
+// for a hunk that starts after offset lines
+func hunkRange(offset, count int) string {
+	start := offset + 1
+	if count == 0 {
+		start = offset
+	}
+	if count == 1 {
+		return strconv.Itoa(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// printKeyChanges prints key differences, one per line
+func printKeyChanges(changes []KeyChange, color bool) {
+	for _, change := range changes {
+		switch change.Kind {
+		case KeyAdded:
+			fmt.Fprintln(stdout, colorize(fmt.Sprintf("+ %s = \"%s\"", change.Key, escapeQuotes(change.NewValue)), colorGreen, color))
+		case KeyRemoved:
+			fmt.Fprintln(stdout, colorize(fmt.Sprintf("- %s = \"%s\"", change.Key, escapeQuotes(change.OldValue)), colorRed, color))
+		case KeyChanged:
+			fmt.Fprintln(stdout, colorize(fmt.Sprintf("~ %s = \"%s\" -> \"%s\"", change.Key,
+				escapeQuotes(change.OldValue), escapeQuotes(change.NewValue)), colorYellow, color))
+		}
+	}
+}
+
+// runDiff implements the diff command
+func runDiff(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	colorMode := fs.String("color", "auto", "colour output: always, never or auto")
+
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool diff [--color always|never|auto] FILE1 FILE2\n")
+		return exitUsage
+	}
+	if len(positional) != 2 {
+		errorf("Error: diff command requires FILE1 and FILE2 arguments\n")
+		errorf("Usage: vmxtool diff [--color always|never|auto] FILE1 FILE2\n")
+		return exitUsage
+	}
+
+	color, err := useColor(*colorMode)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		return exitUsage
+	}
+
+	var dicts [2]*Dictionary
+	for i, filename := range positional {
+		if _, err := files.Stat(filename); err != nil {
+			errorf("Error loading file: %v\n", err)
+			return exitFileError
+		}
+		dict, err := LoadDictionary(filename)
+		if err != nil {
+			errorf("Error loading file: %v\n", err)
+			return exitFileError
+		}
+		dicts[i] = dict
+	}
+
+	changes := DiffDictionaries(dicts[0], dicts[1])
+	printKeyChanges(changes, color)
+
+	if len(changes) > 0 {
+		return exitDifferent
+	}
+	return 0
+}
+
+// runClonePrep implements the clone-prep command
+func runClonePrep(args []string) int {
+	fs := flag.NewFlagSet("clone-prep", flag.ContinueOnError)
+	newName := fs.String("name", "", "new display name")
+
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool clone-prep FILE [--name NEWNAME]\n")
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		errorf("Error: clone-prep command requires FILE argument\n")
+		errorf("Usage: vmxtool clone-prep FILE [--name NEWNAME]\n")
+		return exitUsage
+	}
+	filename := positional[0]
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	changes := 0
+	for _, rule := range clonePrepTable {
+		for _, entry := range dict.FindMatching(rule.Pattern) {
+			switch rule.Action {
+			case clonePrepRemove:
+				infof("Removed %s = \"%s\"\n", entry.Key, escapeQuotes(entry.Value))
+				dict.removeEntry(entry)
+			case clonePrepRegenerateUUID:
+				uuid, err := generateUUID()
+				if err != nil {
+					errorf("Error: %v\n", err)
+					return exitError
+				}
+				infof("Set %s = \"%s\" (was \"%s\")\n", entry.Key, uuid, escapeQuotes(entry.Value))
+				dict.Set(entry.Key, uuid)
+			}
+			changes++
+		}
+	}
+
+	if *newName != "" {
+		if old, err := dict.Query("displayName"); err == nil {
+			infof("Set displayName = \"%s\" (was \"%s\")\n", escapeQuotes(*newName), escapeQuotes(old))
+		} else {
+			infof("Set displayName = \"%s\"\n", escapeQuotes(*newName))
+		}
+		dict.Set("displayName", *newName)
+		changes++
+
+		if old, err := dict.Query("nvram"); err == nil {
+			nvram := *newName + ".nvram"
+			infof("Set nvram = \"%s\" (was \"%s\")\n", escapeQuotes(nvram), escapeQuotes(old))
+			infof("Note: rename the existing NVRAM file to match\n")
+			dict.Set("nvram", nvram)
+			changes++
+		}
+	}
+
+	if changes == 0 {
+		infof("Nothing to change\n")
+		return 0
+	}
+
+	if err := saveDictionary(dict, filename); err != nil {
+		errorf("Error saving file: %v\n", err)
+		return exitFileError
+	}
+
+	return 0
+}
+
+// command is a command of vmxtool: how it is run and the help, man page
+// and shell completion shown for it. Flags are the long flags the command
+// defines itself, which parseGlobalFlags leaves to it even when a global
+// option has the same name.
+type command struct {
+	Name        string
+	Help        []commandUsage
+	Subcommands []string
+	Flags       []string
+	Examples    []string
+	Run         func(args []string) int
+	Prepare     func(args []string) (filename string, apply applyFunc, code int)
+	Hidden      bool // left out of help, man and completion
+}
+
+// applyFunc makes the change a command was given to one file, printing
+// through out, and returns the exit code
+type applyFunc func(filename string, out *fileOutput) int
+
+// commandUsage is one or more usage lines of a command and what they do.
+// A command with subcommands may describe each separately.
+type commandUsage struct {
+	Usage       []string // usage lines, continuation lines indented
+	Description string   // description lines, without their indent
+}
+
+// commands are the commands of vmxtool in the order help lists them. It is
+// set by init, as help and completion, which it runs, read it.
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{
+			Name: "help",
+			Help: []commandUsage{{
+				Usage: []string{"help [COMMAND]"},
+				Description: `Prints help. With a COMMAND, prints the help for that command
+with examples.`,
+			}},
+			Examples: []string{
+				"vmxtool help",
+				"vmxtool help set",
+			},
+			Run: runHelp,
+		},
+		{
+			Name: "version",
+			Help: []commandUsage{{
+				Usage:       []string{"version"},
+				Description: `Prints version information.`,
+			}},
+			Examples: []string{
+				"vmxtool version",
+			},
+			Run: runVersion,
+		},
+		{
+			Name: "print",
+			Help: []commandUsage{{
+				Usage: []string{"print [--format vmx|json|env|yaml] [--full] FILE"},
+				Description: `Prints the contents of the specified VMX file. --format, or
+--output-format, chooses the format: vmx prints the file as it
+is, json an object of keys and values, env shell variable
+assignments with each character of the key that is not a
+letter, digit or underscore replaced by _, and yaml a mapping.
+Except for vmx, comments are left out and a duplicated key
+appears once, at its first position, with the value of its last
+occurrence as VMware uses. Empty values are kept as empty
+strings. --full, with --format json, instead prints an array
+of every line: a key with its value and any inline comment, a
+comment line as it is, or {} for a blank line.`,
+			}},
+			Flags: []string{"--format", "--full"},
+			Examples: []string{
+				"vmxtool print vm.vmx",
+				"vmxtool print --format json vm.vmx",
+			},
+			Run: runPrint,
+		},
+		{
+			Name: "import",
+			Help: []commandUsage{{
+				Usage: []string{"import [--yaml] [--no-validate] FILE DATA|-"},
+				Description: `Builds or updates the specified VMX file from DATA, or standard
+input given -, written by print. A JSON object of keys and
+values, or with --yaml a flat YAML mapping, sets each key in
+place and appends new ones, keeping the rest of the file. A
+JSON array, from print --format json --full, replaces the whole
+file. A key given twice, whatever its case, is an error and the
+file is left unchanged. Known keys are validated as set does.`,
+			}},
+			Flags: []string{"--yaml", "--no-validate"},
+			Examples: []string{
+				"vmxtool import vm.vmx settings.json",
+				"vmxtool print --format json --full old.vmx | vmxtool import new.vmx -",
+			},
+			Run: runImport,
+		},
+		{
+			Name: "render",
+			Help: []commandUsage{{
+				Usage: []string{
+					"render TEMPLATE [--var NAME=VALUE]... [--vars-file FILE]",
+					"    [--allow-missing] [--no-validate] [--output FILE [--overwrite]]",
+				},
+				Description: `Builds a VMX file from a Go text/template, printing it or, with
+--output, writing it to FILE, which must not exist unless
+--overwrite is given. Variables are given with --var, which
+overrides those read from --vars-file, a flat YAML mapping or a
+JSON object if the name ends in .json, and used as {{.NAME}}.
+Besides the built-in template functions, default gives a value
+for a variable that is unset or empty, as in
+{{.mem | default "4096"}}, upper converts to capitals and
+macformat writes a MAC address as 00:50:56:aa:bb:cc. A variable
+that is used without being set is an error, unless it has a
+default, is only tested with if or with, or --allow-missing is
+given to leave it empty. The result must be a valid VMX file
+with each key once, and known keys are validated as set does
+unless --no-validate is given; problems are reported with the
+template line they come from and nothing is written.`,
+			}},
+			Flags: []string{"--var", "--vars-file", "--allow-missing", "--no-validate", "--output", "--overwrite"},
+			Examples: []string{
+				"vmxtool render web.vmx.tmpl --var name=web01 --var mem=8192 --output web01.vmx",
+				"vmxtool render web.vmx.tmpl --vars-file vars.yaml",
+			},
+			Run: runRender,
+		},
+		{
+			Name: "ovf-extract",
+			Help: []commandUsage{{
+				Usage: []string{"ovf-extract FILE.ovf|FILE.ova [--apply TARGET.vmx]"},
+				Description: `Prints the VMX keys that the vmw:ExtraConfig and vmw:Config
+elements of an OVF descriptor, or of the .ovf in an OVA archive,
+set, as KEY=VALUE lines. vmw:Config keys name vSphere settings
+and are printed as the VMX keys they set; those without one are
+skipped with a warning. --apply sets the keys in TARGET.vmx. The
+OVF is only read. See sample.ovf.`,
+			}},
+			Flags: []string{"--apply"},
+			Examples: []string{
+				"vmxtool ovf-extract appliance.ova",
+				"vmxtool ovf-extract appliance.ovf --apply vm.vmx --dry-run",
+			},
+			Run: runOVFExtract,
+		},
+		{
+			Name: "checksum",
+			Help: []commandUsage{{
+				Usage: []string{"checksum FILE [--semantic|--raw]"},
+				Description: `Prints a SHA-256 fingerprint of the specified VMX file as hex.
+--semantic, the default, hashes the configuration VMware sees:
+each key once, case-folded and sorted, with its effective value
+and |XX escapes decoded, so that comments, key order and layout
+do not change it. --raw hashes the bytes of the file.`,
+			}},
+			Flags: []string{"--semantic", "--raw"},
+			Examples: []string{
+				"vmxtool checksum vm.vmx",
+				"vmxtool checksum vm.vmx --raw",
+			},
+			Run: runChecksum,
+		},
+		{
+			Name: "undo",
+			Help: []commandUsage{{
+				Usage: []string{"undo FILE [--steps N]"},
+				Description: `Reverts the last N changes, 1 by default, recorded in the journal
+of the specified VMX file by --journal: keys that were set get
+their old value back, removed keys are added again at their old
+lines and added keys are removed. Each key must still have the
+value the change left it with; if the file was changed since,
+nothing is undone. The reverted changes are dropped from the
+journal.`,
+			}},
+			Flags: []string{"--steps"},
+			Examples: []string{
+				"vmxtool undo vm.vmx",
+				"vmxtool undo vm.vmx --steps 3 --dry-run",
+			},
+			Run: runUndo,
+		},
+		{
+			Name: "history",
+			Help: []commandUsage{{
+				Usage: []string{"history FILE"},
+				Description: `Prints the changes recorded in the journal of the specified VMX
+file, oldest first, each numbered by how many undo steps reach
+it, with the command and the keys it set (+ for added and - for
+removed keys).`,
+			}},
+			Examples: []string{
+				"vmxtool history vm.vmx",
+			},
+			Run: runHistory,
+		},
+		{
+			Name: "add",
+			Help: []commandUsage{{
+				Usage: []string{"add [--strict] [--expand-env] FILE KEY=VALUE"},
+				Description: `Adds a new entry to the specified VMX file.
+Fails if the key already exists. --expand-env substitutes
+environment variables in VALUE as set does.`,
+			}},
+			Flags: []string{"--strict", "--expand-env"},
+			Examples: []string{
+				"vmxtool add vm.vmx annotation=\"Build server\"",
+			},
+			Run: runAdd,
+		},
+		{
+			Name: "set",
+			Help: []commandUsage{{
+				Usage: []string{
+					"set [--require-change] [--update-only] [--all-dupes] [--no-validate]",
+					"    [--validate-resources [--strict]] [--expand-env]",
+					"    FILE KEY=VALUE | FILE KEY --value-from PATH | FILE --batch PATH|-",
+				},
+				Description: `Sets an entry in the specified VMX file, adding it if it does
+not already exist. The file is only rewritten if the value
+changes. With --require-change, exits with code 6 if the key
+already held the value. With --update-only, fails instead of
+adding a key that does not exist. A duplicated key only has its
+first occurrence updated, with a warning as VMware uses the
+last; --all-dupes updates every occurrence. Values for guestOS
+are checked against the known identifiers, and values of the
+keys known to explain against their type and range, unless
+--no-validate is given. With --value-from, the value of KEY is
+read from PATH as it is, with newlines, quotes and other
+characters that cannot appear in a value written as |XX escapes
+as VMware does.
+With --batch, KEY=VALUE lines are read from PATH, or from stdin
+for -, skipping blank lines and lines starting with #, and are
+all set in a single save; if any of them fails, nothing is
+changed. A value of several lines is given as a here-doc, a
+KEY<<END line followed by the lines of the value and a line
+holding only END, and is written as with --value-from. A
+here-doc without its END line is an error.
+With --validate-resources, warns if memsize is not a positive
+multiple of 4 or numvcpus is not from 1 to 128; with --strict
+these are errors.
+With --expand-env, ${NAME} in the value, or in the file read with
+--value-from, is replaced by the environment variable NAME and
+${NAME:-DEFAULT} by DEFAULT if NAME is unset or empty; $$ is a
+single $. Quote the value so that the shell does not expand it
+first. Any variable that is unset without a default is an error,
+with all of them listed, and nothing is changed. Without the
+option, values are set as given, $ included.`,
+			}},
+			Flags: []string{"--require-change", "--update-only", "--all-dupes", "--no-validate", "--validate-resources", "--strict", "--expand-env", "--value-from", "--batch"},
+			Examples: []string{
+				"vmxtool set vm.vmx memsize=4096",
+				"vmxtool set --require-change vm.vmx tools.syncTime=FALSE",
+				"vmxtool set --expand-env vm.vmx 'guestinfo.metadata=${METADATA_B64}'",
+				"vmxtool set vm.vmx --batch settings.txt",
+			},
+			Run:     runSet,
+			Prepare: prepareSet,
+		},
+		{
+			Name: "remove",
+			Help: []commandUsage{{
+				Usage: []string{
+					"remove [--strict] FILE KEY",
+					"remove [--strict] FILE --keys-from LISTFILE",
+					"       [--ignore-missing]",
+				},
+				Description: `Removes the entry with the specified key from the specified VMX
+file. Fails if the key does not exist. With --keys-from, removes
+every key listed in LISTFILE (one per line) and reports how many
+were removed. Fails without changing the file if any key is
+missing, unless --ignore-missing is given.
+
+For add, set and remove, changing storage device or virtualHW
+keys of a VM with snapshots prints a warning, or fails with
+--strict.`,
+			}},
+			Flags: []string{"--strict", "--keys-from", "--ignore-missing"},
+			Examples: []string{
+				"vmxtool remove vm.vmx sound.present",
+				"vmxtool remove vm.vmx --keys-from unwanted.txt",
+			},
+			Run:     runRemove,
+			Prepare: prepareRemove,
+		},
+		{
+			Name: "query",
+			Help: []commandUsage{{
+				Usage: []string{
+					"query [--show-absence] [--last] [--fuzzy] [--print0] FILE... KEY",
+					"query [--last] [--fuzzy] FILE KEY --out PATH",
+					"query [--last] [--fuzzy] FILE KEY --bool-exit",
+				},
+				Description: `Prints the value for the specified key from the specified VMX
+file. Fails if the key does not exist, unless --show-absence is
+given, in which case <absent> is printed instead so that a
+missing key can be told apart from an empty value. If the key
+appears more than once, the first value is printed by default;
+with --last, the last value is printed, which is the one VMware
+uses. With several files, each value is printed as FILE: VALUE.
+With --fuzzy, a KEY that is not an exact key may be part of one:
+if exactly one key contains it, that key is used, and if several
+do they are listed and the query fails. With --out, the value is
+written to PATH instead, with |XX escapes such as |0A decoded.
+With --bool-exit, nothing is printed and the exit code gives the
+value as a boolean, for shell conditionals: 0 if it is TRUE (or
+yes, on or 1), 1 if it is FALSE (or no, off or 0) and 2 if the
+key is missing or not a boolean.`,
+			}},
+			Flags: []string{"--show-absence", "--last", "--fuzzy", "--print0", "--out", "--bool-exit"},
+			Examples: []string{
+				"vmxtool query vm.vmx guestOS",
+				"vmxtool query vm.vmx vhv.enable --bool-exit && echo nested",
+			},
+			Run: runQuery,
+		},
+		{
+			Name: "query-prefix",
+			Help: []commandUsage{{
+				Usage: []string{"query-prefix [--reimportable] FILE PREFIX"},
+				Description: `Prints every key in the specified VMX file that starts with
+PREFIX, with its value. Fails if no keys match. With
+--reimportable, prints KEY="VALUE" lines with quotes escaped, so
+each line can be passed to set, e.g. to copy a device to another
+VM.`,
+			}},
+			Flags: []string{"--reimportable"},
+			Examples: []string{
+				"vmxtool query-prefix vm.vmx ethernet0.",
+				"vmxtool query-prefix --reimportable vm.vmx ethernet0.",
+			},
+			Run: runQueryPrefix,
+		},
+		{
+			Name: "keys",
+			Help: []commandUsage{{
+				Usage:       []string{"keys [--print0] FILE"},
+				Description: `Prints each key in the specified VMX file, once, in file order.`,
+			}},
+			Flags: []string{"--print0"},
+			Examples: []string{
+				"vmxtool keys vm.vmx",
+			},
+			Run: runKeys,
+		},
+		{
+			Name: "list",
+			Help: []commandUsage{{
+				Usage: []string{"list [--print0] FILE"},
+				Description: `Prints each key in the specified VMX file with its value.
+
+For query, keys and list, --print0 (or -0) ends each record with
+a NUL byte instead of a newline, like find -print0, so values
+containing newlines can be passed safely to xargs -0.`,
+			}},
+			Flags: []string{"--print0"},
+			Examples: []string{
+				"vmxtool list vm.vmx",
+				"vmxtool list -0 vm.vmx | xargs -0 -n1 echo",
+			},
+			Run: runList,
+		},
+		{
+			Name: "namespaces",
+			Help: []commandUsage{{
+				Usage: []string{"namespaces FILE"},
+				Description: `Prints the number of keys in each top-level namespace of the
+specified VMX file, sorted by count.`,
+			}},
+			Examples: []string{
+				"vmxtool namespaces vm.vmx",
+			},
+			Run: runNamespaces,
+		},
+		{
+			Name: "clone-prep",
+			Help: []commandUsage{{
+				Usage: []string{"clone-prep FILE [--name NEWNAME]"},
+				Description: `Prepares a copied VM to boot cleanly by removing host-specific
+runtime keys and generated MAC addresses, and regenerating
+uuid.bios and uuid.location. With --name, also updates
+displayName and the nvram filename. Prints every change made.
+With the global --dry-run, also prints a diff of the file
+without saving it.`,
+			}},
+			Flags: []string{"--name"},
+			Examples: []string{
+				"vmxtool clone-prep copy.vmx --name Copy",
+				"vmxtool clone-prep copy.vmx --dry-run",
+			},
+			Run: runClonePrep,
+		},
+		{
+			Name: "set-hw-version",
+			Help: []commandUsage{{
+				Usage: []string{"set-hw-version [--force] FILE VERSION"},
+				Description: `Sets the virtual hardware version of the specified VMX file.
+Fails if any enabled keys require a newer version, listing
+them, or if the VM has snapshots, unless --force is given.
+virtualHW.productCompatibility is left unchanged, as it names
+the product family, hosted for Workstation and Fusion or esx for
+ESXi, rather than a version.`,
+			}},
+			Flags: []string{"--force"},
+			Examples: []string{
+				"vmxtool set-hw-version vm.vmx 21",
+			},
+			Run: runSetHWVersion,
+		},
+		{
+			Name: "merge",
+			Help: []commandUsage{{
+				Usage: []string{"merge [--append-new] BASE OVERLAY"},
+				Description: `Applies every entry in the OVERLAY file to the BASE VMX file.
+Existing keys are updated in place. By default, new keys are
+placed after the last key in BASE with the same top-level
+namespace (e.g. ethernet0), or at the end if there is none.
+With --append-new, all new keys are added at the end.`,
+			}},
+			Flags: []string{"--append-new"},
+			Examples: []string{
+				"vmxtool merge vm.vmx overrides.vmx",
+			},
+			Run: runMerge,
+		},
+		{
+			Name: "guestos",
+			Help: []commandUsage{{
+				Usage: []string{"guestos list [FILTER]"},
+				Description: `Prints the known guestOS identifiers, optionally only those
+whose identifier or description contains FILTER.`,
+			}},
+			Subcommands: []string{"list"},
+			Examples: []string{
+				"vmxtool guestos list",
+				"vmxtool guestos list ubuntu",
+			},
+			Run: runGuestOS,
+		},
+		{
+			Name: "resources",
+			Help: []commandUsage{{
+				Usage: []string{"resources FILE [--memory SIZE] [--cpus N] [--cores-per-socket N]"},
+				Description: `Sets the memory and CPU configuration of the specified VMX file.
+SIZE is in MB, or may have an M or G suffix (e.g. 8G). Memory
+must be a multiple of 4 MB and the CPU count must be divisible
+by the cores per socket. Warns if memory exceeds the maximum for
+the hardware version. Without options, prints the current
+settings.`,
+			}},
+			Flags: []string{"--memory", "--cpus", "--cores-per-socket"},
+			Examples: []string{
+				"vmxtool resources vm.vmx",
+				"vmxtool resources vm.vmx --memory 8G --cpus 4",
+			},
+			Run: runResources,
+		},
+		{
+			Name: "vtpm",
+			Help: []commandUsage{{
+				Usage: []string{"vtpm FILE [on|off]"},
+				Description: `Adds or removes a virtual TPM. Turning it on requires hardware
+version 14 or later and EFI firmware, and the VM must still be
+encrypted by VMware before it can power on. Turning it off also
+removes related vTPM keys. Without on or off, prints the current
+state.`,
+			}},
+			Examples: []string{
+				"vmxtool vtpm vm.vmx",
+				"vmxtool vtpm vm.vmx on",
+			},
+			Run: runVTPM,
+		},
+		{
+			Name: "nested",
+			Help: []commandUsage{{
+				Usage: []string{"nested FILE [on|off] [--fix]"},
+				Description: `Enables or disables nested virtualization (vhv.enable). When
+enabling, warns about keys that conflict with nested
+virtualization, or removes them with --fix. Without on or off,
+prints the current state, conflicts and monitor overrides.`,
+			}},
+			Flags: []string{"--fix"},
+			Examples: []string{
+				"vmxtool nested vm.vmx on",
+				"vmxtool nested vm.vmx --fix",
+			},
+			Run: runNested,
+		},
+		{
+			Name: "graphics",
+			Help: []commandUsage{{
+				Usage: []string{"graphics FILE [--3d on|off] [--vram SIZE] [--gfx-memory SIZE]"},
+				Description: `Configures guest graphics. --3d sets mks.enable3d, --vram sets
+svga.vramSize (in bytes, at most 128 MB) and turns off
+svga.autodetect, and --gfx-memory sets svga.graphicsMemoryKB (in
+KB, at most 8 GB). SIZE is in MB, or may have a K, M or G
+suffix. Without options, prints the current settings.`,
+			}},
+			Flags: []string{"--3d", "--vram", "--gfx-memory"},
+			Examples: []string{
+				"vmxtool graphics vm.vmx",
+				"vmxtool graphics vm.vmx --3d on --gfx-memory 1G",
+			},
+			Run: runGraphics,
+		},
+		{
+			Name: "diff",
+			Help: []commandUsage{{
+				Usage: []string{"diff [--color always|never|auto] FILE1 FILE2"},
+				Description: `Compares the keys of two VMX files, ignoring layout and comments.
+Prints removed keys with -, added keys with + and changed values
+with ~. Output is coloured when stdout is a terminal, unless
+NO_COLOR is set or --color says otherwise. Exits with code 1 if
+the files differ.`,
+			}},
+			Flags: []string{"--color"},
+			Examples: []string{
+				"vmxtool diff old.vmx new.vmx",
+			},
+			Run: runDiff,
+		},
+		{
+			Name: "vnc",
+			Help: []commandUsage{{
+				Usage: []string{
+					"vnc FILE on [--port PORT] [--password-from-stdin|--no-password]",
+					"vnc FILE off",
+					"vnc FILE",
+				},
+				Description: `Enables or disables the built-in VNC server. The port defaults
+to 5900 and a warning is shown outside 5900-5999. A password is
+required unless --no-password is given; --password-from-stdin
+reads it from stdin so it stays out of the shell history.
+Turning VNC off also removes the password. Without on or off,
+prints the current settings with the password masked.`,
+			}},
+			Flags: []string{"--port", "--password-from-stdin", "--no-password"},
+			Examples: []string{
+				"vmxtool vnc vm.vmx on --port 5901 --password-from-stdin < password.txt",
+				"vmxtool vnc vm.vmx off",
+			},
+			Run: runVNC,
+		},
+		{
+			Name: "sort",
+			Help: []commandUsage{{
+				Usage: []string{"sort FILE"},
+				Description: `Sorts the entries of the specified VMX file alphabetically by
+key. Lines before the first key, such as a header comment, stay
+at the top. Other comments move with the key that follows them
+and blank lines between entries are removed.`,
+			}},
+			Examples: []string{
+				"vmxtool sort vm.vmx",
+			},
+			Run: runSort,
+		},
+		{
+			Name: "shared-folder",
+			Help: []commandUsage{{
+				Usage: []string{
+					"shared-folder add FILE --name NAME --host-path PATH [--read-only]",
+					"              [--disabled]",
+					"shared-folder remove FILE NAME",
+					"shared-folder list FILE",
+				},
+				Description: `Manages HGFS shared folders. Add creates the next
+sharedFolderN.* entry, updates sharedFolder.maxNum and enables
+shared folders with isolation.tools.hgfs.disable. Remove deletes
+the named folder and renumbers the remaining folders. List
+prints a table of the shared folders.`,
+			}},
+			Subcommands: []string{"add", "remove", "list"},
+			Flags:       []string{"--name", "--host-path", "--read-only", "--disabled"},
+			Examples: []string{
+				"vmxtool shared-folder add vm.vmx --name src --host-path /home/me/src --read-only",
+				"vmxtool shared-folder list vm.vmx",
+			},
+			Run: runSharedFolder,
+		},
+		{
+			Name: "serial",
+			Help: []commandUsage{{
+				Usage: []string{
+					"serial add FILE --backend TYPE:TARGET [--index N]",
+					"           [--endpoint client|server] [--yield-on-msr-read=false]",
+					"serial remove FILE N",
+					"serial list FILE",
+				},
+				Description: `Manages serial ports. The backend is one of file:PATH,
+pipe:NAME (e.g. pipe:\\.\pipe\vmserial) or network:URI (e.g.
+network:telnet://:2001). Add uses the first free port unless
+--index is given and writes the keys for the backend under a
+comment marking them as added by vmxtool. --endpoint sets the
+pipe or network end point (default server) and
+yieldOnMsrRead is on by default. Remove deletes serialN and
+list prints a table of the serial ports.`,
+			}},
+			Subcommands: []string{"add", "remove", "list"},
+			Flags:       []string{"--backend", "--index", "--endpoint", "--yield-on-msr-read"},
+			Examples: []string{
+				"vmxtool serial add vm.vmx --backend file:serial.log",
+				"vmxtool serial list vm.vmx",
+			},
+			Run: runSerial,
+		},
+		{
+			Name: "usb",
+			Help: []commandUsage{{
+				Usage: []string{
+					"usb FILE [--version 2|3.1] [--autoconnect on|off]",
+					"usb FILE off",
+				},
+				Description: `Configures the USB controllers. Version 2 enables the UHCI and
+EHCI controllers and removes the xHCI controller, while 3.1
+enables all three, warning if the hardware version is too old
+for xHCI. --autoconnect sets usb.generic.autoconnect. Off
+disables every USB controller. Without options, prints the
+current settings.`,
+			}},
+			Flags: []string{"--version", "--autoconnect"},
+			Examples: []string{
+				"vmxtool usb vm.vmx --version 3.1",
+				"vmxtool usb vm.vmx off",
+			},
+			Run: runUSB,
+		},
+		{
+			Name: "disable",
+			Help: []commandUsage{{
+				Usage: []string{"disable [--disable-marker STRING] FILE KEY"},
+				Description: `Comments out the entry with the specified key, leaving it in
+place as # KEY = "VALUE". Fails if the key does not exist.
+--disable-marker uses another marker starting with #, such as #!,
+to tell keys disabled by vmxtool apart from other comments.`,
+			}},
+			Flags: []string{"--disable-marker"},
+			Examples: []string{
+				"vmxtool disable vm.vmx sound.present",
+			},
+			Run: func(args []string) int { return runDisableEnable("disable", args) },
+		},
+		{
+			Name: "enable",
+			Help: []commandUsage{{
+				Usage: []string{"enable [--disable-marker STRING] FILE KEY"},
+				Description: `Reactivates an entry commented out by disable with the same
+marker. Fails if there is no commented out entry for the key or
+the key is already set.`,
+			}},
+			Flags: []string{"--disable-marker"},
+			Examples: []string{
+				"vmxtool enable vm.vmx sound.present",
+			},
+			Run: func(args []string) int { return runDisableEnable("enable", args) },
+		},
+		{
+			Name: "isolation",
+			Help: []commandUsage{{
+				Usage: []string{
+					"isolation FILE [--copy on|off] [--paste on|off] [--dnd on|off]",
+					"          [--disk-ops on|off] [--gui-options on|off] [--hostinfo on|off]",
+					"          [--lockdown]",
+				},
+				Description: `Configures guest isolation and VMware Tools features. On and off
+describe the feature, so --copy off writes
+isolation.tools.copy.disable = "TRUE". --disk-ops covers disk
+shrinking and wiping, --gui-options sets
+isolation.tools.setGUIOptions.enable, which lets the guest change
+the copy and paste options of the console, and --hostinfo sets
+tools.guestlib.enableHostInfo; these two keys are not inverted,
+so off writes "FALSE". --lockdown turns every feature off; other
+options given with it take precedence. Without options, prints
+the effective state of each feature.`,
+			}},
+			Flags: []string{"--copy", "--paste", "--dnd", "--disk-ops", "--gui-options", "--hostinfo", "--lockdown"},
+			Examples: []string{
+				"vmxtool isolation vm.vmx --copy off --paste off",
+				"vmxtool isolation vm.vmx --lockdown",
+			},
+			Run: runIsolation,
+		},
+		{
+			Name: "timesync",
+			Help: []commandUsage{{
+				Usage: []string{"timesync FILE [on|off|status]"},
+				Description: `Controls host to guest time synchronization. Off sets
+tools.syncTime and the five time.synchronize.* keys to FALSE.
+On removes them so VMware's defaults apply. Status, the default,
+prints each key and the effective state, warning when only some
+of the keys are set.`,
+			}},
+			Examples: []string{
+				"vmxtool timesync vm.vmx off",
+			},
+			Run: runTimeSync,
+		},
+		{
+			Name: "macos-prep",
+			Help: []commandUsage{{
+				Usage: []string{"macos-prep FILE [--model MODEL] [--serial auto|VALUE] [--check]"},
+				Description: `Applies the keys a macOS guest needs on an unlocked host: SMC,
+ICH7-M, EFI firmware, SMBIOS values taken from the VMX file
+rather than the host, and USB keyboard and mouse. --model sets
+hw.model and, for known models, board-id. --serial sets
+serialNumber; auto generates a random serial in the Apple format,
+marked with a comment as synthetic. --check lists missing or
+wrong keys without changing the file and exits with code 1 if
+any are found.`,
+			}},
+			Flags: []string{"--model", "--serial", "--check"},
+			Examples: []string{
+				"vmxtool macos-prep vm.vmx --check",
+				"vmxtool macos-prep vm.vmx --serial auto",
+			},
+			Run: runMacOSPrep,
+		},
+		{
+			Name: "mitigations",
+			Help: []commandUsage{{
+				Usage: []string{"mitigations FILE [on|off|status] [--key KEY]..."},
+				Description: `Controls the guest's side-channel mitigations. Off sets the known
+mitigation keys, such as ulm.disableMitigations, to TRUE and
+warns about the security trade-off. On removes them so the
+mitigations are enabled by default. Each change reports the
+products that use the key. Status, the default, shows each key
+and whether the mitigations are on by default or explicitly.
+Use --key to manage other keys instead, for newer VMware versions.`,
+			}},
+			Flags: []string{"--key"},
+			Examples: []string{
+				"vmxtool mitigations vm.vmx",
+				"vmxtool mitigations vm.vmx off",
+			},
+			Run: runMitigations,
+		},
+		{
+			Name: "logging",
+			Help: []commandUsage{{
+				Usage: []string{
+					"logging FILE [--enable|--disable] [--file PATH] [--rotate-size SIZE]",
+					"    [--keep N] [--verbose]",
+				},
+				Description: `Configures virtual machine logging. --file sets log.fileName and
+warns if its directory, relative to the VMX file, does not exist.
+--rotate-size accepts units such as 2M and --keep sets how many
+old logs are kept. --verbose adds debug logging keys, which slow
+the VM down; --verbose=false removes them. Without options,
+prints the current settings.`,
+			}},
+			Flags: []string{"--enable", "--disable", "--file", "--rotate-size", "--keep", "--verbose"},
+			Examples: []string{
+				"vmxtool logging vm.vmx --rotate-size 10M",
+				"vmxtool logging vm.vmx --disable",
+			},
+			Run: runLogging,
+		},
+		{
+			Name: "harden",
+			Help: []commandUsage{{
+				Usage: []string{
+					"harden FILE [--profile baseline|strict | --profile-file PATH] [--check]",
+					"harden --export [--profile baseline|strict | --profile-file PATH]",
+				},
+				Description: `Applies the settings recommended by the VMware security
+configuration guide, such as isolation.tools.* disables and
+RemoteDisplay.maxConnections = 1. The baseline profile is used by
+default; strict also disables features not exposed in the user
+interface. --check reports PASS or FAIL for each key without
+changing the file and exits with code 1 if any fail. --export
+prints the profile as JSON for review; an edited copy can be
+used with --profile-file. A custom profile can set "extends" to
+a built-in profile name to add to or override its keys.`,
+			}},
+			Flags: []string{"--profile", "--profile-file", "--check", "--export"},
+			Examples: []string{
+				"vmxtool harden vm.vmx --check",
+				"vmxtool harden vm.vmx --profile strict",
+			},
+			Run: runHarden,
+		},
+		{
+			Name: "clean",
+			Help: []commandUsage{{
+				Usage: []string{
+					"clean FILE [--aggressive]",
+					"clean --list",
+				},
+				Description: `Removes runtime keys that VMware recreates when needed, such as
+checkpoint.vmState and sched.swap.derivedName, printing each key
+removed. Keys that name a file are kept while the file exists.
+--aggressive also removes host-specific keys such as PCI slot
+numbers and vmci0.id. --list prints the keys that are removed.`,
+			}},
+			Flags: []string{"--aggressive", "--list"},
+			Examples: []string{
+				"vmxtool clean vm.vmx --dry-run",
+				"vmxtool clean --list",
+			},
+			Run: runClean,
+		},
+		{
+			Name: "portable",
+			Help: []commandUsage{{
+				Usage: []string{"portable FILE [--fix]"},
+				Description: `Reports settings that bind the VM to this host and would cause
+"device not found" prompts elsewhere: ISO images and disks on
+absolute paths, host CD drives, host-only or bridged-to-adapter
+networks, automatically connected USB devices, serial and
+parallel ports on host devices or paths, and shared folders, each
+with a suggested fix. --fix detaches ISOs, switches those network
+adapters to NAT and removes host device passthroughs, then lists
+anything it could not safely change. Exits with code 1 if any
+issue remains.`,
+			}},
+			Flags: []string{"--fix"},
+			Examples: []string{
+				"vmxtool portable vm.vmx",
+				"vmxtool portable vm.vmx --fix",
+			},
+			Run: runPortable,
+		},
+		{
+			Name: "relocate",
+			Help: []commandUsage{{
+				Usage: []string{"relocate FILE [--from OLD --to NEW] [--to-relative]"},
+				Description: `Rewrites file paths after a VM has moved. Every *.fileName key,
+including log.fileName, and nvram starting with the directory
+OLD is changed to start with NEW instead. Paths are matched with
+either slash style, so files written on Windows work too. With
+--to-relative, paths under the VMX file's directory are made
+relative. Prints each key with its old and new path and warns
+about new paths that do not exist. With the global --dry-run,
+also prints a diff of the file without saving it.`,
+			}},
+			Flags: []string{"--from", "--to", "--to-relative"},
+			Examples: []string{
+				"vmxtool relocate vm.vmx --from /old/vms --to /new/vms",
+				"vmxtool relocate vm.vmx --to-relative --dry-run",
+			},
+			Run: runRelocate,
+		},
+		{
+			Name: "check",
+			Help: []commandUsage{{
+				Usage: []string{"check FILE [--json] [--fix-detach]"},
+				Description: `Checks that the files the VM refers to exist and can be read:
+disks, CD-ROM and floppy images, nvram, serial port output files
+and shared folders. Relative paths are resolved against the VMX
+file's directory. Prints OK, MISSING, UNREADABLE or UNRESOLVABLE
+(a path for another OS, such as a drive letter on Linux) for each
+reference, and exits with code 1 if a required file is missing.
+Images on devices that are not connected at power on and nvram,
+which VMware creates, are only warnings. --json prints the report
+as JSON. --fix-detach detaches missing ISO images.`,
+			}},
+			Flags: []string{"--json", "--fix-detach"},
+			Examples: []string{
+				"vmxtool check vm.vmx",
+				"vmxtool check vm.vmx --json",
+			},
+			Run: runCheck,
+		},
+		{
+			Name: "check-deps",
+			Help: []commandUsage{{
+				Usage: []string{
+					"check-deps FILE [--disable RULE[,RULE...]]",
+					"check-deps --list",
+				},
+				Description: `Reports keys that appear without the companion keys they need,
+such as scsi0:0.fileName without scsi0:0.present, or a present
+SCSI disk without scsi0.present = "TRUE". Exits with code 1 if
+any are found. --disable skips the named rules and --list prints
+the rules.`,
+			}},
+			Flags: []string{"--disable", "--list"},
+			Examples: []string{
+				"vmxtool check-deps vm.vmx",
+				"vmxtool check-deps --list",
+			},
+			Run: runCheckDeps,
+		},
+		{
+			Name: "snapshots",
+			Help: []commandUsage{{
+				Usage: []string{"snapshots FILE"},
+				Description: `Lists the snapshots recorded in the .vmsd file next to the
+specified VMX file, with each snapshot's UID and its chain of
+parent snapshots, marking the current one.`,
+			}},
+			Examples: []string{
+				"vmxtool snapshots vm.vmx",
+			},
+			Run: runSnapshots,
+		},
+		{
+			Name: "disk-chain",
+			Help: []commandUsage{{
+				Usage: []string{"disk-chain FILE"},
+				Description: `Follows the chain of each VMDK disk in the specified VMX file
+from the current disk to its base disk, using the
+parentFileNameHint in each descriptor. Prints the chain and
+reports a break where a parent does not exist or its CID does
+not match the child's parentCID, exiting with code 1 if any
+chain is broken. Text descriptors and sparse disks with an
+embedded descriptor are supported.`,
+			}},
+			Examples: []string{
+				"vmxtool disk-chain vm.vmx",
+			},
+			Run: runDiskChain,
+		},
+		{
+			Name: "guestinfo",
+			Help: []commandUsage{{
+				Usage: []string{"guestinfo set FILE NAME --from FILE2 [--base64] [--gzip]"},
+				Description: `Sets guestinfo.NAME to the contents of FILE2, or standard input
+if FILE2 is -, for the guest to read with vmware-rpctool.
+--base64 encodes the payload and --gzip compresses and encodes
+it, setting guestinfo.NAME.encoding to base64 or gzip+base64 as
+cloud-init expects. Without either, the payload is written as it
+is, less a final newline, and must be a single line. Warns when
+the value exceeds tools.setinfo.sizeLimit.`,
+			}, {
+				Usage: []string{"guestinfo get FILE NAME [--decode]"},
+				Description: `Prints guestinfo.NAME. --decode reverses the encoding given by
+guestinfo.NAME.encoding and writes the original payload.`,
+			}, {
+				Usage:       []string{"guestinfo list FILE"},
+				Description: `Lists the guestinfo keys with the size and encoding of each.`,
+			}},
+			Subcommands: []string{"set", "get", "list"},
+			Flags:       []string{"--from", "--base64", "--gzip", "--decode"},
+			Examples: []string{
+				"vmxtool guestinfo set vm.vmx userdata --from user-data.yaml --gzip",
+				"vmxtool guestinfo get vm.vmx userdata --decode",
+			},
+			Run: runGuestinfo,
+		},
+		{
+			Name: "append",
+			Help: []commandUsage{{
+				Usage: []string{"append FILE KEY TOKEN"},
+				Description: `Adds TOKEN to the space-separated list in the value of KEY, if it
+is not already there. A missing or empty KEY is set to TOKEN.`,
+			}},
+			Examples: []string{
+				"vmxtool append vm.vmx custom.tags production",
+			},
+			Run: func(args []string) int { return runAppend("append", args) },
+		},
+		{
+			Name: "unappend",
+			Help: []commandUsage{{
+				Usage:       []string{"unappend FILE KEY TOKEN"},
+				Description: `Removes TOKEN from the space-separated list in the value of KEY.`,
+			}},
+			Examples: []string{
+				"vmxtool unappend vm.vmx custom.tags production",
+			},
+			Run: func(args []string) int { return runAppend("unappend", args) },
+		},
+		{
+			Name: "autoanswer",
+			Help: []commandUsage{{
+				Usage: []string{"autoanswer FILE [on|off|status]"},
+				Description: `Sets msg.autoAnswer so that VMware answers its power-on questions,
+such as whether the VM was moved or copied, with their default
+choice instead of waiting, or removes it. With only FILE or
+status, reports msg.autoAnswer and uuid.action.`,
+			}},
+			Examples: []string{
+				"vmxtool autoanswer vm.vmx on",
+			},
+			Run: runAutoAnswer,
+		},
+		{
+			Name: "uuid-action",
+			Help: []commandUsage{{
+				Usage: []string{"uuid-action FILE [keep|create|prompt|status]"},
+				Description: `Sets uuid.action, which decides what happens at the next power-on
+of a VM that was moved or copied. keep keeps the UUID and MAC
+addresses, create generates new ones and prompt removes the key
+so that VMware asks. With only FILE or status, reports
+msg.autoAnswer and uuid.action.`,
+			}},
+			Examples: []string{
+				"vmxtool uuid-action vm.vmx keep",
+			},
+			Run: runUUIDAction,
+		},
+		{
+			Name: "lint",
+			Help: []commandUsage{{
+				Usage: []string{
+					"lint FILE [--disable RULE[,RULE...]] [--fail-on-empty]",
+					"    [--allow-empty KEY[,KEY...]] [--json]",
+					"lint --list",
+				},
+				Description: `Checks the specified VMX file for combinations of settings that
+conflict or make no sense together, such as nested
+virtualization with a 32-bit guestOS, EFI firmware for a guestOS
+without EFI support or too little memory for the guestOS. Each
+finding is an error or a warning and names the keys involved.
+--disable skips rules, --json prints the findings as JSON and
+--list lists the rules. Exits with code 1 if any error is found.
+Keys with empty values are warnings, or errors with
+--fail-on-empty, except for keys that are often empty, such as
+the image of an empty CD-ROM drive; --allow-empty accepts an
+empty value for more keys, which may contain * wildcards.`,
+			}},
+			Flags: []string{"--disable", "--fail-on-empty", "--allow-empty", "--json", "--list"},
+			Examples: []string{
+				"vmxtool lint vm.vmx",
+				"vmxtool lint vm.vmx --disable hgfs-isolated --json",
+			},
+			Run: runLint,
+		},
+		{
+			Name: "normalize-keys",
+			Help: []commandUsage{{
+				Usage: []string{"normalize-keys FILE [--style first-seen|lower]"},
+				Description: `Gives keys that share a prefix, compared ignoring case, the same
+casing for that prefix, so that Ethernet0.present and
+ethernet0.virtualDev both start with Ethernet0. The casing of
+the first key seen is used, or lower case with --style lower.`,
+			}},
+			Flags: []string{"--style"},
+			Examples: []string{
+				"vmxtool normalize-keys vm.vmx --dry-run",
+				"vmxtool normalize-keys vm.vmx --style lower",
+			},
+			Run: runNormalizeKeys,
+		},
+		{
+			Name: "explain",
+			Help: []commandUsage{{
+				Usage: []string{"explain [FILE] KEY"},
+				Description: `Describes a known key: what it does, the values it takes, its
+default and the hardware version it needs. With FILE, also shows
+the current value in the specified VMX file. A KEY ending in .,
+such as isolation.tools., or matching no key exactly lists the
+known keys under it. The same key table is used to validate set
+and by lint.`,
+			}},
+			Examples: []string{
+				"vmxtool explain firmware",
+				"vmxtool explain vm.vmx ethernet0.virtualDev",
+			},
+			Run: runExplain,
+		},
+		{
+			Name: "enforce",
+			Help: []commandUsage{{
+				Usage: []string{"enforce FILE POLICY [--fix]"},
+				Description: `Checks the specified VMX file against a JSON policy file of
+rules, each with an id, a key that may contain * wildcards and
+constraints: required (some matching key must exist), forbidden
+(no matching key may exist), equals (every matching key must have
+the value; a key without wildcards must be set), regex (every
+matching value must match), min and max (every matching value
+must be a number in the range) and values (every matching value
+must be one of a list). The severity of a rule is error, the
+default, or warning. Each violation is reported with its rule,
+key, expected and actual value, and the command exits with code 1
+if any error remains. --fix sets keys to satisfy equals rules and
+removes keys that are forbidden. See sample-policy.json.`,
+			}},
+			Flags: []string{"--fix"},
+			Examples: []string{
+				"vmxtool enforce vm.vmx policy.json",
+				"vmxtool enforce vm.vmx policy.json --fix",
+			},
+			Run: runEnforce,
+		},
+		{
+			Name: "profile",
+			Help: []commandUsage{{
+				Usage: []string{
+					"profile apply FILE NAME [--profile-dir DIR] [--overwrite]",
+					"    [--expand-env]",
+				},
+				Description: `Merges the keys of a named profile into the specified VMX file
+and adds a comment recording that the profile was applied.
+Profiles are files in the VMX format named NAME.profile; several
+are built in and --profile-dir adds those in DIR, which replace
+built-in profiles of the same name. Keys that already have a
+different value are reported and nothing is changed, unless
+--overwrite is given. --expand-env substitutes ${NAME} in the
+profile's values as set --expand-env does, before comparing
+them.`,
+			}, {
+				Usage: []string{"profile list [--profile-dir DIR]"},
+				Description: `Lists the profiles with their source and description, taken from
+the first comment line of the profile.`,
+			}, {
+				Usage:       []string{"profile show NAME [--profile-dir DIR]"},
+				Description: `Prints the keys of a profile.`,
+			}},
+			Subcommands: []string{"apply", "list", "show"},
+			Flags:       []string{"--profile-dir", "--overwrite", "--expand-env"},
+			Examples: []string{
+				"vmxtool profile list",
+				"vmxtool profile apply vm.vmx ci-runner",
+			},
+			Run: runProfile,
+		},
+		{
+			Name: "convert-controller",
+			Help: []commandUsage{{
+				Usage: []string{
+					"convert-controller FILE --from CONTROLLER --to CONTROLLER",
+				},
+				Description: `Moves every device of one storage controller to another, for
+example from scsi0 to nvme0, renaming scsi0:N.* keys to
+nvme0:N.*, removing the keys of the old controller, setting
+the new controller present and updating the boot order. Fails if
+the target already has a device of the same number, cannot hold a
+device, such as a CD-ROM drive on NVMe, or needs a newer hardware
+version. Each rename is listed; the guest OS must have a driver
+for the new controller to boot.`,
+			}},
+			Flags: []string{"--from", "--to"},
+			Examples: []string{
+				"vmxtool convert-controller vm.vmx --from ide0 --to sata0",
+			},
+			Run: runConvertController,
+		},
+		{
+			Name: "topology",
+			Help: []commandUsage{{
+				Usage: []string{"topology FILE [--sockets N --cores N]"},
+				Description: `Prints the virtual CPU layout of the specified VMX file as
+sockets x cores, with any NUMA and CPU affinity keys, warning
+about NUMA node sizes or affinity lists that do not fit the
+layout. With --sockets and --cores, sets numvcpus and
+cpuid.coresPerSocket together. set also checks that
+cpuid.coresPerSocket divides numvcpus when either is written,
+unless --no-validate is given.`,
+			}},
+			Flags: []string{"--sockets", "--cores"},
+			Examples: []string{
+				"vmxtool topology vm.vmx",
+				"vmxtool topology vm.vmx --sockets 2 --cores 4",
+			},
+			Run: runTopology,
+		},
+		{
+			Name: "info",
+			Help: []commandUsage{{
+				Usage: []string{"info FILE"},
+				Description: `Prints an overview of the VM in the specified VMX file: its name,
+guest OS, hardware version, firmware, memory and CPUs, then its
+network adapters with their type, network and MAC address, and
+its disks and CD/DVD drives with their backing files and, where
+the VMDK descriptor can be read, the disk size. Devices whose
+present key is FALSE and settings that are not set are omitted.`,
+			}},
+			Examples: []string{
+				"vmxtool info vm.vmx",
+			},
+			Run: runInfo,
+		},
+		{
+			Name: "find",
+			Help: []commandUsage{{
+				Usage: []string{"find DIR [--key KEY[=VALUE] | --missing KEY] [--json] [--follow-symlinks]"},
+				Description: `Walks DIR for .vmx files and lists them. With --key, lists the
+files that set KEY, with its value, or with KEY=VALUE the files
+where it has that value. With --missing, lists the files that do
+not set KEY. --json prints the files, with the values, as a JSON
+array. Files that cannot be read are reported on stderr and the
+walk continues. Symbolic links are not followed unless
+--follow-symlinks is given.`,
+			}},
+			Flags: []string{"--key", "--missing", "--json", "--follow-symlinks"},
+			Examples: []string{
+				"vmxtool find /vms --key vhv.enable=TRUE",
+				"vmxtool find /vms --missing tools.syncTime --json",
+			},
+			Run: runFind,
+		},
+		{
+			Name: "watch",
+			Help: []commandUsage{{
+				Usage: []string{"watch FILE [--key KEY] [--interval DURATION] [--exec CMD]"},
+				Description: `Prints the specified VMX file, then prints it again with a
+timestamp each time it changes, until interrupted with Ctrl-C.
+With --key, prints only the value of KEY, and only when it
+changes. The file is checked every --interval, 1s by default,
+and a change is reported once the file has stayed unchanged for
+an interval, so a burst of writes is reported once. Files that
+VMware replaces by renaming a new file over them are followed.
+--exec runs CMD with the shell after each change printed, with
+the VMXTOOL_FILE environment variable set to FILE.`,
+			}},
+			Flags: []string{"--key", "--interval", "--exec"},
+			Examples: []string{
+				"vmxtool watch vm.vmx",
+				"vmxtool watch vm.vmx --key uuid.bios --exec 'notify-send changed'",
+			},
+			Run: runWatch,
+		},
+		{
+			Name: "comments",
+			Help: []commandUsage{{
+				Usage: []string{"comments [--inline] [--trim] FILE"},
+				Description: `Prints the comment lines of the specified VMX file, each with its
+line number, leaving out the settings. With --inline, also prints
+the comments at the end of key lines, after their key. Comments
+are printed exactly as in the file unless --trim is given, which
+removes leading and trailing whitespace.`,
+			}},
+			Flags: []string{"--inline", "--trim"},
+			Examples: []string{
+				"vmxtool comments vm.vmx",
+				"vmxtool comments --inline --trim vm.vmx",
+			},
+			Run: runComments,
+		},
+		{
+			Name: "serve",
+			Help: []commandUsage{{
+				Usage: []string{"serve --root DIR [--listen ADDRESS] [--token SECRET]"},
+				Description: `Serves the files under DIR over HTTP on ADDRESS, 127.0.0.1:8080
+by default, until interrupted with Ctrl-C:
+    GET    /files/PATH           every key and value as JSON
+    GET    /files/PATH/keys/KEY  the value of KEY
+    PUT    /files/PATH/keys/KEY  sets KEY from {"value": "..."}
+    DELETE /files/PATH/keys/KEY  removes KEY
+Paths outside DIR, including through symbolic links, are
+refused, and only .vmx files can be changed. Values are
+checked as set checks them; a change that could break
+snapshots, to a file changed since it was loaded or to the
+file of a running VM is refused with 409 Conflict. With
+--token, or the VMXTOOL_TOKEN environment variable, every
+request must send the header Authorization: Bearer SECRET.`,
+			}},
+			Flags: []string{"--root", "--listen", "--token"},
+			Examples: []string{
+				"vmxtool serve --root /vms --token secret",
+				"curl -H 'Authorization: Bearer secret' http://127.0.0.1:8080/files/web/web.vmx/keys/memsize",
+			},
+			Run: runServe,
+		},
+		{
+			Name: "completion",
+			Help: []commandUsage{{
+				Usage: []string{"completion bash|zsh|fish"},
+				Description: `Prints a completion script for the shell, which completes
+commands, subcommands and flags, and keys from the file named
+earlier on the command line. For example, add
+source <(vmxtool completion bash) to ~/.bashrc.`,
+			}},
+			Examples: []string{
+				"source <(vmxtool completion bash)",
+				"vmxtool completion fish > ~/.config/fish/completions/vmxtool.fish",
+			},
+			Run: runCompletion,
+		},
+		{
+			Name: "man",
+			Help: []commandUsage{{
+				Usage: []string{"man"},
+				Description: `Prints the vmxtool(1) man page in roff format, generated from
+this help, for packaging.`,
+			}},
+			Examples: []string{
+				"vmxtool man > vmxtool.1",
+				"vmxtool man | man -l -",
+			},
+			Run: runMan,
+		},
+		{
+			Name: "replace-value",
+			Help: []commandUsage{{
+				Usage: []string{"replace-value [--ignore-case] FILE OLDVALUE NEWVALUE"},
+				Description: `Sets every key in the specified VMX file whose value is exactly
+OLDVALUE to NEWVALUE, whatever the key, for example to move from
+an old datastore, and reports each key and the count. Values
+that only contain OLDVALUE are not changed. --ignore-case
+matches OLDVALUE regardless of case.`,
+			}},
+			Flags: []string{"--ignore-case"},
+			Examples: []string{
+				"vmxtool replace-value vm.vmx /vmfs/volumes/5f1a-old /vmfs/volumes/6b2c-new",
+				"vmxtool replace-value --ignore-case --dry-run vm.vmx bridged nat",
+			},
+			Run: runReplaceValue,
+		},
+		{
+			Name: "config",
+			Help: []commandUsage{{
+				Usage: []string{"config show"},
+				Description: `Prints the config file used and the value of every global option,
+with where it was set: the command line, the environment, the
+config file or the default.`,
+			}},
+			Subcommands: []string{"show"},
+			Examples: []string{
+				"vmxtool config show",
+				"VMXTOOL_CONFIG=ci.toml vmxtool config show",
+			},
+			Run: runConfig,
+		},
+		{
+			Name:   "roundtrip",
+			Run:    runRoundTrip,
+			Hidden: true,
+		},
+		{
+			Name:   "dump",
+			Run:    runDump,
+			Hidden: true,
+		},
+		{
+			Name:   "__complete",
+			Run:    runComplete,
+			Hidden: true,
+		},
+	}
+}
+
+// findCommand returns the command with the given name, or nil
+func findCommand(name string) *command {
+	for _, command := range commands {
+		if command.Name == name {
+			return command
+		}
+	}
+	return nil
+}
+
+// visibleCommands returns the commands help, man and completion show
+func visibleCommands() []*command {
+	var visible []*command
+	for _, command := range commands {
+		if !command.Hidden {
+			visible = append(visible, command)
+		}
+	}
+	return visible
+}
+
+// descriptionLines returns the lines of the description
+func (u commandUsage) descriptionLines() []string {
+	return strings.Split(u.Description, "\n")
+}
+
+// joinedUsage returns the usage lines with each continuation line joined
+// to the line it continues
+func (u commandUsage) joinedUsage() []string {
+	var lines []string
+	for _, line := range u.Usage {
+		if strings.HasPrefix(line, " ") && len(lines) > 0 {
+			lines[len(lines)-1] += " " + strings.TrimSpace(line)
+		} else {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// helpSection returns the lines of the option help from the heading to the
+// next heading
+func helpSection(heading string) []string {
+	text := optionsHelpText[strings.Index("\n"+optionsHelpText, "\n"+heading+"\n")+len(heading)+1:]
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line != "" && line[0] != ' ' {
+			break
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// completeWords returns the completions for the last of words, the
+// arguments typed after vmxtool. KEY arguments are completed from the
+// first existing file named after the command. Nothing is returned when
+// the shell should complete file names instead.
+func completeWords(words []string) []string {
+	if len(words) == 0 {
+		return nil
+	}
+	current := words[len(words)-1]
+	var previous []string
+	for _, word := range words[:len(words)-1] {
+		if !strings.HasPrefix(word, "-") {
+			previous = append(previous, word)
+		}
+	}
+
+	var candidates []string
+	globalFlags := func() {
+		newGlobalFlagSet().VisitAll(func(f *flag.Flag) {
+			if shortFlags[f.Name] == "" {
+				candidates = append(candidates, "--"+f.Name)
+			}
+		})
+	}
+	commands := visibleCommands()
+	switch {
+	case len(previous) == 0 && strings.HasPrefix(current, "-"):
+		globalFlags()
+	case len(previous) == 0:
+		for _, command := range commands {
+			candidates = append(candidates, command.Name)
+		}
+	default:
+		i := slices.IndexFunc(commands, func(c *command) bool { return c.Name == previous[0] })
+		if i == -1 {
+			return nil
+		}
+		command := commands[i]
+		switch {
+		case strings.HasPrefix(current, "-"):
+			candidates = slices.Clone(command.Flags)
+			globalFlags()
+		case len(command.Subcommands) > 0 && len(previous) == 1:
+			candidates = command.Subcommands
+		case strings.Contains(current, "="):
+			return nil
+		default:
+			for _, word := range previous[1:] {
+				if info, err := files.Stat(word); err != nil || !info.Mode().IsRegular() {
+					continue
+				}
+				dict, err := LoadDictionary(word)
+				if err != nil {
+					return nil
+				}
+				candidates = dict.Keys()
+				break
+			}
+		}
+	}
+
+	var matches []string
+	lower := strings.ToLower(current)
+	for _, candidate := range candidates {
+		if strings.HasPrefix(strings.ToLower(candidate), lower) {
+			matches = append(matches, candidate)
+		}
+	}
+	return matches
+}
+
+// runComplete implements the hidden __complete command called by the
+// completion scripts. It never fails, so that completion cannot break the
+// shell.
+func runComplete(args []string) int {
+	for _, match := range completeWords(args) {
+		fmt.Fprintln(stdout, match)
+	}
+	return 0
+}
+
+// completionScripts are the scripts printed by the completion command
+var completionScripts = map[string]string{
+	"bash": `# bash completion for vmxtool
+_vmxtool() {
+    local line=${COMP_LINE:0:COMP_POINT}
+    local -a words
+    read -ra words <<< "$line"
+    [[ $line == *[[:space:]] ]] && words+=("")
+    local cur=${words[${#words[@]}-1]}
+    local IFS=$'\n'
+    COMPREPLY=($(vmxtool __complete "${words[@]:1}" 2>/dev/null))
+    # Bash replaces only the part of the word after the last : or =
+    local prefix=${cur%"${cur##*[:=]}"}
+    if [[ -n $prefix ]]; then
+        COMPREPLY=("${COMPREPLY[@]#"$prefix"}")
+    fi
+}
+complete -o default -F _vmxtool vmxtool
+`,
+	"zsh": `#compdef vmxtool
+# zsh completion for vmxtool
+_vmxtool() {
+    local -a candidates
+    candidates=(${(f)"$(vmxtool __complete "${(@)words[2,CURRENT]}" 2>/dev/null)"})
+    if (( ${#candidates} )); then
+        compadd -- "${candidates[@]}"
+    else
+        _files
+    fi
+}
+compdef _vmxtool vmxtool
+`,
+	"fish": `# fish completion for vmxtool
+function __vmxtool_complete
+    set -l words (commandline -opc)
+    vmxtool __complete $words[2..-1] (commandline -ct) 2>/dev/null
+end
+complete -c vmxtool -a '(__vmxtool_complete)'
+`,
+}
+
+// runCompletion implements the completion command
+func runCompletion(args []string) int {
+	usage := "Usage: vmxtool completion bash|zsh|fish"
+	args, err := parseArgs("completion", args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	if len(args) != 1 {
+		errorf("Error: completion command requires a shell argument\n")
+		errorln(usage)
+		return exitUsage
+	}
+	script, ok := completionScripts[args[0]]
+	if !ok {
+		errorf("Error: unknown shell '%s'\n", args[0])
+		errorln(usage)
+		return exitUsage
+	}
+	fmt.Fprint(stdout, script)
+	return 0
+}
+
+// unknownCommand reports an unknown command, suggesting the closest one
+func unknownCommand(name string) int {
+	errorf("Error: unknown command '%s'\n", name)
+	var names []string
+	for _, command := range visibleCommands() {
+		names = append(names, command.Name)
+	}
+	if suggestion, ok := closestMatch(name, names); ok {
+		errorf("Did you mean '%s'?\n", suggestion)
+	}
+	errorf("Use 'vmxtool help' for usage information\n")
+	return exitUsage
+}
+
+// runHelp implements the help command
+func runHelp(args []string) int {
+	args, err := parseArgs("help", args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool help [COMMAND]\n")
+		return exitUsage
+	}
+	if len(args) == 0 {
+		printHelp()
+		return 0
+	}
+	if len(args) > 1 {
+		errorf("Error: help command takes at most one COMMAND argument\n")
+		errorf("Usage: vmxtool help [COMMAND]\n")
+		return exitUsage
+	}
+
+	command := findCommand(args[0])
+	if command == nil || command.Hidden {
+		return unknownCommand(args[0])
+	}
+	fmt.Fprintln(stdout, "Usage:")
+	for _, help := range command.Help {
+		for _, line := range help.Usage {
+			// Continuation lines keep their alignment under the command
+			if strings.HasPrefix(line, " ") {
+				fmt.Fprintln(stdout, "            "+line)
+			} else {
+				fmt.Fprintln(stdout, "    vmxtool "+line)
+			}
+		}
+	}
+	for _, help := range command.Help {
+		fmt.Fprintln(stdout)
+		for _, line := range help.descriptionLines() {
+			fmt.Fprintln(stdout, strings.TrimRight("    "+line, " "))
+		}
+	}
+	if len(command.Examples) > 0 {
+		fmt.Fprintln(stdout, "\nExamples:")
+		for _, example := range command.Examples {
+			fmt.Fprintln(stdout, "    "+example)
+		}
+	}
+	return 0
+}
+
+// roffEscape escapes text for a man page
+func roffEscape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\e`)
+	s = strings.ReplaceAll(s, "-", `\-`)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s
+}
+
+// writeRoffLines writes help lines as filled paragraphs, starting a new
+// paragraph at each blank line and keeping indented lines, such as
+// tables, as they are
+func writeRoffLines(sb *strings.Builder, lines []string) {
+	for _, line := range lines {
+		switch {
+		case line == "":
+			sb.WriteString(".sp\n")
+		case strings.HasPrefix(line, " "):
+			sb.WriteString(".nf\n" + roffEscape(line) + "\n.fi\n")
+		default:
+			sb.WriteString(roffEscape(line) + "\n")
+		}
+	}
+}
+
+// manPage returns the vmxtool(1) man page generated from the commands and
+// the option help
+func manPage() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, ".TH VMXTOOL 1 \"\" \"vmxtool %s\" \"User Commands\"\n", roffEscape(Version))
+	sb.WriteString(".SH NAME\nvmxtool \\- examine and modify VMware VMX configuration files\n")
+	sb.WriteString(".SH SYNOPSIS\n.B vmxtool\n[\\fIGLOBAL OPTIONS\\fR] \\fICOMMAND\\fR [\\fIARGUMENTS\\fR]\n")
+	sb.WriteString(".SH DESCRIPTION\n")
+	sb.WriteString("vmxtool edits VMware dictionary files, such as VMX files, while preserving their layout, white space and comments.\n")
+
+	sb.WriteString(".SH COMMANDS\n")
+	for _, command := range visibleCommands() {
+		for _, help := range command.Help {
+			for i, line := range help.joinedUsage() {
+				if i == 0 {
+					sb.WriteString(".TP\n")
+				} else {
+					sb.WriteString(".TQ\n")
+				}
+				sb.WriteString(`\fB` + roffEscape("vmxtool "+strings.TrimSpace(line)) + "\\fR\n")
+			}
+			writeRoffLines(&sb, help.descriptionLines())
+		}
+		if len(command.Examples) > 0 {
+			sb.WriteString(".sp\nExamples:\n.RS\n.nf\n")
+			for _, example := range command.Examples {
+				sb.WriteString(roffEscape(example) + "\n")
+			}
+			sb.WriteString(".fi\n.RE\n")
+		}
+	}
+
+	sb.WriteString(".SH GLOBAL OPTIONS\n")
+	var option []string
+	flush := func() {
+		writeRoffLines(&sb, option)
+		option = nil
+	}
+	for _, line := range helpSection("Global options:") {
+		switch {
+		case strings.HasPrefix(line, "    ") && !strings.HasPrefix(line, "     "):
+			flush()
+			sb.WriteString(".TP\n\\fB" + roffEscape(strings.TrimSpace(line)) + "\\fR\n")
+		case line == "" && len(option) == 0:
+		default:
+			option = append(option, strings.TrimPrefix(line, "        "))
+		}
+	}
+	flush()
+
+	sb.WriteString(".SH EXIT STATUS\n")
+	for _, line := range helpSection("Exit codes:") {
+		if code, text, ok := strings.Cut(strings.TrimSpace(line), "   "); ok {
+			sb.WriteString(".TP\n.B " + code + "\n" + roffEscape(strings.TrimSpace(text)) + "\n")
+		}
+	}
+	return sb.String()
+}
+
+// runMan implements the man command
+func runMan(args []string) int {
+	args, err := parseArgs("man", args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool man\n")
+		return exitUsage
+	}
+	if len(args) != 0 {
+		errorf("Error: man command takes no arguments\n")
+		errorf("Usage: vmxtool man\n")
+		return exitUsage
+	}
+	fmt.Fprint(stdout, manPage())
+	return 0
+}
+
+// printHelp displays the help message
+func printHelp() {
+	fmt.Fprintln(stdout, helpText())
+}
+
+// helpText returns the help message: each command with its description,
+// then the global options
+func helpText() string {
+	var sb strings.Builder
+	sb.WriteString("A tool to examine and modify VMware VMX configuration files.\n\n")
+	sb.WriteString("Available commands:\n")
+	for _, command := range visibleCommands() {
+		for _, help := range command.Help {
+			for _, line := range help.Usage {
+				sb.WriteString("    " + line + "\n")
+			}
+			for _, line := range help.descriptionLines() {
+				sb.WriteString(strings.TrimRight("        "+line, " ") + "\n")
+			}
+			sb.WriteString("\n")
+		}
+	}
+	sb.WriteString(optionsHelpText)
+	return sb.String()
+}
+
+// optionsHelpText is the part of the help message after the commands
+const optionsHelpText = `Global options:
+    --sort-on-save
+        Sorts the keys, as the sort command does, whenever a command
+        saves a file. This reorders the whole file, so the first save
+        may produce a large diff.
+
+    --max-line-size SIZE
+        Sets the longest line accepted when loading a file, for example
+        16M. The default is 4 MB. Longer lines are reported as an error
+        with their line number.
+
+    --max-entries N
+        Sets the most lines, counting comments and blank lines, accepted
+        when loading a file, to guard against huge or corrupted files.
+        Larger files are reported as an error. By default there is no
+        limit.
+
+    --recursive
+        Runs the command on every .vmx file under the directory given in
+        place of FILE, reporting the result for each file. Backup
+        (.vmx~), snapshot (.vmsd) and lock files are skipped. A summary
+        of how many files were modified, unchanged or failed is printed
+        at the end.
+
+    -q, --quiet
+        Prints only errors and the data a command was asked for, such as
+        the values from query. Warnings, messages about the keys a
+        command changed and the summary at the end of a --recursive run
+        are not printed.
+
+    -v, --verbose
+        Also prints each file loaded with its number of lines and keys,
+        and each key changed when a file is saved.
+
+    Output
+        The data a command was asked for, such as values, reports and
+        diffs, is printed to stdout. Errors, warnings and messages about
+        what a command changed are printed to stderr, so that the output
+        of a command can be piped to another program.
+
+    FILE patterns
+        The FILE argument of set, remove, query and print may be a glob
+        pattern such as '/vms/*/*.vmx', quoted so that vmxtool expands it
+        the same way on every platform. The command is run on each
+        matching file with a status line for each, as --recursive does,
+        and fails if any file failed. query prints the values of all
+        matching files, each prefixed with its file path. Only the FILE
+        argument is expanded, never a key or value.
+
+    --vmware-compat
+        Reads and writes files in the canonical format VMware itself
+        writes, for files guarded by a checksum or signature: .encoding
+        first, every value quoted as key = "value", and control
+        characters, quotes, | and # escaped as |XX (for example |22 for
+        a quote). |XX escapes are decoded when the file is read. Comments
+        and blank lines are dropped, as VMware drops them, except a #!
+        first line, which is kept.
+
+    --no-suggest
+        Does not suggest a similar key, from the file or the keys known
+        to explain, when a key does not exist.
+
+    --timeout DURATION
+        Aborts with an error when reading or writing a file takes longer
+        than DURATION, for example 5s or 1m, instead of waiting forever
+        on a hung network mount. By default there is no timeout.
+
+    --fail-fast
+        Stops a --recursive or glob run at the first file that fails,
+        instead of processing the rest.
+
+    --jobs N
+        Processes up to N files at once when set or remove runs on
+        several files, through --recursive or a FILE pattern, and when
+        find or query reads several files, which is much faster on
+        network storage. Output is reported in sorted order as if the
+        files were processed one at a time, and each file is saved as
+        safely as in a single-file run. The default is the number of
+        CPUs, at most 16; --jobs 1 processes one file at a time, as do
+        --verbose, --dry-run, --fail-fast and --json-errors.
+
+    --quote-keys
+        Accepts keys containing whitespace, =, # or a quote in set and
+        add, writing them quoted as "KEY" = "VALUE" so that the line
+        reads back as the same key. Without it such keys are rejected,
+        as VMware does not accept them.
+
+    --backup
+        Copies a file to FILE.bak before a command saves over it, so
+        that a bad change can be undone. Each save replaces the previous
+        FILE.bak. A backup has the permissions of the file.
+
+    --backup-dir PATH
+        Keeps backups in PATH, created if needed, instead of next to the
+        file, so that VM directories stay clean. Implies --backup. Each
+        backup is stored under the absolute path of the file with a
+        timestamp added, for example
+        PATH/vms/web/web.vmx.20261014-093000.bak, and earlier backups are
+        kept.
+
+    --verify
+        Reads back each file after saving it and fails with an error,
+        and exit code 3, if its SHA-256 differs from that of the data
+        that was meant to be written, for example on faulty storage.
 
-	case "query":
-		if len(os.Args) != 4 {
-			fmt.Println("Error: query command requires FILE and KEY arguments")
-			fmt.Println("Usage: vmxtool query FILE KEY")
-			return 1
+    --journal
+        Appends a record of the keys each command changes, with their
+        old and new values, to FILE.vmxtool-journal, so that undo can
+        revert them. Setting VMXTOOL_JOURNAL=1 has the same effect.
+        Changes that only affect comments or layout are not recorded.
+
+    --
+        Ends the options: every argument after it is positional, even
+        one starting with -, for example
+        vmxtool set vm.vmx -- --weird.key=value.
+
+    --dry-run
+        Runs any command that changes files in full, but instead of
+        saving prints a unified diff of each file from what is saved to
+        what would be written, and writes nothing, journal and backups
+        included. Exits with code 1 if any file would change and 0 if
+        not, as git diff --exit-code does.
+
+    --diff
+        Accepted with --dry-run, whose preview is always a unified
+        diff, for scripts written for the older add, set and remove
+        --dry-run --diff.
+
+    --exit-code
+        Accepted with --dry-run for compatibility; a dry run that would
+        change a file always exits with code 1.
+
+    --force
+        Saves a file even if another program changed it after vmxtool
+        loaded it. Without it, vmxtool notes the modification time, size
+        and identity of each file when loading it and checks them again
+        just before saving; if VMware or another tool saved the file in
+        between, the command fails with exit code 3 instead of silently
+        undoing that change. A change that keeps the size within the
+        resolution of the file system clock is not detected. It also
+        saves a file that a running VM holds, as shown by a FILE.lck
+        lock directory beside it, which is otherwise refused because
+        VMware writes the file back when the VM stops.
+        set-hw-version has a --force of its own, so give this one before
+        the command name, as in 'vmxtool --force set-hw-version FILE 21'.
+
+    --json-errors
+        When a command fails, prints a single JSON object to stderr in
+        place of the error text, for programs that run vmxtool:
+
+            {"error": "key_not_found", "key": "memsiz", "file": "vm.vmx",
+             "message": "key 'memsiz' does not exist", "exitCode": 4}
+
+        error is one of key_not_found, key_exists, conflict, locked,
+        timeout, file_not_found, permission_denied, file_error, usage,
+        unchanged or error, and these codes do not change between
+        releases. key
+        and file are given when known, further lines such as usage are
+        in details and warnings in warnings. Messages about changes are
+        not printed, and the exit code is the same as without the
+        option. Use print --format json, or --json with lint, check and
+        find, for output as JSON.
+
+    --require-vmx-extension
+        Refuses to save a file whose name does not end in .vmx, so that a
+        command pointed at a .vmdk or other file by mistake fails instead
+        of rewriting it. It is most useful set in the config file, with
+        --require-vmx-extension=false on the command line to change
+        another file, such as a .vmtx template, on purpose.
+
+    Config file and environment
+        Defaults for the global options can be kept in a config file,
+        ~/.config/vmxtool/config.toml, or in vmxtool/config.toml under
+        $XDG_CONFIG_HOME if that is set, or in the file named by
+        VMXTOOL_CONFIG, which must then exist. It uses TOML with one
+        option per line, named as on the command line without dashes:
+
+            backup = true
+            backup-dir = "/var/backups/vmx"
+            jobs = 4
+
+        A table named after a command sets defaults for the flags of that
+        command, which its flags on the command line override:
+
+            [diff]
+            color = "always"
+
+            [set]
+            strict = true
+
+        Each option can also be set with an environment variable named
+        VMXTOOL_ and the option in capitals with - as _, such as
+        VMXTOOL_BACKUP_DIR. Options on the command line override the
+        environment, which overrides the config file. An unknown option,
+        command or command flag, or a malformed line, in the config file
+        is an error. Use
+        'vmxtool config show' to see where each option was set.
+
+Exit codes:
+    0   Success
+    1   General error, or differences found by diff, --dry-run or a check
+    2   Invalid command, flag or argument
+    3   A file could not be read or written
+    4   The key or device does not exist
+    5   The key already exists
+    6   set --require-change found the value already set`
+
+// printVersion displays version information
+func printVersion() {
+	fmt.Fprintf(stdout, "vmxtool version %s\n", Version)
+	fmt.Fprintf(stdout, "Build date: %s\n", BuildDate)
+	fmt.Fprintf(stdout, "Commit: %s\n", Commit)
+	fmt.Fprintln(stdout, "© 2025 David Parsons")
+}
+
+// Exit codes returned by run. Scripts can rely on these, so the values
+// must not change.
+const (
+	exitError       = 1 // any other failure
+	exitUsage       = 2 // invalid command, flag or argument
+	exitFileError   = 3 // a file could not be read or written
+	exitKeyNotFound = 4 // the key or device does not exist
+	exitKeyExists   = 5 // the key already exists
+	exitUnchanged   = 6 // set --require-change found the value already set
+
+	// exitDifferent is returned by diff, dry runs and checks that found
+	// differences, like diff(1)
+	exitDifferent = 1
+)
+
+// exitCode returns the exit code for an error from a Dictionary method
+func exitCode(err error) int {
+	var notFound *KeyNotFoundError
+	var exists *KeyExistsError
+	switch {
+	case errors.As(err, &notFound):
+		return exitKeyNotFound
+	case errors.As(err, &exists):
+		return exitKeyExists
+	}
+	return exitError
+}
+
+// apiServer serves the files under Root over HTTP. Requests are handled
+// one at a time, as loading and saving go through global state.
+type apiServer struct {
+	Root  string
+	Token string
+	mu    sync.Mutex
+}
+
+// apiError is an error with the HTTP status it is reported with
+type apiError struct {
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+// writeJSON writes v as the JSON body of a response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(data, '\n'))
+}
+
+// writeError writes err as a JSON error response
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var apiErr *apiError
+	var notFound *KeyNotFoundError
+	switch {
+	case errors.As(err, &apiErr):
+		status = apiErr.Status
+	case errors.As(err, &notFound), errors.Is(err, fs.ErrNotExist):
+		status = http.StatusNotFound
+	case errors.Is(err, errConflict), errors.Is(err, errLocked):
+		status = http.StatusConflict
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// resolve returns the path of a file named relative to the root, refusing
+// names that leave the root, directly or through a symbolic link
+func (s *apiServer) resolve(name string) (string, error) {
+	name = filepath.FromSlash(name)
+	if !filepath.IsLocal(name) {
+		return "", &apiError{http.StatusForbidden, fmt.Sprintf("path %s is outside the root", name)}
+	}
+	root, err := files.EvalSymlinks(s.Root)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := files.EvalSymlinks(filepath.Join(root, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", &apiError{http.StatusNotFound, fmt.Sprintf("file %s does not exist", name)}
+	} else if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(root, resolved); err != nil || !filepath.IsLocal(rel) {
+		return "", &apiError{http.StatusForbidden, fmt.Sprintf("path %s is outside the root", name)}
+	}
+	if info, err := files.Stat(resolved); err != nil {
+		return "", err
+	} else if info.IsDir() {
+		return "", &apiError{http.StatusBadRequest, fmt.Sprintf("path %s is a directory", name)}
+	}
+	return resolved, nil
+}
+
+// checkWritable refuses changes to a file that is not a .vmx file, such
+// as a disk descriptor or .vmsd file, which can still be read
+func checkWritable(filename string) error {
+	if !strings.EqualFold(filepath.Ext(filename), ".vmx") {
+		return &apiError{http.StatusForbidden, fmt.Sprintf("%s is not a .vmx file and cannot be changed", filepath.Base(filename))}
+	}
+	return nil
+}
+
+// authorized checks the bearer token of a request, if a token is required
+func (s *apiServer) authorized(r *http.Request) bool {
+	if s.Token == "" {
+		return true
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) == 1
+}
+
+// ServeHTTP handles /files/PATH and /files/PATH/keys/KEY
+func (s *apiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="vmxtool"`)
+		writeError(w, &apiError{http.StatusUnauthorized, "missing or invalid token"})
+		return
+	}
+
+	rest, ok := strings.CutPrefix(r.URL.Path, "/files/")
+	if !ok || rest == "" {
+		writeError(w, &apiError{http.StatusNotFound, "not found"})
+		return
+	}
+	name, key := rest, ""
+	if i := strings.LastIndex(rest, "/keys/"); i != -1 {
+		name, key = rest[:i], rest[i+len("/keys/"):]
+		if key == "" {
+			writeError(w, &apiError{http.StatusNotFound, "not found"})
+			return
 		}
-		filename := os.Args[2]
-		key := os.Args[3]
+	}
 
-		dict, err := LoadDictionary(filename)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	filename, err := s.resolve(name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		err = s.getFile(w, filename)
+	case key != "" && r.Method == http.MethodGet:
+		err = s.getKey(w, filename, key)
+	case key != "" && r.Method == http.MethodPut:
+		if err = checkWritable(filename); err == nil {
+			err = s.putKey(w, r, filename, key)
+		}
+	case key != "" && r.Method == http.MethodDelete:
+		if err = checkWritable(filename); err == nil {
+			err = s.deleteKey(w, filename, key)
+		}
+	default:
+		allow := "GET, PUT, DELETE"
+		if key == "" {
+			allow = "GET"
+		}
+		w.Header().Set("Allow", allow)
+		err = &apiError{http.StatusMethodNotAllowed, fmt.Sprintf("method %s not allowed", r.Method)}
+	}
+	if err != nil {
+		writeError(w, err)
+	}
+}
+
+// getFile returns every key of the file in file order
+func (s *apiServer) getFile(w http.ResponseWriter, filename string) error {
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, dict.Pairs())
+	return nil
+}
+
+// getKey returns the value of a key
+func (s *apiServer) getKey(w http.ResponseWriter, filename, key string) error {
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		return err
+	}
+	value, err := dict.Query(key)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, KV{key, value})
+	return nil
+}
+
+// putKey sets a key to the value in the JSON body, {"value": "..."}, after
+// the checks set makes
+func (s *apiServer) putKey(w http.ResponseWriter, r *http.Request, filename, key string) error {
+	var body struct {
+		Value *string `json:"value"`
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, defaultMaxLineSize))
+	if err := decoder.Decode(&body); err != nil || body.Value == nil {
+		return &apiError{http.StatusBadRequest, `body must be a JSON object {"value": "..."}`}
+	}
+	value := *body.Value
+
+	if strings.EqualFold(key, "guestOS") {
+		if err := validateGuestOS(value); err != nil {
+			return &apiError{http.StatusBadRequest, err.Error()}
+		}
+	}
+	if err := validateKnownValue(key, value); err != nil {
+		return &apiError{http.StatusBadRequest, err.Error()}
+	}
+	if err := checkSnapshots(nil, filename, []string{key}, true); err != nil {
+		return &apiError{http.StatusConflict, err.Error()}
+	}
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		return err
+	}
+	if dict.Set(key, value) {
+		if err := saveDictionary(dict, filename); err != nil {
+			return err
+		}
+	}
+	writeJSON(w, http.StatusOK, KV{key, value})
+	return nil
+}
+
+// deleteKey removes a key
+func (s *apiServer) deleteKey(w http.ResponseWriter, filename, key string) error {
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		return err
+	}
+	if err := dict.Remove(key); err != nil {
+		return err
+	}
+	if err := saveDictionary(dict, filename); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// runServe implements the serve command
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	listen := fs.String("listen", "127.0.0.1:8080", "address to listen on")
+	root := fs.String("root", "", "directory whose files are served")
+	token := fs.String("token", "", "bearer token required on every request")
+
+	usage := "Usage: vmxtool serve --root DIR [--listen ADDRESS] [--token SECRET]"
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	if len(positional) != 0 || *root == "" {
+		errorf("Error: serve command requires --root\n")
+		errorln(usage)
+		return exitUsage
+	}
+	if info, err := files.Stat(*root); err != nil || !info.IsDir() {
+		errorf("Error: %s is not a directory\n", *root)
+		return exitFileError
+	}
+	if *token == "" {
+		*token = os.Getenv("VMXTOOL_TOKEN")
+	}
+
+	server := &http.Server{
+		Addr:              *listen,
+		Handler:           &apiServer{Root: *root, Token: *token},
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	go func() {
+		<-interrupt
+		server.Close()
+	}()
+
+	infof("Serving %s on http://%s\n", *root, *listen)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		errorf("Error: %v\n", err)
+		return exitError
+	}
+	return 0
+}
+
+// findVMXFiles returns the VMX files under dir in lexical order. Lock
+// directories are skipped, as are backup (.vmx~) and snapshot (.vmsd)
+// files, which do not have the .vmx extension. Symbolic links are only
+// followed if followLinks is set, and a directory reached through several
+// links is only walked once.
+func findVMXFiles(dir string, followLinks bool) ([]string, error) {
+	var files []string
+	visited := map[string]bool{}
+	seen := func(dir string) bool {
+		real, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			real, err = filepath.Abs(real)
+		}
+		if err != nil {
+			return false
+		}
+		if visited[real] {
+			return true
+		}
+		visited[real] = true
+		return false
+	}
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		if seen(dir) {
+			return nil
+		}
+		// A trailing separator makes WalkDir descend into a link to a
+		// directory
+		root := dir
+		if info, err := os.Lstat(dir); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+			root = dir + string(filepath.Separator)
+		}
+		return filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.Type()&fs.ModeSymlink != 0 && followLinks {
+				info, err := os.Stat(path)
+				if err != nil {
+					return nil
+				}
+				if info.IsDir() {
+					if strings.HasSuffix(entry.Name(), ".lck") {
+						return nil
+					}
+					return walk(path)
+				}
+			} else if entry.IsDir() {
+				if strings.HasSuffix(entry.Name(), ".lck") || (path != root && followLinks && seen(path)) {
+					return filepath.SkipDir
+				}
+				return nil
+			} else if entry.Type()&fs.ModeSymlink != 0 {
+				return nil
+			}
+			if strings.EqualFold(filepath.Ext(path), ".vmx") {
+				files = append(files, path)
+			}
+			return nil
+		})
+	}
+	err := walk(dir)
+	return files, err
+}
+
+// findResult is a file matched by the find command
+type findResult struct {
+	File  string `json:"file"`
+	Value string `json:"value,omitempty"`
+}
+
+// runFind implements the find command
+func runFind(args []string) int {
+	fs := flag.NewFlagSet("find", flag.ContinueOnError)
+	keyFlag := fs.String("key", "", "list files that set KEY, or KEY=VALUE to match its value")
+	missing := fs.String("missing", "", "list files that do not set KEY")
+	jsonOutput := fs.Bool("json", false, "print the matching files as JSON")
+	followLinks := fs.Bool("follow-symlinks", false, "follow symbolic links while walking DIR")
+
+	usage := "Usage: vmxtool find DIR [--key KEY[=VALUE] | --missing KEY] [--json] [--follow-symlinks]"
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorln(usage)
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		errorf("Error: find command requires DIR argument\n")
+		errorln(usage)
+		return exitUsage
+	}
+	if *keyFlag != "" && *missing != "" {
+		errorf("Error: --key and --missing cannot be used together\n")
+		errorln(usage)
+		return exitUsage
+	}
+	dir := positional[0]
+	key, value, matchValue := strings.Cut(*keyFlag, "=")
+	key = strings.TrimSpace(key)
+	value = strings.TrimSpace(value)
+
+	paths, err := findVMXFiles(dir, *followLinks)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		return exitFileError
+	}
+
+	status := 0
+	results := []findResult{}
+	for path, loaded := range eachFile(paths, fileJobs(), func(path string) loadResult {
+		dict, err := LoadDictionary(path)
+		return loadResult{dict, err}
+	}) {
+		dict, err := loaded.dict, loaded.err
 		if err != nil {
-			fmt.Printf("Error loading file: %v\n", err)
-			return 1
+			errorf("Error loading file: %v\n", err)
+			status = exitFileError
+			continue
+		}
+
+		switch {
+		case *missing != "":
+			if !dict.KeyExists(*missing) {
+				results = append(results, findResult{File: path})
+			}
+		case key != "":
+			if v, ok := dict.QueryOK(key); ok && (!matchValue || valuesEqual(v, value)) {
+				results = append(results, findResult{path, v})
+			}
+		default:
+			results = append(results, findResult{File: path})
 		}
+	}
 
-		value, err := dict.Query(key)
+	if *jsonOutput {
+		data, err := json.MarshalIndent(results, "", "  ")
 		if err != nil {
-			fmt.Printf("Error: %v\n", err)
-			return 1
+			errorf("Error: %v\n", err)
+			return exitError
 		}
+		fmt.Fprintln(stdout, string(data))
+		return status
+	}
 
-		fmt.Println(value)
-		return 0
+	if len(results) == 0 {
+		infof("No matching files\n")
+		return status
+	}
+	width := 0
+	for _, result := range results {
+		width = max(width, len(result.File))
+	}
+	for _, result := range results {
+		if key == "" {
+			fmt.Fprintln(stdout, result.File)
+		} else {
+			fmt.Fprintf(stdout, "%-*s  %s\n", width, result.File, result.Value)
+		}
+	}
+	return status
+}
 
-	default:
-		fmt.Printf("Error: unknown command '%s'\n", command)
-		fmt.Println("Use 'vmxtool help' for usage information")
-		return 1
+// runRecursive runs a command once for each VMX file under the directory
+// given in place of its FILE argument, reporting the result for each file.
+// It returns 0 if every run succeeded, otherwise the first failure's code.
+func runRecursive(args []string) int {
+	dirIndex := -1
+	for i, arg := range args[1:] {
+		if strings.HasPrefix(arg, "-") {
+			continue
+		}
+		if info, err := files.Stat(arg); err == nil && info.IsDir() {
+			dirIndex = i + 1
+			break
+		}
+	}
+	if dirIndex == -1 {
+		errorf("Error: --recursive requires a directory in place of FILE\n")
+		errorf("Use 'vmxtool help' for usage information\n")
+		return exitUsage
+	}
+
+	files, err := findVMXFiles(args[dirIndex], false)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		return exitFileError
+	}
+	if len(files) == 0 {
+		errorf("Error: no VMX files found under %s\n", args[dirIndex])
+		return exitFileError
+	}
+
+	return runEach(args, dirIndex, files)
+}
+
+// runEach runs a command once for each of filenames, substituted for the
+// argument at index, and prints a status line for each. It returns 0 if
+// every run succeeded, otherwise the first failure's code. With
+// --fail-fast it stops at the first failure.
+//
+// A command that prepares its change once is applied to up to --jobs
+// files at once, with the output of each file held until the files
+// before it are reported. Other commands run on one file at a time.
+func runEach(args []string, index int, filenames []string) int {
+	status := 0
+	var summary batchSummary
+	report := func(file string, code int, saved bool) bool {
+		summary.record(code, saved)
+		if code == 0 {
+			infof("%s: ok\n", file)
+			return true
+		}
+		errorf("%s: failed with exit code %d\n", file, code)
+		if status == 0 {
+			status = code
+		}
+		return !globalOptions.FailFast
+	}
+
+	command := findCommand(args[0])
+	if jobs := fileJobs(); command != nil && command.Prepare != nil && jobs > 1 {
+		fileArgs := slices.Clone(args)
+		fileArgs[index] = filenames[0]
+		journalCommand = fileArgs
+		_, apply, code := command.Prepare(fileArgs[1:])
+		if apply == nil {
+			return code
+		}
+		defer summary.print()
+
+		type result struct {
+			code  int
+			saved bool
+			out   *fileOutput
+		}
+		for file, r := range eachFile(filenames, jobs, func(file string) result {
+			out := &fileOutput{}
+			before := statFile(file)
+			code := apply(file, out)
+			return result{code, !statFile(file).same(before), out}
+		}) {
+			infof("==> %s <==\n", file)
+			r.out.flush()
+			report(file, r.code, r.saved)
+		}
+		return status
+	}
+
+	defer summary.print()
+	for _, file := range filenames {
+		fileArgs := slices.Clone(args)
+		fileArgs[index] = file
+
+		infof("==> %s <==\n", file)
+		savedBefore := filesSaved.Load()
+		code := runCommand(fileArgs)
+		if !report(file, code, filesSaved.Load() > savedBefore) {
+			break
+		}
+	}
+	return status
+}
+
+// globCommands are the commands whose FILE argument may be a glob
+// pattern, with the flags of each that take a value
+var globCommands = map[string][]string{
+	"set":    nil,
+	"remove": nil,
+	"query":  nil,
+	"print":  {"format"},
+}
+
+// globIndex returns the index in args of the FILE argument if it is a
+// glob pattern rather than a file, or -1. Only the FILE argument, the
+// first after the flags, is examined, so that a value containing a pattern
+// character is never taken for one.
+func globIndex(args []string) int {
+	valueFlags, ok := globCommands[args[0]]
+	if !ok {
+		return -1
+	}
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			i++
+		} else if strings.HasPrefix(arg, "-") && arg != "-" {
+			name := strings.TrimLeft(arg, "-")
+			if !strings.Contains(name, "=") && slices.Contains(valueFlags, name) {
+				i++
+			}
+			continue
+		}
+		if i >= len(args) || !strings.ContainsAny(args[i], "*?[") {
+			return -1
+		}
+		if _, err := files.Stat(args[i]); err == nil {
+			return -1
+		}
+		return i
+	}
+	return -1
+}
+
+// runGlob runs a command on every file matching the glob pattern given in
+// place of its FILE argument. Patterns are expanded here rather than by
+// the shell so that they work the same on Windows. query takes several
+// files already and labels each value with its file, so the matches are
+// passed to a single query.
+func runGlob(args []string, index int) int {
+	pattern := args[index]
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		errorf("Error: invalid pattern %s: %v\n", pattern, err)
+		return exitUsage
+	}
+	if len(files) == 0 {
+		errorf("Error: no files match %s\n", pattern)
+		return exitFileError
+	}
+
+	if args[0] == "query" {
+		return runCommand(slices.Concat(args[:index], files, args[index+1:]))
+	}
+	return runEach(args, index, files)
+}
+
+// run contains the main logic, given the command line arguments, and
+// returns an exit code
+func run(args []string) (code int) {
+	defer func() {
+		if code != 0 && globalOptions.JSONErrors {
+			printFailure(code)
+		}
+	}()
+	failure = commandFailure{}
+	settingSources = map[string]settingSource{}
+	commandSettings = nil
+	lastLoaded.Store("")
+	filesSaved.Store(0)
+	dryRunChanged = false
+
+	// Options on the command line override those from the environment,
+	// which override those from the config file
+	globalFlags = newGlobalFlagSet()
+	err := loadConfig(globalFlags)
+	if err == nil {
+		err = loadEnvironment(globalFlags)
+	}
+	if err != nil {
+		errorf("Error: %v\n", err)
+		return exitUsage
+	}
+
+	args, err = parseGlobalFlags(globalFlags, args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Use 'vmxtool help' for usage information\n")
+		return exitUsage
+	}
+
+	if globalOptions.Diff && !globalOptions.DryRun {
+		errorf("Error: --diff can only be used with --dry-run\n")
+		errorf("Use 'vmxtool help' for usage information\n")
+		return exitUsage
+	}
+
+	if len(args) < 1 {
+		errorf("Error: no command provided\n")
+		errorf("Use 'vmxtool help' for usage information\n")
+		return exitUsage
+	}
+
+	if globalOptions.Recursive {
+		code = runRecursive(args)
+	} else if index := globIndex(args); index != -1 {
+		code = runGlob(args, index)
+	} else {
+		code = runCommand(args)
+	}
+	if code == 0 && dryRunChanged {
+		return exitDifferent
+	}
+	return code
+}
+
+// runCommand runs the command named by args[0] and returns its exit code
+func runCommand(args []string) int {
+	journalCommand = args
+	command := findCommand(args[0])
+	if command == nil {
+		return unknownCommand(args[0])
+	}
+	return command.Run(args[1:])
+}
+
+// runVersion implements the version command
+func runVersion(args []string) int {
+	args, err := parseArgs("version", args)
+	if err == nil && len(args) != 0 {
+		err = errors.New("version command takes no arguments")
+	}
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool version\n")
+		return exitUsage
+	}
+	printVersion()
+	return 0
+}
+
+// runNamespaces implements the namespaces command
+func runNamespaces(args []string) int {
+	args, err := parseArgs("namespaces", args)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		errorf("Usage: vmxtool namespaces FILE\n")
+		return exitUsage
+	}
+	if len(args) != 1 {
+		errorf("Error: namespaces command requires FILE argument\n")
+		errorf("Usage: vmxtool namespaces FILE\n")
+		return exitUsage
+	}
+	filename := args[0]
+
+	dict, err := LoadDictionary(filename)
+	if err != nil {
+		errorf("Error loading file: %v\n", err)
+		return exitFileError
+	}
+
+	for _, ns := range dict.Namespaces() {
+		fmt.Fprintf(stdout, "%s: %d\n", ns.Namespace, ns.Count)
 	}
+	return 0
 }
 
 func main() {
-	os.Exit(run())
+	os.Exit(run(os.Args[1:]))
 }
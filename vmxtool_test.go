@@ -0,0 +1,3510 @@
+// SPDX-FileCopyrightText: © 2025 David Parsons
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// TestMain hides the config file and the VMXTOOL_ environment variables of
+// the user running the tests
+func TestMain(m *testing.M) {
+	for _, env := range os.Environ() {
+		if name, _, _ := strings.Cut(env, "="); strings.HasPrefix(name, "VMXTOOL_") {
+			os.Unsetenv(name)
+		}
+	}
+	dir, err := os.MkdirTemp("", "vmxtool-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Setenv("XDG_CONFIG_HOME", dir)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// checkGolden compares got with the golden file testdata/name, or with
+// -update writes it
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("output differs from %s, rerun with -update if intended:\n%s", path, got)
+	}
+}
+
+// memFileSystem is a fileSystem held in memory, for tests of failures that
+// are hard to cause on disk, such as a write that fails part way
+type memFileSystem struct {
+	mu    sync.Mutex
+	files map[string]*memFile
+	dirs  map[string]bool
+	temp  int
+	clock time.Time
+
+	// denied holds names whose every operation fails with fs.ErrPermission
+	denied map[string]bool
+	// readOnly holds names, of files or of directories, that cannot be
+	// written, created, renamed or removed
+	readOnly map[string]bool
+	// writeLimit, if positive, makes a write fail once a file holds that
+	// many bytes, leaving what fitted written
+	writeLimit int
+}
+
+// memFile is a file of a memFileSystem. Its address identifies it, as the
+// inode does on disk, so renaming another file over it replaces it.
+type memFile struct {
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func newMemFileSystem() *memFileSystem {
+	return &memFileSystem{
+		files:    map[string]*memFile{},
+		dirs:     map[string]bool{".": true},
+		clock:    time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		denied:   map[string]bool{},
+		readOnly: map[string]bool{},
+	}
+}
+
+// useMemFileSystem makes files a new memFileSystem for the rest of the test
+func useMemFileSystem(t *testing.T) *memFileSystem {
+	t.Helper()
+	m := newMemFileSystem()
+	old := files
+	files = m
+	t.Cleanup(func() { files = old })
+	return m
+}
+
+// tick advances the clock of the file system, so that every change gives
+// a file a new modification time
+func (m *memFileSystem) tick() time.Time {
+	m.clock = m.clock.Add(time.Second)
+	return m.clock
+}
+
+// check returns the error for an operation on name, if it is denied
+func (m *memFileSystem) check(op, name string) error {
+	if m.denied[filepath.Clean(name)] {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+	}
+	return nil
+}
+
+// checkWrite returns the error for an operation that changes name, if it
+// or its directory is read-only
+func (m *memFileSystem) checkWrite(op, name string) error {
+	if err := m.check(op, name); err != nil {
+		return err
+	}
+	if m.readOnly[filepath.Clean(name)] || m.readOnly[filepath.Dir(name)] {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+	}
+	return nil
+}
+
+// put creates or replaces a file with data
+func (m *memFileSystem) put(name, data string, mode fs.FileMode) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[filepath.Clean(name)] = &memFile{[]byte(data), mode, m.tick()}
+}
+
+// get returns the contents of a file and whether it exists
+func (m *memFileSystem) get(name string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.files[filepath.Clean(name)]
+	if !ok {
+		return "", false
+	}
+	return string(f.data), true
+}
+
+// names returns the names of the files, sorted
+func (m *memFileSystem) names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var names []string
+	for name := range m.files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (m *memFileSystem) ReadFile(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check("open", name); err != nil {
+		return nil, err
+	}
+	f, ok := m.files[filepath.Clean(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return slices.Clone(f.data), nil
+}
+
+func (m *memFileSystem) Open(name string) (io.ReadCloser, error) {
+	data, err := m.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(strings.NewReader(string(data))), nil
+}
+
+func (m *memFileSystem) OpenFile(name string, flag int, perm fs.FileMode) (io.WriteCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkWrite("open", name); err != nil {
+		return nil, err
+	}
+	key := filepath.Clean(name)
+	f, ok := m.files[key]
+	switch {
+	case ok && flag&os.O_EXCL != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case !ok:
+		f = &memFile{mode: perm, modTime: m.tick()}
+		m.files[key] = f
+	case flag&os.O_TRUNC != 0:
+		f.data, f.modTime = nil, m.tick()
+	}
+	return &memWriter{m, name, f}, nil
+}
+
+func (m *memFileSystem) CreateTemp(dir, pattern string) (tempFile, error) {
+	m.mu.Lock()
+	m.temp++
+	name := filepath.Join(dir, strings.Replace(pattern, "*", fmt.Sprint(m.temp), 1))
+	m.mu.Unlock()
+	w, err := m.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return w.(*memWriter), nil
+}
+
+func (m *memFileSystem) Rename(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkWrite("rename", newname); err != nil {
+		return err
+	}
+	f, ok := m.files[filepath.Clean(oldname)]
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: fs.ErrNotExist}
+	}
+	delete(m.files, filepath.Clean(oldname))
+	m.files[filepath.Clean(newname)] = f
+	return nil
+}
+
+func (m *memFileSystem) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkWrite("remove", name); err != nil {
+		return err
+	}
+	if _, ok := m.files[filepath.Clean(name)]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(m.files, filepath.Clean(name))
+	return nil
+}
+
+func (m *memFileSystem) Chmod(name string, mode fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.files[filepath.Clean(name)]
+	if !ok {
+		return &fs.PathError{Op: "chmod", Path: name, Err: fs.ErrNotExist}
+	}
+	f.mode = mode
+	return nil
+}
+
+func (m *memFileSystem) MkdirAll(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for dir := filepath.Clean(name); !m.dirs[dir]; dir = filepath.Dir(dir) {
+		m.dirs[dir] = true
+	}
+	return nil
+}
+
+func (m *memFileSystem) Stat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check("stat", name); err != nil {
+		return nil, err
+	}
+	key := filepath.Clean(name)
+	if f, ok := m.files[key]; ok {
+		return f.info(filepath.Base(name)), nil
+	}
+	if m.dirs[key] {
+		return (&memFile{mode: fs.ModeDir | 0o755}).info(filepath.Base(name)), nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (m *memFileSystem) Lstat(name string) (fs.FileInfo, error) {
+	return m.Stat(name)
+}
+
+func (m *memFileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var entries []fs.DirEntry
+	for key, f := range m.files {
+		if filepath.Dir(key) == filepath.Clean(name) {
+			entries = append(entries, fs.FileInfoToDirEntry(f.info(filepath.Base(key))))
+		}
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	return entries, nil
+}
+
+func (m *memFileSystem) EvalSymlinks(name string) (string, error) {
+	if _, err := m.Stat(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// memWriter is a file of a memFileSystem open for writing
+type memWriter struct {
+	m    *memFileSystem
+	name string
+	file *memFile
+}
+
+func (w *memWriter) Name() string {
+	return w.name
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	w.m.mu.Lock()
+	defer w.m.mu.Unlock()
+	n := len(p)
+	if limit := w.m.writeLimit; limit > 0 && len(w.file.data)+n > limit {
+		n = max(limit-len(w.file.data), 0)
+	}
+	w.file.data = append(w.file.data, p[:n]...)
+	w.file.modTime = w.m.tick()
+	if n < len(p) {
+		return n, &fs.PathError{Op: "write", Path: w.name, Err: errors.New("no space left on device")}
+	}
+	return n, nil
+}
+
+func (w *memWriter) Close() error {
+	return nil
+}
+
+// memFileInfo describes a file of a memFileSystem as it was when examined
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	file    *memFile
+}
+
+// info describes f, which must be locked, under name
+func (f *memFile) info(name string) memFileInfo {
+	return memFileInfo{name, int64(len(f.data)), f.mode, f.modTime, f}
+}
+
+func (i memFileInfo) Name() string       { return i.name }
+func (i memFileInfo) Size() int64        { return i.size }
+func (i memFileInfo) Mode() fs.FileMode  { return i.mode }
+func (i memFileInfo) ModTime() time.Time { return i.modTime }
+func (i memFileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i memFileInfo) Sys() any           { return i.file }
+
+// setOption sets a global option, or other package variable, for the rest
+// of the test
+func setOption[T any](t testing.TB, p *T, value T) {
+	t.Helper()
+	old := *p
+	*p = value
+	t.Cleanup(func() { *p = old })
+}
+
+const memVMX = `.encoding = "UTF-8"
+# settings
+displayName = "test"
+memsize = "2048"
+`
+
+func TestMemFileSystemLoadSave(t *testing.T) {
+	m := useMemFileSystem(t)
+	m.put("vm.vmx", memVMX, 0o600)
+
+	dict, err := LoadDictionary("vm.vmx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dict.Set("memsize", "4096")
+	if err := saveDictionary(dict, "vm.vmx"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ := m.get("vm.vmx")
+	want := strings.Replace(memVMX, `"2048"`, `"4096"`, 1)
+	if got != want {
+		t.Errorf("saved file is\n%s\nwant\n%s", got, want)
+	}
+	info, _ := m.Stat("vm.vmx")
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("saved file has mode %v, want 0600", info.Mode().Perm())
+	}
+	if names := m.names(); !slices.Equal(names, []string{"vm.vmx"}) {
+		t.Errorf("files after saving are %v, want only vm.vmx", names)
+	}
+}
+
+func TestLoadPermissionDenied(t *testing.T) {
+	m := useMemFileSystem(t)
+	m.put("vm.vmx", memVMX, 0o600)
+	m.denied["vm.vmx"] = true
+
+	if _, err := LoadDictionary("vm.vmx"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("LoadDictionary error is %v, want permission denied", err)
+	}
+}
+
+func TestSavePermissionDenied(t *testing.T) {
+	m := useMemFileSystem(t)
+	m.put("vm.vmx", memVMX, 0o644)
+
+	dict, err := LoadDictionary("vm.vmx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dict.Set("memsize", "4096")
+	m.readOnly["vm.vmx"] = true
+	m.readOnly["."] = true
+	if err := saveDictionary(dict, "vm.vmx"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("saveDictionary error is %v, want permission denied", err)
+	}
+
+	if got, _ := m.get("vm.vmx"); got != memVMX {
+		t.Errorf("file was changed by a failed save:\n%s", got)
+	}
+	if names := m.names(); !slices.Equal(names, []string{"vm.vmx"}) {
+		t.Errorf("files after a failed save are %v, want only vm.vmx", names)
+	}
+}
+
+func TestSavePartialWriteKeepsFile(t *testing.T) {
+	m := useMemFileSystem(t)
+	m.put("vm.vmx", memVMX, 0o644)
+
+	dict, err := LoadDictionary("vm.vmx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dict.Set("annotation", strings.Repeat("x", 100))
+	m.writeLimit = 20
+	if err := saveDictionary(dict, "vm.vmx"); err == nil {
+		t.Fatal("saveDictionary succeeded with a write that failed part way")
+	}
+
+	if got, _ := m.get("vm.vmx"); got != memVMX {
+		t.Errorf("file was changed by a partial write:\n%s", got)
+	}
+	if names := m.names(); !slices.Equal(names, []string{"vm.vmx"}) {
+		t.Errorf("files after a partial write are %v, want only vm.vmx", names)
+	}
+}
+
+func TestSaveNewFilePartialWriteRemoved(t *testing.T) {
+	m := useMemFileSystem(t)
+	m.writeLimit = 5
+
+	dict, err := LoadDictionary("new.vmx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dict.Set("displayName", "new")
+	if err := saveDictionary(dict, "new.vmx"); err == nil {
+		t.Fatal("saveDictionary succeeded with a write that failed part way")
+	}
+	if names := m.names(); len(names) != 0 {
+		t.Errorf("files after a failed save of a new file are %v, want none", names)
+	}
+}
+
+func TestSaveConflict(t *testing.T) {
+	m := useMemFileSystem(t)
+	m.put("vm.vmx", memVMX, 0o644)
+
+	dict, err := LoadDictionary("vm.vmx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dict.Set("memsize", "4096")
+	changed := memVMX + "numvcpus = \"2\"\n"
+	m.put("vm.vmx", changed, 0o644)
+
+	if err := saveDictionary(dict, "vm.vmx"); !errors.Is(err, errConflict) {
+		t.Fatalf("saveDictionary error is %v, want a conflict", err)
+	}
+	if got, _ := m.get("vm.vmx"); got != changed {
+		t.Errorf("file changed by another program was saved over:\n%s", got)
+	}
+
+	setOption(t, &globalOptions.Force, true)
+	if err := saveDictionary(dict, "vm.vmx"); err != nil {
+		t.Fatalf("saveDictionary with --force: %v", err)
+	}
+	if got, _ := m.get("vm.vmx"); !strings.Contains(got, `memsize = "4096"`) {
+		t.Errorf("file was not saved with --force:\n%s", got)
+	}
+}
+
+// runVMXTool runs vmxtool with args, as main would, and returns its exit
+// code and what it printed on stdout and stderr
+func runVMXTool(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+	return runVMXToolInput(t, "", args...)
+}
+
+// runVMXToolInput is runVMXTool with input on stdin
+func runVMXToolInput(t *testing.T, input string, args ...string) (int, string, string) {
+	t.Helper()
+	var out, errs strings.Builder
+	setOption(t, &stdout, io.Writer(&out))
+	setOption(t, &stderr, io.Writer(&errs))
+	setOption(t, &stdin, io.Reader(strings.NewReader(input)))
+	code := run(args)
+	return code, out.String(), errs.String()
+}
+
+func TestRunStreams(t *testing.T) {
+	m := useMemFileSystem(t)
+	m.put("vm.vmx", memVMX, 0o644)
+
+	code, out, errs := runVMXTool(t, "query", "vm.vmx", "displayName")
+	if code != 0 || out != "test\n" || errs != "" {
+		t.Errorf("query printed %q and %q with %d, want %q on stdout only", out, errs, code, "test\n")
+	}
+
+	code, out, errs = runVMXTool(t, "query", "vm.vmx", "missing")
+	if code != exitKeyNotFound || out != "" || !strings.Contains(errs, "'missing' does not exist") {
+		t.Errorf("query of a missing key printed %q and %q with %d, want the error on stderr only", out, errs, code)
+	}
+}
+
+func TestVerboseLoadedOnce(t *testing.T) {
+	m := useMemFileSystem(t)
+	m.put("vm.vmx", memVMX, 0o644)
+
+	code, out, errs := runVMXTool(t, "--verbose", "set", "vm.vmx", "memsize=4096")
+	if code != 0 {
+		t.Fatalf("set failed with %d: %s", code, errs)
+	}
+	if out != "" {
+		t.Errorf("set printed %q on stdout, want nothing", out)
+	}
+	if n := strings.Count(errs, "Loaded vm.vmx"); n != 1 {
+		t.Errorf("--verbose printed Loaded %d times, want once:\n%s", n, errs)
+	}
+	if !strings.Contains(errs, "Saved vm.vmx") {
+		t.Errorf("--verbose did not print Saved:\n%s", errs)
+	}
+}
+
+func TestGlobHeadersOnStderr(t *testing.T) {
+	t.Chdir(t.TempDir())
+	for _, name := range []string{"a.vmx", "b.vmx"} {
+		if err := os.WriteFile(name, []byte(memVMX), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	code, out, errs := runVMXTool(t, "set", "*.vmx", "memsize=4096")
+	if code != 0 {
+		t.Fatalf("set failed with %d: %s", code, errs)
+	}
+	if out != "" {
+		t.Errorf("set over a glob printed %q on stdout, want nothing", out)
+	}
+	for _, header := range []string{"==> a.vmx <==", "==> b.vmx <=="} {
+		if !strings.Contains(errs, header) {
+			t.Errorf("stderr is missing %q:\n%s", header, errs)
+		}
+	}
+
+	code, out, _ = runVMXTool(t, "query", "*.vmx", "memsize")
+	if want := "a.vmx: 4096\nb.vmx: 4096\n"; code != 0 || out != want {
+		t.Errorf("query over a glob printed %q with %d, want %q", out, code, want)
+	}
+}
+
+func TestGlobValueNotPattern(t *testing.T) {
+	t.Chdir(t.TempDir())
+	for _, value := range []string{"a*b", "why?", "[draft]"} {
+		code, _, errs := runVMXTool(t, "set", "new.vmx", "annotation="+value)
+		if code != 0 {
+			t.Fatalf("set of %q failed with %d: %s", value, code, errs)
+		}
+		dict, err := LoadDictionary("new.vmx")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := dict.QueryOK("annotation"); got != value {
+			t.Errorf("annotation is %q, want %q", got, value)
+		}
+	}
+}
+
+func TestGlobIndex(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("[a].vmx", []byte(memVMX), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{[]string{"set", "*.vmx", "memsize=1"}, 1},
+		{[]string{"set", "new.vmx", "annotation=a*b"}, -1},
+		{[]string{"set", "[a].vmx", "memsize=1"}, -1},
+		{[]string{"set", "--strict", "vm*.vmx", "memsize=1"}, 2},
+		{[]string{"set", "--", "vm*.vmx", "memsize=1"}, 2},
+		{[]string{"print", "--format", "json", "*.vmx"}, 3},
+		{[]string{"print", "--format=json", "*.vmx"}, 2},
+		{[]string{"query", "vm.vmx", "key*"}, -1},
+		{[]string{"diff", "*.vmx", "b.vmx"}, -1},
+	}
+	for _, test := range tests {
+		if got := globIndex(test.args); got != test.want {
+			t.Errorf("globIndex(%q) = %d, want %d", test.args, got, test.want)
+		}
+	}
+}
+
+func TestCommandsHaveExamples(t *testing.T) {
+	for _, command := range visibleCommands() {
+		if len(command.Examples) == 0 {
+			t.Errorf("command %s has no examples", command.Name)
+		}
+		runs := slices.ContainsFunc(command.Examples, func(example string) bool {
+			return strings.Contains(example, "vmxtool "+command.Name)
+		})
+		if len(command.Examples) > 0 && !runs {
+			t.Errorf("no example of command %s runs it", command.Name)
+		}
+	}
+}
+
+func TestCommandFlags(t *testing.T) {
+	usageFlag := regexp.MustCompile(`--[a-z0-9][a-z0-9-]*`)
+	for _, command := range visibleCommands() {
+		var used []string
+		for _, help := range command.Help {
+			for _, line := range help.Usage {
+				used = append(used, usageFlag.FindAllString(line, -1)...)
+			}
+		}
+		for _, name := range used {
+			if !slices.Contains(command.Flags, name) {
+				t.Errorf("command %s shows %s but does not list it in Flags", command.Name, name)
+			}
+		}
+
+		// Each flag must be one the command, or one of its subcommands,
+		// defines; an invalid value gets past the check for an unknown flag
+		useMemFileSystem(t)
+		subcommands := append([]string{""}, command.Subcommands...)
+		for _, name := range command.Flags {
+			defined := false
+			for _, subcommand := range subcommands {
+				args := []string{command.Name, subcommand, name + "=!"}
+				_, _, errs := runVMXTool(t, slices.DeleteFunc(args, func(s string) bool { return s == "" })...)
+				if !strings.Contains(errs, "flag provided but not defined") {
+					defined = true
+					break
+				}
+			}
+			if !defined {
+				t.Errorf("command %s lists %s, which it does not define", command.Name, name)
+			}
+		}
+	}
+}
+
+func TestHelpListsCommands(t *testing.T) {
+	help := helpText()
+	for _, command := range commands {
+		listed := strings.Contains(help, "\n    "+command.Name+" ") || strings.Contains(help, "\n    "+command.Name+"\n")
+		if listed == command.Hidden {
+			t.Errorf("command %s is listed %v in the help, want %v", command.Name, listed, !command.Hidden)
+		}
+		if command.Run == nil {
+			t.Errorf("command %s has no Run", command.Name)
+		}
+	}
+	readme, err := os.ReadFile("README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(readme), "```\n"+help+"\n```") {
+		t.Errorf("the help in README.md is not the help text")
+	}
+}
+
+func TestCompletionScripts(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		t.Run(shell, func(t *testing.T) {
+			code, out, errs := runVMXTool(t, "completion", shell)
+			if code != 0 {
+				t.Fatalf("completion %s failed with %d: %s", shell, code, errs)
+			}
+			checkGolden(t, "completion."+shell, out)
+		})
+	}
+}
+
+func TestCompleteWords(t *testing.T) {
+	m := useMemFileSystem(t)
+	m.put("vm.vmx", `ethernet0.present = "TRUE"
+ethernet0.virtualDev = "vmxnet3"
+memsize = "2048"
+`, 0o644)
+
+	tests := []struct {
+		words []string
+		want  []string
+	}{
+		{[]string{"que"}, []string{"query", "query-prefix"}},
+		{[]string{"guestinfo", ""}, []string{"set", "get", "list"}},
+		{[]string{"query", "vm.vmx", "eth"}, []string{"ethernet0.present", "ethernet0.virtualDev"}},
+		{[]string{"query", "vm.vmx", "ETH"}, []string{"ethernet0.present", "ethernet0.virtualDev"}},
+		{[]string{"set", "vm.vmx", "memsize="}, nil},
+		{[]string{"query", "missing.vmx", "eth"}, nil},
+		{[]string{"nested", "--f"}, []string{"--fix", "--fail-fast", "--force"}},
+		{[]string{"roundtr"}, nil},
+	}
+	for _, test := range tests {
+		if got := completeWords(test.words); !slices.Equal(got, test.want) {
+			t.Errorf("completeWords(%q) = %q, want %q", test.words, got, test.want)
+		}
+	}
+}
+
+func TestFlaglessCommandsTerminator(t *testing.T) {
+	m := useMemFileSystem(t)
+	m.put("-vm.vmx", memVMX, 0o644)
+
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{[]string{"sort", "--", "-vm.vmx"}, 0},
+		{[]string{"namespaces", "--", "-vm.vmx"}, 0},
+		{[]string{"history", "--", "-vm.vmx"}, 0},
+		{[]string{"roundtrip", "--", "-vm.vmx"}, 0},
+		{[]string{"dump", "--", "-vm.vmx"}, 0},
+		{[]string{"append", "--", "-vm.vmx", "tags", "-x"}, 0},
+		{[]string{"append", "-vm.vmx", "tags", "x"}, exitUsage},
+		{[]string{"completion", "--", "bash"}, 0},
+		{[]string{"man", "--"}, 0},
+		{[]string{"config", "--", "show"}, 0},
+		{[]string{"sort", "--bogus", "vm.vmx"}, exitUsage},
+		{[]string{"version", "extra"}, exitUsage},
+	}
+	for _, test := range tests {
+		code, _, errs := runVMXTool(t, test.args...)
+		if code != test.want {
+			t.Errorf("%q exited with %d, want %d: %s", test.args, code, test.want, errs)
+		}
+	}
+	got, _ := m.get("-vm.vmx")
+	if !strings.Contains(got, `tags = "-x"`) {
+		t.Errorf("append after -- did not add the token:\n%s", got)
+	}
+}
+
+func TestDryRunDiffs(t *testing.T) {
+	moved := memVMX + "nvram = \"/old/vms/test.nvram\"\n"
+	tests := []struct {
+		name   string
+		vmx    string // the file, if not memVMX
+		args   []string
+		golden string
+	}{
+		{"set", "", []string{"set", "vm.vmx", "memsize=4096", "--dry-run"}, "dry-run-set.diff"},
+		{"add", "", []string{"add", "--dry-run", "vm.vmx", "numvcpus=2"}, "dry-run-add.diff"},
+		{"remove", "", []string{"remove", "vm.vmx", "displayName", "--dry-run"}, "dry-run-remove.diff"},
+		{"import", "", []string{"--dry-run", "import", "vm.vmx", "data.json"}, "dry-run-import.diff"},
+		{"replace-value", "", []string{"--dry-run", "replace-value", "vm.vmx", "test", "renamed"}, "dry-run-replace-value.diff"},
+		{"relocate", moved, []string{"relocate", "vm.vmx", "--from", "/old/vms", "--to", "/new/vms", "--dry-run"}, "dry-run-relocate.diff"},
+		{"clone-prep", "", []string{"--dry-run", "clone-prep", "vm.vmx", "--name", "Copy"}, "dry-run-clone-prep.diff"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vmx := cmp.Or(test.vmx, memVMX)
+			m := useMemFileSystem(t)
+			m.put("vm.vmx", vmx, 0o644)
+			m.put("data.json", `{"displayName": "imported", "guestOS": "ubuntu-64"}`, 0o644)
+
+			code, out, errs := runVMXTool(t, test.args...)
+			if code != exitDifferent {
+				t.Fatalf("%q exited with %d, want %d: %s", test.args, code, exitDifferent, errs)
+			}
+			checkGolden(t, test.golden, out)
+			if got, _ := m.get("vm.vmx"); got != vmx {
+				t.Errorf("--dry-run changed the file:\n%s", got)
+			}
+			if names := m.names(); len(names) != 2 {
+				t.Errorf("--dry-run left files %v", names)
+			}
+
+			for _, flag := range []string{"--diff", "--exit-code"} {
+				code, got, _ := runVMXTool(t, append([]string{flag}, test.args...)...)
+				if code != exitDifferent {
+					t.Errorf("%s exited with %d, want %d", flag, code, exitDifferent)
+				}
+				if got != out {
+					t.Errorf("%s changed the preview:\n%s", flag, got)
+				}
+			}
+		})
+	}
+}
+
+func TestDryRunExitCodes(t *testing.T) {
+	m := useMemFileSystem(t)
+	m.put("vm.vmx", memVMX, 0o644)
+
+	code, out, errs := runVMXTool(t, "set", "--dry-run", "--diff", "vm.vmx", "memsize=8192")
+	if code != exitDifferent {
+		t.Errorf("set --dry-run --diff exited with %d, want %d: %s", code, exitDifferent, errs)
+	}
+	if !strings.Contains(out, "+memsize = \"8192\"") {
+		t.Errorf("set --dry-run --diff printed no diff:\n%s", out)
+	}
+	if got, _ := m.get("vm.vmx"); got != memVMX {
+		t.Errorf("--dry-run changed the file:\n%s", got)
+	}
+
+	code, out, errs = runVMXTool(t, "set", "--dry-run", "vm.vmx", "memsize=2048")
+	if code != 0 {
+		t.Errorf("a dry run that changes nothing exited with %d: %s", code, errs)
+	}
+	if out != "" {
+		t.Errorf("a dry run that changes nothing printed:\n%s", out)
+	}
+
+	code, _, errs = runVMXTool(t, "set", "--diff", "vm.vmx", "memsize=8192")
+	if code != exitUsage || !strings.Contains(errs, "--diff can only be used with --dry-run") {
+		t.Errorf("--diff without --dry-run exited with %d: %s", code, errs)
+	}
+	if got, _ := m.get("vm.vmx"); got != memVMX {
+		t.Errorf("--diff without --dry-run changed the file:\n%s", got)
+	}
+}
+
+func TestConfigCommandFlags(t *testing.T) {
+	m := useMemFileSystem(t)
+	m.put("vm.vmx", memVMX, 0o644)
+	m.put("other.vmx", strings.Replace(memVMX, "2048", "4096", 1), 0o644)
+	m.put("config.toml", `backup = false
+
+[diff]
+color = "always"
+
+[set]
+strict = true
+`, 0o644)
+	t.Setenv("VMXTOOL_CONFIG", "config.toml")
+
+	_, out, _ := runVMXTool(t, "diff", "vm.vmx", "other.vmx")
+	if !strings.Contains(out, "\x1b[") {
+		t.Errorf("[diff] color = \"always\" did not colour the diff:\n%q", out)
+	}
+	_, out, _ = runVMXTool(t, "diff", "--color", "never", "vm.vmx", "other.vmx")
+	if strings.Contains(out, "\x1b[") {
+		t.Errorf("--color never did not override the config file:\n%q", out)
+	}
+
+	code, _, errs := runVMXTool(t, "set", "--validate-resources", "vm.vmx", "memsize=1001")
+	if code == 0 {
+		t.Errorf("[set] strict = true did not make a resource warning an error: %s", errs)
+	}
+	code, _, errs = runVMXTool(t, "set", "--validate-resources", "--strict=false", "vm.vmx", "memsize=1001")
+	if code != 0 {
+		t.Errorf("--strict=false did not override the config file, exit %d: %s", code, errs)
+	}
+
+	_, out, _ = runVMXTool(t, "config", "show")
+	for _, want := range []string{"[diff] color", "[set] strict"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("config show does not list %s:\n%s", want, out)
+		}
+	}
+}
+
+func TestConfigCommandErrors(t *testing.T) {
+	tests := []struct {
+		config string
+		want   string
+	}{
+		{"[nosuch]\ncolor = \"always\"\n", "unknown command 'nosuch'"},
+		{"[diff]\nstrict = true\n", "unknown option 'strict' for the diff command"},
+		{"[sort]\ncolor = \"always\"\n", "unknown option 'color' for the sort command"},
+		{"[diff\ncolor = \"always\"\n", "line 1: expected [COMMAND]"},
+		{"[diff]\ncolor = \"always\"\ncolor = \"never\"\n", "line 3: color is set more than once"},
+		{"[set]\nstrict = \"maybe\"\n", "invalid value \"maybe\" for strict in the [set] table"},
+	}
+	for _, test := range tests {
+		m := useMemFileSystem(t)
+		m.put("vm.vmx", memVMX, 0o644)
+		m.put("config.toml", test.config, 0o644)
+		t.Setenv("VMXTOOL_CONFIG", "config.toml")
+
+		code, _, errs := runVMXTool(t, "set", "vm.vmx", "memsize=4096")
+		if code != exitUsage || !strings.Contains(errs, test.want) {
+			t.Errorf("config %q gave %d and %q, want %d and %q", test.config, code, errs, exitUsage, test.want)
+		}
+	}
+}
+
+// writeTree writes n copies of memVMX named vm00.vmx and so on to a new
+// temporary directory and makes it the working directory
+func writeTree(t testing.TB, n int) []string {
+	t.Chdir(t.TempDir())
+	var names []string
+	for i := range n {
+		name := fmt.Sprintf("vm%02d.vmx", i)
+		if err := os.WriteFile(name, []byte(memVMX), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// writeBroken adds a directory named broken.vmx, which fails to load, to
+// the working directory
+func writeBroken(t *testing.T) {
+	if err := os.Mkdir("broken.vmx", 0o755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJobsSetInOrder(t *testing.T) {
+	run := func(jobs int) (int, string) {
+		names := writeTree(t, 40)
+		writeBroken(t)
+		setOption(t, &globalOptions.Jobs, jobs)
+		code, out, errs := runVMXTool(t, "set", "*.vmx", "memsize=4096")
+		if out != "" {
+			t.Errorf("set with --jobs %d printed %q on stdout, want nothing", jobs, out)
+		}
+		for _, name := range names {
+			dict, err := LoadDictionary(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := dict.QueryOK("memsize"); got != "4096" {
+				t.Errorf("with --jobs %d %s has memsize %q, want 4096", jobs, name, got)
+			}
+		}
+		return code, errs
+	}
+
+	code, want := run(1)
+	if code != exitFileError {
+		t.Errorf("set with --jobs 1 exited with %d, want %d", code, exitFileError)
+	}
+	if !strings.Contains(want, "41 files processed: 40 modified, 0 unchanged, 1 errored") {
+		t.Errorf("set with --jobs 1 did not print the summary:\n%s", want)
+	}
+	code, got := run(8)
+	if code != exitFileError {
+		t.Errorf("set with --jobs 8 exited with %d, want %d", code, exitFileError)
+	}
+	if got != want {
+		t.Errorf("set with --jobs 8 printed:\n%s\nwant, as with --jobs 1:\n%s", got, want)
+	}
+}
+
+func TestJobsRemoveUnchanged(t *testing.T) {
+	writeTree(t, 10)
+	setOption(t, &globalOptions.Jobs, 4)
+	if code, _, errs := runVMXTool(t, "remove", "vm0[0-4].vmx", "memsize"); code != 0 {
+		t.Fatalf("remove failed with %d: %s", code, errs)
+	}
+	code, _, errs := runVMXTool(t, "set", "vm*.vmx", "displayName=test")
+	if code != 0 {
+		t.Fatalf("set failed with %d: %s", code, errs)
+	}
+	if want := "10 files processed: 0 modified, 10 unchanged, 0 errored"; !strings.Contains(errs, want) {
+		t.Errorf("set of an unchanged value printed:\n%s\nwant %q", errs, want)
+	}
+	code, out, _ := runVMXTool(t, "query", "--show-absence", "vm*.vmx", "memsize")
+	var want strings.Builder
+	for i := range 10 {
+		value := "2048"
+		if i < 5 {
+			value = absentToken
+		}
+		fmt.Fprintf(&want, "vm%02d.vmx: %s\n", i, value)
+	}
+	if code != 0 || out != want.String() {
+		t.Errorf("query printed %q with %d, want %q", out, code, want.String())
+	}
+}
+
+func TestJobsQueryFindInOrder(t *testing.T) {
+	names := writeTree(t, 30)
+	writeBroken(t)
+	setOption(t, &globalOptions.Jobs, 8)
+	args := slices.Concat([]string{"query"}, names[:10], []string{"missing.vmx", "broken.vmx"}, names[10:], []string{"memsize"})
+	code, out, errs := runVMXTool(t, args...)
+	if code != exitFileError {
+		t.Errorf("query exited with %d, want %d: %s", code, exitFileError, errs)
+	}
+	var want strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&want, "%s: 2048\n", name)
+	}
+	if out != want.String() {
+		t.Errorf("query printed:\n%s\nwant:\n%s", out, want.String())
+	}
+	if n := strings.Count(errs, "Error loading file"); n != 1 {
+		t.Errorf("query reported %d load errors, want 1 for broken.vmx:\n%s", n, errs)
+	}
+
+	code, out, errs = runVMXTool(t, "find", ".", "--key", "memsize=2048")
+	if code != 0 {
+		t.Fatalf("find failed with %d: %s", code, errs)
+	}
+	var found []string
+	for line := range strings.Lines(out) {
+		found = append(found, strings.Fields(line)[0])
+	}
+	if !slices.Equal(found, names) {
+		t.Errorf("find listed %v, want %v", found, names)
+	}
+}
+
+func TestEachFileStops(t *testing.T) {
+	names := make([]string, 100)
+	for i := range names {
+		names[i] = fmt.Sprint(i)
+	}
+	var started atomic.Int64
+	var seen []string
+	for name, result := range eachFile(names, 4, func(name string) string {
+		started.Add(1)
+		return "done " + name
+	}) {
+		if result != "done "+name {
+			t.Errorf("%s has result %q", name, result)
+		}
+		seen = append(seen, name)
+		if len(seen) == 10 {
+			break
+		}
+	}
+	if !slices.Equal(seen, names[:10]) {
+		t.Errorf("eachFile yielded %v, want %v", seen, names[:10])
+	}
+	// No more than 4 files are ever ahead of the one being yielded
+	if n := started.Load(); n > 10+4 {
+		t.Errorf("eachFile started %d files after stopping at 10", n)
+	}
+}
+
+func BenchmarkSetJobs(b *testing.B) {
+	for _, jobs := range []int{1, 8} {
+		b.Run(fmt.Sprintf("jobs=%d", jobs), func(b *testing.B) {
+			writeTree(b, 200)
+			setOption(b, &globalOptions.Jobs, jobs)
+			setOption(b, &stdout, io.Discard)
+			setOption(b, &stderr, io.Discard)
+			for i := 0; b.Loop(); i++ {
+				// Alternate the value so that every file is saved each time
+				memsize := fmt.Sprintf("memsize=%d", 1024+i%2)
+				if code := run([]string{"set", "*.vmx", memsize}); code != 0 {
+					b.Fatalf("set failed with %d", code)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkFindJobs(b *testing.B) {
+	for _, jobs := range []int{1, 8} {
+		b.Run(fmt.Sprintf("jobs=%d", jobs), func(b *testing.B) {
+			writeTree(b, 200)
+			setOption(b, &globalOptions.Jobs, jobs)
+			setOption(b, &stdout, io.Discard)
+			setOption(b, &stderr, io.Discard)
+			for b.Loop() {
+				if code := run([]string{"find", ".", "--key", "memsize"}); code != 0 {
+					b.Fatalf("find failed with %d", code)
+				}
+			}
+		})
+	}
+}
+
+// writeLarge writes vm.vmx, padded with guestinfo keys to more than
+// streamMinSize so that it is streamed, to a new temporary directory made
+// the working directory, and returns its contents
+func writeLarge(t testing.TB) string {
+	t.Chdir(t.TempDir())
+	var sb strings.Builder
+	sb.WriteString(memVMX)
+	pad := strings.Repeat("x", 100)
+	for i := 0; int64(sb.Len()) < streamMinSize; i++ {
+		fmt.Fprintf(&sb, "guestinfo.pad%06d = \"%s\"\n", i, pad)
+	}
+	sb.WriteString(`numvcpus = "2"` + "\n")
+	if err := os.WriteFile("vm.vmx", []byte(sb.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return sb.String()
+}
+
+func TestStreamMatchesLoad(t *testing.T) {
+	content := writeLarge(t)
+	if !canStream("vm.vmx") {
+		t.Fatal("canStream is false for a large file")
+	}
+	tests := [][]string{
+		{"set", "vm.vmx", "memsize=4096"},
+		{"set", "vm.vmx", "NUMVCPUS=4"},
+		{"set", "vm.vmx", "annotation=added"},
+		{"set", "vm.vmx", "memsize=2048"},
+		{"remove", "vm.vmx", "guestinfo.pad000100"},
+		{"query", "vm.vmx", "numvcpus"},
+	}
+	for _, args := range tests {
+		// --max-entries makes the command load the whole file
+		result := func(maxEntries int) (int, string, string) {
+			setOption(t, &globalOptions.MaxEntries, maxEntries)
+			if err := os.WriteFile("vm.vmx", []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if got := canStream("vm.vmx"); got != (maxEntries == 0) {
+				t.Fatalf("canStream is %v with --max-entries %d", got, maxEntries)
+			}
+			code, out, _ := runVMXTool(t, args...)
+			data, err := os.ReadFile("vm.vmx")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return code, out, string(data)
+		}
+		streamCode, streamOut, streamed := result(0)
+		loadCode, loadOut, loaded := result(1 << 30)
+		if streamCode != loadCode || streamOut != loadOut {
+			t.Errorf("%v streamed printed %q with %d, loaded %q with %d", args, streamOut, streamCode, loadOut, loadCode)
+		}
+		if streamed != loaded {
+			t.Errorf("%v streamed and loaded write different files", args)
+		}
+	}
+}
+
+// BenchmarkStream compares changing and querying one key of a large file
+// a line at a time with loading every entry, which --max-entries forces
+func BenchmarkStream(b *testing.B) {
+	for _, mode := range []string{"stream", "load"} {
+		for _, command := range []string{"set", "query"} {
+			b.Run(mode+"/"+command, func(b *testing.B) {
+				writeLarge(b)
+				if mode == "load" {
+					setOption(b, &globalOptions.MaxEntries, 1<<30)
+				}
+				setOption(b, &stdout, io.Discard)
+				setOption(b, &stderr, io.Discard)
+				b.ReportAllocs()
+				for i := 0; b.Loop(); i++ {
+					args := []string{"query", "vm.vmx", "memsize"}
+					if command == "set" {
+						// Alternate the value so that the file is saved each time
+						args = []string{"set", "vm.vmx", fmt.Sprintf("memsize=%d", 1024+i%2)}
+					}
+					if code := run(args); code != 0 {
+						b.Fatalf("%v failed with %d", args, code)
+					}
+				}
+			})
+		}
+	}
+}
+
+// serveTree writes web/web.vmx, web/web.vmsd and a file outside the root
+// to a new temporary directory, with a link under the root to the file
+// outside it, and returns a server for the root
+func serveTree(t *testing.T) (*apiServer, string) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "vms")
+	for name, data := range map[string]string{
+		"vms/web/web.vmx":  memVMX,
+		"vms/web/web.vmsd": ".encoding = \"UTF-8\"\n",
+		"outside.vmx":      memVMX,
+	} {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(dir, "outside.vmx"), filepath.Join(root, "link.vmx")); err != nil {
+		t.Fatal(err)
+	}
+	return &apiServer{Root: root, Token: "secret"}, root
+}
+
+// serveRequest sends a request with the token to s and returns the status
+// and body of the response
+func serveRequest(s *apiServer, method, path, token, body string) (int, string) {
+	r := httptest.NewRequest(method, "http://vmxtool"+path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w.Code, w.Body.String()
+}
+
+func TestServeAuth(t *testing.T) {
+	s, _ := serveTree(t)
+	for _, token := range []string{"", "wrong", "secre", "secrets"} {
+		r := httptest.NewRequest(http.MethodGet, "/files/web/web.vmx", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("token %q got %d with WWW-Authenticate %q, want 401 with a challenge", token, w.Code, w.Header().Get("WWW-Authenticate"))
+		}
+	}
+	r := httptest.NewRequest(http.MethodGet, "/files/web/web.vmx", nil)
+	r.Header.Set("Authorization", "Basic secret")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("a Basic header got %d, want 401", w.Code)
+	}
+	if code, body := serveRequest(s, http.MethodGet, "/files/web/web.vmx", "secret", ""); code != http.StatusOK || !strings.Contains(body, `"memsize"`) {
+		t.Errorf("the right token got %d: %s", code, body)
+	}
+}
+
+func TestServeKeys(t *testing.T) {
+	s, root := serveTree(t)
+	tests := []struct {
+		method, path, body string
+		status             int
+	}{
+		{http.MethodGet, "/files/web/web.vmx/keys/memsize", "", http.StatusOK},
+		{http.MethodGet, "/files/web/web.vmx/keys/memsiz", "", http.StatusNotFound},
+		{http.MethodDelete, "/files/web/web.vmx/keys/memsiz", "", http.StatusNotFound},
+		{http.MethodGet, "/files/web/missing.vmx/keys/memsize", "", http.StatusNotFound},
+		{http.MethodGet, "/files/web/web.vmx/keys/", "", http.StatusNotFound},
+		{http.MethodPut, "/files/web/web.vmx/keys/memsize", `{"memsize": "4096"}`, http.StatusBadRequest},
+		{http.MethodPut, "/files/web/web.vmx/keys/memsize", `{"value": "4096"}`, http.StatusOK},
+		{http.MethodPut, "/files/web/web.vmx/keys/numvcpus", `{"value": "2"}`, http.StatusOK},
+		{http.MethodDelete, "/files/web/web.vmx/keys/numvcpus", "", http.StatusNoContent},
+		{http.MethodPost, "/files/web/web.vmx/keys/memsize", "", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/files/web/web.vmsd", "", http.StatusOK},
+		{http.MethodPut, "/files/web/web.vmsd/keys/snapshot.numSnapshots", `{"value": "0"}`, http.StatusForbidden},
+		{http.MethodDelete, "/files/web/web.vmsd/keys/.encoding", "", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		if code, body := serveRequest(s, test.method, test.path, "secret", test.body); code != test.status {
+			t.Errorf("%s %s got %d, want %d: %s", test.method, test.path, code, test.status, body)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, "web", "web.vmx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Replace(memVMX, "2048", "4096", 1); string(data) != want {
+		t.Errorf("web.vmx is:\n%s\nwant:\n%s", data, want)
+	}
+}
+
+func TestServePathEscapes(t *testing.T) {
+	s, _ := serveTree(t)
+	for _, path := range []string{
+		"/files/../outside.vmx",
+		"/files/web/../../outside.vmx/keys/memsize",
+		"/files//etc/passwd",
+		"/files/link.vmx",
+		"/files/link.vmx/keys/memsize",
+	} {
+		for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+			if code, body := serveRequest(s, method, path, "secret", `{"value": "1"}`); code != http.StatusForbidden {
+				t.Errorf("%s %s got %d, want 403: %s", method, path, code, body)
+			}
+		}
+	}
+	if code, _ := serveRequest(s, http.MethodGet, "/files/web", "secret", ""); code != http.StatusBadRequest {
+		t.Errorf("GET of a directory got %d, want 400", code)
+	}
+}
+
+func TestServeLocked(t *testing.T) {
+	s, root := serveTree(t)
+	if err := os.Mkdir(filepath.Join(root, "web", "web.vmx.lck"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, method := range []string{http.MethodPut, http.MethodDelete} {
+		code, body := serveRequest(s, method, "/files/web/web.vmx/keys/memsize", "secret", `{"value": "4096"}`)
+		if code != http.StatusConflict || !strings.Contains(body, "web.vmx.lck") {
+			t.Errorf("%s of a locked file got %d, want 409: %s", method, code, body)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(root, "web", "web.vmx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != memVMX {
+		t.Errorf("a locked file was changed to:\n%s", data)
+	}
+
+	w := httptest.NewRecorder()
+	writeError(w, fmt.Errorf("%w: web.vmx changed", errConflict))
+	if w.Code != http.StatusConflict {
+		t.Errorf("a conflict got %d, want 409", w.Code)
+	}
+}
+
+func TestSaveLocked(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("vm.vmx", []byte(memVMX), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir("vm.vmx.lck", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	code, _, errs := runVMXTool(t, "set", "vm.vmx", "memsize=4096")
+	if code != exitFileError || !strings.Contains(errs, "running VM") {
+		t.Errorf("set of a locked file exited with %d: %s", code, errs)
+	}
+	if code, _, errs := runVMXTool(t, "--force", "set", "vm.vmx", "memsize=4096"); code != 0 {
+		t.Errorf("set --force of a locked file exited with %d: %s", code, errs)
+	}
+	if data, _ := os.ReadFile("vm.vmx"); !strings.Contains(string(data), `memsize = "4096"`) {
+		t.Errorf("set --force did not change the locked file:\n%s", data)
+	}
+}
+
+func TestSetBatch(t *testing.T) {
+	input, err := os.ReadFile(filepath.Join("testdata", "batch.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, crlf := range []bool{false, true} {
+		m := useMemFileSystem(t)
+		m.put("vm.vmx", memVMX, 0o644)
+		batch := string(input)
+		if crlf {
+			batch = strings.ReplaceAll(batch, "\n", "\r\n")
+		}
+		code, _, errs := runVMXToolInput(t, batch, "set", "vm.vmx", "--batch", "-")
+		if code != 0 {
+			t.Fatalf("set --batch failed with %d: %s", code, errs)
+		}
+		if n := filesSaved.Load(); n != 1 {
+			t.Errorf("set --batch saved %d times, want once", n)
+		}
+		got, _ := m.get("vm.vmx")
+		checkGolden(t, "batch.vmx", got)
+
+		dict, err := LoadDictionary("vm.vmx")
+		if err != nil {
+			t.Fatal(err)
+		}
+		annotation, _ := dict.QueryOK("annotation")
+		script, _ := dict.QueryOK("guestinfo.script")
+		if got, want := vmwareUnescape(annotation), "Web server\n  indented \"quoted\" | piped\n# not a comment\n"; got != want {
+			t.Errorf("annotation is %q, want %q", got, want)
+		}
+		if got, want := vmwareUnescape(script), "#!/bin/sh\necho \"a<<b\""; got != want {
+			t.Errorf("guestinfo.script is %q, want %q", got, want)
+		}
+	}
+}
+
+func TestSetBatchErrors(t *testing.T) {
+	tests := []struct {
+		batch string
+		code  int
+		err   string
+	}{
+		{"memsize=4096\nannotation<<END\nnever\nended\n", exitUsage, "line 2: here-doc for annotation is not terminated by a line END"},
+		{"annotation<<END\nEN\nEND \n", 0, ""},
+		{"memsize=4096\nannotation<<\nEND\n", exitUsage, "line 2: invalid here-doc delimiter"},
+		{"memsize=4096\nannotation<<TWO WORDS\nTWO WORDS\n", exitUsage, "line 2: invalid here-doc delimiter"},
+		{"memsize=4096\n<<END\nEND\n", exitUsage, "line 2: key cannot be empty"},
+		{"memsize=4096\n\njust a line\n", exitUsage, "line 3: invalid format"},
+		{"memsize=4096\nguestOS=notanos\n", exitError, "line 2: "},
+		{"memsize=4096\nnumvcpus=3\ncpuid.coresPerSocket=2\n", exitError, "cannot be divided"},
+	}
+	for _, test := range tests {
+		m := useMemFileSystem(t)
+		m.put("vm.vmx", memVMX, 0o644)
+		code, _, errs := runVMXToolInput(t, test.batch, "set", "vm.vmx", "--batch", "-")
+		if code != test.code || !strings.Contains(errs, test.err) {
+			t.Errorf("set --batch of %q exited with %d: %s\nwant %d with %q", test.batch, code, errs, test.code, test.err)
+		}
+		if got, _ := m.get("vm.vmx"); test.code != 0 && got != memVMX {
+			t.Errorf("set --batch of %q exited with %d but changed the file to:\n%s", test.batch, code, got)
+		}
+	}
+
+	if code, _, errs := runVMXTool(t, "set", "vm.vmx", "memsize=1", "--batch", "-"); code != exitUsage {
+		t.Errorf("set --batch with KEY=VALUE exited with %d: %s", code, errs)
+	}
+}
+
+func TestBackupKeepsMode(t *testing.T) {
+	for _, existing := range []bool{false, true} {
+		m := useMemFileSystem(t)
+		m.put("vm.vmx", memVMX, 0o600)
+		if existing {
+			m.put("vm.vmx.bak", "old backup\n", 0o644)
+		}
+		if code, _, errs := runVMXTool(t, "--backup", "set", "vm.vmx", "memsize=4096"); code != 0 {
+			t.Fatalf("set --backup failed with %d: %s", code, errs)
+		}
+		info, err := m.Stat("vm.vmx.bak")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode().Perm(); got != 0o600 {
+			t.Errorf("with an existing backup %v, the backup has mode %v, want %v", existing, got, fs.FileMode(0o600))
+		}
+		if got, _ := m.get("vm.vmx.bak"); got != memVMX {
+			t.Errorf("the backup holds %q, want the file before the change", got)
+		}
+	}
+
+	setOption(t, &globalOptions.BackupDir, "")
+	m := useMemFileSystem(t)
+	m.put("vm.vmx", memVMX, 0o640)
+	if code, _, errs := runVMXTool(t, "--backup-dir", "backups", "set", "vm.vmx", "memsize=4096"); code != 0 {
+		t.Fatalf("set --backup-dir failed with %d: %s", code, errs)
+	}
+	for _, name := range m.names() {
+		if !strings.HasPrefix(name, "backups") || !strings.HasSuffix(name, ".bak") {
+			continue
+		}
+		if info, err := m.Stat(name); err != nil || info.Mode().Perm() != 0o640 {
+			t.Errorf("backup %s has mode %v, want %v", name, info.Mode().Perm(), fs.FileMode(0o640))
+		}
+		return
+	}
+	t.Errorf("no backup under backups in %v", m.names())
+}
+
+func TestParseVMDKDescriptor(t *testing.T) {
+	tests := []struct {
+		file string
+		want vmdkDescriptor
+	}{
+		{"flat.vmdk", vmdkDescriptor{
+			CID:        "3a5d0c7e",
+			ParentCID:  noParentCID,
+			CreateType: "monolithicFlat",
+			Extents:    []vmdkExtent{{"RW", 41943040, "FLAT", "flat-flat.vmdk"}},
+		}},
+		{"sparse.vmdk", vmdkDescriptor{
+			CID:                "9f1b44c2",
+			ParentCID:          "3a5d0c7e",
+			CreateType:         "twoGbMaxExtentSparse",
+			ParentFileNameHint: "flat.vmdk",
+			Extents: []vmdkExtent{
+				{"RW", 8323072, "SPARSE", "sparse-s001.vmdk"},
+				{"RW", 8323072, "SPARSE", "sparse-s002.vmdk"},
+				{"RDONLY", 4096, "ZERO", ""},
+				{"NOACCESS", 2048, "SPARSE", "my disk-s003.vmdk"},
+			},
+		}},
+	}
+	for _, test := range tests {
+		data, err := os.ReadFile(filepath.Join("testdata", test.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := parseVMDKDescriptor(string(data))
+		if err != nil {
+			t.Errorf("%s: %v", test.file, err)
+			continue
+		}
+		if fmt.Sprint(*got) != fmt.Sprint(test.want) {
+			t.Errorf("%s parsed as %+v, want %+v", test.file, *got, test.want)
+		}
+	}
+}
+
+func TestParseVMDKDescriptorErrors(t *testing.T) {
+	for text, want := range map[string]string{
+		"version=1\ncreateType=\"monolithicFlat\"\n":    "no CID",
+		"CID=1\nRW 100 FLAT \"a.vmdk\" 0\nnot a line\n": "line 3: unrecognised",
+		"CID=1\nRW many FLAT \"a.vmdk\" 0\n":            "line 2: invalid extent size",
+		"CID=1\nRO 100 FLAT \"a.vmdk\" 0\n":             "line 2: unrecognised",
+	} {
+		if _, err := parseVMDKDescriptor(text); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("parsing %q gave %v, want an error with %q", text, err, want)
+		}
+	}
+}
+
+func TestReadVMDKDescriptorEmbedded(t *testing.T) {
+	text, err := os.ReadFile(filepath.Join("testdata", "flat.vmdk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A sparse extent has its header in the first sector and here its
+	// descriptor, padded with NULs, in the two after it
+	data := make([]byte, 4*sectorSize)
+	copy(data, sparseMagic)
+	binary.LittleEndian.PutUint64(data[sparseDescriptorOffset:], 1)
+	binary.LittleEndian.PutUint64(data[sparseDescriptorSize:], 2)
+	copy(data[sectorSize:], text)
+
+	m := useMemFileSystem(t)
+	m.put("disk.vmdk", string(data), 0o644)
+	desc, err := readVMDKDescriptor("disk.vmdk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.CID != "3a5d0c7e" || desc.CreateType != "monolithicFlat" || len(desc.Extents) != 1 {
+		t.Errorf("embedded descriptor parsed as %+v", *desc)
+	}
+
+	binary.LittleEndian.PutUint64(data[sparseDescriptorSize:], 0)
+	m.put("disk.vmdk", string(data), 0o644)
+	if _, err := readVMDKDescriptor("disk.vmdk"); err == nil || !strings.Contains(err.Error(), "no embedded descriptor") {
+		t.Errorf("a sparse extent without a descriptor gave %v", err)
+	}
+}
+
+func TestDiskChain(t *testing.T) {
+	fixtures := map[string]string{}
+	for _, name := range []string{"flat.vmdk", "sparse.vmdk"} {
+		data, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fixtures[name] = string(data)
+	}
+	t.Chdir(t.TempDir())
+	write := func(name, data string) {
+		if err := os.WriteFile(name, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("vm.vmx", memVMX+"scsi0:0.fileName = \"sparse.vmdk\"\n")
+	write("flat.vmdk", fixtures["flat.vmdk"])
+	write("sparse.vmdk", fixtures["sparse.vmdk"])
+
+	code, out, errs := runVMXTool(t, "disk-chain", "vm.vmx")
+	want := "scsi0:0:\n    sparse.vmdk (CID 9f1b44c2, twoGbMaxExtentSparse)\n    flat.vmdk (CID 3a5d0c7e, monolithicFlat)\n"
+	if code != 0 || out != want {
+		t.Errorf("disk-chain printed %q with %d, want %q: %s", out, code, want, errs)
+	}
+
+	write("flat.vmdk", strings.Replace(fixtures["flat.vmdk"], "CID=3A5D0C7E", "CID=12345678", 1))
+	code, out, _ = runVMXTool(t, "disk-chain", "vm.vmx")
+	if code != exitDifferent || !strings.Contains(out, "BROKEN: parentCID 3a5d0c7e does not match CID 12345678 of flat.vmdk") {
+		t.Errorf("disk-chain of a mismatched parent printed %q with %d", out, code)
+	}
+
+	if err := os.Remove("flat.vmdk"); err != nil {
+		t.Fatal(err)
+	}
+	code, out, _ = runVMXTool(t, "disk-chain", "vm.vmx")
+	if code != exitDifferent || !strings.Contains(out, "BROKEN") {
+		t.Errorf("disk-chain of a missing parent printed %q with %d", out, code)
+	}
+}
+
+// useFixtures makes files a new memFileSystem holding the named files
+// from testdata, under the same names
+func useFixtures(t *testing.T, names ...string) *memFileSystem {
+	t.Helper()
+	var data []string
+	for _, name := range names {
+		d, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, string(d))
+	}
+	m := useMemFileSystem(t)
+	for i, name := range names {
+		m.put(name, data[i], 0o644)
+	}
+	return m
+}
+
+func TestEncodingFixtures(t *testing.T) {
+	tests := []struct {
+		file, encoding, displayName string
+	}{
+		{"encoding-utf8.vmx", "UTF-8", "Café €"},
+		{"encoding-windows-1252.vmx", "windows-1252", "Café €"},
+		{"no-encoding-utf8.vmx", "", "Café €"},
+		{"no-encoding-windows-1252.vmx", "windows-1252", "Café €"},
+		// The directive is followed even when the bytes say otherwise
+		{"encoding-mismatch.vmx", "windows-1252", "CafÃ©"},
+	}
+	for _, test := range tests {
+		m := useFixtures(t, test.file)
+		original, _ := m.get(test.file)
+		dict, err := LoadDictionary(test.file)
+		if err != nil {
+			t.Fatalf("%s: %v", test.file, err)
+		}
+		if dict.Encoding != test.encoding {
+			t.Errorf("%s has encoding %q, want %q", test.file, dict.Encoding, test.encoding)
+		}
+		if got, _ := dict.QueryOK("displayName"); got != test.displayName {
+			t.Errorf("%s has displayName %q, want %q", test.file, got, test.displayName)
+		}
+
+		// A change that the encoding can hold keeps every other byte
+		dict.Set("memsize", "4096")
+		if err := dict.Save(test.file); err != nil {
+			t.Fatal(err)
+		}
+		want := strings.Replace(original, `memsize = "2048"`, `memsize = "4096"`, 1)
+		if got, _ := m.get(test.file); got != want {
+			t.Errorf("%s saved as %q, want %q", test.file, got, want)
+		}
+	}
+}
+
+func TestEncodingUnrepresentable(t *testing.T) {
+	for _, file := range []string{"encoding-windows-1252.vmx", "no-encoding-windows-1252.vmx"} {
+		m := useFixtures(t, file)
+		code, _, errs := runVMXTool(t, "set", file, "annotation=日本")
+		if code != 0 {
+			t.Fatalf("set in %s failed with %d: %s", file, code, errs)
+		}
+		if !strings.Contains(errs, "cannot be represented in windows-1252, saving as UTF-8") {
+			t.Errorf("set in %s did not warn of the change of encoding: %q", file, errs)
+		}
+		got, _ := m.get(file)
+		if !utf8.ValidString(got) {
+			t.Errorf("%s was not saved as UTF-8: %q", file, got)
+		}
+		dict, err := LoadDictionary(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value, _ := dict.QueryOK("displayName"); value != "Café €" {
+			t.Errorf("%s has displayName %q after saving as UTF-8", file, value)
+		}
+		if directive, ok := dict.QueryOK(".encoding"); strings.HasPrefix(file, "encoding") && (!ok || directive != "UTF-8") {
+			t.Errorf("%s has .encoding %q, want UTF-8 to match its content", file, directive)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in          string
+		defaultUnit int64
+		want        int64
+	}{
+		{"512", 1, 512},
+		{"512", kilobyte, 512 * 1024},
+		{"128", megabyte, 128 * 1024 * 1024},
+		{"128M", 1, 134217728},
+		{"128mb", 1, 134217728},
+		{"2G", megabyte, 2147483648},
+		{"2 GB", 1, 2147483648},
+		{"1T", 1, 1 << 40},
+		{"64K", megabyte, 65536},
+		{"64KB", 1, 65536},
+		{"100B", megabyte, 100},
+		{"0", megabyte, 0},
+	}
+	for _, test := range tests {
+		got, err := parseSize(test.in, test.defaultUnit)
+		if err != nil || got != test.want {
+			t.Errorf("parseSize(%q, %d) = %d, %v, want %d", test.in, test.defaultUnit, got, err, test.want)
+		}
+	}
+	for _, in := range []string{"", "M", "-1", "1.5G", "twelve", "9999999T"} {
+		if got, err := parseSize(in, 1); err == nil {
+			t.Errorf("parseSize(%q) = %d, want an error", in, got)
+		}
+	}
+}
+
+func TestResourceValidation(t *testing.T) {
+	for _, test := range []struct {
+		mb int64
+		ok bool
+	}{
+		{4, true}, {2048, true}, {8192, true}, {4096 + 4, true},
+		{0, false}, {-4, false}, {1, false}, {2, false}, {3, false}, {2050, false},
+	} {
+		if err := validateMemory(test.mb); (err == nil) != test.ok {
+			t.Errorf("validateMemory(%d) = %v, want ok %t", test.mb, err, test.ok)
+		}
+	}
+
+	for _, test := range []struct {
+		cpus, cores int
+		ok          bool
+	}{
+		{1, 1, true}, {4, 2, true}, {6, 3, true}, {128, 8, true}, {8, 8, true},
+		{0, 1, false}, {-1, 1, false}, {129, 1, false},
+		{6, 4, false}, {3, 2, false}, {4, 8, false}, {4, 0, false},
+	} {
+		if err := validateTopology(test.cpus, test.cores); (err == nil) != test.ok {
+			t.Errorf("validateTopology(%d, %d) = %v, want ok %t", test.cpus, test.cores, err, test.ok)
+		}
+		if test.cores == 1 {
+			if err := validateCPUs(test.cpus); (err == nil) != test.ok {
+				t.Errorf("validateCPUs(%d) = %v, want ok %t", test.cpus, err, test.ok)
+			}
+		}
+	}
+
+	// The resources and set commands check the rules before writing
+	for _, test := range []struct {
+		args []string
+		code int
+	}{
+		{[]string{"resources", "vm.vmx", "--memory", "8G", "--cpus", "4", "--cores-per-socket", "2"}, 0},
+		{[]string{"resources", "vm.vmx", "--memory", "2049"}, exitError},
+		{[]string{"resources", "vm.vmx", "--memory", "0"}, exitError},
+		{[]string{"resources", "vm.vmx", "--cpus", "129"}, exitError},
+		{[]string{"resources", "vm.vmx", "--cpus", "0"}, exitError},
+		{[]string{"resources", "vm.vmx", "--cpus", "6", "--cores-per-socket", "4"}, exitError},
+		{[]string{"resources", "vm.vmx", "--cores-per-socket", "2"}, exitError}, // numvcpus is 1
+		{[]string{"set", "vm.vmx", "memsize=2"}, exitError},                     // below the minimum of 4
+		{[]string{"set", "--validate-resources", "--strict", "vm.vmx", "memsize=2050"}, exitError},
+		{[]string{"set", "vm.vmx", "numvcpus=129"}, exitError},
+		{[]string{"set", "vm.vmx", "cpuid.coresPerSocket=2"}, exitError},
+		{[]string{"set", "vm.vmx", "memsize=4096"}, 0},
+	} {
+		m := useMemFileSystem(t)
+		m.put("vm.vmx", memVMX, 0o644)
+		code, _, errs := runVMXTool(t, test.args...)
+		if code != test.code {
+			t.Errorf("%q exited with %d, want %d: %s", test.args, code, test.code, errs)
+		}
+		if got, _ := m.get("vm.vmx"); code != 0 && got != memVMX {
+			t.Errorf("%q failed but changed the file to:\n%s", test.args, got)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for n, want := range map[int64]string{
+		0:                  "0 bytes",
+		1000:               "1000 bytes",
+		1024:               "1 KB",
+		1536:               "1536 bytes",
+		1536 * 1024:        "1536 KB",
+		128 * megabyte:     "128 MB",
+		3 * gigabyte:       "3 GB",
+		gigabyte + 1024:    "1048577 KB",
+		2 * terabyte:       "2 TB",
+		8388608 * kilobyte: "8 GB",
+	} {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestGraphicsUnits(t *testing.T) {
+	m := useMemFileSystem(t)
+	m.put("vm.vmx", memVMX, 0o644)
+	// svga.vramSize is in bytes and svga.graphicsMemoryKB in KB, whatever
+	// unit the size is given in
+	code, _, errs := runVMXTool(t, "graphics", "vm.vmx", "--3d", "on", "--vram", "128", "--gfx-memory", "2G")
+	if code != 0 {
+		t.Fatalf("graphics failed with %d: %s", code, errs)
+	}
+	dict, err := LoadDictionary("vm.vmx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{
+		"svga.vramSize":         "134217728",
+		"svga.graphicsMemoryKB": "2097152",
+		"mks.enable3d":          "TRUE",
+		"svga.autodetect":       "FALSE",
+	} {
+		if got, _ := dict.QueryOK(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+
+	code, out, _ := runVMXTool(t, "graphics", "vm.vmx")
+	for _, want := range []string{"128 MB (134217728 bytes)", "2 GB (2097152 KB)"} {
+		if code != 0 || !strings.Contains(out, want) {
+			t.Errorf("graphics status printed %q with %d, want %q", out, code, want)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"--vram", "256M"},
+		{"--vram", "0"},
+		{"--gfx-memory", "9G"},
+		{"--gfx-memory", "1000B"},
+	} {
+		if code, _, errs := runVMXTool(t, append([]string{"graphics", "vm.vmx"}, args...)...); code != exitError {
+			t.Errorf("graphics %v exited with %d, want %d: %s", args, code, exitError, errs)
+		}
+	}
+}
+
+func TestQueryDuplicates(t *testing.T) {
+	useFixtures(t, "duplicates.vmx")
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"query", "duplicates.vmx", "memsize"}, "1024\n"},
+		{[]string{"query", "--last", "duplicates.vmx", "memsize"}, "4096\n"},
+		{[]string{"query", "duplicates.vmx", "MEMSIZE", "--last"}, "4096\n"},
+		{[]string{"query", "--last", "duplicates.vmx", "numvcpus"}, "2\n"},
+	}
+	for _, test := range tests {
+		if code, out, errs := runVMXTool(t, test.args...); code != 0 || out != test.want {
+			t.Errorf("%v printed %q with %d, want %q: %s", test.args, out, code, test.want, errs)
+		}
+	}
+
+	dict, err := LoadDictionary("duplicates.vmx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := dict.QueryOK("memsize"); got != "1024" {
+		t.Errorf("memsize is %q by default, want the first, 1024", got)
+	}
+	dict.LastWins = true
+	if got, _ := dict.QueryOK("memsize"); got != "4096" {
+		t.Errorf("memsize is %q with LastWins, want the last, 4096", got)
+	}
+}
+
+func TestTimeSync(t *testing.T) {
+	allOff := "tools.syncTime = \"FALSE\"\n" +
+		"time.synchronize.continue = \"FALSE\"\n" +
+		"time.synchronize.restore = \"FALSE\"\n" +
+		"time.synchronize.resume.disk = \"FALSE\"\n" +
+		"time.synchronize.shrink = \"FALSE\"\n" +
+		"time.synchronize.tools.startup = \"FALSE\"\n"
+	tests := []struct {
+		name    string
+		keys    string
+		state   string
+		warning string
+	}{
+		{"defaults", "", "on (VMware defaults)", ""},
+		{"off", allOff, "off", ""},
+		{"only syncTime", "tools.syncTime = \"FALSE\"\n", "partial", "Missing: time.synchronize.continue, time.synchronize.restore"},
+		{"one TRUE", strings.Replace(allOff, "shrink = \"FALSE\"", "shrink = \"TRUE\"", 1), "partial", "Not FALSE: time.synchronize.shrink"},
+		{"missing and invalid", "tools.syncTime = \"maybe\"\ntime.synchronize.restore = \"FALSE\"\n", "partial", "Not FALSE: tools.syncTime"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := useMemFileSystem(t)
+			m.put("vm.vmx", memVMX+test.keys, 0o644)
+
+			code, out, errs := runVMXTool(t, "timesync", "vm.vmx")
+			if code != 0 || !regexp.MustCompile(`(?m)^Time synchronization:\s+`+regexp.QuoteMeta(test.state)+`$`).MatchString(out) {
+				t.Errorf("status printed %q with %d, want state %q", out, code, test.state)
+			}
+			if test.warning == "" && errs != "" {
+				t.Errorf("status of a complete state warned: %s", errs)
+			}
+			if !strings.Contains(errs, test.warning) || (test.state == "partial" && !strings.Contains(errs, "only partly disabled")) {
+				t.Errorf("status warned %q, want %q", errs, test.warning)
+			}
+
+			if code, _, errs := runVMXTool(t, "timesync", "vm.vmx", "off"); code != 0 {
+				t.Fatalf("timesync off failed with %d: %s", code, errs)
+			}
+			dict, err := LoadDictionary("vm.vmx")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, key := range timeSyncKeys {
+				if value, _ := dict.QueryOK(key); value != "FALSE" {
+					t.Errorf("after timesync off %s = %q, want FALSE", key, value)
+				}
+			}
+			if _, out, errs := runVMXTool(t, "timesync", "vm.vmx", "status"); !strings.HasSuffix(out, " off\n") || errs != "" {
+				t.Errorf("status after off printed %q: %s", out, errs)
+			}
+
+			if code, _, errs := runVMXTool(t, "timesync", "vm.vmx", "on"); code != 0 {
+				t.Fatalf("timesync on failed with %d: %s", code, errs)
+			}
+			if got, _ := m.get("vm.vmx"); got != memVMX {
+				t.Errorf("timesync on saved:\n%s\nwant every time key removed:\n%s", got, memVMX)
+			}
+		})
+	}
+}
+
+func TestLongLines(t *testing.T) {
+	// Several hundred KB, well past the 64 KB bufio.Scanner default
+	value := strings.Repeat("0123456789abcdef", 40*1024)
+	setOption(t, &globalOptions.MaxLineSize, 0)
+	m := useMemFileSystem(t)
+	m.put("vm.vmx", memVMX+`nvram.blob = "`+value+"\"\n"+`numvcpus = "2"`+"\n", 0o644)
+
+	dict, err := LoadDictionary("vm.vmx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := dict.QueryOK("nvram.blob"); got != value {
+		t.Errorf("nvram.blob has %d bytes, want %d", len(got), len(value))
+	}
+	if got, _ := dict.QueryOK("numvcpus"); got != "2" {
+		t.Errorf("numvcpus after the long line is %q, want 2", got)
+	}
+
+	// The long value survives a change to another key
+	if code, _, errs := runVMXTool(t, "set", "vm.vmx", "memsize=4096"); code != 0 {
+		t.Fatalf("set failed with %d: %s", code, errs)
+	}
+	if got, _ := m.get("vm.vmx"); !strings.Contains(got, `nvram.blob = "`+value+`"`) {
+		t.Errorf("the long value was not saved unchanged")
+	}
+
+	code, _, errs := runVMXTool(t, "--max-line-size", "256K", "query", "vm.vmx", "memsize")
+	if code != exitFileError || !strings.Contains(errs, "line 5 is longer than 256 KB, use --max-line-size") {
+		t.Errorf("query with a smaller --max-line-size exited with %d: %s", code, errs)
+	}
+	if code, out, errs := runVMXTool(t, "--max-line-size", "1M", "query", "vm.vmx", "memsize"); code != 0 || out != "4096\n" {
+		t.Errorf("query with --max-line-size 1M printed %q with %d: %s", out, code, errs)
+	}
+}
+
+func TestNoFinalNewline(t *testing.T) {
+	for _, file := range []string{"no-final-newline.vmx", "no-final-newline-comment.vmx"} {
+		m := useFixtures(t, file)
+		original, _ := m.get(file)
+
+		dict, err := LoadDictionary(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !dict.NoFinalNewline {
+			t.Errorf("%s was not seen to lack a final newline", file)
+		}
+		if err := dict.Save(file); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := m.get(file); got != original {
+			t.Errorf("%s saved unchanged as %q, want %q", file, got, original)
+		}
+
+		// A changed last line and an added key keep it that way
+		if code, _, errs := runVMXTool(t, "set", file, "memsize=4096"); code != 0 {
+			t.Fatalf("set failed with %d: %s", code, errs)
+		}
+		if code, _, errs := runVMXTool(t, "set", file, "numvcpus=2"); code != 0 {
+			t.Fatalf("set failed with %d: %s", code, errs)
+		}
+		got, _ := m.get(file)
+		want := strings.Replace(original, `memsize = "2048"`, `memsize = "4096"`, 1) + "\n" + `numvcpus = "2"`
+		if got != want {
+			t.Errorf("%s saved as %q, want %q", file, got, want)
+		}
+	}
+
+	m := useFixtures(t, "no-final-newline.vmx")
+	m.put("newline.vmx", memVMX, 0o644)
+	if code, _, errs := runVMXTool(t, "set", "newline.vmx", "numvcpus=2"); code != 0 {
+		t.Fatalf("set failed with %d: %s", code, errs)
+	}
+	if got, _ := m.get("newline.vmx"); got != memVMX+`numvcpus = "2"`+"\n" {
+		t.Errorf("a file with a final newline saved as %q", got)
+	}
+}
+
+func TestGuestinfoRoundTrip(t *testing.T) {
+	// A cloud-init style payload of several KB, with newlines and bytes
+	// that are not UTF-8
+	var sb strings.Builder
+	sb.WriteString("#cloud-config\nwrite_files:\n")
+	for i := range 200 {
+		fmt.Fprintf(&sb, "  - path: /etc/motd.d/%03d\n    content: \"line %d with | # and quotes\"\n", i, i)
+	}
+	payload := sb.String() + "\x00\xff\xfe binary tail\n"
+	if len(payload) < 8*1024 {
+		t.Fatalf("payload is only %d bytes", len(payload))
+	}
+
+	for _, flags := range [][]string{{"--base64"}, {"--gzip"}} {
+		m := useMemFileSystem(t)
+		m.put("vm.vmx", memVMX, 0o644)
+		m.put("user-data", payload, 0o644)
+		args := slices.Concat([]string{"guestinfo", "set", "vm.vmx", "userdata", "--from", "user-data"}, flags)
+		if code, _, errs := runVMXTool(t, args...); code != 0 {
+			t.Fatalf("guestinfo set %v failed with %d: %s", flags, code, errs)
+		}
+
+		dict, err := LoadDictionary("vm.vmx")
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoding := map[string]string{"--base64": "base64", "--gzip": "gzip+base64"}[flags[0]]
+		if got, _ := dict.QueryOK("guestinfo.userdata.encoding"); got != encoding {
+			t.Errorf("with %v guestinfo.userdata.encoding = %q, want %q", flags, got, encoding)
+		}
+		value, _ := dict.QueryOK("guestinfo.userdata")
+		if flags[0] == "--gzip" && len(value) >= len(payload) {
+			t.Errorf("gzip did not shrink the payload: %d bytes for %d", len(value), len(payload))
+		}
+		decoded, err := decodeGuestinfo(value, encoding)
+		if err != nil || string(decoded) != payload {
+			t.Errorf("with %v decoding gave %d bytes, %v, want the %d byte payload", flags, len(decoded), err, len(payload))
+		}
+
+		code, out, errs := runVMXTool(t, "guestinfo", "get", "vm.vmx", "userdata", "--decode")
+		if code != 0 || out != payload {
+			t.Errorf("guestinfo get --decode after %v printed %d bytes with %d, want the payload: %s", flags, len(out), code, errs)
+		}
+	}
+
+	m := useMemFileSystem(t)
+	m.put("vm.vmx", memVMX, 0o644)
+	m.put("user-data", payload, 0o644)
+	if code, _, errs := runVMXTool(t, "guestinfo", "set", "vm.vmx", "userdata", "--from", "user-data"); code != exitError || !strings.Contains(errs, "use --base64 or --gzip") {
+		t.Errorf("guestinfo set of a raw binary payload exited with %d: %s", code, errs)
+	}
+	m.put("vm.vmx", memVMX+`tools.setinfo.sizeLimit = "4096"`+"\n", 0o644)
+	if code, _, errs := runVMXTool(t, "guestinfo", "set", "vm.vmx", "userdata", "--from", "user-data", "--base64"); code != 0 || !strings.Contains(errs, "exceeds tools.setinfo.sizeLimit of 4 KB") {
+		t.Errorf("guestinfo set over the size limit exited with %d: %s", code, errs)
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	m := useFixtures(t, "snapshots.vmsd")
+	m.put("snapshots.vmx", memVMX+`scsi0:0.fileName = "snapshots.vmdk"`+"\n", 0o644)
+
+	snapshots, current, err := loadSnapshots("snapshots.vmx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 4 || current != "3" {
+		t.Fatalf("loaded %d snapshots with current %q, want 4 with current 3", len(snapshots), current)
+	}
+	chains := map[string]string{
+		"1": "Base install",
+		"2": "Base install > Patched",
+		"3": "Base install > Patched > Before upgrade",
+		"4": "Base install > Test branch",
+	}
+	for _, s := range snapshots {
+		if got := strings.Join(snapshotChain(s, snapshots), " > "); got != chains[s.UID] {
+			t.Errorf("snapshot %s has chain %q, want %q", s.UID, got, chains[s.UID])
+		}
+	}
+
+	code, out, errs := runVMXTool(t, "snapshots", "snapshots.vmx")
+	if code != 0 {
+		t.Fatalf("snapshots failed with %d: %s", code, errs)
+	}
+	for _, want := range []string{"UID 3 (current):", "Base install > Patched > Before upgrade", "UID 4:"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("snapshots printed:\n%s\nwant %q", out, want)
+		}
+	}
+
+	// Changing a disk warns, or with --strict fails, and other keys do not
+	if code, _, errs := runVMXTool(t, "set", "snapshots.vmx", "scsi0:0.fileName=other.vmdk"); code != 0 || !strings.Contains(errs, "the VM has 4 snapshots and changing scsi0:0.fileName can break them") {
+		t.Errorf("set of a disk exited with %d: %s", code, errs)
+	}
+	before, _ := m.get("snapshots.vmx")
+	if code, _, errs := runVMXTool(t, "set", "--strict", "snapshots.vmx", "virtualHW.version=21"); code != exitError || !strings.Contains(errs, "can break them") {
+		t.Errorf("set --strict of virtualHW.version exited with %d: %s", code, errs)
+	}
+	if got, _ := m.get("snapshots.vmx"); got != before {
+		t.Errorf("set --strict changed the file to:\n%s", got)
+	}
+	if code, _, errs := runVMXTool(t, "set", "--strict", "snapshots.vmx", "memsize=4096"); code != 0 || errs != "" {
+		t.Errorf("set --strict of memsize exited with %d: %s", code, errs)
+	}
+}
+
+func TestUSB(t *testing.T) {
+	usb2 := `usb.present = "TRUE"` + "\n" + `ehci.present = "TRUE"` + "\n"
+	usb3 := usb2 + `usb_xhci.present = "TRUE"` + "\n"
+	tests := []struct {
+		name    string
+		keys    string
+		args    []string
+		want    map[string]string // "" for a key that must be absent
+		warning string
+	}{
+		{"upgrade from none", `virtualHW.version = "21"` + "\n", []string{"--version", "3.1"},
+			map[string]string{"usb.present": "TRUE", "ehci.present": "TRUE", "usb_xhci.present": "TRUE"}, ""},
+		{"upgrade from 2", `virtualHW.version = "21"` + "\n" + usb2, []string{"--version", "3.1", "--autoconnect", "off"},
+			map[string]string{"usb.present": "TRUE", "ehci.present": "TRUE", "usb_xhci.present": "TRUE", "usb.generic.autoconnect": "FALSE"}, ""},
+		{"upgrade on old hardware", `virtualHW.version = "7"` + "\n", []string{"--version", "3.1"},
+			map[string]string{"usb_xhci.present": "TRUE"}, "USB 3.1 requires hardware version"},
+		{"downgrade", usb3, []string{"--version", "2"},
+			map[string]string{"usb.present": "TRUE", "ehci.present": "TRUE", "usb_xhci.present": ""}, ""},
+		{"disable", usb3 + `usb.generic.autoconnect = "TRUE"` + "\n", []string{"off"},
+			map[string]string{"usb.present": "FALSE", "ehci.present": "FALSE", "usb_xhci.present": "FALSE", "usb.generic.autoconnect": "TRUE"}, ""},
+		{"disable USB 2", usb2, []string{"off"},
+			map[string]string{"usb.present": "FALSE", "ehci.present": "FALSE", "usb_xhci.present": ""}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := useMemFileSystem(t)
+			m.put("vm.vmx", memVMX+test.keys, 0o644)
+			code, _, errs := runVMXTool(t, append([]string{"usb", "vm.vmx"}, test.args...)...)
+			if code != 0 {
+				t.Fatalf("usb %v failed with %d: %s", test.args, code, errs)
+			}
+			if test.warning == "" && errs != "" || !strings.Contains(errs, test.warning) {
+				t.Errorf("usb %v warned %q, want %q", test.args, errs, test.warning)
+			}
+			dict, err := LoadDictionary("vm.vmx")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, want := range test.want {
+				if got, _ := dict.QueryOK(key); got != want {
+					t.Errorf("%s = %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+
+	m := useMemFileSystem(t)
+	m.put("vm.vmx", memVMX+usb3, 0o644)
+	if code, out, _ := runVMXTool(t, "usb", "vm.vmx"); code != 0 || !regexp.MustCompile(`Highest version:\s+USB 3.1 \(xHCI\)`).MatchString(out) {
+		t.Errorf("usb status printed %q with %d", out, code)
+	}
+	for _, args := range [][]string{{"off", "--version", "2"}, {"--version", "4"}, {"--autoconnect", "maybe"}} {
+		if code, _, _ := runVMXTool(t, append([]string{"usb", "vm.vmx"}, args...)...); code != exitUsage {
+			t.Errorf("usb %v exited with %d, want %d", args, code, exitUsage)
+		}
+	}
+	if got, _ := m.get("vm.vmx"); got != memVMX+usb3 {
+		t.Errorf("a usage error changed the file to:\n%s", got)
+	}
+}
+
+func TestNested(t *testing.T) {
+	const hw = `virtualHW.version = "21"` + "\n"
+	for mask := range 1 << len(nestedConflicts) {
+		var keys string
+		var present []string
+		for i, conflict := range nestedConflicts {
+			if mask&(1<<i) != 0 {
+				keys += fmt.Sprintf("%s = \"%s\"\n", conflict.Key, conflict.Value)
+				present = append(present, conflict.Key)
+			}
+		}
+		for _, fix := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%v/fix=%v", present, fix), func(t *testing.T) {
+				m := useMemFileSystem(t)
+				m.put("vm.vmx", memVMX+hw+keys, 0o644)
+
+				code, out, _ := runVMXTool(t, "nested", "vm.vmx")
+				if code != 0 {
+					t.Fatalf("nested status exited with %d", code)
+				}
+				if got := strings.Count(out, "Conflict:"); got != len(present) {
+					t.Errorf("status reported %d conflicts, want %d:\n%s", got, len(present), out)
+				}
+				if len(present) == 0 && !regexp.MustCompile(`Conflicts:\s+none`).MatchString(out) {
+					t.Errorf("status did not report no conflicts:\n%s", out)
+				}
+
+				args := []string{"nested", "vm.vmx", "on"}
+				if fix {
+					args = append(args, "--fix")
+				}
+				code, _, errs := runVMXTool(t, args...)
+				if code != 0 {
+					t.Fatalf("%v exited with %d: %s", args, code, errs)
+				}
+				dict, err := LoadDictionary("vm.vmx")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, _ := dict.QueryOK("vhv.enable"); got != "TRUE" {
+					t.Errorf("vhv.enable = %q, want TRUE", got)
+				}
+				for _, key := range present {
+					_, ok := dict.QueryOK(key)
+					if ok == fix {
+						t.Errorf("%s present = %v with --fix = %v", key, ok, fix)
+					}
+					if !strings.Contains(errs, key) {
+						t.Errorf("%s was not reported:\n%s", key, errs)
+					}
+				}
+				if got := strings.Contains(errs, "--fix"); got != (len(present) > 0 && !fix) {
+					t.Errorf("--fix hint shown = %v:\n%s", got, errs)
+				}
+
+				if code, _, _ := runVMXTool(t, "nested", "vm.vmx", "off"); code != 0 {
+					t.Fatalf("nested off exited with %d", code)
+				}
+				dict, err = LoadDictionary("vm.vmx")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, ok := dict.QueryOK("vhv.enable"); ok {
+					t.Error("nested off left vhv.enable in the file")
+				}
+				for _, key := range present {
+					if _, ok := dict.QueryOK(key); ok == fix {
+						t.Errorf("nested off changed %s", key)
+					}
+				}
+			})
+		}
+	}
+
+	t.Run("non-conflicting values", func(t *testing.T) {
+		m := useMemFileSystem(t)
+		keys := `hypervisor.cpuid.v0 = "TRUE"` + "\n" + `vpmc.enable = "FALSE"` + "\n" + `monitor.allowLegacyCPU = "TRUE"` + "\n"
+		m.put("vm.vmx", memVMX+hw+keys, 0o644)
+		code, out, _ := runVMXTool(t, "nested", "vm.vmx")
+		if code != 0 || !regexp.MustCompile(`Conflicts:\s+none`).MatchString(out) ||
+			!regexp.MustCompile(`Override:\s+monitor.allowLegacyCPU = "TRUE"`).MatchString(out) {
+			t.Errorf("nested status printed %q with %d", out, code)
+		}
+		if code, _, errs := runVMXTool(t, "nested", "vm.vmx", "on", "--fix"); code != 0 || errs != "" {
+			t.Errorf("nested on exited with %d: %s", code, errs)
+		}
+		if got, _ := m.get("vm.vmx"); got != memVMX+hw+keys+`vhv.enable = "TRUE"`+"\n" {
+			t.Errorf("nested on --fix changed non-conflicting keys:\n%s", got)
+		}
+	})
+
+	t.Run("old hardware", func(t *testing.T) {
+		m := useMemFileSystem(t)
+		m.put("vm.vmx", memVMX, 0o644)
+		if code, _, errs := runVMXTool(t, "nested", "vm.vmx", "on"); code != exitError || !strings.Contains(errs, "hardware version") {
+			t.Errorf("nested on without virtualHW.version exited with %d: %s", code, errs)
+		}
+		if got, _ := m.get("vm.vmx"); got != memVMX {
+			t.Errorf("nested on changed the file on old hardware:\n%s", got)
+		}
+	})
+}
+
+func TestSetAllDupes(t *testing.T) {
+	original, err := os.ReadFile(filepath.Join("testdata", "duplicates.vmx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	replace := func(values ...string) string {
+		s := string(original)
+		for i, old := range []string{`memsize = "1024"`, `MemSize = "2048"`, `memsize = "4096"`} {
+			s = strings.Replace(s, old, old[:strings.Index(old, `"`)]+`"`+values[i]+`"`, 1)
+		}
+		return s
+	}
+	tests := []struct {
+		args    []string
+		want    string
+		warning bool
+	}{
+		{[]string{"set", "duplicates.vmx", "memsize=8192"}, replace("8192", "2048", "4096"), true},
+		{[]string{"set", "--all-dupes", "duplicates.vmx", "memsize=8192"}, replace("8192", "8192", "8192"), false},
+		{[]string{"set", "--all-dupes", "duplicates.vmx", "MEMSIZE=1024"}, replace("1024", "1024", "1024"), false},
+		{[]string{"set", "--all-dupes", "duplicates.vmx", "numvcpus=4"}, strings.Replace(string(original), `numvcpus = "2"`, `numvcpus = "4"`, 1), false},
+	}
+	for _, test := range tests {
+		m := useFixtures(t, "duplicates.vmx")
+		code, _, errs := runVMXTool(t, test.args...)
+		if code != 0 {
+			t.Fatalf("%v exited with %d: %s", test.args, code, errs)
+		}
+		if got, _ := m.get("duplicates.vmx"); got != test.want {
+			t.Errorf("%v wrote:\n%s\nwant:\n%s", test.args, got, test.want)
+		}
+		if got := strings.Contains(errs, "--all-dupes"); got != test.warning {
+			t.Errorf("%v warned %q", test.args, errs)
+		}
+	}
+
+	m := useFixtures(t, "duplicates.vmx")
+	m.put("duplicates.vmx", replace("8192", "8192", "8192"), 0o644)
+	if code, _, _ := runVMXTool(t, "set", "--all-dupes", "--require-change", "duplicates.vmx", "memsize=8192"); code != exitUnchanged {
+		t.Errorf("set --all-dupes of an unchanged value exited with %d, want %d", code, exitUnchanged)
+	}
+	m.put("duplicates.vmx", replace("8192", "2048", "8192"), 0o644)
+	if code, _, _ := runVMXTool(t, "set", "--all-dupes", "--require-change", "duplicates.vmx", "memsize=8192"); code != 0 {
+		t.Errorf("set --all-dupes with one stale copy exited with %d", code)
+	}
+	if got, _ := m.get("duplicates.vmx"); got != replace("8192", "8192", "8192") {
+		t.Errorf("set --all-dupes left a stale copy:\n%s", got)
+	}
+}
+
+func TestShebangStaysFirst(t *testing.T) {
+	const shebang = "#!/usr/bin/vmware\n"
+	tests := [][]string{
+		{"sort", "shebang.vmx"},
+		{"--sort-on-save", "set", "shebang.vmx", "annotation=sorted"},
+		{"--vmware-compat", "set", "shebang.vmx", "annotation=canonical"},
+		{"--vmware-compat", "sort", "shebang.vmx"},
+		{"--vmware-compat", "--sort-on-save", "remove", "shebang.vmx", "numvcpus"},
+	}
+	for _, args := range tests {
+		m := useFixtures(t, "shebang.vmx")
+		if code, _, errs := runVMXTool(t, args...); code != 0 {
+			t.Fatalf("%v exited with %d: %s", args, code, errs)
+		}
+		got, _ := m.get("shebang.vmx")
+		if !strings.HasPrefix(got, shebang) || strings.Count(got, "#!") != 1 {
+			t.Errorf("%v did not keep the #! line first:\n%s", args, got)
+		}
+		if slices.Contains(args, "--vmware-compat") && strings.Contains(got, "# ") {
+			t.Errorf("%v kept other comments in canonical format:\n%s", args, got)
+		}
+	}
+
+	// Only the first line is held in place; a #! comment further down
+	// moves with the key that follows it.
+	m := useMemFileSystem(t)
+	m.put("vm.vmx", "zeta = \"1\"\n#!/usr/bin/vmware\nalpha = \"2\"\n", 0o644)
+	if code, _, errs := runVMXTool(t, "sort", "vm.vmx"); code != 0 {
+		t.Fatalf("sort exited with %d: %s", code, errs)
+	}
+	if got, _ := m.get("vm.vmx"); got != "#!/usr/bin/vmware\nalpha = \"2\"\nzeta = \"1\"\n" {
+		t.Errorf("sort wrote:\n%s", got)
+	}
+}
+
+func TestCommentBytesPreserved(t *testing.T) {
+	original, err := os.ReadFile(filepath.Join("testdata", "comments.vmx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every comment line, and the inline comment of every key line, that a
+	// command must write back unchanged, with the whitespace before it
+	var comments []string
+	for line := range strings.Lines(string(original)) {
+		switch {
+		case strings.HasPrefix(strings.TrimSpace(line), "#"):
+			comments = append(comments, line)
+		case strings.Contains(line, "#"):
+			comments = append(comments, line[strings.LastIndex(line, `"`)+1:])
+		}
+	}
+
+	tests := [][]string{
+		{"set", "comments.vmx", "memsize=4096"},
+		{"set", "comments.vmx", "numvcpus=2"},
+		{"remove", "comments.vmx", "guestOS"},
+		{"sort", "comments.vmx"},
+		{"--sort-on-save", "set", "comments.vmx", "annotation=sorted"},
+		{"normalize-keys", "comments.vmx", "--style", "lower"},
+		{"append", "comments.vmx", "annotation", "token"},
+		{"replace-value", "comments.vmx", "2048", "8192"},
+		{"set-hw-version", "--force", "comments.vmx", "21"},
+	}
+	for _, args := range tests {
+		m := useFixtures(t, "comments.vmx")
+		if code, _, errs := runVMXTool(t, args...); code != 0 {
+			t.Fatalf("%v exited with %d: %s", args, code, errs)
+		}
+		got, _ := m.get("comments.vmx")
+		if got == string(original) {
+			t.Errorf("%v did not change the file", args)
+		}
+		for _, line := range comments {
+			if !strings.Contains(got, line) {
+				t.Errorf("%v changed the comment %q:\n%s", args, line, got)
+			}
+		}
+	}
+
+	useFixtures(t, "comments.vmx")
+	code, out, _ := runVMXTool(t, "comments", "--inline", "comments.vmx")
+	if code != 0 || !strings.Contains(out, "2: #\t+------------------+\n") ||
+		!strings.Contains(out, "4: #\t+------------------+  \n") ||
+		!strings.Contains(out, "6: displayName # name\tshown in the library \t\n") {
+		t.Errorf("comments --inline printed %q with %d", out, code)
+	}
+	code, out, _ = runVMXTool(t, "comments", "--inline", "--trim", "comments.vmx")
+	if code != 0 || !strings.Contains(out, "4: #\t+------------------+\n") ||
+		!strings.Contains(out, "5: # indented with a tab\n") ||
+		!strings.Contains(out, "6: displayName # name\tshown in the library\n") {
+		t.Errorf("comments --inline --trim printed %q with %d", out, code)
+	}
+}
+
+func TestQuoteKeys(t *testing.T) {
+	m := useMemFileSystem(t)
+	m.put("vm.vmx", memVMX, 0o644)
+	for _, args := range [][]string{
+		{"set", "vm.vmx", "my key=1"},
+		{"set", "vm.vmx", "tab\tkey=1"},
+		{"add", "vm.vmx", "a#b=1"},
+		{"set", "vm.vmx", `q"t=1`},
+	} {
+		if code, _, errs := runVMXTool(t, args...); code != exitUsage || !strings.Contains(errs, "--quote-keys") {
+			t.Errorf("%v exited with %d: %s", args, code, errs)
+		}
+	}
+	if got, _ := m.get("vm.vmx"); got != memVMX {
+		t.Fatalf("a rejected key changed the file:\n%s", got)
+	}
+
+	for _, args := range [][]string{
+		{"--quote-keys", "set", "vm.vmx", "my key=1"},
+		{"--quote-keys", "add", "vm.vmx", "a#b=2"},
+		{"--quote-keys", "set", "vm.vmx", `q"t=3`},
+		{"--quote-keys", "set", "vm.vmx", "my key=4"},
+	} {
+		if code, _, errs := runVMXTool(t, args...); code != 0 {
+			t.Fatalf("%v exited with %d: %s", args, code, errs)
+		}
+	}
+	want := memVMX + `"my key" = "4"` + "\n" + `"a#b" = "2"` + "\n" + `"q\"t" = "3"` + "\n"
+	if got, _ := m.get("vm.vmx"); got != want {
+		t.Errorf("--quote-keys wrote:\n%s\nwant:\n%s", got, want)
+	}
+	for key, want := range map[string]string{"my key": "4\n", "a#b": "2\n", `q"t`: "3\n", "MY KEY": "4\n"} {
+		if code, out, _ := runVMXTool(t, "query", "vm.vmx", key); code != 0 || out != want {
+			t.Errorf("query %q printed %q with %d, want %q", key, out, code, want)
+		}
+	}
+	if code, out, errs := runVMXTool(t, "print", "vm.vmx"); code != 0 || !strings.Contains(out, `"my key" = "4"`) || !strings.Contains(out, `"q\"t" = "3"`) {
+		t.Errorf("print exited with %d: %s\n%s", code, errs, out)
+	}
+	if code, _, errs := runVMXTool(t, "--vmware-compat", "--quote-keys", "set", "vm.vmx", "my key=5"); code != 0 {
+		t.Fatalf("--vmware-compat set exited with %d: %s", code, errs)
+	}
+	if got, _ := m.get("vm.vmx"); !strings.Contains(got, `"my key" = "5"`) || !strings.Contains(got, `"a#b" = "2"`) {
+		t.Errorf("--vmware-compat wrote:\n%s", got)
+	}
+	if code, out, _ := runVMXTool(t, "query", "vm.vmx", "my key"); code != 0 || out != "5\n" {
+		t.Errorf("query after --vmware-compat printed %q with %d", out, code)
+	}
+	m.put("vm.vmx", want, 0o644)
+
+	// Keys set through the API are quoted whenever they need it, so the
+	// line always reads back as the same key
+	dict, err := LoadDictionary("vm.vmx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{"tab\tkey", "k=v", "trailing ", "plain.key"}
+	for i, key := range keys {
+		dict.Set(key, fmt.Sprint(i))
+	}
+	if err := dict.Save("vm.vmx"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := m.get("vm.vmx"); !strings.Contains(got, `"k=v" = "1"`) || !strings.Contains(got, `plain.key = "3"`) {
+		t.Errorf("Save wrote:\n%s", got)
+	}
+	dict, err = LoadDictionary("vm.vmx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range keys {
+		if got, ok := dict.QueryOK(key); !ok || got != fmt.Sprint(i) {
+			t.Errorf("%q read back as %q, %v", key, got, ok)
+		}
+	}
+}
+
+func TestImportRoundTrip(t *testing.T) {
+	fixtures := []string{"comments.vmx", "shebang.vmx", "duplicates.vmx", "no-final-newline.vmx", "encoding-utf8.vmx", "batch.vmx"}
+	for _, name := range fixtures {
+		t.Run(name, func(t *testing.T) {
+			m := useFixtures(t, name)
+			m.put("empty.vmx", "", 0o644)
+
+			code, exported, errs := runVMXTool(t, "print", "--format", "json", "--full", name)
+			if code != 0 {
+				t.Fatalf("print exited with %d: %s", code, errs)
+			}
+			if code, _, errs := runVMXToolInput(t, exported, "import", "--no-validate", "empty.vmx", "-"); code != 0 {
+				t.Fatalf("import exited with %d: %s", code, errs)
+			}
+
+			want, err := LoadDictionary(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := LoadDictionary("empty.vmx")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got.Entries) != len(want.Entries) {
+				t.Fatalf("import wrote %d entries, want %d", len(got.Entries), len(want.Entries))
+			}
+			for i, entry := range want.Entries {
+				g := got.Entries[i]
+				if g.Key != entry.Key || g.Value != entry.Value || g.IsComment != entry.IsComment ||
+					g.InlineComment != entry.InlineComment || entry.IsComment && g.Original != entry.Original {
+					t.Errorf("entry %d is %+v, want %+v", i+1, g, entry)
+				}
+			}
+
+			// The file written by import exports exactly as the original
+			if _, again, _ := runVMXTool(t, "print", "--format", "json", "--full", "empty.vmx"); again != exported {
+				t.Errorf("the imported file exports as:\n%s\nwant:\n%s", again, exported)
+			}
+		})
+	}
+}
+
+// ovaArchive returns a tar archive holding the given members in order
+func ovaArchive(t *testing.T, members ...[2]string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := tar.NewWriter(&buf)
+	for _, member := range members {
+		if err := archive.WriteHeader(&tar.Header{Name: member[0], Mode: 0o644, Size: int64(len(member[1]))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := archive.Write([]byte(member[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestOVFExtract(t *testing.T) {
+	sample, err := os.ReadFile("sample.ovf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const sampleKeys = `firmware=efi
+uefi.secureBoot.enabled=TRUE
+tools.syncTime=FALSE
+tools.upgrade.policy=manual
+svga.autodetect=TRUE
+isolation.tools.copy.disable=TRUE
+guestinfo.appliance.role=frontend
+`
+	m := useFixtures(t, "extraconfig.ovf", "no-config.ovf", "no-key.ovf", "truncated.ovf")
+	m.put("sample.ovf", string(sample), 0o644)
+	m.put("appliance.ova", ovaArchive(t, [2]string{"appliance.ovf", string(sample)}, [2]string{"appliance.mf", "SHA256(appliance.ovf)= 00\n"}), 0o644)
+	m.put("MANIFEST-FIRST.OVA", ovaArchive(t, [2]string{"appliance.mf", ""}, [2]string{"dir/APPLIANCE.OVF", string(sample)}), 0o644)
+	m.put("disks.ova", ovaArchive(t, [2]string{"disk1.vmdk", "KDMV"}), 0o644)
+
+	tests := []struct {
+		file    string
+		code    int
+		want    string
+		warning string
+	}{
+		{"sample.ovf", 0, sampleKeys, "vmw:Config cpuHotAddEnabled has no VMX equivalent"},
+		{"appliance.ova", 0, sampleKeys, "cpuHotAddEnabled"},
+		{"MANIFEST-FIRST.OVA", 0, sampleKeys, "cpuHotAddEnabled"},
+		{"extraconfig.ovf", 0, "vhv.enable=TRUE\nguestinfo.worker.id=7\nannotation=a & b <c>\n", ""},
+		{"no-config.ovf", 0, "", ""},
+		{"no-key.ovf", exitFileError, "", "line 6: vmw:ExtraConfig has no vmw:key"},
+		{"truncated.ovf", exitFileError, "", "Error loading file"},
+		{"disks.ova", exitFileError, "", "no .ovf descriptor found"},
+		{"missing.ovf", exitFileError, "", "Error loading file"},
+	}
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			code, out, errs := runVMXTool(t, "ovf-extract", test.file)
+			if code != test.code || out != test.want {
+				t.Errorf("ovf-extract printed %q with %d, want %q with %d", out, code, test.want, test.code)
+			}
+			if test.warning == "" && errs != "" || !strings.Contains(errs, test.warning) {
+				t.Errorf("ovf-extract reported %q, want %q", errs, test.warning)
+			}
+		})
+	}
+
+	m.put("vm.vmx", memVMX+`firmware = "bios"`+"\n", 0o644)
+	if code, _, errs := runVMXTool(t, "ovf-extract", "sample.ovf", "--apply", "vm.vmx"); code != 0 {
+		t.Fatalf("ovf-extract --apply exited with %d: %s", code, errs)
+	}
+	dict, err := LoadDictionary("vm.vmx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for line := range strings.Lines(sampleKeys) {
+		key, value, _ := strings.Cut(strings.TrimSuffix(line, "\n"), "=")
+		if got, _ := dict.QueryOK(key); got != value {
+			t.Errorf("--apply set %s = %q, want %q", key, got, value)
+		}
+	}
+	if got := dict.occurrences("firmware"); got != 1 {
+		t.Errorf("--apply left %d firmware keys, want the existing one updated", got)
+	}
+	applied, _ := m.get("vm.vmx")
+	if code, _, _ := runVMXTool(t, "ovf-extract", "appliance.ova", "--apply", "vm.vmx"); code != 0 {
+		t.Errorf("ovf-extract --apply of the same settings exited with %d", code)
+	}
+	if got, _ := m.get("vm.vmx"); got != applied {
+		t.Errorf("applying the same settings again changed the file:\n%s", got)
+	}
+	if got, _ := m.get("sample.ovf"); got != string(sample) {
+		t.Error("ovf-extract --apply changed the OVF")
+	}
+
+	m.put("vm.vmx", memVMX, 0o644)
+	if code, _, _ := runVMXTool(t, "ovf-extract", "no-key.ovf", "--apply", "vm.vmx"); code != exitFileError {
+		t.Errorf("ovf-extract --apply of a broken OVF exited with %d, want %d", code, exitFileError)
+	}
+	if got, _ := m.get("vm.vmx"); got != memVMX {
+		t.Errorf("a broken OVF changed the target:\n%s", got)
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	const base = `.encoding = "UTF-8"
+displayName = "hash"
+memsize = "2048"
+numvcpus = "2"
+`
+	hash := func(text string) string {
+		t.Helper()
+		m := useMemFileSystem(t)
+		m.put("vm.vmx", text, 0o644)
+		dict, err := LoadDictionary("vm.vmx")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dict.ContentHash()
+	}
+	want := hash(base)
+
+	same := map[string]string{
+		"reordered":       "numvcpus = \"2\"\nmemsize = \"2048\"\n.encoding = \"UTF-8\"\ndisplayName = \"hash\"\n",
+		"reversed":        "numvcpus = \"2\"\nmemsize = \"2048\"\ndisplayName = \"hash\"\n.encoding = \"UTF-8\"\n",
+		"comments":        "# header\n" + base + "\n# trailer\n",
+		"layout":          ".encoding=\"UTF-8\"\ndisplayName   =   \"hash\"\nmemsize = 2048   # inline\nnumvcpus = \"2\"",
+		"key case":        ".encoding = \"UTF-8\"\nDisplayName = \"hash\"\nMEMSIZE = \"2048\"\nnumVCPUs = \"2\"\n",
+		"stale duplicate": "memsize = \"1024\"\n" + base,
+	}
+	for name, text := range same {
+		if got := hash(text); got != want {
+			t.Errorf("%s: ContentHash is %s, want %s as for the original order", name, got, want)
+		}
+	}
+
+	different := map[string]string{
+		"value":         strings.Replace(base, `"2048"`, `"4096"`, 1),
+		"value case":    strings.Replace(base, `"hash"`, `"Hash"`, 1),
+		"added key":     base + "annotation = \"\"\n",
+		"removed key":   strings.Replace(base, "numvcpus = \"2\"\n", "", 1),
+		"last wins":     base + "memsize = \"1024\"\n",
+		"swapped value": ".encoding = \"UTF-8\"\ndisplayName = \"hash\"\nmemsize = \"2\"\nnumvcpus = \"2048\"\n",
+	}
+	for name, text := range different {
+		if got := hash(text); got == want {
+			t.Errorf("%s: ContentHash is the same as for the original", name)
+		}
+	}
+
+	// The checksum command prints the same hash, and --raw tells the
+	// reordered file apart
+	m := useMemFileSystem(t)
+	m.put("a.vmx", base, 0o644)
+	m.put("b.vmx", same["reordered"], 0o644)
+	_, a, _ := runVMXTool(t, "checksum", "a.vmx")
+	_, b, _ := runVMXTool(t, "checksum", "--semantic", "b.vmx")
+	if a != want+"\n" || b != a {
+		t.Errorf("checksum printed %q and %q, want %q", a, b, want)
+	}
+	_, rawA, _ := runVMXTool(t, "checksum", "--raw", "a.vmx")
+	_, rawB, _ := runVMXTool(t, "checksum", "--raw", "b.vmx")
+	if rawA == rawB || rawA == a {
+		t.Errorf("checksum --raw printed %q and %q", rawA, rawB)
+	}
+}
+
+func TestPairs(t *testing.T) {
+	m := useMemFileSystem(t)
+	m.put("vm.vmx", `# header
+
+.encoding = "UTF-8"
+displayName = "pairs"   # inline comment
+# memory
+memsize = "2048"
+
+MemSize = "4096"
+guestinfo.empty = ""
+`, 0o644)
+	dict, err := LoadDictionary("vm.vmx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []KV{
+		{".encoding", "UTF-8"},
+		{"displayName", "pairs"},
+		{"memsize", "2048"},
+		{"MemSize", "4096"},
+		{"guestinfo.empty", ""},
+	}
+	pairs := dict.Pairs()
+	if !slices.Equal(pairs, want) {
+		t.Errorf("Pairs returned %v, want %v", pairs, want)
+	}
+
+	// The slice is a copy
+	pairs[2].Value = "1"
+	if got, _ := dict.QueryOK("memsize"); got != "2048" {
+		t.Errorf("changing the pairs changed memsize to %q", got)
+	}
+	if !slices.Equal(dict.Pairs(), want) {
+		t.Errorf("Pairs returned %v after the copy was changed", dict.Pairs())
+	}
+
+	empty := &Dictionary{Entries: []*Entry{parseLine("# only a comment"), parseLine("")}}
+	if got := empty.Pairs(); got == nil || len(got) != 0 {
+		t.Errorf("Pairs of a file without keys returned %#v, want an empty slice", got)
+	}
+}
+
+func TestSetHWVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    string
+		args    []string
+		code    int
+		version string // virtualHW.version afterwards
+	}{
+		{"upgrade", `virtualHW.version = "13"` + "\n" + `vtpm.present = "TRUE"` + "\n", []string{"21"}, 0, "21"},
+		{"clean downgrade", `virtualHW.version = "21"` + "\n" + `vtpm.present = "FALSE"` + "\n", []string{"10"}, 0, "10"},
+		{"downgrade blocked by vTPM", `virtualHW.version = "21"` + "\n" + `vtpm.present = "TRUE"` + "\n", []string{"13"}, exitError, "21"},
+		{"downgrade blocked by NVMe", `virtualHW.version = "21"` + "\n" + `nvme0.present = "TRUE"` + "\n", []string{"12"}, exitError, "21"},
+		{"forced downgrade", `virtualHW.version = "21"` + "\n" + `nvme0.present = "TRUE"` + "\n", []string{"--force", "12"}, 0, "12"},
+		{"ESXi VM", `virtualHW.version = "19"` + "\n" + `virtualHW.productCompatibility = "esx"` + "\n", []string{"21"}, 0, "21"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := useMemFileSystem(t)
+			m.put("vm.vmx", memVMX+test.keys, 0o644)
+			args := append([]string{"set-hw-version", "vm.vmx"}, test.args...)
+			code, _, errs := runVMXTool(t, args...)
+			if code != test.code {
+				t.Fatalf("%q exited with %d, want %d: %s", args, code, test.code, errs)
+			}
+			if code != 0 {
+				if got, _ := m.get("vm.vmx"); got != memVMX+test.keys {
+					t.Errorf("a blocked change saved the file:\n%s", got)
+				}
+				if !strings.Contains(errs, "not supported by hardware version") {
+					t.Errorf("a blocked change printed %q", errs)
+				}
+				return
+			}
+			dict, err := LoadDictionary("vm.vmx")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := dict.QueryOK("virtualHW.version"); got != test.version {
+				t.Errorf("virtualHW.version = %q, want %q", got, test.version)
+			}
+			if strings.Contains(test.keys, "productCompatibility") {
+				if got, _ := dict.QueryOK("virtualHW.productCompatibility"); got != "esx" {
+					t.Errorf("virtualHW.productCompatibility = %q, want it left as esx", got)
+				}
+			}
+		})
+	}
+}
+
+func TestUndoRoundTrip(t *testing.T) {
+	const vmx = `.encoding = "UTF-8"
+# settings
+displayName = "test"   # inline comment
+memsize = "2048"
+
+guestOS = "ubuntu-64"
+`
+	tests := []struct {
+		name     string
+		commands [][]string
+	}{
+		{"set", [][]string{{"set", "vm.vmx", "displayName=renamed"}}},
+		{"set new key", [][]string{{"set", "vm.vmx", "numvcpus=2"}}},
+		{"remove", [][]string{{"remove", "vm.vmx", "displayName"}}},
+		{"add", [][]string{{"add", "vm.vmx", "numvcpus=2"}}},
+		{"several", [][]string{
+			{"remove", "vm.vmx", "memsize"},
+			{"set", "vm.vmx", "displayName=renamed"},
+			{"add", "vm.vmx", "numvcpus=2"},
+			{"remove", "vm.vmx", "displayName"},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := useMemFileSystem(t)
+			m.put("vm.vmx", vmx, 0o644)
+			for _, args := range test.commands {
+				if code, _, errs := runVMXTool(t, append([]string{"--journal"}, args...)...); code != 0 {
+					t.Fatalf("%q failed with %d: %s", args, code, errs)
+				}
+			}
+			if got, _ := m.get("vm.vmx"); got == vmx {
+				t.Fatalf("%q did not change the file", test.commands)
+			}
+
+			steps := fmt.Sprint(len(test.commands))
+			if code, _, errs := runVMXTool(t, "undo", "vm.vmx", "--steps", steps); code != 0 {
+				t.Fatalf("undo --steps %s failed with %d: %s", steps, code, errs)
+			}
+			if got, _ := m.get("vm.vmx"); got != vmx {
+				t.Errorf("undo left:\n%s\nwant:\n%s", got, vmx)
+			}
+			if _, ok := m.get("vm.vmx" + journalSuffix); ok {
+				t.Errorf("undoing every change left the journal")
+			}
+		})
+	}
+}
+
+func TestUndoChangedSinceJournal(t *testing.T) {
+	m := useMemFileSystem(t)
+	m.put("vm.vmx", memVMX, 0o644)
+	if code, _, errs := runVMXTool(t, "--journal", "set", "vm.vmx", "memsize=4096"); code != 0 {
+		t.Fatalf("set failed with %d: %s", code, errs)
+	}
+	journal, _ := m.get("vm.vmx" + journalSuffix)
+
+	// Another program changes the key after the journal entry
+	changed := strings.Replace(memVMX, "2048", "8192", 1)
+	m.put("vm.vmx", changed, 0o644)
+
+	code, _, errs := runVMXTool(t, "undo", "vm.vmx")
+	if code != exitError {
+		t.Errorf("undo exited with %d, want %d: %s", code, exitError, errs)
+	}
+	if !strings.Contains(errs, "changed since it was journaled") || !strings.Contains(errs, "Nothing was undone") {
+		t.Errorf("undo printed %q", errs)
+	}
+	if got, _ := m.get("vm.vmx"); got != changed {
+		t.Errorf("undo changed the file to:\n%s", got)
+	}
+	if got, _ := m.get("vm.vmx" + journalSuffix); got != journal {
+		t.Errorf("undo changed the journal to:\n%s", got)
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  []string
+		error string
+		exit  int
+		key   string
+	}{
+		{"usage", []string{"set", "vm.vmx"}, "usage", exitUsage, ""},
+		{"file not found", []string{"merge", "vm.vmx", "missing.vmx"}, "file_not_found", exitFileError, ""},
+		{"permission denied", []string{"query", "denied.vmx", "memsize"}, "permission_denied", exitFileError, ""},
+		{"file error", []string{"--max-line-size", "10", "query", "vm.vmx", "memsize"}, "file_error", exitFileError, ""},
+		{"key not found", []string{"query", "vm.vmx", "numvcpus"}, "key_not_found", exitKeyNotFound, "numvcpus"},
+		{"key exists", []string{"add", "vm.vmx", "memsize=4096"}, "key_exists", exitKeyExists, "memsize"},
+		{"unchanged", []string{"set", "--require-change", "vm.vmx", "memsize=2048"}, "unchanged", exitUnchanged, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := useMemFileSystem(t)
+			m.put("vm.vmx", memVMX, 0o644)
+			m.put("denied.vmx", memVMX, 0o644)
+			m.denied["denied.vmx"] = true
+			setOption(t, &globalOptions.MaxLineSize, 0)
+
+			args := append([]string{"--json-errors"}, test.args...)
+			code, _, errs := runVMXTool(t, args...)
+			if code != test.exit {
+				t.Errorf("%q exited with %d, want %d", args, code, test.exit)
+			}
+			var failure jsonFailure
+			decoder := json.NewDecoder(strings.NewReader(errs))
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(&failure); err != nil {
+				t.Fatalf("stderr is not a JSON failure: %v\n%s", err, errs)
+			}
+			if decoder.More() {
+				t.Errorf("stderr has more than one JSON object:\n%s", errs)
+			}
+			if failure.Error != test.error || failure.ExitCode != test.exit || failure.Key != test.key {
+				t.Errorf("%q printed error %q, exitCode %d and key %q, want %q, %d and %q",
+					args, failure.Error, failure.ExitCode, failure.Key, test.error, test.exit, test.key)
+			}
+			if failure.Message == "" {
+				t.Errorf("%q printed no message", args)
+			}
+		})
+	}
+}
+
+func TestConvertController(t *testing.T) {
+	const ide = `.encoding = "UTF-8"
+virtualHW.version = "21"
+ide0:0.present = "TRUE"
+ide0:0.fileName = "disk.vmdk"
+ide0:1.present = "TRUE"
+ide0:1.deviceType = "cdrom-image"
+memsize = "2048"
+`
+	const scsi = `.encoding = "UTF-8"
+virtualHW.version = "21"
+scsi0.present = "TRUE"
+scsi0.virtualDev = "lsilogic"
+scsi0:0.present = "TRUE"
+scsi0:0.fileName = "disk.vmdk"
+bios.hddOrder = "scsi0:0"
+`
+	tests := []struct {
+		name     string
+		vmx      string
+		from, to string
+		code     int
+		want     string // the file afterwards, if it changes
+	}{
+		{"ide to sata", ide, "ide0", "sata0", 0, `.encoding = "UTF-8"
+virtualHW.version = "21"
+sata0:0.present = "TRUE"
+sata0:0.fileName = "disk.vmdk"
+sata0:1.present = "TRUE"
+sata0:1.deviceType = "cdrom-image"
+memsize = "2048"
+sata0.present = "TRUE"
+`},
+		{"scsi to nvme", scsi, "scsi0", "nvme0", 0, `.encoding = "UTF-8"
+virtualHW.version = "21"
+nvme0:0.present = "TRUE"
+nvme0:0.fileName = "disk.vmdk"
+bios.hddOrder = "nvme0:0"
+nvme0.present = "TRUE"
+`},
+		{"slot in use", ide + "sata0.present = \"TRUE\"\nsata0:1.present = \"TRUE\"\n", "ide0", "sata0", exitKeyExists, ""},
+		{"CD-ROM on nvme", ide, "ide0", "nvme0", exitError, ""},
+		{"old hardware", strings.Replace(scsi, `"21"`, `"12"`, 1), "scsi0", "nvme0", exitError, ""},
+		{"no devices", scsi, "sata0", "nvme0", exitKeyNotFound, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := useMemFileSystem(t)
+			m.put("vm.vmx", test.vmx, 0o644)
+			code, _, errs := runVMXTool(t, "convert-controller", "vm.vmx", "--from", test.from, "--to", test.to)
+			if code != test.code {
+				t.Fatalf("--from %s --to %s exited with %d, want %d: %s", test.from, test.to, code, test.code, errs)
+			}
+			want := cmp.Or(test.want, test.vmx)
+			if got, _ := m.get("vm.vmx"); got != want {
+				t.Errorf("--from %s --to %s left:\n%s\nwant:\n%s", test.from, test.to, got, want)
+			}
+		})
+	}
+}
+
+func TestEnforceConstraints(t *testing.T) {
+	tests := []struct {
+		name string
+		rule string
+		pass bool
+	}{
+		{"equals", `"key": "memsize", "equals": "2048"`, true},
+		{"equals differs", `"key": "memsize", "equals": "4096"`, false},
+		{"equals unset", `"key": "numvcpus", "equals": "2"`, false},
+		{"min and max", `"key": "memsize", "min": 1024, "max": 4096`, true},
+		{"below min", `"key": "memsize", "min": 4096`, false},
+		{"above max", `"key": "memsize", "max": 1024`, false},
+		{"min not a number", `"key": "displayName", "min": 1`, false},
+		{"choice", `"key": "displayName", "values": ["other", "TEST"]`, true},
+		{"not a choice", `"key": "displayName", "values": ["other"]`, false},
+		{"absent", `"key": "RemoteDisplay.vnc.*", "forbidden": true`, true},
+		{"not absent", `"key": "memsize", "forbidden": true`, false},
+		{"required", `"key": "display*", "required": true`, true},
+		{"required missing", `"key": "guestOS", "required": true`, false},
+		{"regex", `"key": "memsize", "regex": "^[0-9]+$"`, true},
+		{"regex mismatch", `"key": "displayName", "regex": "^[0-9]+$"`, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := useMemFileSystem(t)
+			m.put("vm.vmx", memVMX, 0o644)
+			m.put("policy.json", `{"name": "test", "rules": [{"id": "rule", `+test.rule+`}]}`, 0o644)
+
+			code, out, errs := runVMXTool(t, "enforce", "vm.vmx", "policy.json")
+			want := exitDifferent
+			if test.pass {
+				want = 0
+			}
+			if code != want {
+				t.Errorf("rule {%s} exited with %d, want %d:\n%s%s", test.rule, code, want, out, errs)
+			}
+			if complies := strings.Contains(out, "File complies with policy test"); complies != test.pass {
+				t.Errorf("rule {%s} printed:\n%s", test.rule, out)
+			}
+			if !test.pass && !strings.HasPrefix(out, "error: rule: ") {
+				t.Errorf("rule {%s} reported:\n%s", test.rule, out)
+			}
+		})
+	}
+
+	m := useMemFileSystem(t)
+	m.put("vm.vmx", memVMX, 0o644)
+	m.put("policy.json", `{"name": "test", "rules": [{"id": "rule", "key": "memsize", "min": 4096, "max": 1024}]}`, 0o644)
+	if code, _, errs := runVMXTool(t, "enforce", "vm.vmx", "policy.json"); code != exitError || !strings.Contains(errs, "min greater than max") {
+		t.Errorf("min greater than max exited with %d: %s", code, errs)
+	}
+}
+
+func TestIsolationInversion(t *testing.T) {
+	tests := []struct {
+		flag string
+		on   map[string]string // the keys written by on; off writes the opposite
+	}{
+		{"copy", map[string]string{"isolation.tools.copy.disable": "FALSE"}},
+		{"paste", map[string]string{"isolation.tools.paste.disable": "FALSE"}},
+		{"dnd", map[string]string{"isolation.tools.dnd.disable": "FALSE"}},
+		{"disk-ops", map[string]string{"isolation.tools.diskShrink.disable": "FALSE", "isolation.tools.diskWiper.disable": "FALSE"}},
+		{"gui-options", map[string]string{"isolation.tools.setGUIOptions.enable": "TRUE"}},
+		{"hostinfo", map[string]string{"tools.guestlib.enableHostInfo": "TRUE"}},
+	}
+	if len(tests) != len(isolationSettings) {
+		t.Fatalf("the test covers %d isolation flags, there are %d", len(tests), len(isolationSettings))
+	}
+	opposite := map[string]string{"TRUE": "FALSE", "FALSE": "TRUE"}
+	for _, test := range tests {
+		for _, state := range []string{"on", "off"} {
+			t.Run(test.flag+" "+state, func(t *testing.T) {
+				m := useMemFileSystem(t)
+				m.put("vm.vmx", memVMX, 0o644)
+				if code, _, errs := runVMXTool(t, "isolation", "vm.vmx", "--"+test.flag, state); code != 0 {
+					t.Fatalf("--%s %s failed with %d: %s", test.flag, state, code, errs)
+				}
+				dict, err := LoadDictionary("vm.vmx")
+				if err != nil {
+					t.Fatal(err)
+				}
+				for key, want := range test.on {
+					if state == "off" {
+						want = opposite[want]
+					}
+					if got, _ := dict.QueryOK(key); got != want {
+						t.Errorf("--%s %s set %s = %q, want %q", test.flag, state, key, got, want)
+					}
+				}
+				if len(dict.Keys()) != 3+len(test.on) {
+					t.Errorf("--%s %s set other keys: %v", test.flag, state, dict.Keys())
+				}
+
+				_, out, _ := runVMXTool(t, "isolation", "vm.vmx")
+				label := isolationSettings[slices.IndexFunc(isolationSettings, func(s isolationSetting) bool { return s.Flag == test.flag })].Label
+				if !regexp.MustCompile(`(?m)^` + regexp.QuoteMeta(label) + `:\s+` + state + `$`).MatchString(out) {
+					t.Errorf("after --%s %s the status is:\n%s", test.flag, state, out)
+				}
+			})
+		}
+	}
+
+	m := useMemFileSystem(t)
+	m.put("vm.vmx", memVMX, 0o644)
+	if code, _, errs := runVMXTool(t, "isolation", "vm.vmx", "--lockdown"); code != 0 {
+		t.Fatalf("--lockdown failed with %d: %s", code, errs)
+	}
+	dict, err := LoadDictionary("vm.vmx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		for key, on := range test.on {
+			if got, _ := dict.QueryOK(key); got != opposite[on] {
+				t.Errorf("--lockdown set %s = %q, want %q", key, got, opposite[on])
+			}
+		}
+	}
+}
+
+func TestSharedFolderRemoveRenumbers(t *testing.T) {
+	m := useMemFileSystem(t)
+	m.put("vm.vmx", memVMX, 0o644)
+	for _, name := range []string{"first", "middle", "last"} {
+		if code, _, errs := runVMXTool(t, "shared-folder", "add", "vm.vmx", "--name", name, "--host-path", "/host/"+name); code != 0 {
+			t.Fatalf("shared-folder add %s failed with %d: %s", name, code, errs)
+		}
+	}
+	if code, _, errs := runVMXTool(t, "shared-folder", "remove", "vm.vmx", "middle"); code != 0 {
+		t.Fatalf("shared-folder remove failed with %d: %s", code, errs)
+	}
+
+	dict, err := LoadDictionary("vm.vmx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{
+		"sharedFolder0.guestName": "first",
+		"sharedFolder0.hostPath":  "/host/first",
+		"sharedFolder1.guestName": "last",
+		"sharedFolder1.hostPath":  "/host/last",
+		"sharedFolder1.present":   "TRUE",
+		"sharedFolder.maxNum":     "2",
+	} {
+		if got, _ := dict.QueryOK(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	if keys := dict.FindMatching("sharedFolder2.*"); len(keys) != 0 {
+		t.Errorf("the last folder kept its old keys: %v", keys)
+	}
+	if folders := dict.sharedFolders(); len(folders) != 2 || folders[0].Index != 0 || folders[1].Index != 1 {
+		t.Errorf("folders after the removal are %+v", folders)
+	}
+	for _, rule := range dependencyRules {
+		if rule.ID == "shared-folder-count" {
+			if problems := rule.Check(dict); len(problems) != 0 {
+				t.Errorf("the file fails %s: %v", rule.ID, problems)
+			}
+		}
+	}
+}
+
+func TestVNC(t *testing.T) {
+	tests := []struct {
+		name  string
+		keys  string
+		args  []string
+		input string
+		code  int
+		want  map[string]string // "" for a key that must be absent
+	}{
+		{"on with a password", "", []string{"on", "--password-from-stdin"}, "secret\n", 0,
+			map[string]string{"RemoteDisplay.vnc.enabled": "TRUE", "RemoteDisplay.vnc.port": "5900", "RemoteDisplay.vnc.password": "secret"}},
+		{"on without a password", "", []string{"on", "--port", "5901", "--no-password"}, "", 0,
+			map[string]string{"RemoteDisplay.vnc.enabled": "TRUE", "RemoteDisplay.vnc.port": "5901", "RemoteDisplay.vnc.password": ""}},
+		{"on keeps the port", `RemoteDisplay.vnc.port = "5905"` + "\n" + `RemoteDisplay.vnc.password = "old"` + "\n", []string{"on"}, "", 0,
+			map[string]string{"RemoteDisplay.vnc.enabled": "TRUE", "RemoteDisplay.vnc.port": "5905", "RemoteDisplay.vnc.password": "old"}},
+		{"outside the VNC range", "", []string{"on", "--port", "65535", "--no-password"}, "", 0,
+			map[string]string{"RemoteDisplay.vnc.port": "65535"}},
+		{"port 0", "", []string{"on", "--port", "0", "--no-password"}, "", exitUsage,
+			map[string]string{"RemoteDisplay.vnc.enabled": ""}},
+		{"port too high", "", []string{"on", "--port", "65536", "--no-password"}, "", exitUsage,
+			map[string]string{"RemoteDisplay.vnc.enabled": ""}},
+		{"no password", "", []string{"on"}, "", exitError,
+			map[string]string{"RemoteDisplay.vnc.enabled": ""}},
+		{"off", `RemoteDisplay.vnc.enabled = "TRUE"` + "\n" + `RemoteDisplay.vnc.password = "old"` + "\n", []string{"off"}, "", 0,
+			map[string]string{"RemoteDisplay.vnc.enabled": "FALSE", "RemoteDisplay.vnc.password": ""}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := useMemFileSystem(t)
+			m.put("vm.vmx", memVMX+test.keys, 0o644)
+			args := append([]string{"vnc", "vm.vmx"}, test.args...)
+			code, _, errs := runVMXToolInput(t, test.input, args...)
+			if code != test.code {
+				t.Fatalf("%q exited with %d, want %d: %s", args, code, test.code, errs)
+			}
+			if code != 0 {
+				if got, _ := m.get("vm.vmx"); got != memVMX+test.keys {
+					t.Errorf("%q failed but changed the file to:\n%s", args, got)
+				}
+			}
+			dict, err := LoadDictionary("vm.vmx")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, want := range test.want {
+				if got, _ := dict.QueryOK(key); got != want {
+					t.Errorf("%s = %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+
+	m := useMemFileSystem(t)
+	m.put("vm.vmx", memVMX+`RemoteDisplay.vnc.enabled = "TRUE"`+"\n"+`RemoteDisplay.vnc.port = "5901"`+"\n"+`RemoteDisplay.vnc.password = "secret"`+"\n", 0o644)
+	code, out, _ := runVMXTool(t, "vnc", "vm.vmx")
+	for _, want := range []string{`VNC:\s+on`, `Port:\s+5901`, `Password:\s+\*{8}`} {
+		if !regexp.MustCompile(`(?m)^` + want + `$`).MatchString(out) {
+			t.Errorf("vnc status exited with %d and printed:\n%s\nwant %s", code, out, want)
+		}
+	}
+	if strings.Contains(out, "secret") {
+		t.Errorf("vnc status printed the password:\n%s", out)
+	}
+}
+
+func TestCleanKeepsExistingFiles(t *testing.T) {
+	const vmx = memVMX + `checkpoint.vmState = "vm-1.vmss"
+sched.swap.derivedName = "/vms/vm-1.vswp"
+gui.lastPoweredViewMode = "fullscreen"
+`
+	tests := []struct {
+		name  string
+		files []string // files that exist besides vm.vmx
+		kept  []string
+	}{
+		{"no files", nil, nil},
+		{"suspended", []string{"vm-1.vmss"}, []string{"checkpoint.vmState"}},
+		{"swap file", []string{"/vms/vm-1.vswp"}, []string{"sched.swap.derivedName"}},
+		{"both", []string{"vm-1.vmss", "/vms/vm-1.vswp"}, []string{"checkpoint.vmState", "sched.swap.derivedName"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := useMemFileSystem(t)
+			m.put("vm.vmx", vmx, 0o644)
+			for _, name := range test.files {
+				m.put(name, "state", 0o644)
+			}
+
+			code, out, errs := runVMXTool(t, "--dry-run", "clean", "vm.vmx")
+			if code != exitDifferent {
+				t.Errorf("clean --dry-run exited with %d, want %d: %s", code, exitDifferent, errs)
+			}
+			if got, _ := m.get("vm.vmx"); got != vmx {
+				t.Errorf("clean --dry-run changed the file:\n%s", got)
+			}
+			for _, key := range test.kept {
+				if strings.Contains(out, "-"+key) || !strings.Contains(errs, "Kept "+key) {
+					t.Errorf("clean --dry-run would remove %s:\n%s%s", key, out, errs)
+				}
+			}
+
+			if code, _, errs := runVMXTool(t, "clean", "vm.vmx"); code != 0 {
+				t.Fatalf("clean failed with %d: %s", code, errs)
+			}
+			dict, err := LoadDictionary("vm.vmx")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, key := range []string{"checkpoint.vmState", "sched.swap.derivedName", "gui.lastPoweredViewMode"} {
+				if want := slices.Contains(test.kept, key); dict.KeyExists(key) != want {
+					t.Errorf("after clean, %s exists is %t, want %t", key, !want, want)
+				}
+			}
+			for _, name := range test.files {
+				if _, ok := m.get(name); !ok {
+					t.Errorf("clean removed %s", name)
+				}
+			}
+		})
+	}
+}
+
+func TestAutoAnswerTransitions(t *testing.T) {
+	tests := []struct {
+		command string
+		key     string
+		from    string // "" for absent
+		arg     string
+		want    string // "" for absent
+	}{
+		{"autoanswer", "msg.autoAnswer", "", "on", "TRUE"},
+		{"autoanswer", "msg.autoAnswer", "", "off", ""},
+		{"autoanswer", "msg.autoAnswer", "TRUE", "on", "TRUE"},
+		{"autoanswer", "msg.autoAnswer", "TRUE", "off", ""},
+		{"autoanswer", "msg.autoAnswer", "FALSE", "on", "TRUE"},
+		{"autoanswer", "msg.autoAnswer", "FALSE", "off", ""},
+		{"uuid-action", "uuid.action", "", "keep", "keep"},
+		{"uuid-action", "uuid.action", "", "create", "create"},
+		{"uuid-action", "uuid.action", "", "prompt", ""},
+		{"uuid-action", "uuid.action", "keep", "keep", "keep"},
+		{"uuid-action", "uuid.action", "keep", "create", "create"},
+		{"uuid-action", "uuid.action", "keep", "prompt", ""},
+		{"uuid-action", "uuid.action", "create", "keep", "keep"},
+		{"uuid-action", "uuid.action", "create", "create", "create"},
+		{"uuid-action", "uuid.action", "create", "prompt", ""},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s %q to %s", test.command, test.from, test.arg), func(t *testing.T) {
+			vmx := memVMX
+			if test.from != "" {
+				vmx += test.key + ` = "` + test.from + `"` + "\n"
+			}
+			m := useMemFileSystem(t)
+			m.put("vm.vmx", vmx, 0o644)
+
+			code, _, errs := runVMXTool(t, test.command, "vm.vmx", test.arg)
+			if code != 0 {
+				t.Fatalf("%s %s failed with %d: %s", test.command, test.arg, code, errs)
+			}
+			got, _ := m.get("vm.vmx")
+			dict, err := LoadDictionary("vm.vmx")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value, _ := dict.QueryOK(test.key); value != test.want {
+				t.Errorf("%s = %q, want %q", test.key, value, test.want)
+			}
+			if test.from == test.want && got != vmx {
+				t.Errorf("a transition to the same state changed the file to:\n%s", got)
+			}
+		})
+	}
+
+	m := useMemFileSystem(t)
+	m.put("vm.vmx", memVMX+`msg.autoAnswer = "TRUE"`+"\n"+`uuid.action = "create"`+"\n", 0o644)
+	for _, command := range []string{"autoanswer", "uuid-action"} {
+		_, out, _ := runVMXTool(t, command, "vm.vmx", "status")
+		for _, want := range []string{`(?m)^Auto answer:\s+on$`, `(?m)^UUID action:\s+create$`, `a new UUID`} {
+			if !regexp.MustCompile(want).MatchString(out) {
+				t.Errorf("%s status printed:\n%s\nwant %s", command, out, want)
+			}
+		}
+	}
+	for _, args := range [][]string{{"autoanswer", "vm.vmx", "maybe"}, {"uuid-action", "vm.vmx", "move"}} {
+		if code, _, _ := runVMXTool(t, args...); code != exitUsage {
+			t.Errorf("%q exited with %d, want %d", args, code, exitUsage)
+		}
+	}
+}
+
+func TestClosestMatch(t *testing.T) {
+	candidates := []string{"displayName", "memsize", "numvcpus", "ethernet0.addressType", "guestOS"}
+	tests := []struct {
+		s    string
+		want string // "" for no suggestion
+	}{
+		{"memsie", "memsize"},          // a missing character
+		{"memsizee", "memsize"},        // an extra character
+		{"numvcpos", "numvcpus"},       // a wrong character
+		{"DISPLAYNAME", "displayName"}, // case is ignored
+		{"ethernet0.adressType", "ethernet0.addressType"},
+		{"guestos", "guestOS"},
+		{"floppy0.present", ""},
+		{"zzzzzzzz", ""},
+		{"x", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		got, ok := closestMatch(test.s, candidates)
+		if got != test.want || ok != (test.want != "") {
+			t.Errorf("closestMatch(%q) = %q, %t, want %q", test.s, got, ok, test.want)
+		}
+	}
+	if got, ok := closestMatch("memsize", nil); ok {
+		t.Errorf("closestMatch without candidates suggested %q", got)
+	}
+
+	m := useMemFileSystem(t)
+	m.put("vm.vmx", memVMX, 0o644)
+	for _, test := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"query", "vm.vmx", "memsie"}, "did you mean 'memsize'?"},
+		{[]string{"remove", "vm.vmx", "dispalyName"}, "did you mean 'displayName'?"},
+		{[]string{"query", "vm.vmx", "qqqqqqqqqq"}, ""},
+		{[]string{"--no-suggest", "query", "vm.vmx", "memsie"}, ""},
+	} {
+		code, _, errs := runVMXTool(t, test.args...)
+		if code != exitKeyNotFound {
+			t.Errorf("%q exited with %d, want %d", test.args, code, exitKeyNotFound)
+		}
+		if test.want == "" && strings.Contains(errs, "did you mean") || !strings.Contains(errs, test.want) {
+			t.Errorf("%q printed %q, want %q", test.args, errs, test.want)
+		}
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	env := map[string]string{"HOST": "build01", "EMPTY": "", "PATH_VAR": `C:\VMs`}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+	tests := []struct {
+		in, want string
+		err      string // part of the error, "" for none
+	}{
+		{"${HOST}", "build01", ""},
+		{"vm-${HOST}.local", "vm-build01.local", ""},
+		{"${HOST}${HOST}", "build01build01", ""},
+		{`${PATH_VAR}\disk.vmdk`, `C:\VMs\disk.vmdk`, ""},
+		{"$HOST", "$HOST", ""}, // only the braced form is expanded
+		{"pa$word", "pa$word", ""},
+		{"$$", "$", ""},
+		{"$${HOST}", "${HOST}", ""},
+		{"cost $$5", "cost $5", ""},
+		{"trailing $", "trailing $", ""},
+		{"${EMPTY}", "", ""},
+		{"[${EMPTY}]", "[]", ""},
+		{"${EMPTY:-fallback}", "fallback", ""},
+		{"${UNSET:-fallback}", "fallback", ""},
+		{"${UNSET:-}", "", ""},
+		{"${HOST:-fallback}", "build01", ""},
+		{"", "", ""},
+		{"no variables", "no variables", ""},
+		{"${UNSET}", "", "environment variables not set: UNSET"},
+		{"${UNSET} ${OTHER} ${UNSET}", "", "environment variables not set: UNSET, OTHER"},
+		{"${HOST", "", "no closing }"},
+		{"${1BAD}", "", "invalid variable name"},
+		{"${}", "", "invalid variable name"},
+	}
+	for _, test := range tests {
+		got, err := expandEnv(test.in, lookup)
+		switch {
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("expandEnv(%q) = %q, %v, want an error with %q", test.in, got, err, test.err)
+		case test.err == "" && (err != nil || got != test.want):
+			t.Errorf("expandEnv(%q) = %q, %v, want %q", test.in, got, err, test.want)
+		}
+	}
+}
+
+func TestProfileApplyExpandEnv(t *testing.T) {
+	m := useMemFileSystem(t)
+	m.put("vm.vmx", memVMX, 0o644)
+	if err := m.MkdirAll("profiles", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	m.put("profiles/lab.profile", "# Lab VMs\nguestinfo.host = \"${LAB_HOST}\"\nguestinfo.price = \"$$5\"\n", 0o644)
+	t.Setenv("LAB_HOST", "build01")
+
+	code, _, errs := runVMXTool(t, "profile", "apply", "vm.vmx", "lab", "--profile-dir", "profiles", "--expand-env")
+	if code != 0 {
+		t.Fatalf("profile apply --expand-env failed with %d: %s", code, errs)
+	}
+	dict, err := LoadDictionary("vm.vmx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"guestinfo.host": "build01", "guestinfo.price": "$5"} {
+		if got, _ := dict.QueryOK(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+
+	// Without --expand-env values are literal
+	m.put("vm.vmx", memVMX, 0o644)
+	if code, _, errs := runVMXTool(t, "profile", "apply", "vm.vmx", "lab", "--profile-dir", "profiles"); code != 0 {
+		t.Fatalf("profile apply failed with %d: %s", code, errs)
+	}
+	if dict, _ := LoadDictionary("vm.vmx"); dict.queryOr("guestinfo.host", "") != "${LAB_HOST}" {
+		t.Errorf("profile apply without --expand-env set guestinfo.host = %q", dict.queryOr("guestinfo.host", ""))
+	}
+
+	// An unset variable is an error and nothing is written
+	m.put("vm.vmx", memVMX, 0o644)
+	os.Unsetenv("LAB_HOST")
+	code, _, errs = runVMXTool(t, "profile", "apply", "vm.vmx", "lab", "--profile-dir", "profiles", "--expand-env")
+	if code != exitUsage || !strings.Contains(errs, "LAB_HOST") {
+		t.Errorf("an unset variable exited with %d: %s", code, errs)
+	}
+	if got, _ := m.get("vm.vmx"); got != memVMX {
+		t.Errorf("an unset variable changed the file to:\n%s", got)
+	}
+}
+
+func TestTimeoutFile(t *testing.T) {
+	setOption(t, &globalOptions.Timeout, 50*time.Millisecond)
+
+	// A pipe supports deadlines, so a read with nothing to read times out
+	// without leaving a goroutine holding the buffer
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	reader := timeoutFile{r}
+	buf := make([]byte, 16)
+	n, err := reader.Read(buf)
+	var timeout timeoutError
+	if !errors.As(err, &timeout) || n != 0 {
+		t.Fatalf("reading an empty pipe returned %d, %v, want a timeout", n, err)
+	}
+	if failureCode(err, exitFileError) != "timeout" {
+		t.Errorf("a read deadline has the error code %q", failureCode(err, exitFileError))
+	}
+	if _, err := (timeoutFile{w}).Write([]byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := reader.Read(buf); err != nil || string(buf[:n]) != "data" {
+		t.Errorf("reading after a timeout returned %q, %v", buf[:n], err)
+	}
+
+	// A regular file has no deadlines and is read and written through a
+	// copy of the buffer
+	name := filepath.Join(t.TempDir(), "vm.vmx")
+	file, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte(memVMX)
+	if n, err := (timeoutFile{file}).Write(data); err != nil || n != len(data) {
+		t.Fatalf("Write returned %d, %v", n, err)
+	}
+	if err := (timeoutFile{file}).Close(); err != nil {
+		t.Fatal(err)
+	}
+	file, err = os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(timeoutFile{file})
+	file.Close()
+	if err != nil || string(got) != memVMX {
+		t.Errorf("reading the file returned %q, %v", got, err)
+	}
+}